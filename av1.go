@@ -0,0 +1,257 @@
+package goutil
+
+// AV1 OBU types(AV1 spec 6.2.2).
+const (
+	AV1_OBU_SEQUENCE_HEADER        uint8 = 1
+	AV1_OBU_TEMPORAL_DELIMITER     uint8 = 2
+	AV1_OBU_FRAME_HEADER           uint8 = 3
+	AV1_OBU_TILE_GROUP             uint8 = 4
+	AV1_OBU_METADATA               uint8 = 5
+	AV1_OBU_FRAME                  uint8 = 6
+	AV1_OBU_REDUNDANT_FRAME_HEADER uint8 = 7
+	AV1_OBU_TILE_LIST              uint8 = 8
+	AV1_OBU_PADDING                uint8 = 15
+)
+
+// AV1 frame types(AV1 spec 6.8.2).
+const (
+	AV1_KEY_FRAME        = 0
+	AV1_INTER_FRAME      = 1
+	AV1_INTRA_ONLY_FRAME = 2
+	AV1_SWITCH_FRAME     = 3
+)
+
+const (
+	kAv1ObuTypeMask     = 0x78
+	kAv1ObuExtensionBit = 0x04
+	kAv1ObuHasSizeBit   = 0x02
+	kAv1ObuForbiddenBit = 0x80
+	kAv1MaxLeb128Size   = 8
+)
+
+// ReadLeb128 reads one leb128() number, and returns the value and its size.
+func ReadLeb128(data []byte) (uint64, int, error) {
+	var value uint64
+	for i := 0; i < kAv1MaxLeb128Size; i++ {
+		if i >= len(data) {
+			return 0, 0, NewErrorf("leb128 insufficient: %d", len(data))
+		}
+		value |= uint64(data[i]&0x7F) << (7 * uint(i))
+		if data[i]&0x80 == 0 {
+			return value, i + 1, nil
+		}
+	}
+	return 0, 0, NewErrorf("leb128 too long")
+}
+
+// AppendLeb128 appends the leb128() of value to buf.
+func AppendLeb128(buf []byte, value uint64) []byte {
+	for value >= 0x80 {
+		buf = append(buf, uint8(value&0x7F)|0x80)
+		value >>= 7
+	}
+	return append(buf, uint8(value))
+}
+
+// Leb128Size returns the size of leb128() for value.
+func Leb128Size(value uint64) int {
+	size := 1
+	for value >= 0x80 {
+		value >>= 7
+		size++
+	}
+	return size
+}
+
+// Av1Obu is one OBU without obu_size(AV1 spec 5.3).
+type Av1Obu struct {
+	Type         uint8
+	HasExtension bool
+	TemporalId   uint8
+	SpatialId    uint8
+	Payload      []byte
+}
+
+// ParseAv1Obu parses one OBU at the beginning of data, and returns its total
+// size. The OBU without obu_size takes all of data.
+func ParseAv1Obu(data []byte) (*Av1Obu, int, error) {
+	if len(data) < 1 {
+		return nil, 0, NewErrorf("AV1 OBU insufficient: %d", len(data))
+	}
+	if data[0]&kAv1ObuForbiddenBit != 0 {
+		return nil, 0, NewErrorf("AV1 OBU forbidden bit")
+	}
+	obu := &Av1Obu{
+		Type:         (data[0] & kAv1ObuTypeMask) >> 3,
+		HasExtension: data[0]&kAv1ObuExtensionBit != 0,
+	}
+	n := 1
+	if obu.HasExtension {
+		if len(data) < 2 {
+			return nil, 0, NewErrorf("AV1 OBU extension insufficient: %d", len(data))
+		}
+		obu.TemporalId = data[1] >> 5
+		obu.SpatialId = (data[1] >> 3) & 0x03
+		n += 1
+	}
+	if data[0]&kAv1ObuHasSizeBit == 0 {
+		obu.Payload = data[n:]
+		return obu, len(data), nil
+	}
+	size, sz, err := ReadLeb128(data[n:])
+	if err != nil {
+		return nil, 0, NewError2(err, "AV1 OBU size invalid")
+	}
+	n += sz
+	if uint64(len(data)-n) < size {
+		return nil, 0, NewErrorf("AV1 OBU payload insufficient: %d < %d", len(data)-n, size)
+	}
+	obu.Payload = data[n : n+int(size)]
+	return obu, n + int(size), nil
+}
+
+// SplitAv1Obus splits the low overhead bitstream format(e.g. one temporal unit) into OBUs.
+func SplitAv1Obus(data []byte) ([]*Av1Obu, error) {
+	var obus []*Av1Obu
+	for len(data) > 0 {
+		obu, n, err := ParseAv1Obu(data)
+		if err != nil {
+			return nil, err
+		}
+		obus = append(obus, obu)
+		data = data[n:]
+	}
+	return obus, nil
+}
+
+// headerSize returns the size of OBU header(without obu_size).
+func (o *Av1Obu) headerSize() int {
+	if o.HasExtension {
+		return 2
+	}
+	return 1
+}
+
+// Bytes returns the OBU, with obu_size if withSize.
+func (o *Av1Obu) Bytes(withSize bool) []byte {
+	buf := make([]byte, 0, o.headerSize()+kAv1MaxLeb128Size+len(o.Payload))
+	header := (o.Type << 3) & kAv1ObuTypeMask
+	if o.HasExtension {
+		header |= kAv1ObuExtensionBit
+	}
+	if withSize {
+		header |= kAv1ObuHasSizeBit
+	}
+	buf = append(buf, header)
+	if o.HasExtension {
+		buf = append(buf, o.TemporalId<<5|(o.SpatialId&0x03)<<3)
+	}
+	if withSize {
+		buf = AppendLeb128(buf, uint64(len(o.Payload)))
+	}
+	return append(buf, o.Payload...)
+}
+
+// Av1SequenceHeader is the sequence_header_obu()(AV1 spec 5.5), parsed to
+// max_frame_height_minus_1.
+type Av1SequenceHeader struct {
+	Profile                   uint8
+	StillPicture              bool
+	ReducedStillPictureHeader bool
+	TimingInfoPresent         bool
+	NumUnitsInDisplayTick     uint32
+	TimeScale                 uint32
+	OperatingPoints           int
+	OperatingPointIdc         uint32 // of operating point 0
+	LevelIdx                  uint8  // of operating point 0
+	Tier                      uint8  // of operating point 0
+	MaxWidth                  int
+	MaxHeight                 int
+}
+
+// readAv1Uvlc reads one uvlc()(AV1 spec 4.10.3).
+func readAv1Uvlc(r *BitReader) uint32 {
+	zeros := 0
+	for r.Err() == nil && !r.ReadFlag() {
+		zeros++
+	}
+	if zeros >= 32 {
+		return 0xFFFFFFFF
+	}
+	return r.ReadBits(zeros) + uint32((uint64(1)<<uint(zeros))-1)
+}
+
+// ParseAv1SequenceHeader parses the payload of one sequence header OBU.
+func ParseAv1SequenceHeader(payload []byte) (*Av1SequenceHeader, error) {
+	r := NewBitReader(payload)
+	s := &Av1SequenceHeader{}
+	s.Profile = uint8(r.ReadBits(3))
+	s.StillPicture = r.ReadFlag()
+	s.ReducedStillPictureHeader = r.ReadFlag()
+	if s.ReducedStillPictureHeader {
+		s.OperatingPoints = 1
+		s.LevelIdx = uint8(r.ReadBits(5))
+	} else {
+		var decoderModelInfo bool
+		var bufferDelayLength int
+		s.TimingInfoPresent = r.ReadFlag()
+		if s.TimingInfoPresent {
+			s.NumUnitsInDisplayTick = r.ReadBits(32)
+			s.TimeScale = r.ReadBits(32)
+			if r.ReadFlag() { // equal_picture_interval
+				readAv1Uvlc(r) // num_ticks_per_picture_minus_1
+			}
+			decoderModelInfo = r.ReadFlag()
+			if decoderModelInfo {
+				bufferDelayLength = int(r.ReadBits(5)) + 1
+				r.SkipBits(32) // num_units_in_decoding_tick
+				r.SkipBits(10) // buffer_removal_time_length_minus_1, frame_presentation_time_length_minus_1
+			}
+		}
+		initialDisplayDelay := r.ReadFlag()
+		s.OperatingPoints = int(r.ReadBits(5)) + 1
+		for i := 0; i < s.OperatingPoints; i++ {
+			idc := r.ReadBits(12)
+			level := uint8(r.ReadBits(5))
+			var tier uint8
+			if level > 7 {
+				tier = uint8(r.ReadBits(1))
+			}
+			if i == 0 {
+				s.OperatingPointIdc = idc
+				s.LevelIdx = level
+				s.Tier = tier
+			}
+			if decoderModelInfo && r.ReadFlag() {
+				// decoder_buffer_delay, encoder_buffer_delay, low_delay_mode_flag
+				r.SkipBits(2*bufferDelayLength + 1)
+			}
+			if initialDisplayDelay && r.ReadFlag() {
+				r.SkipBits(4) // initial_display_delay_minus_1
+			}
+		}
+	}
+	widthBits := int(r.ReadBits(4)) + 1
+	heightBits := int(r.ReadBits(4)) + 1
+	s.MaxWidth = int(r.ReadBits(widthBits)) + 1
+	s.MaxHeight = int(r.ReadBits(heightBits)) + 1
+	if r.Err() != nil {
+		return nil, NewError2(r.Err(), "AV1 sequence header invalid")
+	}
+	return s, nil
+}
+
+// GetAv1FrameType returns the frame_type of one frame header or frame OBU
+// payload(AV1 spec 5.9.2), and -1 for show_existing_frame.
+func GetAv1FrameType(payload []byte, seq *Av1SequenceHeader) (int, error) {
+	if seq != nil && seq.ReducedStillPictureHeader {
+		return AV1_KEY_FRAME, nil
+	}
+	if len(payload) < 1 {
+		return 0, NewErrorf("AV1 frame header insufficient: %d", len(payload))
+	}
+	if payload[0]&0x80 != 0 { // show_existing_frame
+		return -1, nil
+	}
+	return int(payload[0]>>5) & 0x03, nil
+}
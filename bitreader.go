@@ -0,0 +1,160 @@
+package goutil
+
+// BitReader reads the MSB-first bits of video bitstreams(e.g. H.264/H.265 RBSP,
+// VP9/AV1 headers). The first error is kept and later reads return zero, so
+// that a parser can check Err() once after a group of fields.
+type BitReader struct {
+	data   []byte
+	offset int // bit offset
+	err    error
+}
+
+func NewBitReader(data []byte) *BitReader {
+	return &BitReader{data: data}
+}
+
+// Err returns the first error of reading.
+func (r *BitReader) Err() error {
+	return r.err
+}
+
+// Offset returns the number of bits read.
+func (r *BitReader) Offset() int {
+	return r.offset
+}
+
+// BitsLeft returns the number of bits unread.
+func (r *BitReader) BitsLeft() int {
+	return len(r.data)*8 - r.offset
+}
+
+// ByteAligned checks whether the offset is at a byte boundary.
+func (r *BitReader) ByteAligned() bool {
+	return r.offset%8 == 0
+}
+
+// ReadBits reads n(<=32) bits as an unsigned number.
+func (r *BitReader) ReadBits(n int) uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if n < 0 || n > 32 {
+		r.err = NewErrorf("BitReader invalid bits: %d", n)
+		return 0
+	}
+	if n > r.BitsLeft() {
+		r.err = NewErrorf("BitReader insufficient: %d > %d", n, r.BitsLeft())
+		return 0
+	}
+
+	var value uint32
+	for i := 0; i < n; i++ {
+		bit := (r.data[r.offset>>3] >> (7 - uint(r.offset&0x7))) & 0x1
+		value = (value << 1) | uint32(bit)
+		r.offset++
+	}
+	return value
+}
+
+// ReadBits64 reads n(<=64) bits as an unsigned number.
+func (r *BitReader) ReadBits64(n int) uint64 {
+	if n > 32 {
+		high := uint64(r.ReadBits(n - 32))
+		return (high << 32) | uint64(r.ReadBits(32))
+	}
+	return uint64(r.ReadBits(n))
+}
+
+// ReadBit reads one bit.
+func (r *BitReader) ReadBit() uint32 {
+	return r.ReadBits(1)
+}
+
+// ReadFlag reads one bit as bool.
+func (r *BitReader) ReadFlag() bool {
+	return r.ReadBits(1) == 1
+}
+
+// SkipBits skips n bits.
+func (r *BitReader) SkipBits(n int) {
+	if r.err != nil {
+		return
+	}
+	if n < 0 || n > r.BitsLeft() {
+		r.err = NewErrorf("BitReader insufficient to skip: %d > %d", n, r.BitsLeft())
+		return
+	}
+	r.offset += n
+}
+
+// ByteAlign skips the bits to the next byte boundary.
+func (r *BitReader) ByteAlign() {
+	if rem := r.offset % 8; rem != 0 {
+		r.SkipBits(8 - rem)
+	}
+}
+
+// ReadUe reads one unsigned exp-Golomb code, ue(v).
+func (r *BitReader) ReadUe() uint32 {
+	zeros := 0
+	for r.err == nil && r.ReadBit() == 0 {
+		zeros++
+		if zeros > 31 {
+			r.err = NewErrorf("BitReader exp-Golomb overflow")
+			return 0
+		}
+	}
+	if r.err != nil {
+		return 0
+	}
+	return uint32((uint64(1)<<uint(zeros))-1) + r.ReadBits(zeros)
+}
+
+// ReadSe reads one signed exp-Golomb code, se(v).
+func (r *BitReader) ReadSe() int32 {
+	value := r.ReadUe()
+	if value&0x1 == 1 {
+		return int32((value + 1) / 2)
+	}
+	return -int32(value / 2)
+}
+
+// MoreRbspData checks whether there is more data before the rbsp_trailing_bits.
+func (r *BitReader) MoreRbspData() bool {
+	if r.err != nil {
+		return false
+	}
+	// the position of rbsp_stop_one_bit(the last bit 1)
+	last := len(r.data) - 1
+	for last >= 0 && r.data[last] == 0 {
+		last--
+	}
+	if last < 0 {
+		return false
+	}
+	stop := last*8 + 7
+	for bit := r.data[last]; bit&0x1 == 0; bit >>= 1 {
+		stop--
+	}
+	return r.offset < stop
+}
+
+// RemoveEmulationPrevention converts the payload of one NAL unit to RBSP, i.e.
+// removes the emulation_prevention_three_byte of 00 00 03.
+func RemoveEmulationPrevention(data []byte) []byte {
+	rbsp := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		rbsp = append(rbsp, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return rbsp
+}
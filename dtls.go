@@ -0,0 +1,729 @@
+package goutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+/*
+ * DTLS 1.2(RFC 6347) for DTLS-SRTP(RFC 5764).
+ *
+ * Record:
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * | content type  |    version    |     epoch     |               |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+               +
+ * |                  sequence number(48-bit)                      |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |    length     |  fragment ...
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * Handshake fragment:
+ *   msg_type(1) | length(3) | message_seq(2) | fragment_offset(3) | fragment_length(3) | body
+ */
+
+const (
+	kDtlsVersion10 uint16 = 0xFEFF
+	kDtlsVersion12 uint16 = 0xFEFD
+
+	kDtlsContentChangeCipherSpec uint8 = 20
+	kDtlsContentAlert            uint8 = 21
+	kDtlsContentHandshake        uint8 = 22
+	kDtlsContentApplicationData  uint8 = 23
+
+	kDtlsHandshakeClientHello        uint8 = 1
+	kDtlsHandshakeServerHello        uint8 = 2
+	kDtlsHandshakeHelloVerifyRequest uint8 = 3
+	kDtlsHandshakeCertificate        uint8 = 11
+	kDtlsHandshakeServerKeyExchange  uint8 = 12
+	kDtlsHandshakeCertificateRequest uint8 = 13
+	kDtlsHandshakeServerHelloDone    uint8 = 14
+	kDtlsHandshakeCertificateVerify  uint8 = 15
+	kDtlsHandshakeClientKeyExchange  uint8 = 16
+	kDtlsHandshakeFinished           uint8 = 20
+
+	kDtlsExtSupportedGroups         uint16 = 10
+	kDtlsExtPointFormats            uint16 = 11
+	kDtlsExtSignatureAlgorithms     uint16 = 13
+	kDtlsExtUseSrtp                 uint16 = 14
+	kDtlsExtExtendedMasterSecret    uint16 = 23
+	kDtlsExtRenegotiationInfo       uint16 = 0xFF01
+	kDtlsEmptyRenegotiationInfoScsv        = 0x00FF // the cipher suite of empty renegotiation_info
+	kDtlsPointFormatUncompressed    uint8  = 0
+	kDtlsAlertLevelWarning          uint8  = 1
+	kDtlsAlertLevelFatal            uint8  = 2
+	kDtlsAlertCloseNotify           uint8  = 0
+	kDtlsHandshakeHeaderLen                = 12
+	kDtlsMaxSessionIdLen                   = 32
+	kDtlsCookieLen                         = 20
+	kDtlsDefaultMtu                        = 1200
+	kDtlsMaxHandshakeLen                   = 0x10000
+	kDtlsMaxHandshakeQueue                 = 16
+	kDtlsSrtpExporterLabel                 = "EXTRACTOR-dtls_srtp"
+)
+
+// dtlsRecordHeader is the header of one record.
+type dtlsRecordHeader struct {
+	contentType uint8
+	version     uint16
+	epoch       uint16
+	sequence    uint64 // 48-bit
+	length      uint16
+}
+
+func (h *dtlsRecordHeader) marshalTo(buf []byte) {
+	buf[0] = h.contentType
+	binary.BigEndian.PutUint16(buf[1:], h.version)
+	binary.BigEndian.PutUint16(buf[3:], h.epoch)
+	dtlsPutUint48(buf[5:], h.sequence)
+	binary.BigEndian.PutUint16(buf[11:], h.length)
+}
+
+func (h *dtlsRecordHeader) unmarshal(data []byte) error {
+	if len(data) < kDtlsRecordHeaderLen {
+		return NewErrorf("DTLS record header insufficient: %d", len(data))
+	}
+	h.contentType = data[0]
+	h.version = binary.BigEndian.Uint16(data[1:])
+	h.epoch = binary.BigEndian.Uint16(data[3:])
+	h.sequence = dtlsUint48(data[5:])
+	h.length = binary.BigEndian.Uint16(data[11:])
+	if kDtlsRecordHeaderLen+int(h.length) > len(data) {
+		return NewErrorf("DTLS record insufficient: %d > %d", h.length, len(data)-kDtlsRecordHeaderLen)
+	}
+	return nil
+}
+
+// dtlsHandshakeHeader is the header of one handshake fragment.
+type dtlsHandshakeHeader struct {
+	msgType        uint8
+	length         uint32
+	messageSeq     uint16
+	fragmentOffset uint32
+	fragmentLength uint32
+}
+
+func (h *dtlsHandshakeHeader) marshalTo(buf []byte) {
+	buf[0] = h.msgType
+	dtlsPutUint24(buf[1:], h.length)
+	binary.BigEndian.PutUint16(buf[4:], h.messageSeq)
+	dtlsPutUint24(buf[6:], h.fragmentOffset)
+	dtlsPutUint24(buf[9:], h.fragmentLength)
+}
+
+func (h *dtlsHandshakeHeader) unmarshal(data []byte) error {
+	if len(data) < kDtlsHandshakeHeaderLen {
+		return NewErrorf("DTLS handshake header insufficient: %d", len(data))
+	}
+	h.msgType = data[0]
+	h.length = dtlsUint24(data[1:])
+	h.messageSeq = binary.BigEndian.Uint16(data[4:])
+	h.fragmentOffset = dtlsUint24(data[6:])
+	h.fragmentLength = dtlsUint24(data[9:])
+	if h.length >= kDtlsMaxHandshakeLen || h.fragmentOffset+h.fragmentLength > h.length {
+		return NewErrorf("DTLS handshake fragment invalid: %d+%d > %d", h.fragmentOffset, h.fragmentLength, h.length)
+	}
+	if kDtlsHandshakeHeaderLen+int(h.fragmentLength) > len(data) {
+		return NewErrorf("DTLS handshake fragment insufficient: %d", len(data))
+	}
+	return nil
+}
+
+// marshalDtlsHandshake returns the unfragmented message, which is also used
+// for the handshake hash.
+func marshalDtlsHandshake(msgType uint8, messageSeq uint16, body []byte) []byte {
+	buf := make([]byte, kDtlsHandshakeHeaderLen+len(body))
+	header := dtlsHandshakeHeader{
+		msgType:        msgType,
+		length:         uint32(len(body)),
+		messageSeq:     messageSeq,
+		fragmentLength: uint32(len(body)),
+	}
+	header.marshalTo(buf)
+	copy(buf[kDtlsHandshakeHeaderLen:], body)
+	return buf
+}
+
+func dtlsPutUint24(buf []byte, value uint32) {
+	buf[0] = byte(value >> 16)
+	buf[1] = byte(value >> 8)
+	buf[2] = byte(value)
+}
+
+func dtlsUint24(data []byte) uint32 {
+	return uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2])
+}
+
+func dtlsPutUint48(buf []byte, value uint64) {
+	binary.BigEndian.PutUint16(buf, uint16(value>>32))
+	binary.BigEndian.PutUint32(buf[2:], uint32(value))
+}
+
+func dtlsUint48(data []byte) uint64 {
+	return uint64(binary.BigEndian.Uint16(data))<<32 | uint64(binary.BigEndian.Uint32(data[2:]))
+}
+
+// dtlsBuilder appends the fields of handshake messages.
+type dtlsBuilder struct {
+	buf []byte
+}
+
+func (b *dtlsBuilder) addUint8(value uint8) {
+	b.buf = append(b.buf, value)
+}
+
+func (b *dtlsBuilder) addUint16(value uint16) {
+	b.buf = append(b.buf, byte(value>>8), byte(value))
+}
+
+func (b *dtlsBuilder) addUint24(value uint32) {
+	b.buf = append(b.buf, byte(value>>16), byte(value>>8), byte(value))
+}
+
+func (b *dtlsBuilder) addBytes(data []byte) {
+	b.buf = append(b.buf, data...)
+}
+
+// addVector appends data with the length prefix of size(1~3) bytes.
+func (b *dtlsBuilder) addVector(size int, data []byte) {
+	switch size {
+	case 1:
+		b.addUint8(uint8(len(data)))
+	case 2:
+		b.addUint16(uint16(len(data)))
+	default:
+		b.addUint24(uint32(len(data)))
+	}
+	b.addBytes(data)
+}
+
+// dtlsReader reads the fields of handshake messages, and ok is false after
+// any insufficient reading.
+type dtlsReader struct {
+	data []byte
+	ok   bool
+}
+
+func newDtlsReader(data []byte) *dtlsReader {
+	return &dtlsReader{data: data, ok: true}
+}
+
+func (r *dtlsReader) readBytes(n int) []byte {
+	if !r.ok || n > len(r.data) {
+		r.ok = false
+		return nil
+	}
+	value := r.data[:n]
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *dtlsReader) readUint8() uint8 {
+	if value := r.readBytes(1); value != nil {
+		return value[0]
+	}
+	return 0
+}
+
+func (r *dtlsReader) readUint16() uint16 {
+	if value := r.readBytes(2); value != nil {
+		return binary.BigEndian.Uint16(value)
+	}
+	return 0
+}
+
+func (r *dtlsReader) readUint24() uint32 {
+	if value := r.readBytes(3); value != nil {
+		return dtlsUint24(value)
+	}
+	return 0
+}
+
+// readVector reads data with the length prefix of size(1~3) bytes.
+func (r *dtlsReader) readVector(size int) []byte {
+	var n int
+	switch size {
+	case 1:
+		n = int(r.readUint8())
+	case 2:
+		n = int(r.readUint16())
+	default:
+		n = int(r.readUint24())
+	}
+	if !r.ok {
+		return nil
+	}
+	return r.readBytes(n)
+}
+
+func (r *dtlsReader) empty() bool {
+	return r.ok && len(r.data) == 0
+}
+
+// dtlsExtensions are the supported hello extensions.
+type dtlsExtensions struct {
+	curves               []uint16
+	pointFormats         []uint8
+	signatureAlgorithms  []uint16
+	srtpProfiles         []SrtpProtectionProfile
+	srtpMki              []byte
+	extendedMasterSecret bool
+	renegotiationInfo    bool
+}
+
+func (e *dtlsExtensions) marshal() []byte {
+	b := &dtlsBuilder{}
+	if len(e.curves) > 0 {
+		list := &dtlsBuilder{}
+		for _, curve := range e.curves {
+			list.addUint16(curve)
+		}
+		b.addUint16(kDtlsExtSupportedGroups)
+		b.addUint16(uint16(2 + len(list.buf)))
+		b.addVector(2, list.buf)
+	}
+	if len(e.pointFormats) > 0 {
+		b.addUint16(kDtlsExtPointFormats)
+		b.addUint16(uint16(1 + len(e.pointFormats)))
+		b.addVector(1, e.pointFormats)
+	}
+	if len(e.signatureAlgorithms) > 0 {
+		list := &dtlsBuilder{}
+		for _, algorithm := range e.signatureAlgorithms {
+			list.addUint16(algorithm)
+		}
+		b.addUint16(kDtlsExtSignatureAlgorithms)
+		b.addUint16(uint16(2 + len(list.buf)))
+		b.addVector(2, list.buf)
+	}
+	if len(e.srtpProfiles) > 0 {
+		list := &dtlsBuilder{}
+		for _, profile := range e.srtpProfiles {
+			list.addUint16(uint16(profile))
+		}
+		b.addUint16(kDtlsExtUseSrtp)
+		b.addUint16(uint16(2 + len(list.buf) + 1 + len(e.srtpMki)))
+		b.addVector(2, list.buf)
+		b.addVector(1, e.srtpMki)
+	}
+	if e.extendedMasterSecret {
+		b.addUint16(kDtlsExtExtendedMasterSecret)
+		b.addUint16(0)
+	}
+	if e.renegotiationInfo {
+		b.addUint16(kDtlsExtRenegotiationInfo)
+		b.addUint16(1)
+		b.addUint8(0)
+	}
+	return b.buf
+}
+
+// unmarshal parses the extensions block, and the unknown ones are ignored.
+func (e *dtlsExtensions) unmarshal(data []byte) error {
+	r := newDtlsReader(data)
+	for r.ok && len(r.data) > 0 {
+		extType := r.readUint16()
+		extData := newDtlsReader(r.readVector(2))
+		if !r.ok {
+			break
+		}
+		switch extType {
+		case kDtlsExtSupportedGroups:
+			list := newDtlsReader(extData.readVector(2))
+			for list.ok && len(list.data) >= 2 {
+				e.curves = append(e.curves, list.readUint16())
+			}
+		case kDtlsExtPointFormats:
+			e.pointFormats = extData.readVector(1)
+		case kDtlsExtSignatureAlgorithms:
+			list := newDtlsReader(extData.readVector(2))
+			for list.ok && len(list.data) >= 2 {
+				e.signatureAlgorithms = append(e.signatureAlgorithms, list.readUint16())
+			}
+		case kDtlsExtUseSrtp:
+			list := newDtlsReader(extData.readVector(2))
+			for list.ok && len(list.data) >= 2 {
+				e.srtpProfiles = append(e.srtpProfiles, SrtpProtectionProfile(list.readUint16()))
+			}
+			e.srtpMki = extData.readVector(1)
+		case kDtlsExtExtendedMasterSecret:
+			e.extendedMasterSecret = true
+		case kDtlsExtRenegotiationInfo:
+			e.renegotiationInfo = true
+		}
+		if !extData.ok {
+			return NewErrorf("DTLS extension %d invalid", extType)
+		}
+	}
+	if !r.ok {
+		return NewErrorf("DTLS extensions invalid")
+	}
+	return nil
+}
+
+// dtlsClientHello is the message of ClientHello.
+type dtlsClientHello struct {
+	version      uint16
+	random       []byte
+	sessionId    []byte
+	cookie       []byte
+	cipherSuites []uint16
+	extensions   dtlsExtensions
+}
+
+func (m *dtlsClientHello) marshal() []byte {
+	b := &dtlsBuilder{}
+	b.addUint16(m.version)
+	b.addBytes(m.random)
+	b.addVector(1, m.sessionId)
+	b.addVector(1, m.cookie)
+	suites := &dtlsBuilder{}
+	for _, suite := range m.cipherSuites {
+		suites.addUint16(suite)
+	}
+	b.addVector(2, suites.buf)
+	b.addVector(1, []byte{0}) // null compression
+	b.addVector(2, m.extensions.marshal())
+	return b.buf
+}
+
+func (m *dtlsClientHello) unmarshal(data []byte) error {
+	r := newDtlsReader(data)
+	m.version = r.readUint16()
+	m.random = r.readBytes(kDtlsRandomLen)
+	m.sessionId = r.readVector(1)
+	m.cookie = r.readVector(1)
+	suites := newDtlsReader(r.readVector(2))
+	for suites.ok && len(suites.data) >= 2 {
+		m.cipherSuites = append(m.cipherSuites, suites.readUint16())
+	}
+	r.readVector(1) // compression methods
+	if !r.ok || len(m.sessionId) > kDtlsMaxSessionIdLen {
+		return NewErrorf("DTLS ClientHello invalid")
+	}
+	if len(r.data) > 0 {
+		return m.extensions.unmarshal(r.readVector(2))
+	}
+	return nil
+}
+
+// dtlsServerHello is the message of ServerHello.
+type dtlsServerHello struct {
+	version     uint16
+	random      []byte
+	sessionId   []byte
+	cipherSuite uint16
+	extensions  dtlsExtensions
+}
+
+func (m *dtlsServerHello) marshal() []byte {
+	b := &dtlsBuilder{}
+	b.addUint16(m.version)
+	b.addBytes(m.random)
+	b.addVector(1, m.sessionId)
+	b.addUint16(m.cipherSuite)
+	b.addUint8(0) // null compression
+	b.addVector(2, m.extensions.marshal())
+	return b.buf
+}
+
+func (m *dtlsServerHello) unmarshal(data []byte) error {
+	r := newDtlsReader(data)
+	m.version = r.readUint16()
+	m.random = r.readBytes(kDtlsRandomLen)
+	m.sessionId = r.readVector(1)
+	m.cipherSuite = r.readUint16()
+	compression := r.readUint8()
+	if !r.ok || compression != 0 {
+		return NewErrorf("DTLS ServerHello invalid")
+	}
+	if len(r.data) > 0 {
+		return m.extensions.unmarshal(r.readVector(2))
+	}
+	return nil
+}
+
+// marshalDtlsHelloVerifyRequest returns the body of HelloVerifyRequest.
+func marshalDtlsHelloVerifyRequest(cookie []byte) []byte {
+	b := &dtlsBuilder{}
+	b.addUint16(kDtlsVersion10)
+	b.addVector(1, cookie)
+	return b.buf
+}
+
+func unmarshalDtlsHelloVerifyRequest(data []byte) ([]byte, error) {
+	r := newDtlsReader(data)
+	r.readUint16()
+	cookie := r.readVector(1)
+	if !r.ok {
+		return nil, NewErrorf("DTLS HelloVerifyRequest invalid")
+	}
+	return cookie, nil
+}
+
+// marshalDtlsCertificate returns the body of Certificate.
+func marshalDtlsCertificate(certs [][]byte) []byte {
+	list := &dtlsBuilder{}
+	for _, cert := range certs {
+		list.addVector(3, cert)
+	}
+	b := &dtlsBuilder{}
+	b.addVector(3, list.buf)
+	return b.buf
+}
+
+func unmarshalDtlsCertificate(data []byte) ([][]byte, error) {
+	r := newDtlsReader(data)
+	list := newDtlsReader(r.readVector(3))
+	var certs [][]byte
+	for list.ok && len(list.data) > 0 {
+		if cert := list.readVector(3); list.ok {
+			certs = append(certs, cert)
+		}
+	}
+	if !r.ok || !list.ok {
+		return nil, NewErrorf("DTLS Certificate invalid")
+	}
+	return certs, nil
+}
+
+// dtlsServerKeyExchange is the message of ServerKeyExchange for ECDHE.
+type dtlsServerKeyExchange struct {
+	curve     uint16
+	public    []byte
+	algorithm uint16
+	signature []byte
+}
+
+// params returns the ServerECDHParams which are signed.
+func (m *dtlsServerKeyExchange) params() []byte {
+	b := &dtlsBuilder{}
+	b.addUint8(kDtlsEcCurveTypeNamed)
+	b.addUint16(m.curve)
+	b.addVector(1, m.public)
+	return b.buf
+}
+
+func (m *dtlsServerKeyExchange) marshal() []byte {
+	b := &dtlsBuilder{buf: m.params()}
+	b.addUint16(m.algorithm)
+	b.addVector(2, m.signature)
+	return b.buf
+}
+
+func (m *dtlsServerKeyExchange) unmarshal(data []byte) error {
+	r := newDtlsReader(data)
+	if curveType := r.readUint8(); curveType != kDtlsEcCurveTypeNamed {
+		return NewErrorf("DTLS ServerKeyExchange curve type unsupported: %d", curveType)
+	}
+	m.curve = r.readUint16()
+	m.public = r.readVector(1)
+	m.algorithm = r.readUint16()
+	m.signature = r.readVector(2)
+	if !r.ok {
+		return NewErrorf("DTLS ServerKeyExchange invalid")
+	}
+	return nil
+}
+
+// marshalDtlsCertificateRequest returns the body of CertificateRequest.
+func marshalDtlsCertificateRequest(algorithms []uint16) []byte {
+	b := &dtlsBuilder{}
+	b.addVector(1, []byte{kDtlsCertTypeRsaSign, kDtlsCertTypeEcdsaSign})
+	list := &dtlsBuilder{}
+	for _, algorithm := range algorithms {
+		list.addUint16(algorithm)
+	}
+	b.addVector(2, list.buf)
+	b.addVector(2, nil) // certificate authorities
+	return b.buf
+}
+
+func unmarshalDtlsCertificateRequest(data []byte) ([]uint16, error) {
+	r := newDtlsReader(data)
+	r.readVector(1)
+	list := newDtlsReader(r.readVector(2))
+	var algorithms []uint16
+	for list.ok && len(list.data) >= 2 {
+		algorithms = append(algorithms, list.readUint16())
+	}
+	r.readVector(2)
+	if !r.ok {
+		return nil, NewErrorf("DTLS CertificateRequest invalid")
+	}
+	return algorithms, nil
+}
+
+// marshalDtlsSignature returns the body of CertificateVerify(or the signature of ServerKeyExchange).
+func marshalDtlsSignature(algorithm uint16, signature []byte) []byte {
+	b := &dtlsBuilder{}
+	b.addUint16(algorithm)
+	b.addVector(2, signature)
+	return b.buf
+}
+
+func unmarshalDtlsSignature(data []byte) (uint16, []byte, error) {
+	r := newDtlsReader(data)
+	algorithm := r.readUint16()
+	signature := r.readVector(2)
+	if !r.ok {
+		return 0, nil, NewErrorf("DTLS CertificateVerify invalid")
+	}
+	return algorithm, signature, nil
+}
+
+// DtlsConfig is the configuration of one DTLS session for DTLS-SRTP.
+type DtlsConfig struct {
+	// Certificate is the local certificate and private key(ECDSA or RSA), whose
+	// fingerprint is in the local SDP.
+	Certificate tls.Certificate
+
+	// SrtpProfiles are the SRTP profiles by priority, and nil for all supported.
+	SrtpProfiles []SrtpProtectionProfile
+
+	// RemoteFingerprint is the peer a=fingerprint(e.g. "sha-256" and "AB:CD:.."),
+	// and the peer certificate is required to match it.
+	RemoteFingerprint StringPair
+
+	// InsecureSkipVerify allows the empty RemoteFingerprint, and then the peer
+	// certificate is not checked(e.g. for testing), which is open to MITM.
+	InsecureSkipVerify bool
+
+	// MTU is the max size of datagrams, and <=0 for default.
+	MTU int
+}
+
+func (c *DtlsConfig) getSrtpProfiles() []SrtpProtectionProfile {
+	if len(c.SrtpProfiles) == 0 {
+		return []SrtpProtectionProfile{
+			SRTP_AEAD_AES_128_GCM,
+			SRTP_AEAD_AES_256_GCM,
+			SRTP_AES128_CM_HMAC_SHA1_80,
+			SRTP_AES128_CM_HMAC_SHA1_32,
+		}
+	}
+	return c.SrtpProfiles
+}
+
+func (c *DtlsConfig) getMtu() int {
+	if c.MTU <= 0 {
+		return kDtlsDefaultMtu
+	}
+	return c.MTU
+}
+
+// LoadDtlsCertificate loads the PEM certificate and private key.
+func LoadDtlsCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return cert, NewError2(err, "fail to load DTLS certificate")
+	}
+	return cert, nil
+}
+
+// GenerateDtlsCertificate generates one self-signed ECDSA(P-256) certificate.
+func GenerateDtlsCertificate(commonName string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, NewError2(err, "fail to generate DTLS key")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 63))
+	if err != nil {
+		return tls.Certificate{}, NewError2(err, "fail to generate DTLS serial")
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-24 * time.Hour),
+		NotAfter:     now.Add(30 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, NewError2(err, "fail to create DTLS certificate")
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+func dtlsFingerprintHash(algorithm string) hash.Hash {
+	switch strings.ToLower(algorithm) {
+	case "sha-1":
+		return sha1.New()
+	case "sha-224":
+		return sha256.New224()
+	case "sha-256":
+		return sha256.New()
+	case "sha-384":
+		return sha512.New384()
+	case "sha-512":
+		return sha512.New()
+	}
+	return nil
+}
+
+// DtlsFingerprint returns the fingerprint(RFC 8122) of the DER certificate,
+// e.g. "AB:CD:..", with the hash algorithm(e.g. "sha-256").
+func DtlsFingerprint(cert []byte, algorithm string) (string, error) {
+	h := dtlsFingerprintHash(algorithm)
+	if h == nil {
+		return "", NewErrorf("fingerprint algorithm unsupported: %s", algorithm)
+	}
+	h.Write(cert)
+	var parts []string
+	for _, value := range h.Sum(nil) {
+		parts = append(parts, fmt.Sprintf("%02X", value))
+	}
+	return strings.Join(parts, ":"), nil
+}
+
+// VerifyDtlsFingerprint checks the DER certificate against the SDP fingerprint.
+func VerifyDtlsFingerprint(cert []byte, fingerprint StringPair) error {
+	value, err := DtlsFingerprint(cert, fingerprint.First)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(value, strings.TrimSpace(fingerprint.Second)) {
+		return NewErrorf("DTLS fingerprint mismatch: %s", fingerprint.ToString(" "))
+	}
+	return nil
+}
+
+// dtlsSigner returns the signer and leaf certificate of tls.Certificate.
+func dtlsSigner(cert *tls.Certificate) (crypto.Signer, *x509.Certificate, error) {
+	if len(cert.Certificate) == 0 {
+		return nil, nil, NewErrorf("DTLS certificate empty")
+	}
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, NewErrorf("DTLS private key unsupported")
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, nil, NewError2(err, "DTLS certificate invalid")
+		}
+	}
+	switch signer.Public().(type) {
+	case *ecdsa.PublicKey:
+	case *rsa.PublicKey:
+	default:
+		return nil, nil, NewErrorf("DTLS private key unsupported")
+	}
+	return signer, leaf, nil
+}
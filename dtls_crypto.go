@@ -0,0 +1,305 @@
+package goutil
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"hash"
+)
+
+// The cipher suites(RFC 5289) of DTLS 1.2, and only the AEAD ones are supported.
+const (
+	DTLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 uint16 = 0xC02B
+	DTLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 uint16 = 0xC02C
+	DTLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256   uint16 = 0xC02F
+	DTLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384   uint16 = 0xC030
+)
+
+// The named curves(RFC 8422) of ECDHE.
+const (
+	kDtlsCurveP256 uint16 = 23
+	kDtlsCurveP384 uint16 = 24
+)
+
+// The signature algorithms(RFC 5246 7.4.1.4.1) of hash and signature.
+const (
+	kDtlsSignatureRsaSha256   uint16 = 0x0401
+	kDtlsSignatureRsaSha384   uint16 = 0x0501
+	kDtlsSignatureEcdsaSha256 uint16 = 0x0403
+	kDtlsSignatureEcdsaSha384 uint16 = 0x0503
+)
+
+const (
+	kDtlsGcmKeyLen128      = 16
+	kDtlsGcmKeyLen256      = 32
+	kDtlsGcmImplicitIvLen  = 4
+	kDtlsGcmExplicitIvLen  = 8
+	kDtlsGcmTagLen         = 16
+	kDtlsVerifyDataLen     = 12
+	kDtlsMasterSecretLen   = 48
+	kDtlsRandomLen         = 32
+	kDtlsEcCurveTypeNamed  = 3
+	kDtlsCertTypeRsaSign   = 1
+	kDtlsCertTypeEcdsaSign = 64
+)
+
+// dtlsCipherSuite is one supported cipher suite.
+type dtlsCipherSuite struct {
+	id     uint16
+	ecdsa  bool // the certificate type, or RSA
+	keyLen int
+	hash   func() hash.Hash
+}
+
+var kDtlsCipherSuites = []*dtlsCipherSuite{
+	{DTLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, true, kDtlsGcmKeyLen128, sha256.New},
+	{DTLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, false, kDtlsGcmKeyLen128, sha256.New},
+	{DTLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, true, kDtlsGcmKeyLen256, sha512.New384},
+	{DTLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, false, kDtlsGcmKeyLen256, sha512.New384},
+}
+
+func dtlsCipherSuiteOf(id uint16) *dtlsCipherSuite {
+	for _, suite := range kDtlsCipherSuites {
+		if suite.id == id {
+			return suite
+		}
+	}
+	return nil
+}
+
+var kDtlsCurves = []uint16{kDtlsCurveP256, kDtlsCurveP384}
+
+func dtlsCurveOf(id uint16) ecdh.Curve {
+	switch id {
+	case kDtlsCurveP256:
+		return ecdh.P256()
+	case kDtlsCurveP384:
+		return ecdh.P384()
+	}
+	return nil
+}
+
+var kDtlsSignatureAlgorithms = []uint16{
+	kDtlsSignatureEcdsaSha256,
+	kDtlsSignatureRsaSha256,
+	kDtlsSignatureEcdsaSha384,
+	kDtlsSignatureRsaSha384,
+}
+
+func dtlsSignatureHash(algorithm uint16) crypto.Hash {
+	switch algorithm {
+	case kDtlsSignatureRsaSha256, kDtlsSignatureEcdsaSha256:
+		return crypto.SHA256
+	case kDtlsSignatureRsaSha384, kDtlsSignatureEcdsaSha384:
+		return crypto.SHA384
+	}
+	return 0
+}
+
+func dtlsSignatureIsEcdsa(algorithm uint16) bool {
+	return algorithm == kDtlsSignatureEcdsaSha256 || algorithm == kDtlsSignatureEcdsaSha384
+}
+
+func dtlsIsEcdsaSigner(key crypto.Signer) bool {
+	_, ok := key.Public().(*ecdsa.PublicKey)
+	return ok
+}
+
+// dtlsSelectSignature returns the first algorithm of peer supported which fits the key.
+func dtlsSelectSignature(key crypto.Signer, peerAlgorithms []uint16) (uint16, error) {
+	isEcdsa := dtlsIsEcdsaSigner(key)
+	for _, algorithm := range peerAlgorithms {
+		if dtlsSignatureHash(algorithm) != 0 && dtlsSignatureIsEcdsa(algorithm) == isEcdsa {
+			return algorithm, nil
+		}
+	}
+	return 0, NewErrorf("DTLS no signature algorithm")
+}
+
+// dtlsSign signs the data by key with the hash of algorithm.
+func dtlsSign(key crypto.Signer, algorithm uint16, data []byte) ([]byte, error) {
+	hashType := dtlsSignatureHash(algorithm)
+	if hashType == 0 {
+		return nil, NewErrorf("DTLS signature algorithm unsupported: 0x%04x", algorithm)
+	}
+	h := hashType.New()
+	h.Write(data)
+	return key.Sign(rand.Reader, h.Sum(nil), hashType)
+}
+
+// dtlsVerify verifies the signature of data by the public key of cert.
+func dtlsVerify(cert *x509.Certificate, algorithm uint16, data, signature []byte) error {
+	hashType := dtlsSignatureHash(algorithm)
+	if hashType == 0 {
+		return NewErrorf("DTLS signature algorithm unsupported: 0x%04x", algorithm)
+	}
+	h := hashType.New()
+	h.Write(data)
+	digest := h.Sum(nil)
+	switch pub := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !dtlsSignatureIsEcdsa(algorithm) || !ecdsa.VerifyASN1(pub, digest, signature) {
+			return NewErrorf("DTLS ECDSA signature invalid")
+		}
+	case *rsa.PublicKey:
+		if dtlsSignatureIsEcdsa(algorithm) {
+			return NewErrorf("DTLS RSA signature invalid")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hashType, digest, signature); err != nil {
+			return NewError2(err, "DTLS RSA signature invalid")
+		}
+	default:
+		return NewErrorf("DTLS public key unsupported")
+	}
+	return nil
+}
+
+// dtlsEcdheKey is one ephemeral ECDH key.
+type dtlsEcdheKey struct {
+	curve   ecdh.Curve
+	private *ecdh.PrivateKey
+	public  []byte // uncompressed point
+}
+
+func newDtlsEcdheKey(curveId uint16) (*dtlsEcdheKey, error) {
+	curve := dtlsCurveOf(curveId)
+	if curve == nil {
+		return nil, NewErrorf("DTLS curve unsupported: %d", curveId)
+	}
+	private, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, NewError2(err, "DTLS fail to generate ECDHE key")
+	}
+	return &dtlsEcdheKey{
+		curve:   curve,
+		private: private,
+		public:  private.PublicKey().Bytes(),
+	}, nil
+}
+
+// sharedSecret returns the premaster secret(the x-coordinate) with the peer
+// public key, which is checked on the curve.
+func (k *dtlsEcdheKey) sharedSecret(peerPublic []byte) ([]byte, error) {
+	public, err := k.curve.NewPublicKey(peerPublic)
+	if err != nil {
+		return nil, NewError2(err, "DTLS ECDHE public key invalid")
+	}
+	secret, err := k.private.ECDH(public)
+	if err != nil {
+		return nil, NewError2(err, "DTLS ECDHE shared secret invalid")
+	}
+	return secret, nil
+}
+
+// dtlsPrf is the PRF of TLS 1.2(RFC 5246 5), P_hash(secret, label + seed).
+func dtlsPrf(hashFunc func() hash.Hash, secret []byte, label string, seed []byte, size int) []byte {
+	labelSeed := append([]byte(label), seed...)
+	mac := hmac.New(hashFunc, secret)
+	mac.Write(labelSeed)
+	a := mac.Sum(nil)
+
+	out := make([]byte, 0, size+mac.Size())
+	for len(out) < size {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(labelSeed)
+		out = mac.Sum(out)
+
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+	}
+	return out[:size]
+}
+
+// dtlsRecordCipher protects the records of one epoch with AES-GCM(RFC 5288).
+type dtlsRecordCipher struct {
+	localAead  cipher.AEAD
+	localIv    []byte
+	remoteAead cipher.AEAD
+	remoteIv   []byte
+}
+
+// newDtlsRecordCipher creates the cipher with the key block of master secret.
+func newDtlsRecordCipher(suite *dtlsCipherSuite, masterSecret, clientRandom, serverRandom []byte, isClient bool) (*dtlsRecordCipher, error) {
+	seed := append(append([]byte(nil), serverRandom...), clientRandom...)
+	size := 2*suite.keyLen + 2*kDtlsGcmImplicitIvLen
+	block := dtlsPrf(suite.hash, masterSecret, "key expansion", seed, size)
+	clientKey := block[:suite.keyLen]
+	serverKey := block[suite.keyLen : 2*suite.keyLen]
+	clientIv := block[2*suite.keyLen : 2*suite.keyLen+kDtlsGcmImplicitIvLen]
+	serverIv := block[2*suite.keyLen+kDtlsGcmImplicitIvLen:]
+
+	clientAead, err := newDtlsGcm(clientKey)
+	if err != nil {
+		return nil, err
+	}
+	serverAead, err := newDtlsGcm(serverKey)
+	if err != nil {
+		return nil, err
+	}
+	if isClient {
+		return &dtlsRecordCipher{clientAead, clientIv, serverAead, serverIv}, nil
+	}
+	return &dtlsRecordCipher{serverAead, serverIv, clientAead, clientIv}, nil
+}
+
+func newDtlsGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, NewError2(err, "DTLS key invalid")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, NewError2(err, "DTLS GCM invalid")
+	}
+	return aead, nil
+}
+
+// dtlsRecordAad returns the additional data of one record.
+func dtlsRecordAad(header *dtlsRecordHeader, length int) []byte {
+	aad := make([]byte, 13)
+	binary.BigEndian.PutUint16(aad, header.epoch)
+	dtlsPutUint48(aad[2:], header.sequence)
+	aad[8] = header.contentType
+	binary.BigEndian.PutUint16(aad[9:], kDtlsVersion12)
+	binary.BigEndian.PutUint16(aad[11:], uint16(length))
+	return aad
+}
+
+// encrypt returns the fragment of record: explicit nonce | ciphertext | tag.
+func (c *dtlsRecordCipher) encrypt(header *dtlsRecordHeader, plaintext []byte) []byte {
+	nonce := make([]byte, kDtlsGcmImplicitIvLen+kDtlsGcmExplicitIvLen)
+	copy(nonce, c.localIv)
+	binary.BigEndian.PutUint16(nonce[kDtlsGcmImplicitIvLen:], header.epoch)
+	dtlsPutUint48(nonce[kDtlsGcmImplicitIvLen+2:], header.sequence)
+
+	out := make([]byte, kDtlsGcmExplicitIvLen, kDtlsGcmExplicitIvLen+len(plaintext)+kDtlsGcmTagLen)
+	copy(out, nonce[kDtlsGcmImplicitIvLen:])
+	return c.localAead.Seal(out, nonce, plaintext, dtlsRecordAad(header, len(plaintext)))
+}
+
+// decrypt returns the plaintext of one record fragment.
+func (c *dtlsRecordCipher) decrypt(header *dtlsRecordHeader, fragment []byte) ([]byte, error) {
+	if len(fragment) < kDtlsGcmExplicitIvLen+kDtlsGcmTagLen {
+		return nil, NewErrorf("DTLS encrypted record insufficient: %d", len(fragment))
+	}
+	nonce := make([]byte, kDtlsGcmImplicitIvLen+kDtlsGcmExplicitIvLen)
+	copy(nonce, c.remoteIv)
+	copy(nonce[kDtlsGcmImplicitIvLen:], fragment[:kDtlsGcmExplicitIvLen])
+	length := len(fragment) - kDtlsGcmExplicitIvLen - kDtlsGcmTagLen
+	plaintext, err := c.remoteAead.Open(nil, nonce, fragment[kDtlsGcmExplicitIvLen:], dtlsRecordAad(header, length))
+	if err != nil {
+		return nil, NewError2(err, "DTLS record authentication failed")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,922 @@
+package goutil
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"sync"
+)
+
+/*
+ * The handshake of DTLS-SRTP with cookie exchange and client authentication:
+ *
+ *   Client                                   Server
+ *   ClientHello                  -------->                    (flight 1)
+ *                                <--------   HelloVerifyRequest (flight 2)
+ *   ClientHello(with cookie)     -------->                    (flight 3)
+ *                                            ServerHello
+ *                                            Certificate
+ *                                            ServerKeyExchange
+ *                                            CertificateRequest
+ *                                <--------   ServerHelloDone  (flight 4)
+ *   Certificate
+ *   ClientKeyExchange
+ *   CertificateVerify
+ *   [ChangeCipherSpec]
+ *   Finished                     -------->                    (flight 5)
+ *                                            [ChangeCipherSpec]
+ *                                <--------   Finished         (flight 6)
+ *
+ * The last flight is sent again when the timer expires(with doubled timeout),
+ * or when the peer's previous flight is received again. The final flight 6
+ * has no timer, and is only sent again for the retransmitted flight 5.
+ */
+
+type dtlsState int
+
+const (
+	kDtlsStateInit dtlsState = iota
+	kDtlsStateClientWaitHello
+	kDtlsStateClientWaitHelloDone
+	kDtlsStateClientWaitFinished
+	kDtlsStateServerWaitHello
+	kDtlsStateServerWaitFinished
+	kDtlsStateComplete
+	kDtlsStateClosed
+)
+
+const (
+	kDtlsInitialRtoMs    int64 = 1000
+	kDtlsMaxRtoMs        int64 = 60000
+	kDtlsMaxRetransmits        = 6
+	kDtlsCookieSecretLen       = 32
+)
+
+// dtlsFlightMessage is one message of the last flight, which is fragmented
+// and protected again in each retransmission.
+type dtlsFlightMessage struct {
+	contentType uint8
+	epoch       uint16
+	data        []byte // the unfragmented handshake message, or ChangeCipherSpec
+}
+
+// dtlsFragmentBuffer reassembles the fragments of one handshake message.
+type dtlsFragmentBuffer struct {
+	msgType  uint8
+	body     []byte
+	received BitSet // each byte of body
+}
+
+// DtlsSession is one DTLS 1.2 client or server for DTLS-SRTP, which doesn't
+// own the socket: the DTLS packets demuxed from the UDP path(by IsDtlsPacket)
+// are pushed in, and the returned datagrams should be sent to the peer.
+//
+// The role is from SDP a=setup: the passive(e.g. CreateAnswer) one is server.
+// After IsComplete, the SRTP contexts are created by NewSrtpContexts.
+type DtlsSession struct {
+	sync.Mutex
+	config   *DtlsConfig
+	isClient bool
+	state    dtlsState
+	err      error
+	signer   crypto.Signer
+	mtu      int
+
+	// record layer
+	localSeqs    [2]uint64        // the next record sequence of epoch 0/1
+	remoteReplay srtpReplayWindow // epoch 1 only, epoch 0 is unauthenticated
+	remoteEpoch  uint16           // the epoch of last authenticated record
+	cipher       *dtlsRecordCipher
+
+	// handshake messages
+	sendSeq     uint16
+	recvSeq     uint16
+	fragments   map[uint16]*dtlsFragmentBuffer
+	transcript  []byte
+	flight      []*dtlsFlightMessage
+	timerMs     int64
+	rtoMs       int64
+	retransmits int
+
+	// negotiated parameters
+	cookieSecret         []byte
+	cookieVerified       bool // the server got ClientHello with valid cookie
+	clientHello          *dtlsClientHello
+	clientRandom         []byte
+	serverRandom         []byte
+	suite                *dtlsCipherSuite
+	ecdheKey             *dtlsEcdheKey
+	serverPublic         []byte
+	peerAlgorithms       []uint16
+	certRequested        bool
+	extendedMasterSecret bool
+	masterSecret         []byte
+	srtpProfile          SrtpProtectionProfile
+	peerCert             *x509.Certificate
+	peerCertVerified     bool
+}
+
+// NewDtlsClient creates the client(a=setup:active) session.
+func NewDtlsClient(config *DtlsConfig) (*DtlsSession, error) {
+	return newDtlsSession(config, true)
+}
+
+// NewDtlsServer creates the server(a=setup:passive) session.
+func NewDtlsServer(config *DtlsConfig) (*DtlsSession, error) {
+	return newDtlsSession(config, false)
+}
+
+func newDtlsSession(config *DtlsConfig, isClient bool) (*DtlsSession, error) {
+	if config.RemoteFingerprint.Second == "" && !config.InsecureSkipVerify {
+		return nil, NewErrorf("DTLS remote fingerprint empty")
+	}
+	signer, _, err := dtlsSigner(&config.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	s := &DtlsSession{
+		config:    config,
+		isClient:  isClient,
+		state:     kDtlsStateInit,
+		signer:    signer,
+		mtu:       config.getMtu(),
+		fragments: make(map[uint16]*dtlsFragmentBuffer),
+	}
+	if !isClient {
+		s.cookieSecret = make([]byte, kDtlsCookieSecretLen)
+		if _, err := rand.Read(s.cookieSecret); err != nil {
+			return nil, NewError2(err, "DTLS fail to generate cookie secret")
+		}
+		s.state = kDtlsStateServerWaitHello
+	}
+	return s, nil
+}
+
+// IsClient returns whether the session is client.
+func (s *DtlsSession) IsClient() bool {
+	return s.isClient
+}
+
+// IsComplete returns whether the handshake is complete.
+func (s *DtlsSession) IsComplete() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.state == kDtlsStateComplete
+}
+
+// SrtpProfile returns the SRTP profile of use_srtp after handshake.
+func (s *DtlsSession) SrtpProfile() SrtpProtectionProfile {
+	s.Lock()
+	defer s.Unlock()
+	return s.srtpProfile
+}
+
+// PeerCertificate returns the peer certificate.
+func (s *DtlsSession) PeerCertificate() *x509.Certificate {
+	s.Lock()
+	defer s.Unlock()
+	return s.peerCert
+}
+
+// NextTimeout returns the time(ms) when OnTimer should be called, or 0 for none.
+func (s *DtlsSession) NextTimeout() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.timerMs
+}
+
+// Start begins the handshake, and returns the ClientHello for client.
+func (s *DtlsSession) Start(nowMs int64) ([][]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	if !s.isClient || s.state != kDtlsStateInit {
+		return nil, nil
+	}
+
+	s.clientRandom = make([]byte, kDtlsRandomLen)
+	if _, err := rand.Read(s.clientRandom); err != nil {
+		return nil, NewError2(err, "DTLS fail to generate random")
+	}
+	var suites []uint16
+	for _, suite := range kDtlsCipherSuites {
+		suites = append(suites, suite.id)
+	}
+	s.clientHello = &dtlsClientHello{
+		version:      kDtlsVersion12,
+		random:       s.clientRandom,
+		cipherSuites: suites,
+		extensions: dtlsExtensions{
+			curves:               kDtlsCurves,
+			pointFormats:         []uint8{kDtlsPointFormatUncompressed},
+			signatureAlgorithms:  kDtlsSignatureAlgorithms,
+			srtpProfiles:         s.config.getSrtpProfiles(),
+			extendedMasterSecret: true,
+			renegotiationInfo:    true,
+		},
+	}
+	s.state = kDtlsStateClientWaitHello
+	s.flight = nil
+	s.addFlightHandshake(kDtlsHandshakeClientHello, s.clientHello.marshal())
+	return s.sendFlight(nowMs, true), nil
+}
+
+// Push processes one received DTLS datagram, and returns the datagrams to send.
+// The invalid records(and the server messages before ClientHello with valid
+// cookie) are dropped silently, and the error is fatal.
+func (s *DtlsSession) Push(data []byte, nowMs int64) ([][]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var out [][]byte
+	retransmit := false
+	for len(data) > 0 {
+		var header dtlsRecordHeader
+		if err := header.unmarshal(data); err != nil {
+			break
+		}
+		fragment := data[kDtlsRecordHeaderLen : kDtlsRecordHeaderLen+int(header.length)]
+		data = data[kDtlsRecordHeaderLen+int(header.length):]
+
+		// the replays of epoch 0 are not tracked, since a spoofed record could
+		// advance the window, and the old handshake messages are dropped by seq.
+		if header.epoch > 1 {
+			continue
+		}
+		if header.epoch == 1 {
+			if s.cipher == nil || !s.remoteReplay.check(int64(header.sequence)) {
+				continue
+			}
+			var err error
+			if fragment, err = s.cipher.decrypt(&header, fragment); err != nil {
+				continue
+			}
+			s.remoteReplay.update(int64(header.sequence))
+			s.remoteEpoch = 1
+		}
+
+		switch header.contentType {
+		case kDtlsContentAlert:
+			// the plaintext alert could be spoofed after the peer's epoch 1
+			if header.epoch < s.remoteEpoch {
+				continue
+			}
+			if err := s.handleAlert(fragment); err != nil {
+				s.err = err
+				return nil, err
+			}
+		case kDtlsContentHandshake:
+			if s.addFragments(fragment) {
+				retransmit = true
+			}
+			more, err := s.processMessages(nowMs)
+			if err != nil {
+				s.err = err
+				return nil, err
+			}
+			out = append(out, more...)
+		}
+	}
+	if retransmit && len(out) == 0 && len(s.flight) > 0 {
+		out = s.sendFlight(nowMs, false)
+	}
+	return out, nil
+}
+
+// OnTimer sends the last flight again if the timer expires.
+func (s *DtlsSession) OnTimer(nowMs int64) ([][]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.timerMs == 0 || nowMs < s.timerMs {
+		return nil, nil
+	}
+	s.retransmits += 1
+	if s.retransmits > kDtlsMaxRetransmits {
+		s.timerMs = 0
+		s.err = NewErrorf("DTLS handshake timeout")
+		return nil, s.err
+	}
+	s.rtoMs *= 2
+	if s.rtoMs > kDtlsMaxRtoMs {
+		s.rtoMs = kDtlsMaxRtoMs
+	}
+	s.timerMs = nowMs + s.rtoMs
+	return s.marshalFlight(), nil
+}
+
+// Close returns the close_notify alert to send.
+func (s *DtlsSession) Close() ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.timerMs = 0
+	if s.state == kDtlsStateClosed {
+		return nil, NewErrorf("DTLS closed")
+	}
+	var epoch uint16
+	if s.state == kDtlsStateComplete {
+		epoch = 1
+	}
+	s.state = kDtlsStateClosed
+	return s.marshalRecord(kDtlsContentAlert, epoch, []byte{kDtlsAlertLevelWarning, kDtlsAlertCloseNotify}), nil
+}
+
+func (s *DtlsSession) handleAlert(data []byte) error {
+	if len(data) < 2 {
+		return nil
+	}
+	if data[1] == kDtlsAlertCloseNotify {
+		s.state = kDtlsStateClosed
+		s.timerMs = 0
+		return NewErrorf("DTLS closed by peer")
+	}
+	if data[0] == kDtlsAlertLevelFatal {
+		s.timerMs = 0
+		return NewErrorf("DTLS fatal alert: %d", data[1])
+	}
+	return nil
+}
+
+// addFragments buffers the handshake fragments of one record, and returns
+// whether some old message is received again.
+func (s *DtlsSession) addFragments(data []byte) bool {
+	old := false
+	for len(data) > 0 {
+		var header dtlsHandshakeHeader
+		if err := header.unmarshal(data); err != nil {
+			break
+		}
+		body := data[kDtlsHandshakeHeaderLen : kDtlsHandshakeHeaderLen+int(header.fragmentLength)]
+		data = data[kDtlsHandshakeHeaderLen+int(header.fragmentLength):]
+
+		// the server is stateless before the ClientHello with valid cookie
+		if s.state == kDtlsStateServerWaitHello && header.msgType == kDtlsHandshakeClientHello &&
+			header.messageSeq != s.recvSeq {
+			s.fragments = make(map[uint16]*dtlsFragmentBuffer)
+			s.recvSeq = header.messageSeq
+		}
+		if header.messageSeq < s.recvSeq {
+			old = true
+			continue
+		}
+		if header.messageSeq >= s.recvSeq+kDtlsMaxHandshakeQueue {
+			continue
+		}
+
+		buffer, ok := s.fragments[header.messageSeq]
+		if !ok {
+			buffer = &dtlsFragmentBuffer{
+				msgType:  header.msgType,
+				body:     make([]byte, header.length),
+				received: NewBitSet(int(header.length)),
+			}
+			s.fragments[header.messageSeq] = buffer
+		}
+		if buffer.msgType != header.msgType || len(buffer.body) != int(header.length) {
+			continue
+		}
+		copy(buffer.body[header.fragmentOffset:], body)
+		for i := 0; i < len(body); i++ {
+			buffer.received.Set(int(header.fragmentOffset) + i)
+		}
+	}
+	return old
+}
+
+// processMessages handles the complete messages in order.
+func (s *DtlsSession) processMessages(nowMs int64) ([][]byte, error) {
+	var out [][]byte
+	for {
+		buffer, ok := s.fragments[s.recvSeq]
+		if !ok || !buffer.received.All() {
+			break
+		}
+		delete(s.fragments, s.recvSeq)
+		msg := marshalDtlsHandshake(buffer.msgType, s.recvSeq, buffer.body)
+		s.recvSeq += 1
+
+		var more [][]byte
+		var err error
+		if s.isClient {
+			more, err = s.handleClientMessage(buffer.msgType, buffer.body, msg, nowMs)
+		} else {
+			more, err = s.handleServerMessage(buffer.msgType, buffer.body, msg, nowMs)
+		}
+		if err != nil {
+			if !s.isClient && !s.cookieVerified {
+				// the server is stateless before the valid cookie, so drop it
+				continue
+			}
+			s.timerMs = 0
+			return nil, err
+		}
+		out = append(out, more...)
+	}
+	return out, nil
+}
+
+func (s *DtlsSession) unexpectedMessage(msgType uint8) error {
+	return NewErrorf("DTLS unexpected message %d in state %d", msgType, s.state)
+}
+
+func (s *DtlsSession) handleClientMessage(msgType uint8, body, msg []byte, nowMs int64) ([][]byte, error) {
+	switch {
+	case msgType == kDtlsHandshakeHelloVerifyRequest && s.state == kDtlsStateClientWaitHello:
+		cookie, err := unmarshalDtlsHelloVerifyRequest(body)
+		if err != nil {
+			return nil, err
+		}
+		// the ClientHello and HelloVerifyRequest before are not in handshake hash
+		s.clientHello.cookie = cookie
+		s.transcript = nil
+		s.flight = nil
+		s.addFlightHandshake(kDtlsHandshakeClientHello, s.clientHello.marshal())
+		return s.sendFlight(nowMs, true), nil
+
+	case msgType == kDtlsHandshakeServerHello && s.state == kDtlsStateClientWaitHello:
+		hello := &dtlsServerHello{}
+		if err := hello.unmarshal(body); err != nil {
+			return nil, err
+		}
+		if hello.version != kDtlsVersion12 {
+			return nil, NewErrorf("DTLS version unsupported: 0x%04x", hello.version)
+		}
+		if s.suite = dtlsCipherSuiteOf(hello.cipherSuite); s.suite == nil {
+			return nil, NewErrorf("DTLS cipher suite unsupported: 0x%04x", hello.cipherSuite)
+		}
+		if len(hello.extensions.srtpProfiles) != 1 || !dtlsHasSrtpProfile(s.config.getSrtpProfiles(), hello.extensions.srtpProfiles[0]) {
+			return nil, NewErrorf("DTLS use_srtp not negotiated")
+		}
+		s.srtpProfile = hello.extensions.srtpProfiles[0]
+		s.serverRandom = hello.random
+		s.extendedMasterSecret = hello.extensions.extendedMasterSecret
+		s.transcript = append(s.transcript, msg...)
+		s.state = kDtlsStateClientWaitHelloDone
+		return nil, nil
+
+	case msgType == kDtlsHandshakeCertificate && s.state == kDtlsStateClientWaitHelloDone:
+		if err := s.setPeerCertificate(body); err != nil {
+			return nil, err
+		}
+		s.transcript = append(s.transcript, msg...)
+		return nil, nil
+
+	case msgType == kDtlsHandshakeServerKeyExchange && s.state == kDtlsStateClientWaitHelloDone:
+		if s.peerCert == nil {
+			return nil, s.unexpectedMessage(msgType)
+		}
+		exchange := &dtlsServerKeyExchange{}
+		if err := exchange.unmarshal(body); err != nil {
+			return nil, err
+		}
+		if dtlsSignatureIsEcdsa(exchange.algorithm) != s.suite.ecdsa {
+			return nil, NewErrorf("DTLS ServerKeyExchange signature mismatch: 0x%04x", exchange.algorithm)
+		}
+		signed := append(append(append([]byte(nil), s.clientRandom...), s.serverRandom...), exchange.params()...)
+		if err := dtlsVerify(s.peerCert, exchange.algorithm, signed, exchange.signature); err != nil {
+			return nil, err
+		}
+		var err error
+		if s.ecdheKey, err = newDtlsEcdheKey(exchange.curve); err != nil {
+			return nil, err
+		}
+		s.serverPublic = exchange.public
+		s.transcript = append(s.transcript, msg...)
+		return nil, nil
+
+	case msgType == kDtlsHandshakeCertificateRequest && s.state == kDtlsStateClientWaitHelloDone:
+		algorithms, err := unmarshalDtlsCertificateRequest(body)
+		if err != nil {
+			return nil, err
+		}
+		s.peerAlgorithms = algorithms
+		s.certRequested = true
+		s.transcript = append(s.transcript, msg...)
+		return nil, nil
+
+	case msgType == kDtlsHandshakeServerHelloDone && s.state == kDtlsStateClientWaitHelloDone:
+		if s.ecdheKey == nil {
+			return nil, s.unexpectedMessage(msgType)
+		}
+		s.transcript = append(s.transcript, msg...)
+		return s.sendClientFinished(nowMs)
+
+	case msgType == kDtlsHandshakeFinished && s.state == kDtlsStateClientWaitFinished:
+		if err := s.verifyFinished("server finished", body); err != nil {
+			return nil, err
+		}
+		s.transcript = append(s.transcript, msg...)
+		s.state = kDtlsStateComplete
+		s.timerMs = 0
+		return nil, nil
+	}
+	return nil, s.unexpectedMessage(msgType)
+}
+
+// sendClientFinished returns the flight 5.
+func (s *DtlsSession) sendClientFinished(nowMs int64) ([][]byte, error) {
+	s.flight = nil
+	if s.certRequested {
+		s.addFlightHandshake(kDtlsHandshakeCertificate, marshalDtlsCertificate(s.config.Certificate.Certificate))
+	}
+
+	preMasterSecret, err := s.ecdheKey.sharedSecret(s.serverPublic)
+	if err != nil {
+		return nil, err
+	}
+	exchange := &dtlsBuilder{}
+	exchange.addVector(1, s.ecdheKey.public)
+	s.addFlightHandshake(kDtlsHandshakeClientKeyExchange, exchange.buf)
+	if err := s.setMasterSecret(preMasterSecret); err != nil {
+		return nil, err
+	}
+
+	if s.certRequested {
+		algorithm, err := dtlsSelectSignature(s.signer, s.peerAlgorithms)
+		if err != nil {
+			return nil, err
+		}
+		signature, err := dtlsSign(s.signer, algorithm, s.transcript)
+		if err != nil {
+			return nil, NewError2(err, "DTLS fail to sign CertificateVerify")
+		}
+		s.addFlightHandshake(kDtlsHandshakeCertificateVerify, marshalDtlsSignature(algorithm, signature))
+	}
+
+	s.flight = append(s.flight, &dtlsFlightMessage{contentType: kDtlsContentChangeCipherSpec, data: []byte{1}})
+	s.addFinished("client finished")
+	s.state = kDtlsStateClientWaitFinished
+	return s.sendFlight(nowMs, true), nil
+}
+
+func (s *DtlsSession) handleServerMessage(msgType uint8, body, msg []byte, nowMs int64) ([][]byte, error) {
+	switch {
+	case msgType == kDtlsHandshakeClientHello && s.state == kDtlsStateServerWaitHello:
+		return s.handleClientHello(body, msg, nowMs)
+
+	case msgType == kDtlsHandshakeCertificate && s.state == kDtlsStateServerWaitFinished && s.masterSecret == nil:
+		if err := s.setPeerCertificate(body); err != nil {
+			return nil, err
+		}
+		s.transcript = append(s.transcript, msg...)
+		return nil, nil
+
+	case msgType == kDtlsHandshakeClientKeyExchange && s.state == kDtlsStateServerWaitFinished && s.masterSecret == nil:
+		r := newDtlsReader(body)
+		public := r.readVector(1)
+		if !r.ok {
+			return nil, NewErrorf("DTLS ClientKeyExchange invalid")
+		}
+		preMasterSecret, err := s.ecdheKey.sharedSecret(public)
+		if err != nil {
+			return nil, err
+		}
+		s.transcript = append(s.transcript, msg...)
+		return nil, s.setMasterSecret(preMasterSecret)
+
+	case msgType == kDtlsHandshakeCertificateVerify && s.state == kDtlsStateServerWaitFinished && s.masterSecret != nil:
+		if s.peerCert == nil || s.peerCertVerified {
+			return nil, s.unexpectedMessage(msgType)
+		}
+		algorithm, signature, err := unmarshalDtlsSignature(body)
+		if err != nil {
+			return nil, err
+		}
+		if err := dtlsVerify(s.peerCert, algorithm, s.transcript, signature); err != nil {
+			return nil, err
+		}
+		s.peerCertVerified = true
+		s.transcript = append(s.transcript, msg...)
+		return nil, nil
+
+	case msgType == kDtlsHandshakeFinished && s.state == kDtlsStateServerWaitFinished && s.masterSecret != nil:
+		if s.peerCert != nil && !s.peerCertVerified {
+			return nil, NewErrorf("DTLS CertificateVerify missing")
+		}
+		if !s.config.InsecureSkipVerify && !s.peerCertVerified {
+			return nil, NewErrorf("DTLS client certificate missing")
+		}
+		if err := s.verifyFinished("client finished", body); err != nil {
+			return nil, err
+		}
+		s.transcript = append(s.transcript, msg...)
+
+		s.flight = nil
+		s.flight = append(s.flight, &dtlsFlightMessage{contentType: kDtlsContentChangeCipherSpec, data: []byte{1}})
+		s.addFinished("server finished")
+		s.state = kDtlsStateComplete
+		return s.sendFlight(nowMs, false), nil
+	}
+	return nil, s.unexpectedMessage(msgType)
+}
+
+// handleClientHello returns the HelloVerifyRequest for the invalid cookie, or
+// the flight 4.
+func (s *DtlsSession) handleClientHello(body, msg []byte, nowMs int64) ([][]byte, error) {
+	hello := &dtlsClientHello{}
+	if err := hello.unmarshal(body); err != nil {
+		return nil, err
+	}
+	seq := s.recvSeq - 1
+	mac := hmac.New(sha256.New, s.cookieSecret)
+	mac.Write(hello.random)
+	cookie := mac.Sum(nil)[:kDtlsCookieLen]
+	if !hmac.Equal(cookie, hello.cookie) {
+		verify := marshalDtlsHandshake(kDtlsHandshakeHelloVerifyRequest, seq, marshalDtlsHelloVerifyRequest(cookie))
+		return [][]byte{s.marshalRecord(kDtlsContentHandshake, 0, verify)}, nil
+	}
+	s.cookieVerified = true
+
+	// negotiate the parameters
+	if hello.version > kDtlsVersion12 {
+		return nil, NewErrorf("DTLS version unsupported: 0x%04x", hello.version)
+	}
+	isEcdsa := dtlsIsEcdsaSigner(s.signer)
+	for _, id := range hello.cipherSuites {
+		if suite := dtlsCipherSuiteOf(id); suite != nil && suite.ecdsa == isEcdsa {
+			s.suite = suite
+			break
+		}
+	}
+	if s.suite == nil {
+		return nil, NewErrorf("DTLS no cipher suite")
+	}
+	curve := kDtlsCurveP256
+	for _, id := range hello.extensions.curves {
+		if dtlsCurveOf(id) != nil {
+			curve = id
+			break
+		}
+	}
+	for _, profile := range s.config.getSrtpProfiles() {
+		if dtlsHasSrtpProfile(hello.extensions.srtpProfiles, profile) {
+			s.srtpProfile = profile
+			break
+		}
+	}
+	if s.srtpProfile == 0 {
+		return nil, NewErrorf("DTLS use_srtp not negotiated")
+	}
+	peerAlgorithms := hello.extensions.signatureAlgorithms
+	if len(peerAlgorithms) == 0 {
+		peerAlgorithms = kDtlsSignatureAlgorithms
+	}
+	algorithm, err := dtlsSelectSignature(s.signer, peerAlgorithms)
+	if err != nil {
+		return nil, err
+	}
+	if s.ecdheKey, err = newDtlsEcdheKey(curve); err != nil {
+		return nil, err
+	}
+	s.serverRandom = make([]byte, kDtlsRandomLen)
+	if _, err := rand.Read(s.serverRandom); err != nil {
+		return nil, NewError2(err, "DTLS fail to generate random")
+	}
+	s.clientRandom = hello.random
+	s.extendedMasterSecret = hello.extensions.extendedMasterSecret
+	renegotiationInfo := hello.extensions.renegotiationInfo
+	for _, id := range hello.cipherSuites {
+		if id == kDtlsEmptyRenegotiationInfoScsv {
+			renegotiationInfo = true
+		}
+	}
+
+	// the flight 4, with the message_seq after ClientHello
+	s.sendSeq = seq
+	s.transcript = append([]byte(nil), msg...)
+	s.flight = nil
+
+	serverHello := &dtlsServerHello{
+		version:     kDtlsVersion12,
+		random:      s.serverRandom,
+		cipherSuite: s.suite.id,
+		extensions: dtlsExtensions{
+			srtpProfiles:         []SrtpProtectionProfile{s.srtpProfile},
+			extendedMasterSecret: s.extendedMasterSecret,
+			renegotiationInfo:    renegotiationInfo,
+		},
+	}
+	if len(hello.extensions.pointFormats) > 0 {
+		serverHello.extensions.pointFormats = []uint8{kDtlsPointFormatUncompressed}
+	}
+	s.addFlightHandshake(kDtlsHandshakeServerHello, serverHello.marshal())
+	s.addFlightHandshake(kDtlsHandshakeCertificate, marshalDtlsCertificate(s.config.Certificate.Certificate))
+
+	exchange := &dtlsServerKeyExchange{curve: curve, public: s.ecdheKey.public, algorithm: algorithm}
+	signed := append(append(append([]byte(nil), s.clientRandom...), s.serverRandom...), exchange.params()...)
+	if exchange.signature, err = dtlsSign(s.signer, algorithm, signed); err != nil {
+		return nil, NewError2(err, "DTLS fail to sign ServerKeyExchange")
+	}
+	s.addFlightHandshake(kDtlsHandshakeServerKeyExchange, exchange.marshal())
+	s.addFlightHandshake(kDtlsHandshakeCertificateRequest, marshalDtlsCertificateRequest(kDtlsSignatureAlgorithms))
+	s.addFlightHandshake(kDtlsHandshakeServerHelloDone, nil)
+	s.state = kDtlsStateServerWaitFinished
+	return s.sendFlight(nowMs, true), nil
+}
+
+func dtlsHasSrtpProfile(profiles []SrtpProtectionProfile, profile SrtpProtectionProfile) bool {
+	for _, item := range profiles {
+		if item == profile && item.KeyLen() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// setPeerCertificate parses the peer Certificate, and checks it against the SDP fingerprint.
+func (s *DtlsSession) setPeerCertificate(body []byte) error {
+	certs, err := unmarshalDtlsCertificate(body)
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		if s.isClient {
+			return NewErrorf("DTLS server certificate empty")
+		}
+		return nil
+	}
+	if s.peerCert, err = x509.ParseCertificate(certs[0]); err != nil {
+		return NewError2(err, "DTLS peer certificate invalid")
+	}
+	if s.config.InsecureSkipVerify {
+		return nil
+	}
+	return VerifyDtlsFingerprint(certs[0], s.config.RemoteFingerprint)
+}
+
+// setMasterSecret computes the master secret(RFC 7627 with extended_master_secret)
+// and the record cipher of epoch 1.
+func (s *DtlsSession) setMasterSecret(preMasterSecret []byte) error {
+	if s.extendedMasterSecret {
+		h := s.suite.hash()
+		h.Write(s.transcript)
+		s.masterSecret = dtlsPrf(s.suite.hash, preMasterSecret, "extended master secret", h.Sum(nil), kDtlsMasterSecretLen)
+	} else {
+		seed := append(append([]byte(nil), s.clientRandom...), s.serverRandom...)
+		s.masterSecret = dtlsPrf(s.suite.hash, preMasterSecret, "master secret", seed, kDtlsMasterSecretLen)
+	}
+	var err error
+	s.cipher, err = newDtlsRecordCipher(s.suite, s.masterSecret, s.clientRandom, s.serverRandom, s.isClient)
+	return err
+}
+
+func (s *DtlsSession) verifyData(label string) []byte {
+	h := s.suite.hash()
+	h.Write(s.transcript)
+	return dtlsPrf(s.suite.hash, s.masterSecret, label, h.Sum(nil), kDtlsVerifyDataLen)
+}
+
+func (s *DtlsSession) verifyFinished(label string, body []byte) error {
+	if !hmac.Equal(s.verifyData(label), body) {
+		return NewErrorf("DTLS Finished invalid")
+	}
+	return nil
+}
+
+// addFinished adds the local Finished of epoch 1 to flight.
+func (s *DtlsSession) addFinished(label string) {
+	msg := marshalDtlsHandshake(kDtlsHandshakeFinished, s.sendSeq, s.verifyData(label))
+	s.sendSeq += 1
+	s.transcript = append(s.transcript, msg...)
+	s.flight = append(s.flight, &dtlsFlightMessage{contentType: kDtlsContentHandshake, epoch: 1, data: msg})
+}
+
+// addFlightHandshake adds one handshake message of epoch 0 to flight and handshake hash.
+func (s *DtlsSession) addFlightHandshake(msgType uint8, body []byte) {
+	msg := marshalDtlsHandshake(msgType, s.sendSeq, body)
+	s.sendSeq += 1
+	s.transcript = append(s.transcript, msg...)
+	s.flight = append(s.flight, &dtlsFlightMessage{contentType: kDtlsContentHandshake, data: msg})
+}
+
+// sendFlight returns the datagrams of the new flight, and starts the timer if
+// some response is expected.
+func (s *DtlsSession) sendFlight(nowMs int64, timer bool) [][]byte {
+	if timer {
+		s.rtoMs = kDtlsInitialRtoMs
+		s.retransmits = 0
+		s.timerMs = nowMs + s.rtoMs
+	}
+	return s.marshalFlight()
+}
+
+// marshalFlight fragments the flight by MTU, and packs the records into datagrams.
+func (s *DtlsSession) marshalFlight() [][]byte {
+	var datagrams [][]byte
+	var current []byte
+	addRecord := func(record []byte) {
+		if len(current) > 0 && len(current)+len(record) > s.mtu {
+			datagrams = append(datagrams, current)
+			current = nil
+		}
+		current = append(current, record...)
+	}
+
+	for _, msg := range s.flight {
+		if msg.contentType != kDtlsContentHandshake {
+			addRecord(s.marshalRecord(msg.contentType, msg.epoch, msg.data))
+			continue
+		}
+		var header dtlsHandshakeHeader
+		header.unmarshal(msg.data)
+		body := msg.data[kDtlsHandshakeHeaderLen:]
+		overhead := kDtlsRecordHeaderLen + kDtlsHandshakeHeaderLen
+		if msg.epoch > 0 {
+			overhead += kDtlsGcmExplicitIvLen + kDtlsGcmTagLen
+		}
+		maxFragment := Max(s.mtu-overhead, kDtlsHandshakeHeaderLen)
+		for offset := 0; offset == 0 || offset < len(body); {
+			n := Min(maxFragment, len(body)-offset)
+			header.fragmentOffset = uint32(offset)
+			header.fragmentLength = uint32(n)
+			fragment := make([]byte, kDtlsHandshakeHeaderLen+n)
+			header.marshalTo(fragment)
+			copy(fragment[kDtlsHandshakeHeaderLen:], body[offset:offset+n])
+			addRecord(s.marshalRecord(kDtlsContentHandshake, msg.epoch, fragment))
+			offset += n
+			if n == 0 {
+				break
+			}
+		}
+	}
+	if len(current) > 0 {
+		datagrams = append(datagrams, current)
+	}
+	return datagrams
+}
+
+// marshalRecord returns one record of the next sequence of epoch.
+func (s *DtlsSession) marshalRecord(contentType uint8, epoch uint16, plaintext []byte) []byte {
+	header := dtlsRecordHeader{
+		contentType: contentType,
+		version:     kDtlsVersion12,
+		epoch:       epoch,
+		sequence:    s.localSeqs[epoch],
+	}
+	s.localSeqs[epoch] += 1
+	fragment := plaintext
+	if epoch > 0 {
+		fragment = s.cipher.encrypt(&header, plaintext)
+	}
+	header.length = uint16(len(fragment))
+	buf := make([]byte, kDtlsRecordHeaderLen+len(fragment))
+	header.marshalTo(buf)
+	copy(buf[kDtlsRecordHeaderLen:], fragment)
+	return buf
+}
+
+// ExportKeyingMaterial returns the keying material(RFC 5705) after handshake.
+func (s *DtlsSession) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.state != kDtlsStateComplete {
+		return nil, NewErrorf("DTLS handshake not complete")
+	}
+	seed := append(append([]byte(nil), s.clientRandom...), s.serverRandom...)
+	if context != nil {
+		var size [2]byte
+		binary.BigEndian.PutUint16(size[:], uint16(len(context)))
+		seed = append(append(seed, size[:]...), context...)
+	}
+	return dtlsPrf(s.suite.hash, s.masterSecret, label, seed, length), nil
+}
+
+// SrtpKeys returns the SRTP master keys and salts(RFC 5764 4.2) of local and remote.
+func (s *DtlsSession) SrtpKeys() (localKey, localSalt, remoteKey, remoteSalt []byte, err error) {
+	profile := s.SrtpProfile()
+	keyLen, saltLen := profile.KeyLen(), profile.SaltLen()
+	material, err := s.ExportKeyingMaterial(kDtlsSrtpExporterLabel, nil, 2*(keyLen+saltLen))
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	clientKey := material[:keyLen]
+	serverKey := material[keyLen : 2*keyLen]
+	clientSalt := material[2*keyLen : 2*keyLen+saltLen]
+	serverSalt := material[2*keyLen+saltLen:]
+	if s.isClient {
+		return clientKey, clientSalt, serverKey, serverSalt, nil
+	}
+	return serverKey, serverSalt, clientKey, clientSalt, nil
+}
+
+// NewSrtpContexts creates the SRTP contexts for sending(local) and receiving(remote).
+func (s *DtlsSession) NewSrtpContexts() (local, remote *SrtpContext, err error) {
+	localKey, localSalt, remoteKey, remoteSalt, err := s.SrtpKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+	if local, err = NewSrtpContext(s.SrtpProfile(), localKey, localSalt); err != nil {
+		return nil, nil, err
+	}
+	if remote, err = NewSrtpContext(s.SrtpProfile(), remoteKey, remoteSalt); err != nil {
+		return nil, nil, err
+	}
+	return local, remote, nil
+}
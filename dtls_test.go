@@ -0,0 +1,290 @@
+package goutil
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// runDtlsHandshake exchanges the datagrams until both are complete, and the
+// nth datagram is dropped if drop returns true.
+func runDtlsHandshake(t *testing.T, client, server *DtlsSession, drop func(n int) bool) error {
+	nowMs := int64(1000)
+	toServer, err := client.Start(nowMs)
+	if err != nil {
+		return err
+	}
+	var toClient [][]byte
+	count := 0
+	for i := 0; i < 100 && !(client.IsComplete() && server.IsComplete()); i++ {
+		for _, data := range toServer {
+			count++
+			if drop != nil && drop(count) {
+				continue
+			}
+			out, err := server.Push(data, nowMs)
+			if err != nil {
+				return err
+			}
+			toClient = append(toClient, out...)
+		}
+		toServer = nil
+		for _, data := range toClient {
+			count++
+			if drop != nil && drop(count) {
+				continue
+			}
+			out, err := client.Push(data, nowMs)
+			if err != nil {
+				return err
+			}
+			toServer = append(toServer, out...)
+		}
+		toClient = nil
+
+		if len(toServer) == 0 {
+			nowMs += 1000
+			for _, session := range []*DtlsSession{client, server} {
+				out, err := session.OnTimer(nowMs)
+				if err != nil {
+					return err
+				}
+				if session == client {
+					toServer = append(toServer, out...)
+				} else {
+					toClient = append(toClient, out...)
+				}
+			}
+		}
+	}
+	if !client.IsComplete() || !server.IsComplete() {
+		t.Fatalf("handshake not complete")
+	}
+	return nil
+}
+
+func generateRsaDtlsCertificate(t *testing.T) tls.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("cert: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestDtls_1(t *testing.T) {
+	ecdsaCert, err := GenerateDtlsCertificate("test")
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	rsaCert := generateRsaDtlsCertificate(t)
+
+	checks := []struct {
+		clientCert, serverCert tls.Certificate
+		profiles               []SrtpProtectionProfile
+		mtu                    int
+		drop                   func(n int) bool
+		suite                  uint16
+	}{
+		{ecdsaCert, ecdsaCert, nil, 0, nil, DTLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		{ecdsaCert, rsaCert, []SrtpProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80}, 0, nil, DTLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		// the fragmented flights and lost datagrams
+		{rsaCert, rsaCert, nil, 300, func(n int) bool { return n%3 == 0 }, DTLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		{ecdsaCert, ecdsaCert, nil, 0, func(n int) bool { return n == 5 || n == 6 }, DTLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}
+	for i, check := range checks {
+		clientFp, _ := DtlsFingerprint(check.clientCert.Certificate[0], "sha-256")
+		serverFp, _ := DtlsFingerprint(check.serverCert.Certificate[0], "sha-256")
+		client, err := NewDtlsClient(&DtlsConfig{
+			Certificate:       check.clientCert,
+			SrtpProfiles:      check.profiles,
+			RemoteFingerprint: StringPair{"sha-256", serverFp},
+			MTU:               check.mtu,
+		})
+		if err != nil {
+			t.Fatalf("%d client: %v", i, err)
+		}
+		server, err := NewDtlsServer(&DtlsConfig{
+			Certificate:       check.serverCert,
+			RemoteFingerprint: StringPair{"sha-256", clientFp},
+			MTU:               check.mtu,
+		})
+		if err != nil {
+			t.Fatalf("%d server: %v", i, err)
+		}
+		if err := runDtlsHandshake(t, client, server, check.drop); err != nil {
+			t.Fatalf("%d handshake: %v", i, err)
+		}
+		if client.suite.id != check.suite || client.SrtpProfile() != server.SrtpProfile() {
+			t.Fatalf("%d negotiated: 0x%04x, %v, %v", i, client.suite.id, client.SrtpProfile(), server.SrtpProfile())
+		}
+		if check.profiles != nil && client.SrtpProfile() != check.profiles[0] {
+			t.Fatalf("%d profile: %v", i, client.SrtpProfile())
+		}
+
+		clientLocal, _, err := client.NewSrtpContexts()
+		if err != nil {
+			t.Fatalf("%d client srtp: %v", i, err)
+		}
+		_, serverRemote, err := server.NewSrtpContexts()
+		if err != nil {
+			t.Fatalf("%d server srtp: %v", i, err)
+		}
+		pkt := &RtpPacket{RtpHeader: RtpHeader{Version: kRtpVersion, SequenceNumber: 1, SSRC: 1}, Payload: []byte{1, 2, 3}}
+		data, _ := clientLocal.ProtectRtp(pkt)
+		if out, err := serverRemote.UnprotectRtp(data); err != nil || !bytes.Equal(out.Payload, pkt.Payload) {
+			t.Fatalf("%d srtp: %v", i, err)
+		}
+	}
+}
+
+func TestDtls_2(t *testing.T) {
+	cert, _ := GenerateDtlsCertificate("test")
+	other, _ := GenerateDtlsCertificate("other")
+	otherFp, _ := DtlsFingerprint(other.Certificate[0], "sha-256")
+
+	// the server certificate mismatches the fingerprint of SDP
+	client, _ := NewDtlsClient(&DtlsConfig{Certificate: cert, RemoteFingerprint: StringPair{"sha-256", otherFp}})
+	server, _ := NewDtlsServer(&DtlsConfig{Certificate: cert, InsecureSkipVerify: true})
+	if err := runDtlsHandshake(t, client, server, nil); err == nil {
+		t.Fatalf("fingerprint mismatch accepted")
+	}
+
+	// no common SRTP profile
+	client, _ = NewDtlsClient(&DtlsConfig{Certificate: cert, SrtpProfiles: []SrtpProtectionProfile{SRTP_AEAD_AES_256_GCM}, InsecureSkipVerify: true})
+	server, _ = NewDtlsServer(&DtlsConfig{Certificate: cert, SrtpProfiles: []SrtpProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80}, InsecureSkipVerify: true})
+	if err := runDtlsHandshake(t, client, server, nil); err == nil {
+		t.Fatalf("use_srtp mismatch accepted")
+	}
+
+	// the retransmission timeout
+	client, _ = NewDtlsClient(&DtlsConfig{Certificate: cert, InsecureSkipVerify: true})
+	client.Start(0)
+	var err error
+	for nowMs := int64(0); nowMs < 200000 && err == nil; nowMs += 1000 {
+		_, err = client.OnTimer(nowMs)
+	}
+	if err == nil {
+		t.Fatalf("timeout expected")
+	}
+
+	// the remote fingerprint is required unless InsecureSkipVerify
+	if _, err := NewDtlsClient(&DtlsConfig{Certificate: cert}); err == nil {
+		t.Fatalf("client without fingerprint accepted")
+	}
+	if _, err := NewDtlsServer(&DtlsConfig{Certificate: cert}); err == nil {
+		t.Fatalf("server without fingerprint accepted")
+	}
+	client, _ = NewDtlsClient(&DtlsConfig{Certificate: cert, InsecureSkipVerify: true})
+	server, _ = NewDtlsServer(&DtlsConfig{Certificate: other, InsecureSkipVerify: true})
+	if err := runDtlsHandshake(t, client, server, nil); err != nil {
+		t.Fatalf("insecure handshake: %v", err)
+	}
+}
+
+func TestDtls_3(t *testing.T) {
+	cert, _ := GenerateDtlsCertificate("test")
+	fp, _ := DtlsFingerprint(cert.Certificate[0], "sha-256")
+	config := &DtlsConfig{Certificate: cert, RemoteFingerprint: StringPair{"sha-256", fp}}
+	client, _ := NewDtlsClient(config)
+	server, _ := NewDtlsServer(config)
+
+	// the spoofed ClientHello(invalid session_id length) and unexpected message
+	// before the valid cookie are dropped
+	spoofer, _ := NewDtlsClient(config)
+	flight, _ := spoofer.Start(0)
+	malformed := append([]byte(nil), flight[0]...)
+	malformed[kDtlsRecordHeaderLen+kDtlsHandshakeHeaderLen+2+kDtlsRandomLen] = 0xFF
+	unexpected := append([]byte(nil), flight[0]...)
+	unexpected[kDtlsRecordHeaderLen] = kDtlsHandshakeClientKeyExchange
+	for i, data := range [][]byte{malformed, unexpected} {
+		if out, err := server.Push(data, 0); err != nil || len(out) != 0 {
+			t.Fatalf("%d spoofed: %v, %d", i, err, len(out))
+		}
+	}
+
+	if err := runDtlsHandshake(t, client, server, nil); err != nil {
+		t.Fatalf("handshake after spoofed: %v", err)
+	}
+}
+
+func TestDtls_4(t *testing.T) {
+	cert, _ := GenerateDtlsCertificate("test")
+	fp, _ := DtlsFingerprint(cert.Certificate[0], "sha-256")
+	config := &DtlsConfig{Certificate: cert, RemoteFingerprint: StringPair{"sha-256", fp}}
+	client, _ := NewDtlsClient(config)
+	server, _ := NewDtlsServer(config)
+
+	// the spoofed record of epoch 0 with high sequence doesn't block the later ones
+	spoofed := make([]byte, kDtlsRecordHeaderLen+1)
+	header := dtlsRecordHeader{contentType: kDtlsContentHandshake, version: kDtlsVersion12, sequence: 1 << 40, length: 1}
+	header.marshalTo(spoofed)
+	for _, session := range []*DtlsSession{client, server} {
+		if out, err := session.Push(spoofed, 0); err != nil || len(out) != 0 {
+			t.Fatalf("spoofed: %v, %d", err, len(out))
+		}
+	}
+	if err := runDtlsHandshake(t, client, server, nil); err != nil {
+		t.Fatalf("handshake after spoofed: %v", err)
+	}
+
+	// the plaintext alerts are ignored after epoch 1, but not the encrypted one
+	for _, level := range []byte{kDtlsAlertLevelWarning, kDtlsAlertLevelFatal} {
+		alert := make([]byte, kDtlsRecordHeaderLen+2)
+		header := dtlsRecordHeader{contentType: kDtlsContentAlert, version: kDtlsVersion12, sequence: 100, length: 2}
+		header.marshalTo(alert)
+		alert[kDtlsRecordHeaderLen] = level
+		alert[kDtlsRecordHeaderLen+1] = kDtlsAlertCloseNotify
+		for _, session := range []*DtlsSession{client, server} {
+			if _, err := session.Push(alert, 0); err != nil || !session.IsComplete() {
+				t.Fatalf("spoofed alert: %v", err)
+			}
+		}
+	}
+	alert, _ := client.Close()
+	if _, err := server.Push(alert, 0); err == nil || server.IsComplete() {
+		t.Fatalf("close_notify ignored")
+	}
+}
+
+func TestDtls_5(t *testing.T) {
+	for _, curve := range kDtlsCurves {
+		a, err := newDtlsEcdheKey(curve)
+		if err != nil {
+			t.Fatalf("%d key: %v", curve, err)
+		}
+		b, _ := newDtlsEcdheKey(curve)
+		sa, err := a.sharedSecret(b.public)
+		if err != nil {
+			t.Fatalf("%d shared: %v", curve, err)
+		}
+		if sb, _ := b.sharedSecret(a.public); !bytes.Equal(sa, sb) || len(sa) != (len(a.public)-1)/2 {
+			t.Fatalf("%d shared mismatch: %x, %x", curve, sa, sb)
+		}
+
+		// the point not on curve, the infinity and the truncated point
+		offCurve := append([]byte(nil), b.public...)
+		offCurve[len(offCurve)-1] ^= 1
+		for i, public := range [][]byte{offCurve, {0}, b.public[:len(b.public)/2+1]} {
+			if _, err := a.sharedSecret(public); err == nil {
+				t.Fatalf("%d invalid %d accepted", curve, i)
+			}
+		}
+	}
+	if _, err := newDtlsEcdheKey(29); err == nil {
+		t.Fatalf("x25519 accepted")
+	}
+}
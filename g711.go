@@ -0,0 +1,246 @@
+package goutil
+
+import (
+	"strings"
+)
+
+// G.711(ITU-T G.711) μ-law(PCMU) and A-law(PCMA) codec of 16-bit linear PCM.
+
+const (
+	kG711QuantMask = 0x0F
+	kG711SegMask   = 0x70
+	kG711SegShift  = 4
+	kG711SignBit   = 0x80
+	kG711UlawBias  = 0x84
+	kG711UlawClip  = 8159 // of 14-bit magnitude
+
+	G711_ULAW_SILENCE uint8 = 0xFF
+	G711_ALAW_SILENCE uint8 = 0xD5
+)
+
+var (
+	kG711UlawSegEnd = []int32{0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF, 0x1FFF}
+	kG711AlawSegEnd = []int32{0x1F, 0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF}
+
+	// the decode and transcode tables
+	kG711UlawTable   [256]int16
+	kG711AlawTable   [256]int16
+	kG711UlawToAlawT [256]uint8
+	kG711AlawToUlawT [256]uint8
+)
+
+func init() {
+	for i := 0; i < 256; i++ {
+		kG711UlawTable[i] = ulawToLinear(uint8(i))
+		kG711AlawTable[i] = alawToLinear(uint8(i))
+	}
+	for i := 0; i < 256; i++ {
+		kG711UlawToAlawT[i] = LinearToAlaw(kG711UlawTable[i])
+		kG711AlawToUlawT[i] = LinearToUlaw(kG711AlawTable[i])
+	}
+}
+
+func g711Segment(value int32, table []int32) int {
+	for i, end := range table {
+		if value <= end {
+			return i
+		}
+	}
+	return len(table)
+}
+
+// LinearToUlaw encodes one 16-bit sample to μ-law.
+func LinearToUlaw(sample int16) uint8 {
+	value := int32(sample) >> 2
+	mask := uint8(0xFF)
+	if value < 0 {
+		value = -value
+		mask = 0x7F
+	}
+	if value > kG711UlawClip {
+		value = kG711UlawClip
+	}
+	value += kG711UlawBias >> 2
+
+	seg := g711Segment(value, kG711UlawSegEnd)
+	if seg >= 8 {
+		return 0x7F ^ mask
+	}
+	ulaw := uint8(seg<<4) | uint8((value>>uint(seg+1))&kG711QuantMask)
+	return ulaw ^ mask
+}
+
+func ulawToLinear(ulaw uint8) int16 {
+	ulaw = ^ulaw
+	t := (int32(ulaw&kG711QuantMask) << 3) + kG711UlawBias
+	t <<= uint(ulaw&kG711SegMask) >> kG711SegShift
+	if ulaw&kG711SignBit != 0 {
+		return int16(kG711UlawBias - t)
+	}
+	return int16(t - kG711UlawBias)
+}
+
+// UlawToLinear decodes one μ-law sample.
+func UlawToLinear(ulaw uint8) int16 {
+	return kG711UlawTable[ulaw]
+}
+
+// LinearToAlaw encodes one 16-bit sample to A-law.
+func LinearToAlaw(sample int16) uint8 {
+	value := int32(sample) >> 3
+	mask := uint8(0xD5)
+	if value < 0 {
+		mask = 0x55
+		value = -value - 1
+	}
+
+	seg := g711Segment(value, kG711AlawSegEnd)
+	if seg >= 8 {
+		return 0x7F ^ mask
+	}
+	alaw := uint8(seg << kG711SegShift)
+	if seg < 2 {
+		alaw |= uint8((value >> 1) & kG711QuantMask)
+	} else {
+		alaw |= uint8((value >> uint(seg)) & kG711QuantMask)
+	}
+	return alaw ^ mask
+}
+
+func alawToLinear(alaw uint8) int16 {
+	alaw ^= 0x55
+	t := int32(alaw&kG711QuantMask) << 4
+	seg := uint(alaw&kG711SegMask) >> kG711SegShift
+	switch seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if alaw&kG711SignBit != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
+
+// AlawToLinear decodes one A-law sample.
+func AlawToLinear(alaw uint8) int16 {
+	return kG711AlawTable[alaw]
+}
+
+// EncodePcmu encodes 16-bit samples to μ-law bytes.
+func EncodePcmu(samples []int16) []byte {
+	data := make([]byte, len(samples))
+	for i, sample := range samples {
+		data[i] = LinearToUlaw(sample)
+	}
+	return data
+}
+
+// DecodePcmu decodes μ-law bytes to 16-bit samples.
+func DecodePcmu(data []byte) []int16 {
+	samples := make([]int16, len(data))
+	for i, value := range data {
+		samples[i] = kG711UlawTable[value]
+	}
+	return samples
+}
+
+// EncodePcma encodes 16-bit samples to A-law bytes.
+func EncodePcma(samples []int16) []byte {
+	data := make([]byte, len(samples))
+	for i, sample := range samples {
+		data[i] = LinearToAlaw(sample)
+	}
+	return data
+}
+
+// DecodePcma decodes A-law bytes to 16-bit samples.
+func DecodePcma(data []byte) []int16 {
+	samples := make([]int16, len(data))
+	for i, value := range data {
+		samples[i] = kG711AlawTable[value]
+	}
+	return samples
+}
+
+// PcmuToPcma transcodes μ-law bytes to A-law directly.
+func PcmuToPcma(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, value := range data {
+		out[i] = kG711UlawToAlawT[value]
+	}
+	return out
+}
+
+// PcmaToPcmu transcodes A-law bytes to μ-law directly.
+func PcmaToPcmu(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, value := range data {
+		out[i] = kG711AlawToUlawT[value]
+	}
+	return out
+}
+
+// G711Codec converts between the 16-bit little-endian PCM bytes(e.g. of
+// Int16ToByteSlice) and the PCMU/PCMA RTP payloads at 8kHz.
+type G711Codec struct {
+	Alaw bool
+}
+
+// NewG711Codec creates a codec of SDP codec name(pcmu or pcma).
+func NewG711Codec(codec string) (*G711Codec, error) {
+	switch strings.ToLower(codec) {
+	case "pcmu":
+		return &G711Codec{Alaw: false}, nil
+	case "pcma":
+		return &G711Codec{Alaw: true}, nil
+	}
+	return nil, NewErrorf("G711 codec unsupported: %s", codec)
+}
+
+// Encode encodes little-endian PCM bytes into one payload.
+func (c *G711Codec) Encode(pcm []byte) ([]byte, error) {
+	samples, err := ByteToInt16Slice(pcm)
+	if err != nil {
+		return nil, NewError2(err, "G711 pcm invalid")
+	}
+	return c.EncodeSamples(samples), nil
+}
+
+// EncodeSamples encodes 16-bit samples into one payload.
+func (c *G711Codec) EncodeSamples(samples []int16) []byte {
+	if c.Alaw {
+		return EncodePcma(samples)
+	}
+	return EncodePcmu(samples)
+}
+
+// Decode decodes one payload into little-endian PCM bytes.
+func (c *G711Codec) Decode(payload []byte) []byte {
+	return Int16ToByteSlice(c.DecodeSamples(payload))
+}
+
+// DecodeSamples decodes one payload into 16-bit samples.
+func (c *G711Codec) DecodeSamples(payload []byte) []int16 {
+	if c.Alaw {
+		return DecodePcma(payload)
+	}
+	return DecodePcmu(payload)
+}
+
+// Silence returns the silent payload of n samples.
+func (c *G711Codec) Silence(n int) []byte {
+	value := G711_ULAW_SILENCE
+	if c.Alaw {
+		value = G711_ALAW_SILENCE
+	}
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = value
+	}
+	return data
+}
@@ -1,3 +1,3 @@
 module github.com/PeterXu/goutil
 
-go 1.16
+go 1.20
@@ -0,0 +1,755 @@
+package goutil
+
+// H.264 slice types(slice_type % 5).
+const (
+	H264_SLICE_P  = 0
+	H264_SLICE_B  = 1
+	H264_SLICE_I  = 2
+	H264_SLICE_SP = 3
+	H264_SLICE_SI = 4
+)
+
+const (
+	kH264MaxSpsId       = 31
+	kH264MaxPpsId       = 255
+	kH264ExtendedSar    = 255
+	kH264MaxRefIdx      = 32
+	kH264MaxSliceGroups = 8
+)
+
+// the sample aspect ratios of aspect_ratio_idc(Table E-1)
+var kH264SarTable = [][2]uint32{
+	{0, 0}, {1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11}, {32, 11},
+	{80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1},
+}
+
+// H264Vui is the VUI parameters of SPS(E.1.1).
+type H264Vui struct {
+	AspectRatioIdc uint32
+	SarWidth       uint32
+	SarHeight      uint32
+
+	VideoFormat             uint32 // 5: unspecified
+	FullRange               bool
+	ColourPrimaries         uint32 // 2: unspecified
+	TransferCharacteristics uint32 // 2: unspecified
+	MatrixCoefficients      uint32 // 2: unspecified
+
+	TimingInfoPresent bool
+	NumUnitsInTick    uint32
+	TimeScale         uint32
+	FixedFrameRate    bool
+
+	NalHrdPresent         bool
+	VclHrdPresent         bool
+	PicStructPresent      bool
+	BitstreamRestriction  bool
+	MaxNumReorderFrames   uint32
+	MaxDecFrameBuffering  uint32
+	LowDelayHrd           bool
+	ChromaLocTopField     uint32
+	ChromaLocBottomField  uint32
+	OverscanAppropriate   bool
+	MotionVectorsOverPicB bool
+}
+
+// FrameRate returns the frame rate of timing info, or 0.
+func (v *H264Vui) FrameRate() float64 {
+	if !v.TimingInfoPresent || v.NumUnitsInTick == 0 {
+		return 0
+	}
+	return float64(v.TimeScale) / float64(2*v.NumUnitsInTick)
+}
+
+// H264Sps is the sequence parameter set(7.3.2.1.1).
+type H264Sps struct {
+	ProfileIdc       uint8
+	ConstraintFlags  uint8 // constraint_set0..5_flag and reserved bits
+	LevelIdc         uint8
+	SpsId            uint32
+	ChromaFormatIdc  uint32
+	SeparateColour   bool
+	BitDepthLuma     uint32
+	BitDepthChroma   uint32
+	ScalingMatrix    bool
+	Log2MaxFrameNum  uint32
+	PicOrderCntType  uint32
+	Log2MaxPocLsb    uint32
+	DeltaPocZero     bool // delta_pic_order_always_zero_flag
+	MaxNumRefFrames  uint32
+	GapsInFrameNum   bool
+	PicWidthInMbs    uint32
+	PicHeightInMapUs uint32 // pic_height_in_map_units
+	FrameMbsOnly     bool
+	MbAdaptiveFrame  bool
+	Direct8x8        bool
+
+	FrameCropping bool
+	CropLeft      uint32
+	CropRight     uint32
+	CropTop       uint32
+	CropBottom    uint32
+
+	Width  int // the cropped size in luma samples
+	Height int
+
+	VuiPresent bool
+	Vui        H264Vui
+}
+
+// ChromaArrayType returns 0 for separate colour planes or monochrome, else chroma_format_idc.
+func (s *H264Sps) ChromaArrayType() uint32 {
+	if s.SeparateColour {
+		return 0
+	}
+	return s.ChromaFormatIdc
+}
+
+// FrameRate returns the frame rate of VUI timing info, or 0.
+func (s *H264Sps) FrameRate() float64 {
+	return s.Vui.FrameRate()
+}
+
+// H264Pps is the picture parameter set(7.3.2.2).
+type H264Pps struct {
+	PpsId                      uint32
+	SpsId                      uint32
+	EntropyCodingMode          bool // CABAC if true
+	BottomFieldPicOrderPresent bool
+	NumSliceGroups             uint32
+	SliceGroupMapType          uint32
+	SliceGroupChangeRate       uint32
+	NumRefIdxL0Active          uint32 // default
+	NumRefIdxL1Active          uint32 // default
+	WeightedPred               bool
+	WeightedBipredIdc          uint32
+	PicInitQp                  int32
+	PicInitQs                  int32
+	ChromaQpIndexOffset        int32
+	DeblockingFilterControl    bool
+	ConstrainedIntraPred       bool
+	RedundantPicCntPresent     bool
+	Transform8x8Mode           bool
+	PicScalingMatrix           bool
+	SecondChromaQpIndexOffset  int32
+}
+
+// H264SliceHeader is the slice header(7.3.3).
+type H264SliceHeader struct {
+	NalType   uint8
+	NalRefIdc uint8
+
+	FirstMbInSlice         uint32
+	SliceType              uint32 // slice_type % 5
+	PpsId                  uint32
+	ColourPlaneId          uint32
+	FrameNum               uint32
+	FieldPic               bool
+	BottomField            bool
+	IdrPicId               uint32
+	PicOrderCntLsb         uint32
+	DeltaPicOrderCntBottom int32
+	DeltaPicOrderCnt       [2]int32
+	RedundantPicCnt        uint32
+	DirectSpatialMvPred    bool
+	NumRefIdxL0Active      uint32
+	NumRefIdxL1Active      uint32
+
+	// dec_ref_pic_marking
+	NoOutputOfPriorPics   bool
+	LongTermReference     bool
+	AdaptiveRefPicMarking bool
+
+	CabacInitIdc               uint32
+	SliceQpDelta               int32
+	SpForSwitch                bool
+	SliceQsDelta               int32
+	DisableDeblockingFilterIdc uint32
+	SliceAlphaC0OffsetDiv2     int32
+	SliceBetaOffsetDiv2        int32
+	SliceGroupChangeCycle      uint32
+}
+
+// IsIdr checks whether the slice belongs to an IDR picture.
+func (h *H264SliceHeader) IsIdr() bool {
+	return h.NalType == H264_NAL_IDR
+}
+
+// IsIntra checks whether the slice is I or SI.
+func (h *H264SliceHeader) IsIntra() bool {
+	return h.SliceType == H264_SLICE_I || h.SliceType == H264_SLICE_SI
+}
+
+// h264Rbsp checks the NAL type and returns the RBSP after NAL header.
+func h264Rbsp(nalu []byte, ntype uint8) ([]byte, error) {
+	if len(nalu) < 2 {
+		return nil, NewErrorf("H264 NAL unit insufficient: %d", len(nalu))
+	}
+	if ntype != 0 && GetH264NalType(nalu) != ntype {
+		return nil, NewErrorf("H264 NAL type mismatch: %d != %d", GetH264NalType(nalu), ntype)
+	}
+	return RemoveEmulationPrevention(nalu[1:]), nil
+}
+
+// skipH264ScalingList skips one scaling_list() of size.
+func skipH264ScalingList(r *BitReader, size int) {
+	last, next := int32(8), int32(8)
+	for j := 0; j < size && r.Err() == nil; j++ {
+		if next != 0 {
+			delta := r.ReadSe()
+			next = (last + delta + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// ParseH264Sps parses one SPS NAL unit(with NAL header, without start code).
+func ParseH264Sps(nalu []byte) (*H264Sps, error) {
+	rbsp, err := h264Rbsp(nalu, H264_NAL_SPS)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewBitReader(rbsp)
+	s := &H264Sps{ChromaFormatIdc: 1, BitDepthLuma: 8, BitDepthChroma: 8}
+	s.ProfileIdc = uint8(r.ReadBits(8))
+	s.ConstraintFlags = uint8(r.ReadBits(8))
+	s.LevelIdc = uint8(r.ReadBits(8))
+	s.SpsId = r.ReadUe()
+	if r.Err() == nil && s.SpsId > kH264MaxSpsId {
+		return nil, NewErrorf("H264 SPS id invalid: %d", s.SpsId)
+	}
+
+	switch s.ProfileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		s.ChromaFormatIdc = r.ReadUe()
+		if s.ChromaFormatIdc > 3 {
+			return nil, NewErrorf("H264 SPS chroma_format_idc invalid: %d", s.ChromaFormatIdc)
+		}
+		if s.ChromaFormatIdc == 3 {
+			s.SeparateColour = r.ReadFlag()
+		}
+		s.BitDepthLuma = r.ReadUe() + 8
+		s.BitDepthChroma = r.ReadUe() + 8
+		r.SkipBits(1) // qpprime_y_zero_transform_bypass_flag
+		s.ScalingMatrix = r.ReadFlag()
+		if s.ScalingMatrix {
+			count := 8
+			if s.ChromaFormatIdc == 3 {
+				count = 12
+			}
+			for i := 0; i < count; i++ {
+				if r.ReadFlag() {
+					if i < 6 {
+						skipH264ScalingList(r, 16)
+					} else {
+						skipH264ScalingList(r, 64)
+					}
+				}
+			}
+		}
+	}
+
+	s.Log2MaxFrameNum = r.ReadUe() + 4
+	s.PicOrderCntType = r.ReadUe()
+	if s.PicOrderCntType == 0 {
+		s.Log2MaxPocLsb = r.ReadUe() + 4
+	} else if s.PicOrderCntType == 1 {
+		s.DeltaPocZero = r.ReadFlag()
+		r.ReadSe() // offset_for_non_ref_pic
+		r.ReadSe() // offset_for_top_to_bottom_field
+		num := r.ReadUe()
+		if num > 255 {
+			return nil, NewErrorf("H264 SPS num_ref_frames_in_pic_order_cnt_cycle invalid: %d", num)
+		}
+		for i := uint32(0); i < num; i++ {
+			r.ReadSe()
+		}
+	} else if s.PicOrderCntType > 2 {
+		return nil, NewErrorf("H264 SPS pic_order_cnt_type invalid: %d", s.PicOrderCntType)
+	}
+	if s.Log2MaxFrameNum > 16 || s.Log2MaxPocLsb > 16 {
+		return nil, NewErrorf("H264 SPS log2 invalid: %d, %d", s.Log2MaxFrameNum, s.Log2MaxPocLsb)
+	}
+
+	s.MaxNumRefFrames = r.ReadUe()
+	s.GapsInFrameNum = r.ReadFlag()
+	s.PicWidthInMbs = r.ReadUe() + 1
+	s.PicHeightInMapUs = r.ReadUe() + 1
+	s.FrameMbsOnly = r.ReadFlag()
+	if !s.FrameMbsOnly {
+		s.MbAdaptiveFrame = r.ReadFlag()
+	}
+	s.Direct8x8 = r.ReadFlag()
+	s.FrameCropping = r.ReadFlag()
+	if s.FrameCropping {
+		s.CropLeft = r.ReadUe()
+		s.CropRight = r.ReadUe()
+		s.CropTop = r.ReadUe()
+		s.CropBottom = r.ReadUe()
+	}
+	s.VuiPresent = r.ReadFlag()
+	if r.Err() != nil {
+		return nil, NewError2(r.Err(), "H264 SPS invalid")
+	}
+
+	// the cropped size(7.4.2.1.1)
+	fieldFactor := uint32(2)
+	if s.FrameMbsOnly {
+		fieldFactor = 1
+	}
+	cropUnitX, cropUnitY := uint32(1), fieldFactor
+	if s.ChromaArrayType() != 0 {
+		subWidth, subHeight := uint32(2), uint32(2)
+		if s.ChromaFormatIdc == 2 {
+			subHeight = 1
+		} else if s.ChromaFormatIdc == 3 {
+			subWidth, subHeight = 1, 1
+		}
+		cropUnitX, cropUnitY = subWidth, subHeight*fieldFactor
+	}
+	s.Width = int(s.PicWidthInMbs*16) - int(cropUnitX*(s.CropLeft+s.CropRight))
+	s.Height = int(fieldFactor*s.PicHeightInMapUs*16) - int(cropUnitY*(s.CropTop+s.CropBottom))
+	if s.Width <= 0 || s.Height <= 0 {
+		return nil, NewErrorf("H264 SPS size invalid: %dx%d", s.Width, s.Height)
+	}
+
+	// the truncated VUI is treated as absent, and the parsed fields are kept
+	defaultVui := H264Vui{VideoFormat: 5, ColourPrimaries: 2, TransferCharacteristics: 2, MatrixCoefficients: 2}
+	s.Vui = defaultVui
+	if s.VuiPresent && parseH264Vui(r, &s.Vui) != nil {
+		s.VuiPresent = false
+		s.Vui = defaultVui
+	}
+	return s, nil
+}
+
+// parseH264Vui parses vui_parameters()(E.1.1).
+func parseH264Vui(r *BitReader, v *H264Vui) error {
+	if r.ReadFlag() { // aspect_ratio_info_present_flag
+		v.AspectRatioIdc = r.ReadBits(8)
+		if v.AspectRatioIdc == kH264ExtendedSar {
+			v.SarWidth = r.ReadBits(16)
+			v.SarHeight = r.ReadBits(16)
+		} else if int(v.AspectRatioIdc) < len(kH264SarTable) {
+			v.SarWidth = kH264SarTable[v.AspectRatioIdc][0]
+			v.SarHeight = kH264SarTable[v.AspectRatioIdc][1]
+		}
+	}
+	if r.ReadFlag() { // overscan_info_present_flag
+		v.OverscanAppropriate = r.ReadFlag()
+	}
+	if r.ReadFlag() { // video_signal_type_present_flag
+		v.VideoFormat = r.ReadBits(3)
+		v.FullRange = r.ReadFlag()
+		if r.ReadFlag() { // colour_description_present_flag
+			v.ColourPrimaries = r.ReadBits(8)
+			v.TransferCharacteristics = r.ReadBits(8)
+			v.MatrixCoefficients = r.ReadBits(8)
+		}
+	}
+	if r.ReadFlag() { // chroma_loc_info_present_flag
+		v.ChromaLocTopField = r.ReadUe()
+		v.ChromaLocBottomField = r.ReadUe()
+	}
+	v.TimingInfoPresent = r.ReadFlag()
+	if v.TimingInfoPresent {
+		v.NumUnitsInTick = r.ReadBits(32)
+		v.TimeScale = r.ReadBits(32)
+		v.FixedFrameRate = r.ReadFlag()
+	}
+	v.NalHrdPresent = r.ReadFlag()
+	if v.NalHrdPresent {
+		skipH264Hrd(r)
+	}
+	v.VclHrdPresent = r.ReadFlag()
+	if v.VclHrdPresent {
+		skipH264Hrd(r)
+	}
+	if v.NalHrdPresent || v.VclHrdPresent {
+		v.LowDelayHrd = r.ReadFlag()
+	}
+	v.PicStructPresent = r.ReadFlag()
+	v.BitstreamRestriction = r.ReadFlag()
+	if v.BitstreamRestriction {
+		v.MotionVectorsOverPicB = r.ReadFlag()
+		r.ReadUe() // max_bytes_per_pic_denom
+		r.ReadUe() // max_bits_per_mb_denom
+		r.ReadUe() // log2_max_mv_length_horizontal
+		r.ReadUe() // log2_max_mv_length_vertical
+		v.MaxNumReorderFrames = r.ReadUe()
+		v.MaxDecFrameBuffering = r.ReadUe()
+	}
+	if r.Err() != nil {
+		return NewError2(r.Err(), "H264 VUI invalid")
+	}
+	return nil
+}
+
+// skipH264Hrd skips hrd_parameters()(E.1.2).
+func skipH264Hrd(r *BitReader) {
+	count := r.ReadUe() + 1 // cpb_cnt_minus1
+	if count > 32 {
+		r.SkipBits(r.BitsLeft() + 1) // invalid, set error
+		return
+	}
+	r.SkipBits(4 + 4) // bit_rate_scale, cpb_size_scale
+	for i := uint32(0); i < count; i++ {
+		r.ReadUe()    // bit_rate_value_minus1
+		r.ReadUe()    // cpb_size_value_minus1
+		r.SkipBits(1) // cbr_flag
+	}
+	r.SkipBits(5 * 4) // delay lengths and time_offset_length
+}
+
+// ParseH264Pps parses one PPS NAL unit, and sps(optional) is used for its
+// chroma_format_idc(1 if nil).
+func ParseH264Pps(nalu []byte, sps *H264Sps) (*H264Pps, error) {
+	rbsp, err := h264Rbsp(nalu, H264_NAL_PPS)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewBitReader(rbsp)
+	p := &H264Pps{}
+	p.PpsId = r.ReadUe()
+	p.SpsId = r.ReadUe()
+	if r.Err() == nil && (p.PpsId > kH264MaxPpsId || p.SpsId > kH264MaxSpsId) {
+		return nil, NewErrorf("H264 PPS id invalid: %d, %d", p.PpsId, p.SpsId)
+	}
+	p.EntropyCodingMode = r.ReadFlag()
+	p.BottomFieldPicOrderPresent = r.ReadFlag()
+	p.NumSliceGroups = r.ReadUe() + 1
+	if p.NumSliceGroups > kH264MaxSliceGroups {
+		return nil, NewErrorf("H264 PPS num_slice_groups invalid: %d", p.NumSliceGroups)
+	}
+	if p.NumSliceGroups > 1 {
+		p.SliceGroupMapType = r.ReadUe()
+		switch p.SliceGroupMapType {
+		case 0:
+			for i := uint32(0); i < p.NumSliceGroups; i++ {
+				r.ReadUe() // run_length_minus1
+			}
+		case 2:
+			for i := uint32(0); i+1 < p.NumSliceGroups; i++ {
+				r.ReadUe() // top_left
+				r.ReadUe() // bottom_right
+			}
+		case 3, 4, 5:
+			r.SkipBits(1) // slice_group_change_direction_flag
+			p.SliceGroupChangeRate = r.ReadUe() + 1
+		case 6:
+			units := r.ReadUe() + 1 // pic_size_in_map_units_minus1
+			bits := 0
+			for (uint32(1) << uint(bits)) < p.NumSliceGroups {
+				bits++
+			}
+			if units > 1<<22 {
+				return nil, NewErrorf("H264 PPS pic_size_in_map_units invalid: %d", units)
+			}
+			for i := uint32(0); i < units && r.Err() == nil; i++ {
+				r.SkipBits(bits) // slice_group_id
+			}
+		}
+	}
+	p.NumRefIdxL0Active = r.ReadUe() + 1
+	p.NumRefIdxL1Active = r.ReadUe() + 1
+	if p.NumRefIdxL0Active > kH264MaxRefIdx || p.NumRefIdxL1Active > kH264MaxRefIdx {
+		return nil, NewErrorf("H264 PPS num_ref_idx invalid: %d, %d", p.NumRefIdxL0Active, p.NumRefIdxL1Active)
+	}
+	p.WeightedPred = r.ReadFlag()
+	p.WeightedBipredIdc = r.ReadBits(2)
+	p.PicInitQp = 26 + r.ReadSe()
+	p.PicInitQs = 26 + r.ReadSe()
+	p.ChromaQpIndexOffset = r.ReadSe()
+	p.DeblockingFilterControl = r.ReadFlag()
+	p.ConstrainedIntraPred = r.ReadFlag()
+	p.RedundantPicCntPresent = r.ReadFlag()
+	p.SecondChromaQpIndexOffset = p.ChromaQpIndexOffset
+	if r.MoreRbspData() {
+		p.Transform8x8Mode = r.ReadFlag()
+		p.PicScalingMatrix = r.ReadFlag()
+		if p.PicScalingMatrix {
+			chromaFormatIdc := uint32(1)
+			if sps != nil {
+				chromaFormatIdc = sps.ChromaFormatIdc
+			}
+			count := 6
+			if p.Transform8x8Mode {
+				if chromaFormatIdc == 3 {
+					count += 6
+				} else {
+					count += 2
+				}
+			}
+			for i := 0; i < count; i++ {
+				if r.ReadFlag() {
+					if i < 6 {
+						skipH264ScalingList(r, 16)
+					} else {
+						skipH264ScalingList(r, 64)
+					}
+				}
+			}
+		}
+		p.SecondChromaQpIndexOffset = r.ReadSe()
+	}
+	if r.Err() != nil {
+		return nil, NewError2(r.Err(), "H264 PPS invalid")
+	}
+	return p, nil
+}
+
+// ParseH264SliceHeader parses the slice header of one slice NAL unit(type 1 or 5)
+// with its active SPS and PPS.
+func ParseH264SliceHeader(nalu []byte, sps *H264Sps, pps *H264Pps) (*H264SliceHeader, error) {
+	ntype := GetH264NalType(nalu)
+	if ntype != H264_NAL_SLICE && ntype != H264_NAL_IDR {
+		return nil, NewErrorf("H264 NAL type is not slice: %d", ntype)
+	}
+	if sps == nil || pps == nil {
+		return nil, NewErrorf("H264 slice without SPS/PPS")
+	}
+	rbsp, err := h264Rbsp(nalu, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewBitReader(rbsp)
+	h := &H264SliceHeader{NalType: ntype, NalRefIdc: (nalu[0] & kH264NalNriMask) >> 5}
+	h.FirstMbInSlice = r.ReadUe()
+	h.SliceType = r.ReadUe()
+	if h.SliceType > 9 {
+		return nil, NewErrorf("H264 slice_type invalid: %d", h.SliceType)
+	}
+	h.SliceType %= 5
+	h.PpsId = r.ReadUe()
+	if r.Err() == nil && h.PpsId != pps.PpsId {
+		return nil, NewErrorf("H264 slice pps id mismatch: %d != %d", h.PpsId, pps.PpsId)
+	}
+	if sps.SeparateColour {
+		h.ColourPlaneId = r.ReadBits(2)
+	}
+	h.FrameNum = r.ReadBits(int(sps.Log2MaxFrameNum))
+	if !sps.FrameMbsOnly {
+		h.FieldPic = r.ReadFlag()
+		if h.FieldPic {
+			h.BottomField = r.ReadFlag()
+		}
+	}
+	if h.IsIdr() {
+		h.IdrPicId = r.ReadUe()
+	}
+	if sps.PicOrderCntType == 0 {
+		h.PicOrderCntLsb = r.ReadBits(int(sps.Log2MaxPocLsb))
+		if pps.BottomFieldPicOrderPresent && !h.FieldPic {
+			h.DeltaPicOrderCntBottom = r.ReadSe()
+		}
+	}
+	if sps.PicOrderCntType == 1 && !sps.DeltaPocZero {
+		h.DeltaPicOrderCnt[0] = r.ReadSe()
+		if pps.BottomFieldPicOrderPresent && !h.FieldPic {
+			h.DeltaPicOrderCnt[1] = r.ReadSe()
+		}
+	}
+	if pps.RedundantPicCntPresent {
+		h.RedundantPicCnt = r.ReadUe()
+	}
+
+	isB := h.SliceType == H264_SLICE_B
+	isP := h.SliceType == H264_SLICE_P || h.SliceType == H264_SLICE_SP
+	if isB {
+		h.DirectSpatialMvPred = r.ReadFlag()
+	}
+	h.NumRefIdxL0Active = pps.NumRefIdxL0Active
+	h.NumRefIdxL1Active = pps.NumRefIdxL1Active
+	if isP || isB {
+		if r.ReadFlag() { // num_ref_idx_active_override_flag
+			h.NumRefIdxL0Active = r.ReadUe() + 1
+			if isB {
+				h.NumRefIdxL1Active = r.ReadUe() + 1
+			}
+		}
+	}
+	if h.NumRefIdxL0Active > kH264MaxRefIdx || h.NumRefIdxL1Active > kH264MaxRefIdx {
+		return nil, NewErrorf("H264 slice num_ref_idx invalid: %d, %d", h.NumRefIdxL0Active, h.NumRefIdxL1Active)
+	}
+
+	// ref_pic_list_modification()
+	if !h.IsIntra() {
+		skipH264RefPicListModification(r)
+		if isB {
+			skipH264RefPicListModification(r)
+		}
+	}
+	if (pps.WeightedPred && isP) || (pps.WeightedBipredIdc == 1 && isB) {
+		skipH264PredWeightTable(r, sps, h, isB)
+	}
+	if h.NalRefIdc != 0 {
+		// dec_ref_pic_marking()
+		if h.IsIdr() {
+			h.NoOutputOfPriorPics = r.ReadFlag()
+			h.LongTermReference = r.ReadFlag()
+		} else {
+			h.AdaptiveRefPicMarking = r.ReadFlag()
+			if h.AdaptiveRefPicMarking {
+				for r.Err() == nil {
+					mmco := r.ReadUe()
+					if mmco == 0 {
+						break
+					}
+					if mmco == 1 || mmco == 3 {
+						r.ReadUe() // difference_of_pic_nums_minus1
+					}
+					if mmco == 2 {
+						r.ReadUe() // long_term_pic_num
+					}
+					if mmco == 3 || mmco == 6 {
+						r.ReadUe() // long_term_frame_idx
+					}
+					if mmco == 4 {
+						r.ReadUe() // max_long_term_frame_idx_plus1
+					}
+				}
+			}
+		}
+	}
+	if pps.EntropyCodingMode && !h.IsIntra() {
+		h.CabacInitIdc = r.ReadUe()
+	}
+	h.SliceQpDelta = r.ReadSe()
+	if h.SliceType == H264_SLICE_SP || h.SliceType == H264_SLICE_SI {
+		if h.SliceType == H264_SLICE_SP {
+			h.SpForSwitch = r.ReadFlag()
+		}
+		h.SliceQsDelta = r.ReadSe()
+	}
+	if pps.DeblockingFilterControl {
+		h.DisableDeblockingFilterIdc = r.ReadUe()
+		if h.DisableDeblockingFilterIdc != 1 {
+			h.SliceAlphaC0OffsetDiv2 = r.ReadSe()
+			h.SliceBetaOffsetDiv2 = r.ReadSe()
+		}
+	}
+	if pps.NumSliceGroups > 1 && pps.SliceGroupMapType >= 3 && pps.SliceGroupMapType <= 5 {
+		units := sps.PicWidthInMbs * sps.PicHeightInMapUs
+		value := units/pps.SliceGroupChangeRate + 1
+		if units%pps.SliceGroupChangeRate != 0 {
+			value += 1
+		}
+		bits := 0
+		for (uint32(1) << uint(bits)) < value {
+			bits++
+		}
+		h.SliceGroupChangeCycle = r.ReadBits(bits)
+	}
+	if r.Err() != nil {
+		return nil, NewError2(r.Err(), "H264 slice header invalid")
+	}
+	return h, nil
+}
+
+func skipH264RefPicListModification(r *BitReader) {
+	if !r.ReadFlag() { // ref_pic_list_modification_flag
+		return
+	}
+	for r.Err() == nil {
+		idc := r.ReadUe() // modification_of_pic_nums_idc
+		if idc == 3 {
+			break
+		}
+		if idc > 5 {
+			r.SkipBits(r.BitsLeft() + 1) // invalid, set error
+			break
+		}
+		r.ReadUe() // abs_diff_pic_num_minus1, long_term_pic_num or abs_diff_view_idx_minus1
+	}
+}
+
+func skipH264PredWeightTable(r *BitReader, sps *H264Sps, h *H264SliceHeader, isB bool) {
+	r.ReadUe() // luma_log2_weight_denom
+	if sps.ChromaArrayType() != 0 {
+		r.ReadUe() // chroma_log2_weight_denom
+	}
+	lists := []uint32{h.NumRefIdxL0Active}
+	if isB {
+		lists = append(lists, h.NumRefIdxL1Active)
+	}
+	for _, num := range lists {
+		for i := uint32(0); i < num && r.Err() == nil; i++ {
+			if r.ReadFlag() { // luma_weight_flag
+				r.ReadSe()
+				r.ReadSe()
+			}
+			if sps.ChromaArrayType() != 0 && r.ReadFlag() { // chroma_weight_flag
+				for j := 0; j < 2; j++ {
+					r.ReadSe()
+					r.ReadSe()
+				}
+			}
+		}
+	}
+}
+
+// H264Parser keeps the SPS/PPS of one H.264 stream to parse its slice headers.
+type H264Parser struct {
+	Sps map[uint32]*H264Sps
+	Pps map[uint32]*H264Pps
+}
+
+func NewH264Parser() *H264Parser {
+	return &H264Parser{
+		Sps: make(map[uint32]*H264Sps),
+		Pps: make(map[uint32]*H264Pps),
+	}
+}
+
+// ParseNalu parses one NAL unit: SPS/PPS are stored, and the slice header is
+// returned for slices(nil for other types).
+func (p *H264Parser) ParseNalu(nalu []byte) (*H264SliceHeader, error) {
+	switch GetH264NalType(nalu) {
+	case H264_NAL_SPS:
+		sps, err := ParseH264Sps(nalu)
+		if err != nil {
+			return nil, err
+		}
+		p.Sps[sps.SpsId] = sps
+	case H264_NAL_PPS:
+		rbsp, err := h264Rbsp(nalu, 0)
+		if err != nil {
+			return nil, err
+		}
+		r := NewBitReader(rbsp)
+		r.ReadUe() // pps_id
+		spsId := r.ReadUe()
+		pps, err := ParseH264Pps(nalu, p.Sps[spsId])
+		if err != nil {
+			return nil, err
+		}
+		p.Pps[pps.PpsId] = pps
+	case H264_NAL_SLICE, H264_NAL_IDR:
+		rbsp, err := h264Rbsp(nalu, 0)
+		if err != nil {
+			return nil, err
+		}
+		r := NewBitReader(rbsp)
+		r.ReadUe() // first_mb_in_slice
+		r.ReadUe() // slice_type
+		ppsId := r.ReadUe()
+		if r.Err() != nil {
+			return nil, NewError2(r.Err(), "H264 slice header invalid")
+		}
+		pps, ok := p.Pps[ppsId]
+		if !ok {
+			return nil, NewErrorf("H264 slice with unknown pps: %d", ppsId)
+		}
+		sps, ok := p.Sps[pps.SpsId]
+		if !ok {
+			return nil, NewErrorf("H264 slice with unknown sps: %d", pps.SpsId)
+		}
+		return ParseH264SliceHeader(nalu, sps, pps)
+	}
+	return nil, nil
+}
@@ -0,0 +1,285 @@
+package goutil
+
+// H.265 NAL unit types(ITU-T H.265 Table 7-1 and RFC 7798).
+const (
+	H265_NAL_TRAIL_N    uint8 = 0
+	H265_NAL_TRAIL_R    uint8 = 1
+	H265_NAL_BLA_W_LP   uint8 = 16
+	H265_NAL_BLA_W_RADL uint8 = 17
+	H265_NAL_BLA_N_LP   uint8 = 18
+	H265_NAL_IDR_W_RADL uint8 = 19
+	H265_NAL_IDR_N_LP   uint8 = 20
+	H265_NAL_CRA        uint8 = 21
+	H265_NAL_IRAP_MAX   uint8 = 23 // the reserved IRAP types 22..23
+	H265_NAL_VPS        uint8 = 32
+	H265_NAL_SPS        uint8 = 33
+	H265_NAL_PPS        uint8 = 34
+	H265_NAL_AUD        uint8 = 35
+	H265_NAL_EOS        uint8 = 36
+	H265_NAL_EOB        uint8 = 37
+	H265_NAL_FD         uint8 = 38
+	H265_NAL_SEI_PREFIX uint8 = 39
+	H265_NAL_SEI_SUFFIX uint8 = 40
+	H265_NAL_AP         uint8 = 48
+	H265_NAL_FU         uint8 = 49
+	H265_NAL_PACI       uint8 = 50
+)
+
+const (
+	kH265NalHeaderSize = 2
+	kH265MaxSubLayers  = 7
+	kH265MaxVpsId      = 15
+	kH265MaxSpsId      = 15
+	kH265MaxPpsId      = 63
+)
+
+// GetH265NalType returns the type of one NAL unit.
+func GetH265NalType(nalu []byte) uint8 {
+	if len(nalu) == 0 {
+		return 0
+	}
+	return (nalu[0] >> 1) & 0x3F
+}
+
+// IsH265Irap checks whether the NAL type is IRAP(BLA, IDR or CRA), i.e. keyframe.
+func IsH265Irap(ntype uint8) bool {
+	return ntype >= H265_NAL_BLA_W_LP && ntype <= H265_NAL_IRAP_MAX
+}
+
+// H265ProfileTierLevel is the general profile_tier_level()(7.3.3).
+type H265ProfileTierLevel struct {
+	ProfileSpace       uint8
+	TierFlag           bool
+	ProfileIdc         uint8
+	CompatibilityFlags uint32
+	ConstraintFlags    uint64 // progressive, interlaced, non-packed, frame-only and 44 bits
+	LevelIdc           uint8  // 30 * level, e.g. 93 for level 3.1
+}
+
+// H265Vps is the video parameter set(7.3.2.1).
+type H265Vps struct {
+	VpsId             uint8
+	MaxLayers         uint8
+	MaxSubLayers      uint8
+	TemporalIdNesting bool
+	Ptl               H265ProfileTierLevel
+}
+
+// H265Sps is the sequence parameter set(7.3.2.2), parsed to log2_max_pic_order_cnt_lsb.
+type H265Sps struct {
+	VpsId             uint8
+	MaxSubLayers      uint8
+	TemporalIdNesting bool
+	Ptl               H265ProfileTierLevel
+	SpsId             uint32
+	ChromaFormatIdc   uint32
+	SeparateColour    bool
+	PicWidth          uint32 // pic_width_in_luma_samples
+	PicHeight         uint32 // pic_height_in_luma_samples
+
+	ConformanceWindow bool
+	ConfWinLeft       uint32
+	ConfWinRight      uint32
+	ConfWinTop        uint32
+	ConfWinBottom     uint32
+
+	Width  int // the cropped size in luma samples
+	Height int
+
+	BitDepthLuma   uint32
+	BitDepthChroma uint32
+	Log2MaxPocLsb  uint32
+}
+
+// H265Pps is the picture parameter set(7.3.2.3), parsed to entropy_coding_sync_enabled_flag.
+type H265Pps struct {
+	PpsId                      uint32
+	SpsId                      uint32
+	DependentSliceSegments     bool
+	OutputFlagPresent          bool
+	NumExtraSliceHeaderBits    uint32
+	SignDataHiding             bool
+	CabacInitPresent           bool
+	NumRefIdxL0Active          uint32 // default
+	NumRefIdxL1Active          uint32 // default
+	InitQp                     int32
+	ConstrainedIntraPred       bool
+	TransformSkip              bool
+	CuQpDeltaEnabled           bool
+	DiffCuQpDeltaDepth         uint32
+	CbQpOffset                 int32
+	CrQpOffset                 int32
+	SliceChromaQpOffsetPresent bool
+	WeightedPred               bool
+	WeightedBipred             bool
+	TransquantBypass           bool
+	TilesEnabled               bool
+	EntropyCodingSync          bool
+}
+
+// h265Rbsp checks the NAL type and returns the RBSP after NAL header.
+func h265Rbsp(nalu []byte, ntype uint8) ([]byte, error) {
+	if len(nalu) < kH265NalHeaderSize+1 {
+		return nil, NewErrorf("H265 NAL unit insufficient: %d", len(nalu))
+	}
+	if GetH265NalType(nalu) != ntype {
+		return nil, NewErrorf("H265 NAL type mismatch: %d != %d", GetH265NalType(nalu), ntype)
+	}
+	return RemoveEmulationPrevention(nalu[kH265NalHeaderSize:]), nil
+}
+
+// parseH265ProfileTierLevel parses profile_tier_level(1, maxSubLayersMinus1).
+func parseH265ProfileTierLevel(r *BitReader, ptl *H265ProfileTierLevel, maxSubLayersMinus1 int) {
+	ptl.ProfileSpace = uint8(r.ReadBits(2))
+	ptl.TierFlag = r.ReadFlag()
+	ptl.ProfileIdc = uint8(r.ReadBits(5))
+	ptl.CompatibilityFlags = r.ReadBits(32)
+	ptl.ConstraintFlags = r.ReadBits64(48)
+	ptl.LevelIdc = uint8(r.ReadBits(8))
+
+	var profilePresent, levelPresent [kH265MaxSubLayers]bool
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		profilePresent[i] = r.ReadFlag()
+		levelPresent[i] = r.ReadFlag()
+	}
+	if maxSubLayersMinus1 > 0 {
+		for i := maxSubLayersMinus1; i < 8; i++ {
+			r.SkipBits(2) // reserved_zero_2bits
+		}
+	}
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if profilePresent[i] {
+			r.SkipBits(88)
+		}
+		if levelPresent[i] {
+			r.SkipBits(8)
+		}
+	}
+}
+
+// ParseH265Vps parses one VPS NAL unit(with NAL header, without start code).
+func ParseH265Vps(nalu []byte) (*H265Vps, error) {
+	rbsp, err := h265Rbsp(nalu, H265_NAL_VPS)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewBitReader(rbsp)
+	v := &H265Vps{}
+	v.VpsId = uint8(r.ReadBits(4))
+	r.SkipBits(2) // vps_base_layer_internal_flag, vps_base_layer_available_flag
+	v.MaxLayers = uint8(r.ReadBits(6)) + 1
+	v.MaxSubLayers = uint8(r.ReadBits(3)) + 1
+	v.TemporalIdNesting = r.ReadFlag()
+	r.SkipBits(16) // vps_reserved_0xffff_16bits
+	if v.MaxSubLayers > kH265MaxSubLayers {
+		return nil, NewErrorf("H265 VPS max_sub_layers invalid: %d", v.MaxSubLayers)
+	}
+	parseH265ProfileTierLevel(r, &v.Ptl, int(v.MaxSubLayers)-1)
+	if r.Err() != nil {
+		return nil, NewError2(r.Err(), "H265 VPS invalid")
+	}
+	return v, nil
+}
+
+// ParseH265Sps parses one SPS NAL unit for the resolution and profile-tier-level.
+func ParseH265Sps(nalu []byte) (*H265Sps, error) {
+	rbsp, err := h265Rbsp(nalu, H265_NAL_SPS)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewBitReader(rbsp)
+	s := &H265Sps{}
+	s.VpsId = uint8(r.ReadBits(4))
+	s.MaxSubLayers = uint8(r.ReadBits(3)) + 1
+	s.TemporalIdNesting = r.ReadFlag()
+	if s.MaxSubLayers > kH265MaxSubLayers {
+		return nil, NewErrorf("H265 SPS max_sub_layers invalid: %d", s.MaxSubLayers)
+	}
+	parseH265ProfileTierLevel(r, &s.Ptl, int(s.MaxSubLayers)-1)
+	s.SpsId = r.ReadUe()
+	if r.Err() == nil && s.SpsId > kH265MaxSpsId {
+		return nil, NewErrorf("H265 SPS id invalid: %d", s.SpsId)
+	}
+	s.ChromaFormatIdc = r.ReadUe()
+	if s.ChromaFormatIdc > 3 {
+		return nil, NewErrorf("H265 SPS chroma_format_idc invalid: %d", s.ChromaFormatIdc)
+	}
+	if s.ChromaFormatIdc == 3 {
+		s.SeparateColour = r.ReadFlag()
+	}
+	s.PicWidth = r.ReadUe()
+	s.PicHeight = r.ReadUe()
+	s.ConformanceWindow = r.ReadFlag()
+	if s.ConformanceWindow {
+		s.ConfWinLeft = r.ReadUe()
+		s.ConfWinRight = r.ReadUe()
+		s.ConfWinTop = r.ReadUe()
+		s.ConfWinBottom = r.ReadUe()
+	}
+	s.BitDepthLuma = r.ReadUe() + 8
+	s.BitDepthChroma = r.ReadUe() + 8
+	s.Log2MaxPocLsb = r.ReadUe() + 4
+	if r.Err() != nil {
+		return nil, NewError2(r.Err(), "H265 SPS invalid")
+	}
+
+	// the cropped size(7.4.3.2.1)
+	subWidth, subHeight := uint32(1), uint32(1)
+	if !s.SeparateColour {
+		if s.ChromaFormatIdc == 1 {
+			subWidth, subHeight = 2, 2
+		} else if s.ChromaFormatIdc == 2 {
+			subWidth = 2
+		}
+	}
+	s.Width = int(s.PicWidth) - int(subWidth*(s.ConfWinLeft+s.ConfWinRight))
+	s.Height = int(s.PicHeight) - int(subHeight*(s.ConfWinTop+s.ConfWinBottom))
+	if s.Width <= 0 || s.Height <= 0 {
+		return nil, NewErrorf("H265 SPS size invalid: %dx%d", s.Width, s.Height)
+	}
+	return s, nil
+}
+
+// ParseH265Pps parses one PPS NAL unit.
+func ParseH265Pps(nalu []byte) (*H265Pps, error) {
+	rbsp, err := h265Rbsp(nalu, H265_NAL_PPS)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewBitReader(rbsp)
+	p := &H265Pps{}
+	p.PpsId = r.ReadUe()
+	p.SpsId = r.ReadUe()
+	if r.Err() == nil && (p.PpsId > kH265MaxPpsId || p.SpsId > kH265MaxSpsId) {
+		return nil, NewErrorf("H265 PPS id invalid: %d, %d", p.PpsId, p.SpsId)
+	}
+	p.DependentSliceSegments = r.ReadFlag()
+	p.OutputFlagPresent = r.ReadFlag()
+	p.NumExtraSliceHeaderBits = r.ReadBits(3)
+	p.SignDataHiding = r.ReadFlag()
+	p.CabacInitPresent = r.ReadFlag()
+	p.NumRefIdxL0Active = r.ReadUe() + 1
+	p.NumRefIdxL1Active = r.ReadUe() + 1
+	p.InitQp = 26 + r.ReadSe()
+	p.ConstrainedIntraPred = r.ReadFlag()
+	p.TransformSkip = r.ReadFlag()
+	p.CuQpDeltaEnabled = r.ReadFlag()
+	if p.CuQpDeltaEnabled {
+		p.DiffCuQpDeltaDepth = r.ReadUe()
+	}
+	p.CbQpOffset = r.ReadSe()
+	p.CrQpOffset = r.ReadSe()
+	p.SliceChromaQpOffsetPresent = r.ReadFlag()
+	p.WeightedPred = r.ReadFlag()
+	p.WeightedBipred = r.ReadFlag()
+	p.TransquantBypass = r.ReadFlag()
+	p.TilesEnabled = r.ReadFlag()
+	p.EntropyCodingSync = r.ReadFlag()
+	if r.Err() != nil {
+		return nil, NewError2(r.Err(), "H265 PPS invalid")
+	}
+	return p, nil
+}
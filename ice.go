@@ -3,13 +3,13 @@ package goutil
 import (
 	"bytes"
 	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
 	"crypto/sha1"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"hash/crc32"
-	"io"
 	"net"
-	"unsafe"
 )
 
 // StunMessageType 2-bytes
@@ -41,13 +41,14 @@ const (
 	STUN_ATTR_NONCE              StunAttributeType = 0x0015 // ByteString
 	STUN_ATTR_XOR_MAPPED_ADDRESS StunAttributeType = 0x0020 // XorAddress
 	STUN_ATTR_SOFTWARE           StunAttributeType = 0x8022 // ByteString
-	STUN_ATTR_ALTERNATE_SERVER   StunAttributeType = 0x8023 // ByteString
+	STUN_ATTR_ALTERNATE_SERVER   StunAttributeType = 0x8023 // Address
 	STUN_ATTR_FINGERPRINT        StunAttributeType = 0x8028 // UInt32
 	STUN_ATTR_RETRANSMIT_COUNT   StunAttributeType = 0xFF00 // UInt32
 
 	// RFC 5245 ICE STUN attributes.
 	STUN_ATTR_PRIORITY        StunAttributeType = 0x0024 // UInt32
 	STUN_ATTR_USE_CANDIDATE   StunAttributeType = 0x0025 // No content, Length = 0
+	STUN_ATTR_ICE_CONTROLLED  StunAttributeType = 0x8029 // UInt64
 	STUN_ATTR_ICE_CONTROLLING StunAttributeType = 0x802A // UInt64
 	STUN_ATTR_NETWORK_INFO    StunAttributeType = 0xC057 // UInt32
 )
@@ -103,15 +104,48 @@ const (
 	kStunMessageIntegritySize int = 20
 
 	STUN_FINGERPRINT_XOR_VALUE uint32 = 0x5354554E
+
+	// STUN FINGERPRINT attribute size.
+	kStunFingerprintSize int = 4
+
+	// The attributes of type 0x0000-0x7FFF are comprehension-required.
+	kStunComprehensionOptional StunAttributeType = 0x8000
+
+	// The class bits of error response, e.g. 0x0111 for binding.
+	kStunErrorResponseMask StunMessageType = 0x0110
+
+	STUN_ERROR_TRY_ALTERNATE     int = 300
+	STUN_ERROR_UNKNOWN_ATTRIBUTE int = 420
+)
+
+// These are the errors of validating MESSAGE-INTEGRITY and FINGERPRINT.
+var (
+	ErrStunNoRawMessage        = errors.New("stun message not read from raw bytes")
+	ErrStunNoMessageIntegrity  = errors.New("stun MESSAGE-INTEGRITY missing")
+	ErrStunIntegrityInvalid    = errors.New("stun MESSAGE-INTEGRITY invalid size")
+	ErrStunIntegrityMismatch   = errors.New("stun MESSAGE-INTEGRITY mismatch")
+	ErrStunNoFingerprint       = errors.New("stun FINGERPRINT missing")
+	ErrStunFingerprintNotLast  = errors.New("stun FINGERPRINT not the last attribute")
+	ErrStunFingerprintMismatch = errors.New("stun FINGERPRINT mismatch")
 )
 
 func NewStunMessageRequest() *StunMessage {
 	return &StunMessage{
 		Dtype:   STUN_BINDING_REQUEST,
-		TransId: RandomString(kStunTransactionIdLength),
+		TransId: NewStunTransId(),
 	}
 }
 
+// NewStunTransId returns a cryptographically random transaction ID of RFC 5389.
+func NewStunTransId() string {
+	transId := make([]byte, kStunTransactionIdLength)
+	if _, err := rand.Read(transId); err != nil {
+		// crypto/rand fails only if the system random source is broken
+		panic(err)
+	}
+	return string(transId)
+}
+
 func NewStunMessageResponse(transId string) *StunMessage {
 	return &StunMessage{
 		Dtype:   STUN_BINDING_RESPONSE,
@@ -131,6 +165,11 @@ type StunMessage struct {
 	TransId    string
 	Attrs      map[StunAttributeType]StunAttribute
 	OrderAttrs []StunAttribute
+	Raw        []byte // the raw bytes of Read(not copied)
+
+	// UnknownAttrs are the unknown comprehension-required attributes of Read,
+	// which should be rejected by GenStunUnknownAttributesResponse.
+	UnknownAttrs []StunAttributeType
 }
 
 // IceMessage is A RFC 5245 ICE STUN message.
@@ -138,114 +177,60 @@ type IceMessage struct {
 	StunMessage
 }
 
+// kStunAttributeFactories creates the attributes decoded by Read, and the
+// others of comprehension-required are unknown.
+var kStunAttributeFactories = map[StunAttributeType]func() StunAttribute{
+	STUN_ATTR_MAPPED_ADDRESS:     func() StunAttribute { return &StunAddressAttribute{} },
+	STUN_ATTR_XOR_MAPPED_ADDRESS: func() StunAttribute { return &StunXorAddressAttribute{} },
+	STUN_ATTR_USERNAME:           func() StunAttribute { return &StunByteStringAttribute{} },
+	STUN_ATTR_ERROR_CODE:         func() StunAttribute { return &StunErrorCodeAttribute{} },
+	STUN_ATTR_MESSAGE_INTEGRITY:  func() StunAttribute { return &StunByteStringAttribute{} },
+	STUN_ATTR_FINGERPRINT:        func() StunAttribute { return &StunUInt32Attribute{} },
+	STUN_ATTR_UNKNOWN_ATTRIBUTES: func() StunAttribute { return &StunUInt16ListAttribute{} },
+	STUN_ATTR_REALM:              func() StunAttribute { return &StunByteStringAttribute{} },
+	STUN_ATTR_NONCE:              func() StunAttribute { return &StunByteStringAttribute{} },
+	STUN_ATTR_SOFTWARE:           func() StunAttribute { return &StunByteStringAttribute{} },
+	STUN_ATTR_ALTERNATE_SERVER:   func() StunAttribute { return &StunAddressAttribute{} },
+	STUN_ATTR_PRIORITY:           func() StunAttribute { return &StunUInt32Attribute{} },
+	STUN_ATTR_NETWORK_INFO:       func() StunAttribute { return &StunUInt32Attribute{} },
+	STUN_ATTR_RETRANSMIT_COUNT:   func() StunAttribute { return &StunUInt32Attribute{} },
+	STUN_ATTR_USE_CANDIDATE:      func() StunAttribute { return &StunEmptyAttribute{} },
+	STUN_ATTR_ICE_CONTROLLING:    func() StunAttribute { return &StunUInt64Attribute{} },
+	STUN_ATTR_ICE_CONTROLLED:     func() StunAttribute { return &StunUInt64Attribute{} },
+}
+
 // Read Parses the STUN packet in the given buffer and records it here. The
-// return value indicates whether this was successful.
+// return value indicates whether this was successful. It is the convenience
+// layer of StunDecoder, which should be used for the busy path.
 func (m *StunMessage) Read(data []byte) error {
-	if len(data) < kStunHeaderSize {
-		return NewError("invalid stun size=", len(data))
-	}
-
-	// check the 1st byte
-	utype := data[0]
-	if utype != 0 && utype != 1 {
-		return NewError("invalid utype=", utype)
-	}
-
-	// create Reader
-	buf := bytes.NewReader(data)
-
-	// 0-2, read stun message type
-	ReadBig(buf, &m.Dtype)
-	//fmt.Println("[ice] message type=", m.Dtype)
-	if (m.Dtype & 0x8000) != 0 {
-		// RTP and RTCP set the MSB of first byte, since first two bits are version,
-		// and version is always 2 (10). If set, this is not a STUN packet.
-		return NewError("not stun message, (RTP/RTCP)type=", m.Dtype)
-	}
-
-	// 2-4, read stun message size
-	ReadBig(buf, &m.Length)
-	//fmt.Println("[ice] message length=", m.Length)
-	if (m.Length & 0x0003) != 0 {
-		return NewError("invalid message length=", m.Length)
-	}
-
-	// 4-8, read stun magic
-	ReadBig(buf, &m.Magic)
-	//fmt.Println("[ice] read magic:", m.Magic, kStunMagicCookie)
-
-	// 8-20, read stun transaction id
-	var transId [kStunTransactionIdLength]byte
-	if ret, err := buf.Read(transId[:]); err != nil || ret != kStunTransactionIdLength {
-		return NewError2(err, "invalid transid ret=", ret)
-	}
-
-	if m.Magic != kStunMagicCookie {
-		// If magic cookie is invalid it means that the peer implements
-		// RFC3489 instead of RFC5389.
-		m.TransId = string(ValueToBytes(m.Magic)[:]) + string(transId[:])
-	} else {
-		m.TransId = string(transId[:])
-	}
-	// kStunTransactionIdLength/kStunLegacyTransactionIdLength
-	//fmt.Printf("[ice] message magic=%x, transId=%s_%d\n", m.Magic, m.TransId, len(m.TransId))
-
-	if int(m.Length) != buf.Len() {
-		// TODO: length= 80 , Len= 696
-		// invalid length= 108 , Len= 31
-		return NewError("invalid length=", m.Length, ", Len=", buf.Len(), len(data), m.TransId)
-	}
-
-	if m.Attrs == nil {
-		m.Attrs = make(map[StunAttributeType]StunAttribute)
+	var d StunDecoder
+	if err := d.Decode(data); err != nil {
+		return err
 	}
 
-	for {
-		if buf.Len() < 4 {
-			//fmt.Println("[ice] no more data len=", buf.Len())
-			break
+	m.Dtype = d.Dtype
+	m.Length = d.Length
+	m.Magic = d.Magic
+	m.TransId = string(d.TransId())
+	m.Raw = d.Raw
+	m.UnknownAttrs = d.UnknownAttrs
+	m.Attrs = make(map[StunAttributeType]StunAttribute)
+	m.OrderAttrs = nil
+
+	for _, raw := range d.Attrs {
+		factory, ok := kStunAttributeFactories[raw.Type]
+		if !ok {
+			continue
 		}
-
-		var attrType StunAttributeType
-		var attrLen uint16
-		ReadBig(buf, &attrType)
-		ReadBig(buf, &attrLen)
-		//fmt.Println("[ice] attrType, attrLen=", attrType, attrLen)
-
-		var attr StunAttribute
-		switch attrType {
-		case STUN_ATTR_MAPPED_ADDRESS:
-			attr = &StunAddressAttribute{}
-		case STUN_ATTR_XOR_MAPPED_ADDRESS:
-			attr = &StunXorAddressAttribute{}
-		case STUN_ATTR_USERNAME:
-			attr = &StunByteStringAttribute{}
-		case STUN_ATTR_ERROR_CODE:
-			attr = &StunErrorCodeAttribute{}
-		//case STUN_ATTR_MESSAGE_INTEGRITY:
-		//case STUN_ATTR_FINGERPRINT:
-		//case STUN_ATTR_PRIORITY:
-		//case STUN_ATTR_USE_CANDIDATE:
-		//case STUN_ATTR_ICE_CONTROLLING:
-		//case STUN_ATTR_NETWORK_INFO:
-		default:
-			newLen := attrLen
-			if remainder := attrLen % 4; remainder > 0 {
-				padding := 4 - remainder
-				newLen += padding
-			}
-			buf.Seek(int64(newLen), io.SeekCurrent)
-		}
-
-		// save attr
-		if attr != nil {
-			attr.SetInfo(attrType, attrLen, m.TransId)
-			attr.Read(buf)
-			m.Attrs[attrType] = attr
-			m.OrderAttrs = append(m.OrderAttrs, attr)
+		attr := factory()
+		attr.SetInfo(raw.Type, raw.Length, m.TransId)
+		attr.SetOffset(raw.Offset)
+		if err := attr.Read(bytes.NewReader(d.Value(raw))); err != nil {
+			return NewError2(err, "invalid attr type=", raw.Type)
 		}
+		m.Attrs[raw.Type] = attr
+		m.OrderAttrs = append(m.OrderAttrs, attr)
 	}
-
 	return nil
 }
 
@@ -270,6 +255,8 @@ func (m *StunMessage) Write(buf *bytes.Buffer) error {
 	// STUN_ATTR_MESSAGE_INTEGRITY: 2+2+20
 	// STUN_ATTR_FINGERPRINT: 2+2+4
 	for _, attr := range m.OrderAttrs {
+		// the XORed attributes depend on transId
+		attr.SetTransId(m.TransId)
 		// 2bytes attr type
 		WriteBig(buf, attr.GetType())
 		// 2bytes attr len
@@ -367,8 +354,39 @@ func (m *StunMessage) AddMessageIntegrity(key string) error {
 	return nil
 }
 
-func (m *StunMessage) ValidateMessageIntegrity() bool {
-	return false
+// ValidateMessageIntegrity checks the MESSAGE-INTEGRITY of the message from Read,
+// whose HMAC-SHA1 is over the bytes before it with the length adjusted to its
+// end. The key is the password for short-term credentials, or StunLongTermKey.
+func (m *StunMessage) ValidateMessageIntegrity(key string) error {
+	if m.Raw == nil {
+		return ErrStunNoRawMessage
+	}
+	attr := m.GetAttribute(STUN_ATTR_MESSAGE_INTEGRITY)
+	if attr == nil {
+		return ErrStunNoMessageIntegrity
+	}
+	raw := StunRawAttribute{attr.GetType(), attr.GetOffset(), attr.GetLen()}
+	var header [kStunHeaderSize]byte
+	return stunCheckIntegrity(hmac.New(sha1.New, []byte(key)), header[:], nil, m.Raw, raw)
+}
+
+// StunLongTermKey returns the key of long-term credentials, MD5(username:realm:password).
+func StunLongTermKey(username, realm, password string) string {
+	sum := md5.Sum([]byte(username + ":" + realm + ":" + password))
+	return string(sum[:])
+}
+
+// ValidateFingerprint checks the FINGERPRINT of the message from Read, which
+// must be the last attribute.
+func (m *StunMessage) ValidateFingerprint() error {
+	if m.Raw == nil {
+		return ErrStunNoRawMessage
+	}
+	attr := m.GetAttribute(STUN_ATTR_FINGERPRINT)
+	if attr == nil {
+		return ErrStunNoFingerprint
+	}
+	return stunCheckFingerprint(m.Raw, StunRawAttribute{attr.GetType(), attr.GetOffset(), attr.GetLen()})
 }
 
 // AddFingerprint Adds a FINGERPRINT attribute that is valid for the current message.
@@ -385,12 +403,7 @@ func (m *StunMessage) AddFingerprint() error {
 	attrLen := int(fingerprinAttr.GetLen2())
 	msg_len_for_crc32 := buf.Len() - kStunAttributeHeaderSize - attrLen
 
-	const kCrc32Polynomial uint32 = 0xEDB88320
-	crc32q := crc32.MakeTable(kCrc32Polynomial)
-	crc := crc32.Checksum(buf.Bytes()[0:msg_len_for_crc32], crc32q)
-	//fmt.Println("[ice] go stun crc32=", crc, msg_len_for_crc32, buf.Len())
-
-	fingerprinAttr.SetValue(crc ^ STUN_FINGERPRINT_XOR_VALUE)
+	fingerprinAttr.SetValue(stunFingerprint(buf.Bytes()[0:msg_len_for_crc32]))
 	return nil
 }
 
@@ -403,6 +416,10 @@ type StunAttribute interface {
 	// value is true if successful.
 	Write(buf *bytes.Buffer) error
 	SetInfo(attrType StunAttributeType, attrLen uint16, transId string)
+	SetTransId(transId string)
+	// The offset of attribute header in the raw message of Read.
+	SetOffset(offset int)
+	GetOffset() int
 	GetType() StunAttributeType
 	GetLen() uint16  // for read
 	GetLen2() uint16 // for write
@@ -414,6 +431,7 @@ type StunAttributeBase struct {
 	attrType StunAttributeType
 	attrLen  uint16
 	transId  string
+	offset   int
 }
 
 func (a *StunAttributeBase) Check(buf *bytes.Reader) error {
@@ -433,6 +451,18 @@ func (a *StunAttributeBase) SetInfo(attrType StunAttributeType, attrLen uint16,
 	a.transId = transId
 }
 
+func (a *StunAttributeBase) SetTransId(transId string) {
+	a.transId = transId
+}
+
+func (a *StunAttributeBase) SetOffset(offset int) {
+	a.offset = offset
+}
+
+func (a *StunAttributeBase) GetOffset() int {
+	return a.offset
+}
+
 func (a *StunAttributeBase) GetType() StunAttributeType {
 	return a.attrType
 }
@@ -474,15 +504,24 @@ type StunAddressAttribute struct {
 	ip     net.IP
 }
 
+func NewStunAddressAttribute(attrType StunAttributeType, addr net.Addr) (*StunAddressAttribute, error) {
+	attr := &StunAddressAttribute{}
+	attr.SetType(attrType)
+	if err := attr.SetAddr(addr); err != nil {
+		return nil, err
+	}
+	return attr, nil
+}
+
 func (a *StunAddressAttribute) String() string {
-	return a.ip.String() + ":" + fmt.Sprint(a.port)
+	return net.JoinHostPort(a.ip.String(), fmt.Sprint(a.port))
 }
 
 func (a *StunAddressAttribute) GetLen2() uint16 {
 	if a.family == STUN_ADDRESS_IPV4 {
-		return 1 + 1 + 2 + 4
+		return 1 + 1 + 2 + net.IPv4len
 	} else {
-		return 1 + 1 + 2 + 20
+		return 1 + 1 + 2 + net.IPv6len
 	}
 }
 
@@ -507,50 +546,70 @@ func (a *StunAddressAttribute) Read(buf *bytes.Reader) error {
 	}
 
 	// read ip
-	if a.family == STUN_ADDRESS_IPV4 {
-		a.ip = make([]byte, 4)
-		if err := ReadBig(buf, a.ip); err != nil {
-			return NewError2(err, "read ipv4 failed")
-		}
-	} else if a.family == STUN_ADDRESS_IPV6 {
-		a.ip = make([]byte, 20)
-		if err := ReadBig(buf, a.ip); err != nil {
-			return NewError2(err, "read ipv6 failed")
-		}
+	var ipLen int
+	switch a.family {
+	case STUN_ADDRESS_IPV4:
+		ipLen = net.IPv4len
+	case STUN_ADDRESS_IPV6:
+		ipLen = net.IPv6len
+	default:
+		return NewError("invalid address family=", a.family)
+	}
+	if buf.Len() != ipLen {
+		return NewError("invalid address length=", buf.Len(), ", family=", a.family)
+	}
+	a.ip = make(net.IP, ipLen)
+	if _, err := buf.Read(a.ip); err != nil {
+		return NewError2(err, "read ip failed")
 	}
-
 	return nil
 }
 
 func (a *StunAddressAttribute) Write(buf *bytes.Buffer) error {
+	return a.writeAddr(buf, a.ip, a.port)
+}
+
+func (a *StunAddressAttribute) writeAddr(buf *bytes.Buffer, ip net.IP, port uint16) error {
 	if a.family == STUN_ADDRESS_UNDEF {
 		return NewError("Error writing address attribute: unknown family")
 	}
 	var zero uint8 = 0
 	WriteBig(buf, zero)
 	WriteBig(buf, a.family)
-	WriteBig(buf, a.port)
-	WriteBig(buf, a.ip)
+	WriteBig(buf, port)
+	buf.Write(ip)
 	return nil
 }
 
-func (a *StunAddressAttribute) SetAddr(addr net.Addr) {
-	strAddr := addr.String()
-	if host, port, err := net.SplitHostPort(strAddr); err == nil {
-		//fmt.Println("[ice] set addr:", strAddr)
-		a.SetIP(net.ParseIP(host))
-		a.SetPort(Atou16(port))
-	} else {
-		fmt.Println("[ice] fail to set addr:", strAddr)
+// SetAddr sets the ip and port of *net.UDPAddr or *net.TCPAddr.
+func (a *StunAddressAttribute) SetAddr(addr net.Addr) error {
+	switch v := addr.(type) {
+	case *net.UDPAddr:
+		a.SetIP(v.IP)
+		a.SetPort(uint16(v.Port))
+	case *net.TCPAddr:
+		a.SetIP(v.IP)
+		a.SetPort(uint16(v.Port))
+	default:
+		return NewError("unsupported addr:", addr)
 	}
+	if a.family == STUN_ADDRESS_UNDEF {
+		return NewError("invalid addr ip:", addr)
+	}
+	return nil
 }
 
+// SetIP sets the ip in 4-bytes for ipv4, or 16-bytes for ipv6.
 func (a *StunAddressAttribute) SetIP(ip net.IP) {
-	a.ip = ip
-	if ip.To4() != nil {
+	if ip4 := ip.To4(); ip4 != nil {
+		a.ip = ip4
 		a.family = STUN_ADDRESS_IPV4
-	} else {
+	} else if ip6 := ip.To16(); ip6 != nil {
+		a.ip = ip6
 		a.family = STUN_ADDRESS_IPV6
+	} else {
+		a.ip = nil
+		a.family = STUN_ADDRESS_UNDEF
 	}
 }
 
@@ -558,30 +617,60 @@ func (a *StunAddressAttribute) SetPort(port uint16) {
 	a.port = port
 }
 
+func (a *StunAddressAttribute) GetFamily() StunAddressFamily {
+	return a.family
+}
+
+func (a *StunAddressAttribute) GetIP() net.IP {
+	return a.ip
+}
+
+func (a *StunAddressAttribute) GetPort() uint16 {
+	return a.port
+}
+
+func (a *StunAddressAttribute) UDPAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: a.ip, Port: int(a.port)}
+}
+
+func (a *StunAddressAttribute) TCPAddr() *net.TCPAddr {
+	return &net.TCPAddr{IP: a.ip, Port: int(a.port)}
+}
+
 // StunXorAddressAttribute implements STUN attributes that record an Internet address. When encoded
 // in a STUN message, the address contained in this attribute is XORed with the
 // transaction ID of the message.
 type StunXorAddressAttribute struct {
 	StunAttributeBase
-	Addr    StunAddressAttribute
-	XorIP   net.IP
-	XorPort uint16
+	Addr StunAddressAttribute // the address is not XORed
 }
 
-func (a *StunXorAddressAttribute) GetLen2() uint16 {
-	if a.Addr.family == STUN_ADDRESS_IPV4 {
-		return 1 + 1 + 2 + 4
-	} else {
-		return 1 + 1 + 2 + 20
+func NewStunXorAddressAttribute(attrType StunAttributeType, addr net.Addr) (*StunXorAddressAttribute, error) {
+	attr := &StunXorAddressAttribute{}
+	attr.SetType(attrType)
+	if err := attr.SetAddr(addr); err != nil {
+		return nil, err
 	}
+	return attr, nil
+}
+
+func (a *StunXorAddressAttribute) String() string {
+	return a.Addr.String()
+}
+
+func (a *StunXorAddressAttribute) GetLen2() uint16 {
+	return a.Addr.GetLen2()
 }
 
 func (a *StunXorAddressAttribute) Read(buf *bytes.Reader) error {
 	if err := a.Addr.Read(buf); err != nil {
 		return err
 	}
-	a.XorPort = a.Addr.port ^ uint16(kStunMagicCookie>>16)
-	a.GetXoredIP()
+	ip, port, err := stunXorAddress(a.Addr.ip, a.Addr.port, a.transId)
+	if err != nil {
+		return err
+	}
+	a.Addr.ip, a.Addr.port = ip, port
 	return nil
 }
 
@@ -589,43 +678,46 @@ func (a *StunXorAddressAttribute) Write(buf *bytes.Buffer) error {
 	if a.Addr.family == STUN_ADDRESS_UNDEF {
 		return NewError("invalid addr family in xoraddr")
 	}
+	ip, port, err := stunXorAddress(a.Addr.ip, a.Addr.port, a.transId)
+	if err != nil {
+		return err
+	}
+	return a.Addr.writeAddr(buf, ip, port)
+}
 
-	var zero uint8 = 0
-	// 1byte
-	WriteBig(buf, zero)
-	// 1byte
-	WriteBig(buf, a.Addr.family)
-
-	a.XorPort = a.Addr.port ^ uint16(kStunMagicCookie>>16)
-	a.GetXoredIP()
-	// 2bytes
-	WriteBig(buf, a.XorPort)
-	// 4bytes
-	WriteBig(buf, a.XorIP)
-	return nil
+func (a *StunXorAddressAttribute) SetAddr(addr net.Addr) error {
+	return a.Addr.SetAddr(addr)
+}
+
+func (a *StunXorAddressAttribute) UDPAddr() *net.UDPAddr {
+	return a.Addr.UDPAddr()
 }
 
-func (a *StunXorAddressAttribute) GetXoredIP() {
-	magic := HostToNet32(kStunMagicCookie)
-	if a.Addr.family == STUN_ADDRESS_IPV4 {
-		ip32 := HostToNet32(BytesToUint32(a.Addr.ip.To4()))
-		xorip := Uint32ToBytes(ip32 ^ magic)
-		a.XorIP = xorip
-	} else if a.Addr.family == STUN_ADDRESS_IPV6 {
-		//TODO
-		a.XorIP = make([]byte, 20)
-		if len(a.Addr.transId) == kStunTransactionIdLength {
-			var transIds [3]uint32
-			copy((*[12]byte)(unsafe.Pointer(&transIds[0]))[:], a.transId)
-
-			dst := (*[4]uint32)(unsafe.Pointer(&a.XorIP[0]))[:]
-			ipv6 := (*[4]uint32)(unsafe.Pointer(&a.Addr.ip[0]))[:]
-			dst[0] = ipv6[0] ^ magic
-			for i := 1; i < 4; i++ {
-				dst[i] = ipv6[i] ^ transIds[i-1]
-			}
+func (a *StunXorAddressAttribute) TCPAddr() *net.TCPAddr {
+	return a.Addr.TCPAddr()
+}
+
+// stunXorAddress XORs the port with the most significant 16 bits of magic
+// cookie, and the ip with the magic cookie(ipv4) or the concatenation of magic
+// cookie and transaction ID(ipv6), in network byte order.
+func stunXorAddress(ip net.IP, port uint16, transId string) (net.IP, uint16, error) {
+	var key [kStunMagicCookieLength + kStunTransactionIdLength]byte
+	binary.BigEndian.PutUint32(key[:], kStunMagicCookie)
+	if len(ip) == net.IPv6len {
+		// the legacy transId includes the magic of RFC3489
+		if len(transId) < kStunTransactionIdLength {
+			return nil, 0, NewError("invalid transid for ipv6 xoraddr")
 		}
+		copy(key[kStunMagicCookieLength:], transId[len(transId)-kStunTransactionIdLength:])
+	} else if len(ip) != net.IPv4len {
+		return nil, 0, NewError("invalid ip length=", len(ip))
+	}
+
+	xorIP := make(net.IP, len(ip))
+	for i := range ip {
+		xorIP[i] = ip[i] ^ key[i]
 	}
+	return xorIP, port ^ uint16(kStunMagicCookie>>16), nil
 }
 
 // StunByteStringAttribute implements STUN attributes that record an arbitrary byte string.
@@ -653,6 +745,9 @@ func (a *StunByteStringAttribute) Read(buf *bytes.Reader) error {
 	}
 
 	a.Data = make([]byte, a.attrLen)
+	if a.attrLen == 0 {
+		return nil
+	}
 	if _, err := buf.Read(a.Data); err != nil {
 		a.Data = nil
 		return NewError2(err, "fail to read for StunByteStringAttribute")
@@ -677,6 +772,12 @@ type StunUInt32Attribute struct {
 	bits uint32
 }
 
+func NewStunUInt32Attribute(attrType StunAttributeType, value uint32) *StunUInt32Attribute {
+	attr := &StunUInt32Attribute{bits: value}
+	attr.SetType(attrType)
+	return attr
+}
+
 func (a *StunUInt32Attribute) GetLen2() uint16 {
 	return 4
 }
@@ -685,6 +786,10 @@ func (a *StunUInt32Attribute) SetValue(value uint32) {
 	a.bits = value
 }
 
+func (a *StunUInt32Attribute) GetValue() uint32 {
+	return a.bits
+}
+
 func (a *StunUInt32Attribute) GetBit(index int) bool {
 	return ((a.bits >> uint32(index)) & 0x1) == 0x01
 }
@@ -700,8 +805,7 @@ func (a *StunUInt32Attribute) Read(buf *bytes.Reader) error {
 	if a.GetLen() != 4 {
 		return NewError("len is not 4")
 	}
-	ReadBig(buf, a.bits)
-	return nil
+	return ReadBig(buf, &a.bits)
 }
 
 func (a *StunUInt32Attribute) Write(buf *bytes.Buffer) error {
@@ -709,6 +813,123 @@ func (a *StunUInt32Attribute) Write(buf *bytes.Buffer) error {
 	return nil
 }
 
+// StunUInt64Attribute implements STUN attributes that record a 64-bit integer.
+type StunUInt64Attribute struct {
+	StunAttributeBase
+	bits uint64
+}
+
+func NewStunUInt64Attribute(attrType StunAttributeType, value uint64) *StunUInt64Attribute {
+	attr := &StunUInt64Attribute{bits: value}
+	attr.SetType(attrType)
+	return attr
+}
+
+func (a *StunUInt64Attribute) GetLen2() uint16 {
+	return 8
+}
+
+func (a *StunUInt64Attribute) SetValue(value uint64) {
+	a.bits = value
+}
+
+func (a *StunUInt64Attribute) GetValue() uint64 {
+	return a.bits
+}
+
+func (a *StunUInt64Attribute) Read(buf *bytes.Reader) error {
+	if a.GetLen() != 8 {
+		return NewError("len is not 8")
+	}
+	return ReadBig(buf, &a.bits)
+}
+
+func (a *StunUInt64Attribute) Write(buf *bytes.Buffer) error {
+	WriteBig(buf, a.bits)
+	return nil
+}
+
+// StunUInt16ListAttribute implements STUN attributes that record a list of
+// 16-bit integers, e.g. UNKNOWN-ATTRIBUTES.
+type StunUInt16ListAttribute struct {
+	StunAttributeBase
+	values []uint16
+}
+
+func NewStunUInt16ListAttribute(attrType StunAttributeType) *StunUInt16ListAttribute {
+	attr := &StunUInt16ListAttribute{}
+	attr.SetType(attrType)
+	return attr
+}
+
+func (a *StunUInt16ListAttribute) GetLen2() uint16 {
+	return uint16(2 * len(a.values))
+}
+
+func (a *StunUInt16ListAttribute) Size() int {
+	return len(a.values)
+}
+
+func (a *StunUInt16ListAttribute) GetValue(index int) uint16 {
+	return a.values[index]
+}
+
+func (a *StunUInt16ListAttribute) SetValue(index int, value uint16) {
+	a.values[index] = value
+}
+
+func (a *StunUInt16ListAttribute) AddValue(value uint16) {
+	a.values = append(a.values, value)
+}
+
+func (a *StunUInt16ListAttribute) Read(buf *bytes.Reader) error {
+	if (a.GetLen() % 2) != 0 {
+		return NewError("len is not even")
+	}
+	a.values = make([]uint16, a.GetLen()/2)
+	for i := range a.values {
+		if err := ReadBig(buf, &a.values[i]); err != nil {
+			return NewError2(err, "fail to read uint16 list")
+		}
+	}
+	a.ConsumePadding(buf, int(a.GetLen()))
+	return nil
+}
+
+func (a *StunUInt16ListAttribute) Write(buf *bytes.Buffer) error {
+	for _, value := range a.values {
+		WriteBig(buf, value)
+	}
+	a.WritePadding(buf, int(a.GetLen2()))
+	return nil
+}
+
+// StunEmptyAttribute implements STUN attributes that have no value, e.g. USE-CANDIDATE.
+type StunEmptyAttribute struct {
+	StunAttributeBase
+}
+
+func NewStunEmptyAttribute(attrType StunAttributeType) *StunEmptyAttribute {
+	attr := &StunEmptyAttribute{}
+	attr.SetType(attrType)
+	return attr
+}
+
+func (a *StunEmptyAttribute) GetLen2() uint16 {
+	return 0
+}
+
+func (a *StunEmptyAttribute) Read(buf *bytes.Reader) error {
+	if a.GetLen() != 0 {
+		return NewError("len is not 0")
+	}
+	return nil
+}
+
+func (a *StunEmptyAttribute) Write(buf *bytes.Buffer) error {
+	return nil
+}
+
 // Implements STUN attributes that record an error code.
 // MIN_SIZE = 4
 type StunErrorCodeAttribute struct {
@@ -747,12 +968,25 @@ func (a *StunErrorCodeAttribute) Read(buf *bytes.Reader) error {
 	return nil
 }
 
+func (a *StunErrorCodeAttribute) GetLen2() uint16 {
+	return uint16(4 + len(a.Reason))
+}
+
 func (a *StunErrorCodeAttribute) Write(buf *bytes.Buffer) error {
+	val := uint32(a.Class&0x7)<<8 | uint32(a.Number)
+	WriteBig(buf, val)
+	buf.WriteString(a.Reason)
+	a.WritePadding(buf, len(a.Reason))
 	return nil
 }
 
 func (a *StunErrorCodeAttribute) Code() int {
-	return int(a.Class*100) + int(a.Number)
+	return int(a.Class)*100 + int(a.Number)
+}
+
+// Error implements error for the error response of StunClient.
+func (a *StunErrorCodeAttribute) Error() string {
+	return fmt.Sprintf("stun error %d: %s", a.Code(), a.Reason)
 }
 
 func (a *StunErrorCodeAttribute) SetCode(code int) {
@@ -778,9 +1012,10 @@ func GenStunMessageRequest(buf *bytes.Buffer, sendUfrag, recvUfrag, recvPwd stri
 
 // GenStunMessageResponse generates stun response packet
 func GenStunMessageResponse(buf *bytes.Buffer, passwd string, transId string, addr net.Addr) error {
-	xorAttr := &StunXorAddressAttribute{}
-	xorAttr.SetType(STUN_ATTR_XOR_MAPPED_ADDRESS)
-	xorAttr.Addr.SetAddr(addr)
+	xorAttr, err := NewStunXorAddressAttribute(STUN_ATTR_XOR_MAPPED_ADDRESS, addr)
+	if err != nil {
+		return err
+	}
 
 	resp := NewStunMessageResponse(transId)
 	resp.AddAttribute(xorAttr)
@@ -789,6 +1024,43 @@ func GenStunMessageResponse(buf *bytes.Buffer, passwd string, transId string, ad
 	return resp.Write(buf)
 }
 
+// NewStunMessageErrorResponse creates the error response of request type
+// dtype, with ERROR-CODE of code and reason.
+func NewStunMessageErrorResponse(dtype StunMessageType, transId string, code int, reason string) *StunMessage {
+	errorAttr := &StunErrorCodeAttribute{}
+	errorAttr.SetType(STUN_ATTR_ERROR_CODE)
+	errorAttr.SetCode(code)
+	errorAttr.SetReason(reason)
+
+	resp := &StunMessage{
+		Dtype:   dtype | kStunErrorResponseMask,
+		TransId: transId,
+	}
+	resp.AddAttribute(errorAttr)
+	return resp
+}
+
+// GenStunUnknownAttributesResponse generates the 420 error response of req,
+// which lists its UnknownAttrs in UNKNOWN-ATTRIBUTES. The passwd is optional
+// for MESSAGE-INTEGRITY.
+func GenStunUnknownAttributesResponse(buf *bytes.Buffer, passwd string, req *StunMessage) error {
+	if len(req.UnknownAttrs) == 0 {
+		return NewError("no unknown attributes")
+	}
+	unknownAttr := NewStunUInt16ListAttribute(STUN_ATTR_UNKNOWN_ATTRIBUTES)
+	for _, attrType := range req.UnknownAttrs {
+		unknownAttr.AddValue(uint16(attrType))
+	}
+
+	resp := NewStunMessageErrorResponse(req.Dtype, req.TransId, STUN_ERROR_UNKNOWN_ATTRIBUTE, "Unknown Attribute")
+	resp.AddAttribute(unknownAttr)
+	if len(passwd) > 0 {
+		resp.AddMessageIntegrity(passwd)
+	}
+	resp.AddFingerprint()
+	return resp.Write(buf)
+}
+
 // The packet length of dtls/rtp/rtcp
 const (
 	kDtlsRecordHeaderLen int = 13
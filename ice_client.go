@@ -0,0 +1,343 @@
+package goutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// The default transaction parameters of RFC 5389.
+const (
+	kStunClientRTO          = 500 * time.Millisecond
+	kStunClientRc           = 7
+	kStunClientRm           = 16
+	kStunClientMaxRedirects = 3
+	kStunClientBufferSize   = 64 * 1024
+)
+
+// These are the errors of StunClient, and the error response is returned as
+// *StunErrorCodeAttribute.
+var (
+	ErrStunClientClosed     = errors.New("stun client closed")
+	ErrStunTimeout          = errors.New("stun transaction timeout")
+	ErrStunDuplicateTransId = errors.New("stun transaction id in use")
+	ErrStunTooManyRedirects = errors.New("stun too many ALTERNATE-SERVER redirects")
+	ErrStunRedirectLoop     = errors.New("stun ALTERNATE-SERVER already tried")
+	ErrStunNoErrorCode      = errors.New("stun ERROR-CODE missing in error response")
+	ErrStunNoMappedAddress  = errors.New("stun XOR-MAPPED-ADDRESS missing")
+)
+
+type stunTransaction struct {
+	server net.Addr // nil for the stream connection
+	result chan *StunMessage
+}
+
+// StunClient sends STUN requests over net.PacketConn or a stream connection
+// (e.g. TCP/TLS), and matches the responses by TransId. The transactions could
+// be run concurrently, and the parameters should be set before the first one.
+type StunClient struct {
+	sync.Mutex
+
+	RTO          time.Duration // the initial retransmission timeout
+	Rc           int           // the max number of requests
+	Rm           int           // the last request waits Rm*RTO
+	MaxRedirects int           // the max number of ALTERNATE-SERVER redirects
+
+	packetConn   net.PacketConn
+	streamConn   net.Conn
+	transactions map[string]*stunTransaction
+	closed       bool
+	done         chan struct{}
+}
+
+// NewStunClient creates the client over conn, which is read by the client
+// until Close.
+func NewStunClient(conn net.PacketConn) *StunClient {
+	c := newStunClient()
+	c.packetConn = conn
+	go c.readPackets()
+	return c
+}
+
+// NewStunStreamClient creates the client over the stream conn, where the
+// requests are not retransmitted and ALTERNATE-SERVER is not redirected.
+func NewStunStreamClient(conn net.Conn) *StunClient {
+	c := newStunClient()
+	c.streamConn = conn
+	go c.readStream()
+	return c
+}
+
+func newStunClient() *StunClient {
+	return &StunClient{
+		RTO:          kStunClientRTO,
+		Rc:           kStunClientRc,
+		Rm:           kStunClientRm,
+		MaxRedirects: kStunClientMaxRedirects,
+		transactions: make(map[string]*stunTransaction),
+		done:         make(chan struct{}),
+	}
+}
+
+// Close closes the connection and the pending transactions.
+func (c *StunClient) Close() error {
+	c.shutdown()
+	if c.packetConn != nil {
+		return c.packetConn.Close()
+	}
+	return c.streamConn.Close()
+}
+
+func (c *StunClient) shutdown() {
+	c.Lock()
+	defer c.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+}
+
+// Timeout returns the transaction timeout, which is 39.5s by default.
+func (c *StunClient) Timeout() time.Duration {
+	var timeout time.Duration
+	for i := 0; i < c.Rc-1; i++ {
+		timeout += c.RTO << uint(i)
+	}
+	return timeout + c.RTO*time.Duration(c.Rm)
+}
+
+// Binding sends a binding request to server, and returns the address of
+// XOR-MAPPED-ADDRESS, or MAPPED-ADDRESS of RFC3489 servers.
+func (c *StunClient) Binding(ctx context.Context, server net.Addr) (*StunAddressAttribute, error) {
+	resp, err := c.Do(ctx, NewStunMessageRequest(), server)
+	if err != nil {
+		return nil, err
+	}
+	if attr, ok := resp.GetAttribute(STUN_ATTR_XOR_MAPPED_ADDRESS).(*StunXorAddressAttribute); ok {
+		return &attr.Addr, nil
+	}
+	if attr, ok := resp.GetAttribute(STUN_ATTR_MAPPED_ADDRESS).(*StunAddressAttribute); ok {
+		return attr, nil
+	}
+	return nil, ErrStunNoMappedAddress
+}
+
+// Do sends req to server and waits for the response of the same TransId. Over
+// the packet connection, req is retransmitted with RTO doubling until Rc
+// requests are sent, and the last one waits Rm*RTO before ErrStunTimeout.
+// Over the stream connection, req is sent once with Timeout and server is ignored.
+//
+// For the error response, both the response and *StunErrorCodeAttribute are
+// returned, except that 300(Try Alternate) is redirected to ALTERNATE-SERVER
+// over the packet connection. The redirected request is a new transaction of
+// fresh TransId, so req with MESSAGE-INTEGRITY is redirected only by DoWithKey.
+// The MESSAGE-INTEGRITY of response is not checked.
+func (c *StunClient) Do(ctx context.Context, req *StunMessage, server net.Addr) (*StunMessage, error) {
+	return c.DoWithKey(ctx, req, server, "")
+}
+
+// DoWithKey is like Do, and key is the MESSAGE-INTEGRITY key of req, with which
+// the redirected request is signed again with the same credentials(RFC 5389
+// section 11). The empty key is for req without MESSAGE-INTEGRITY.
+func (c *StunClient) DoWithKey(ctx context.Context, req *StunMessage, server net.Addr, key string) (*StunMessage, error) {
+	integrity := req.GetAttribute(STUN_ATTR_MESSAGE_INTEGRITY) != nil
+	tried := []net.Addr{server}
+	for {
+		var buf bytes.Buffer
+		if err := req.Write(&buf); err != nil {
+			return nil, err
+		}
+		resp, err := c.roundTrip(ctx, req.TransId, buf.Bytes(), server)
+		if err != nil {
+			return nil, err
+		}
+		if (resp.Dtype & kStunErrorResponseMask) != kStunErrorResponseMask {
+			return resp, nil
+		}
+
+		errorAttr, ok := resp.GetAttribute(STUN_ATTR_ERROR_CODE).(*StunErrorCodeAttribute)
+		if !ok {
+			return resp, ErrStunNoErrorCode
+		}
+		if errorAttr.Code() != STUN_ERROR_TRY_ALTERNATE || c.packetConn == nil ||
+			(integrity && key == "") {
+			return resp, errorAttr
+		}
+		alternate, ok := resp.GetAttribute(STUN_ATTR_ALTERNATE_SERVER).(*StunAddressAttribute)
+		if !ok {
+			return resp, errorAttr
+		}
+		if len(tried) > c.MaxRedirects {
+			return resp, ErrStunTooManyRedirects
+		}
+		server = alternate.UDPAddr()
+		for _, addr := range tried {
+			if stunSameAddr(addr, server) {
+				return resp, ErrStunRedirectLoop
+			}
+		}
+		tried = append(tried, server)
+		if req, err = renewStunRequest(req, key); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// renewStunRequest copies req with a fresh TransId, and MESSAGE-INTEGRITY(by
+// key) and FINGERPRINT are added again.
+func renewStunRequest(req *StunMessage, key string) (*StunMessage, error) {
+	renewed := &StunMessage{
+		Dtype:   req.Dtype,
+		TransId: NewStunTransId(),
+	}
+	for _, attr := range req.OrderAttrs {
+		if attr.GetType() == STUN_ATTR_MESSAGE_INTEGRITY {
+			// the attributes after MESSAGE-INTEGRITY are ignored, except FINGERPRINT
+			break
+		}
+		if attr.GetType() != STUN_ATTR_FINGERPRINT {
+			renewed.AddAttribute(attr)
+		}
+	}
+	if req.GetAttribute(STUN_ATTR_MESSAGE_INTEGRITY) != nil {
+		if err := renewed.AddMessageIntegrity(key); err != nil {
+			return nil, err
+		}
+	}
+	if req.GetAttribute(STUN_ATTR_FINGERPRINT) != nil {
+		if err := renewed.AddFingerprint(); err != nil {
+			return nil, err
+		}
+	}
+	return renewed, nil
+}
+
+// stunSameAddr returns whether a and b are the same address.
+func stunSameAddr(a, b net.Addr) bool {
+	if ua, ok := a.(*net.UDPAddr); ok {
+		if ub, ok := b.(*net.UDPAddr); ok {
+			return ua.IP.Equal(ub.IP) && ua.Port == ub.Port
+		}
+	}
+	return a.String() == b.String()
+}
+
+func (c *StunClient) roundTrip(ctx context.Context, transId string, data []byte, server net.Addr) (*StunMessage, error) {
+	tr := &stunTransaction{result: make(chan *StunMessage, 1)}
+	if c.packetConn != nil {
+		tr.server = server
+	}
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return nil, ErrStunClientClosed
+	}
+	if _, ok := c.transactions[transId]; ok {
+		c.Unlock()
+		return nil, ErrStunDuplicateTransId
+	}
+	c.transactions[transId] = tr
+	c.Unlock()
+
+	defer func() {
+		c.Lock()
+		delete(c.transactions, transId)
+		c.Unlock()
+	}()
+
+	for count := 1; ; count++ {
+		var wait time.Duration
+		var err error
+		if c.packetConn != nil {
+			_, err = c.packetConn.WriteTo(data, server)
+			if count < c.Rc {
+				wait = c.RTO << uint(count-1)
+			} else {
+				wait = c.RTO * time.Duration(c.Rm)
+			}
+		} else {
+			_, err = c.streamConn.Write(data)
+			wait = c.Timeout()
+		}
+		if err != nil {
+			return nil, NewError2(err, "fail to send stun request")
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case resp := <-tr.result:
+			timer.Stop()
+			return resp, nil
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-c.done:
+			timer.Stop()
+			return nil, ErrStunClientClosed
+		case <-timer.C:
+		}
+		if c.streamConn != nil || count >= c.Rc {
+			return nil, ErrStunTimeout
+		}
+	}
+}
+
+func (c *StunClient) readPackets() {
+	defer c.shutdown()
+	buf := make([]byte, kStunClientBufferSize)
+	for {
+		n, from, err := c.packetConn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		c.dispatch(append([]byte(nil), buf[:n]...), from)
+	}
+}
+
+// readStream reads the messages which are delimited by the length of header.
+func (c *StunClient) readStream() {
+	defer c.shutdown()
+	var header [kStunHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(c.streamConn, header[:]); err != nil {
+			return
+		}
+		data := make([]byte, kStunHeaderSize+int(binary.BigEndian.Uint16(header[2:])))
+		copy(data, header[:])
+		if _, err := io.ReadFull(c.streamConn, data[kStunHeaderSize:]); err != nil {
+			return
+		}
+		c.dispatch(data, nil)
+	}
+}
+
+// dispatch delivers the response to its transaction, and the others (e.g.
+// non-STUN packets, retransmitted responses and the ones not from the server
+// of transaction) are dropped. The from is nil for the stream connection.
+func (c *StunClient) dispatch(data []byte, from net.Addr) {
+	var resp StunMessage
+	if err := resp.Read(data); err != nil {
+		return
+	}
+	if (resp.Dtype & 0x0100) == 0 {
+		return
+	}
+	if resp.GetAttribute(STUN_ATTR_FINGERPRINT) != nil && resp.ValidateFingerprint() != nil {
+		return
+	}
+
+	c.Lock()
+	tr := c.transactions[resp.TransId]
+	c.Unlock()
+	if tr != nil && (tr.server == nil || (from != nil && stunSameAddr(tr.server, from))) {
+		select {
+		case tr.result <- &resp:
+		default:
+		}
+	}
+}
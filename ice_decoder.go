@@ -0,0 +1,269 @@
+package goutil
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"net"
+)
+
+// StunRawAttribute is an attribute of StunDecoder, which refers to the raw bytes.
+type StunRawAttribute struct {
+	Type   StunAttributeType
+	Offset int    // the offset of attribute header in the raw message
+	Length uint16 // the value length without padding
+}
+
+// StunDecoder decodes the STUN message over a caller-owned buffer without
+// allocation. The decoder could be reused for every packet, and the results
+// (e.g. Raw, Attrs and the returned slices) are valid until the next Decode
+// or the buffer is changed.
+type StunDecoder struct {
+	Dtype        StunMessageType
+	Length       uint16
+	Magic        uint32
+	Raw          []byte
+	Attrs        []StunRawAttribute
+	UnknownAttrs []StunAttributeType
+
+	mac    hash.Hash
+	macKey string
+	header [kStunHeaderSize]byte
+	digest [sha1.Size]byte
+	xorIP  [net.IPv6len]byte
+}
+
+// Decode parses the STUN packet of data, which must contain exactly one message.
+func (d *StunDecoder) Decode(data []byte) error {
+	d.Raw = nil
+	d.Attrs = d.Attrs[:0]
+	d.UnknownAttrs = d.UnknownAttrs[:0]
+
+	if len(data) < kStunHeaderSize {
+		return NewError("invalid stun size=", len(data))
+	}
+
+	// check the 1st byte
+	utype := data[0]
+	if utype != 0 && utype != 1 {
+		return NewError("invalid utype=", utype)
+	}
+
+	// 0-2, stun message type
+	d.Dtype = StunMessageType(binary.BigEndian.Uint16(data[0:]))
+	if (d.Dtype & 0x8000) != 0 {
+		// RTP and RTCP set the MSB of first byte, since first two bits are version,
+		// and version is always 2 (10). If set, this is not a STUN packet.
+		return NewError("not stun message, (RTP/RTCP)type=", d.Dtype)
+	}
+
+	// 2-4, stun message size
+	d.Length = binary.BigEndian.Uint16(data[2:])
+	if (d.Length & 0x0003) != 0 {
+		return NewError("invalid message length=", d.Length)
+	}
+
+	// 4-8, stun magic
+	d.Magic = binary.BigEndian.Uint32(data[4:])
+
+	if int(d.Length) != len(data)-kStunHeaderSize {
+		return NewError("invalid length=", d.Length, ", Len=", len(data)-kStunHeaderSize)
+	}
+	d.Raw = data
+
+	hasIntegrity := false
+	for offset := kStunHeaderSize; offset+kStunAttributeHeaderSize <= len(data); {
+		attrType := StunAttributeType(binary.BigEndian.Uint16(data[offset:]))
+		attrLen := binary.BigEndian.Uint16(data[offset+2:])
+		valueOffset := offset + kStunAttributeHeaderSize
+		if valueOffset+int(attrLen) > len(data) {
+			d.Raw = nil
+			return NewError("invalid attr length=", attrLen, ", type=", attrType)
+		}
+
+		// the attributes after MESSAGE-INTEGRITY are ignored except FINGERPRINT
+		if !hasIntegrity || attrType == STUN_ATTR_FINGERPRINT {
+			d.Attrs = append(d.Attrs, StunRawAttribute{attrType, offset, attrLen})
+			if _, ok := kStunAttributeFactories[attrType]; !ok && attrType < kStunComprehensionOptional {
+				d.UnknownAttrs = append(d.UnknownAttrs, attrType)
+			}
+		}
+
+		// the value is padded to 4 bytes
+		newLen := int(attrLen)
+		if remainder := newLen % 4; remainder > 0 {
+			newLen += 4 - remainder
+		}
+		offset = valueOffset + newLen
+
+		if attrType == STUN_ATTR_MESSAGE_INTEGRITY {
+			hasIntegrity = true
+		} else if attrType == STUN_ATTR_FINGERPRINT {
+			break
+		}
+	}
+	return nil
+}
+
+func (d *StunDecoder) IsLegacy() bool {
+	return d.Magic != kStunMagicCookie
+}
+
+// TransId returns the transaction ID, which includes the magic for RFC3489.
+func (d *StunDecoder) TransId() []byte {
+	if d.Raw == nil {
+		return nil
+	}
+	if d.IsLegacy() {
+		return d.Raw[kStunMagicCookieLength:kStunHeaderSize]
+	}
+	return d.Raw[kStunTransactionIdOffset:kStunHeaderSize]
+}
+
+// Find returns the first attribute of attrType.
+func (d *StunDecoder) Find(attrType StunAttributeType) (StunRawAttribute, bool) {
+	for _, attr := range d.Attrs {
+		if attr.Type == attrType {
+			return attr, true
+		}
+	}
+	return StunRawAttribute{}, false
+}
+
+// Value returns the value of attr without padding.
+func (d *StunDecoder) Value(attr StunRawAttribute) []byte {
+	offset := attr.Offset + kStunAttributeHeaderSize
+	return d.Raw[offset : offset+int(attr.Length)]
+}
+
+// GetBytes returns the value of attrType, e.g. USERNAME.
+func (d *StunDecoder) GetBytes(attrType StunAttributeType) ([]byte, bool) {
+	attr, ok := d.Find(attrType)
+	if !ok {
+		return nil, false
+	}
+	return d.Value(attr), true
+}
+
+func (d *StunDecoder) GetUInt32(attrType StunAttributeType) (uint32, bool) {
+	value, ok := d.GetBytes(attrType)
+	if !ok || len(value) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(value), true
+}
+
+func (d *StunDecoder) GetUInt64(attrType StunAttributeType) (uint64, bool) {
+	value, ok := d.GetBytes(attrType)
+	if !ok || len(value) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(value), true
+}
+
+// GetAddress returns the ip and port of address attribute, e.g. MAPPED-ADDRESS.
+func (d *StunDecoder) GetAddress(attrType StunAttributeType) (net.IP, uint16, bool) {
+	value, ok := d.GetBytes(attrType)
+	if !ok || len(value) < 4 {
+		return nil, 0, false
+	}
+	ip := net.IP(value[4:])
+	family := StunAddressFamily(value[1])
+	if !(family == STUN_ADDRESS_IPV4 && len(ip) == net.IPv4len) &&
+		!(family == STUN_ADDRESS_IPV6 && len(ip) == net.IPv6len) {
+		return nil, 0, false
+	}
+	return ip, binary.BigEndian.Uint16(value[2:]), true
+}
+
+// GetXorAddress returns the ip and port of XORed address attribute, e.g.
+// XOR-MAPPED-ADDRESS. The ip is valid until the next call.
+func (d *StunDecoder) GetXorAddress(attrType StunAttributeType) (net.IP, uint16, bool) {
+	ip, port, ok := d.GetAddress(attrType)
+	if !ok {
+		return nil, 0, false
+	}
+	key := d.xorIP[:]
+	binary.BigEndian.PutUint32(key, kStunMagicCookie)
+	if len(ip) == net.IPv6len {
+		copy(key[kStunMagicCookieLength:], d.Raw[kStunTransactionIdOffset:kStunHeaderSize])
+	}
+	xorIP := d.xorIP[:len(ip)]
+	for i := range ip {
+		xorIP[i] = ip[i] ^ key[i]
+	}
+	return xorIP, port ^ uint16(kStunMagicCookie>>16), true
+}
+
+// ValidateMessageIntegrity checks the MESSAGE-INTEGRITY with key, which is
+// the password of short-term credentials or StunLongTermKey.
+func (d *StunDecoder) ValidateMessageIntegrity(key string) error {
+	if d.Raw == nil {
+		return ErrStunNoRawMessage
+	}
+	attr, ok := d.Find(STUN_ATTR_MESSAGE_INTEGRITY)
+	if !ok {
+		return ErrStunNoMessageIntegrity
+	}
+	if d.mac == nil || d.macKey != key {
+		d.mac = hmac.New(sha1.New, []byte(key))
+		d.macKey = key
+	}
+	return stunCheckIntegrity(d.mac, d.header[:], d.digest[:0], d.Raw, attr)
+}
+
+// ValidateFingerprint checks the FINGERPRINT, which must be the last attribute.
+func (d *StunDecoder) ValidateFingerprint() error {
+	if d.Raw == nil {
+		return ErrStunNoRawMessage
+	}
+	attr, ok := d.Find(STUN_ATTR_FINGERPRINT)
+	if !ok {
+		return ErrStunNoFingerprint
+	}
+	return stunCheckFingerprint(d.Raw, attr)
+}
+
+// stunCheckIntegrity computes the HMAC-SHA1 of raw message before attr, whose
+// header length is adjusted to the end of MESSAGE-INTEGRITY. The header and
+// digest are the buffers to avoid allocation.
+func stunCheckIntegrity(mac hash.Hash, header, digest, raw []byte, attr StunRawAttribute) error {
+	if int(attr.Length) != kStunMessageIntegritySize {
+		return ErrStunIntegrityInvalid
+	}
+
+	copy(header, raw[:kStunHeaderSize])
+	adjustedLen := attr.Offset + kStunAttributeHeaderSize + kStunMessageIntegritySize - kStunHeaderSize
+	binary.BigEndian.PutUint16(header[2:], uint16(adjustedLen))
+
+	mac.Reset()
+	mac.Write(header[:kStunHeaderSize])
+	mac.Write(raw[kStunHeaderSize:attr.Offset])
+	valueOffset := attr.Offset + kStunAttributeHeaderSize
+	if !hmac.Equal(mac.Sum(digest), raw[valueOffset:valueOffset+kStunMessageIntegritySize]) {
+		return ErrStunIntegrityMismatch
+	}
+	return nil
+}
+
+// stunCheckFingerprint checks the CRC-32 of raw message before attr.
+func stunCheckFingerprint(raw []byte, attr StunRawAttribute) error {
+	if attr.Offset+kStunAttributeHeaderSize+kStunFingerprintSize != len(raw) {
+		return ErrStunFingerprintNotLast
+	}
+	if int(attr.Length) != kStunFingerprintSize {
+		return ErrStunFingerprintMismatch
+	}
+	value := binary.BigEndian.Uint32(raw[attr.Offset+kStunAttributeHeaderSize:])
+	if stunFingerprint(raw[:attr.Offset]) != value {
+		return ErrStunFingerprintMismatch
+	}
+	return nil
+}
+
+// stunFingerprint returns the FINGERPRINT value of data.
+func stunFingerprint(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data) ^ STUN_FINGERPRINT_XOR_VALUE
+}
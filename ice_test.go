@@ -0,0 +1,735 @@
+package goutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The samples of RFC 5769, with password "VOkJxbRl1RmTxUk/WvJxBt".
+var kStunRfc5769Request = `
+	00 01 00 58 21 12 a4 42 b7 e7 a7 01 bc 34 d6 86 fa 87 df ae
+	80 22 00 10 53 54 55 4e 20 74 65 73 74 20 63 6c 69 65 6e 74
+	00 24 00 04 6e 00 01 ff
+	80 29 00 08 93 2f f9 b1 51 26 3b 36
+	00 06 00 09 65 76 74 6a 3a 68 36 76 59 20 20 20
+	00 08 00 14 9a ea a7 0c bf d8 cb 56 78 1e f2 b5 b2 d3 f2 49 c1 b5 71 a2
+	80 28 00 04 e5 7a 3b cf`
+
+var kStunRfc5769Ipv4Response = `
+	01 01 00 3c 21 12 a4 42 b7 e7 a7 01 bc 34 d6 86 fa 87 df ae
+	80 22 00 0b 74 65 73 74 20 76 65 63 74 6f 72 20
+	00 20 00 08 00 01 a1 47 e1 12 a6 43
+	00 08 00 14 2b 91 f5 99 fd 9e 90 c3 8c 74 89 f9 2a f9 ba 53 f0 6b e7 d7
+	80 28 00 04 c0 7d 4c 96`
+
+var kStunRfc5769Ipv6Response = `
+	01 01 00 48 21 12 a4 42 b7 e7 a7 01 bc 34 d6 86 fa 87 df ae
+	80 22 00 0b 74 65 73 74 20 76 65 63 74 6f 72 20
+	00 20 00 14 00 02 a1 47 01 13 a9 fa a5 d3 f1 79 bc 25 f4 b5 be d2 b9 d9
+	00 08 00 14 a3 82 95 4e 4b e6 7b f1 17 84 c9 7c 82 92 c2 75 bf e3 ed 41
+	80 28 00 04 c8 fb 0b 4c`
+
+// The sample request of RFC 5769 with long-term authentication, whose
+// username is "\u30DE\u30C8\u30EA\u30C3\u30AF\u30B9", realm is "example.org"
+// and password is "TheMatrIX" after SASLprep.
+var kStunRfc5769LongTermRequest = `
+	00 01 00 60 21 12 a4 42 78 ad 34 33 c6 ad 72 c0 29 da 41 2e
+	00 06 00 12 e3 83 9e e3 83 88 e3 83 aa e3 83 83 e3 82 af e3 82 b9 00 00
+	00 15 00 1c 66 2f 2f 34 39 39 6b 39 35 34 64 36 4f 4c 33 34 6f 4c 39 46 53 54 76 79 36 34 73 41
+	00 14 00 0b 65 78 61 6d 70 6c 65 2e 6f 72 67 00
+	00 08 00 14 f6 70 24 65 6d d6 4a 3e 02 b8 e0 71 2e 85 c9 a2 8c a8 96 66`
+
+func decodeStunHex(t *testing.T, text string) []byte {
+	data, err := hex.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		t.Fatalf("hex: %v", err)
+	}
+	return data
+}
+
+func TestStunMessage_1(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GenStunMessageRequest(&buf, "local", "remote", "password"); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	data := buf.Bytes()
+
+	var msg StunMessage
+	if err := msg.Read(data); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := msg.ValidateMessageIntegrity("password"); err != nil {
+		t.Fatalf("integrity: %v", err)
+	}
+	if err := msg.ValidateFingerprint(); err != nil {
+		t.Fatalf("fingerprint: %v", err)
+	}
+	if err := msg.ValidateMessageIntegrity("wrong"); err != ErrStunIntegrityMismatch {
+		t.Fatalf("wrong password: %v", err)
+	}
+
+	// the username is changed
+	forged := append([]byte(nil), data...)
+	forged[kStunHeaderSize+kStunAttributeHeaderSize] ^= 1
+	msg = StunMessage{}
+	if err := msg.Read(forged); err != nil {
+		t.Fatalf("read forged: %v", err)
+	}
+	if err := msg.ValidateFingerprint(); err != ErrStunFingerprintMismatch {
+		t.Fatalf("forged fingerprint: %v", err)
+	}
+	if err := msg.ValidateMessageIntegrity("password"); err != ErrStunIntegrityMismatch {
+		t.Fatalf("forged integrity: %v", err)
+	}
+
+	// no FINGERPRINT, and the long-term key
+	key := StunLongTermKey("user", "realm", "pass")
+	resp := NewStunMessageResponse(msg.TransId)
+	xorAttr := &StunXorAddressAttribute{}
+	xorAttr.SetType(STUN_ATTR_XOR_MAPPED_ADDRESS)
+	xorAttr.Addr.SetAddr(&net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 5000})
+	resp.AddAttribute(xorAttr)
+	resp.AddMessageIntegrity(key)
+	buf.Reset()
+	resp.Write(&buf)
+	msg = StunMessage{}
+	if err := msg.Read(buf.Bytes()); err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if err := msg.ValidateMessageIntegrity(key); err != nil {
+		t.Fatalf("long-term integrity: %v", err)
+	}
+	if err := msg.ValidateFingerprint(); err != ErrStunNoFingerprint {
+		t.Fatalf("no fingerprint: %v", err)
+	}
+
+	if err := (&StunMessage{}).ValidateMessageIntegrity(key); err != ErrStunNoRawMessage {
+		t.Fatalf("no raw: %v", err)
+	}
+}
+
+func TestStunMessage_2(t *testing.T) {
+	req := NewStunMessageRequest()
+	req.AddAttribute(NewStunUInt32Attribute(STUN_ATTR_PRIORITY, 0x6e0001ff))
+	req.AddAttribute(NewStunEmptyAttribute(STUN_ATTR_USE_CANDIDATE))
+	req.AddAttribute(NewStunUInt64Attribute(STUN_ATTR_ICE_CONTROLLING, 0x0102030405060708))
+	// the unknown comprehension-required and comprehension-optional attributes
+	req.AddAttribute(NewStunByteStringAttribute(0x0031, []byte("abc")))
+	req.AddAttribute(NewStunByteStringAttribute(0x8031, []byte("abc")))
+	req.AddMessageIntegrity("password")
+	req.AddFingerprint()
+	var buf bytes.Buffer
+	req.Write(&buf)
+
+	var msg StunMessage
+	if err := msg.Read(buf.Bytes()); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := msg.ValidateMessageIntegrity("password"); err != nil {
+		t.Fatalf("integrity: %v", err)
+	}
+	if attr, ok := msg.GetAttribute(STUN_ATTR_PRIORITY).(*StunUInt32Attribute); !ok || attr.GetValue() != 0x6e0001ff {
+		t.Fatalf("priority: %v", msg.GetAttribute(STUN_ATTR_PRIORITY))
+	}
+	if _, ok := msg.GetAttribute(STUN_ATTR_USE_CANDIDATE).(*StunEmptyAttribute); !ok {
+		t.Fatalf("use-candidate: %v", msg.GetAttribute(STUN_ATTR_USE_CANDIDATE))
+	}
+	if attr, ok := msg.GetAttribute(STUN_ATTR_ICE_CONTROLLING).(*StunUInt64Attribute); !ok || attr.GetValue() != 0x0102030405060708 {
+		t.Fatalf("ice-controlling: %v", msg.GetAttribute(STUN_ATTR_ICE_CONTROLLING))
+	}
+	if len(msg.UnknownAttrs) != 1 || msg.UnknownAttrs[0] != 0x0031 {
+		t.Fatalf("unknown attributes: %v", msg.UnknownAttrs)
+	}
+
+	// the 420 error response
+	buf.Reset()
+	if err := GenStunUnknownAttributesResponse(&buf, "password", &msg); err != nil {
+		t.Fatalf("420 response: %v", err)
+	}
+	resp := StunMessage{}
+	if err := resp.Read(buf.Bytes()); err != nil {
+		t.Fatalf("read 420 response: %v", err)
+	}
+	if resp.Dtype != STUN_BINDING_ERROR_RESPONSE || resp.TransId != msg.TransId {
+		t.Fatalf("420 response type: 0x%04x", resp.Dtype)
+	}
+	if err := resp.ValidateMessageIntegrity("password"); err != nil {
+		t.Fatalf("420 integrity: %v", err)
+	}
+	if err := resp.ValidateFingerprint(); err != nil {
+		t.Fatalf("420 fingerprint: %v", err)
+	}
+	errorAttr, ok := resp.GetAttribute(STUN_ATTR_ERROR_CODE).(*StunErrorCodeAttribute)
+	if !ok || errorAttr.Code() != STUN_ERROR_UNKNOWN_ATTRIBUTE || errorAttr.Reason != "Unknown Attribute" {
+		t.Fatalf("error-code: %v", resp.GetAttribute(STUN_ATTR_ERROR_CODE))
+	}
+	unknownAttr, ok := resp.GetAttribute(STUN_ATTR_UNKNOWN_ATTRIBUTES).(*StunUInt16ListAttribute)
+	if !ok || unknownAttr.Size() != 1 || unknownAttr.GetValue(0) != 0x0031 {
+		t.Fatalf("unknown-attributes: %v", resp.GetAttribute(STUN_ATTR_UNKNOWN_ATTRIBUTES))
+	}
+
+	// no response for the known attributes
+	if err := GenStunUnknownAttributesResponse(&buf, "", &resp); err == nil {
+		t.Fatalf("420 response without unknown attributes")
+	}
+}
+
+func TestStunMessage_3(t *testing.T) {
+	checks := []struct {
+		text string
+		addr *net.UDPAddr
+	}{
+		{kStunRfc5769Ipv4Response, &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 32853}},
+		{kStunRfc5769Ipv6Response, &net.UDPAddr{IP: net.ParseIP("2001:db8:1234:5678:11:2233:4455:6677"), Port: 32853}},
+	}
+	for i, check := range checks {
+		data := decodeStunHex(t, check.text)
+		var msg StunMessage
+		if err := msg.Read(data); err != nil {
+			t.Fatalf("%d read: %v", i, err)
+		}
+		if err := msg.ValidateMessageIntegrity("VOkJxbRl1RmTxUk/WvJxBt"); err != nil {
+			t.Fatalf("%d integrity: %v", i, err)
+		}
+		if err := msg.ValidateFingerprint(); err != nil {
+			t.Fatalf("%d fingerprint: %v", i, err)
+		}
+		xorAttr, ok := msg.GetAttribute(STUN_ATTR_XOR_MAPPED_ADDRESS).(*StunXorAddressAttribute)
+		if !ok {
+			t.Fatalf("%d no xor-mapped-address", i)
+		}
+		if addr := xorAttr.UDPAddr(); !addr.IP.Equal(check.addr.IP) || addr.Port != check.addr.Port {
+			t.Fatalf("%d xor-mapped-address: %v", i, addr)
+		}
+
+		// encode the address with the same transaction ID
+		resp := NewStunMessageResponse(msg.TransId)
+		attr, err := NewStunXorAddressAttribute(STUN_ATTR_XOR_MAPPED_ADDRESS, &net.TCPAddr{IP: check.addr.IP, Port: check.addr.Port})
+		if err != nil {
+			t.Fatalf("%d xor attr: %v", i, err)
+		}
+		resp.AddAttribute(attr)
+		var buf bytes.Buffer
+		if err := resp.Write(&buf); err != nil {
+			t.Fatalf("%d write: %v", i, err)
+		}
+		offset := xorAttr.GetOffset()
+		want := data[offset : offset+kStunAttributeHeaderSize+int(xorAttr.GetLen())]
+		if !bytes.Equal(buf.Bytes()[kStunHeaderSize:], want) {
+			t.Fatalf("%d encoded: %x, want %x", i, buf.Bytes()[kStunHeaderSize:], want)
+		}
+	}
+
+	// MAPPED-ADDRESS is not XORed
+	for _, ip := range []string{"192.0.2.1", "2001:db8::1"} {
+		attr, err := NewStunAddressAttribute(STUN_ATTR_MAPPED_ADDRESS, &net.UDPAddr{IP: net.ParseIP(ip), Port: 3478})
+		if err != nil {
+			t.Fatalf("mapped attr: %v", err)
+		}
+		resp := NewStunMessageResponse(NewStunTransId())
+		resp.AddAttribute(attr)
+		var buf bytes.Buffer
+		resp.Write(&buf)
+		var msg StunMessage
+		if err := msg.Read(buf.Bytes()); err != nil {
+			t.Fatalf("read mapped: %v", err)
+		}
+		mapped, ok := msg.GetAttribute(STUN_ATTR_MAPPED_ADDRESS).(*StunAddressAttribute)
+		if !ok || mapped.TCPAddr().String() != attr.String() {
+			t.Fatalf("mapped-address: %v, want %v", msg.GetAttribute(STUN_ATTR_MAPPED_ADDRESS), attr)
+		}
+	}
+	if _, err := NewStunAddressAttribute(STUN_ATTR_MAPPED_ADDRESS, &net.UnixAddr{Name: "x"}); err == nil {
+		t.Fatalf("unix addr accepted")
+	}
+}
+
+func TestStunMessage_4(t *testing.T) {
+	// the concurrent requests have distinct TransIds
+	var transIds sync.Map
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				transId := NewStunMessageRequest().TransId
+				if _, loaded := transIds.LoadOrStore(transId, true); loaded || len(transId) != kStunTransactionIdLength {
+					t.Errorf("transaction id: %x", transId)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestStunDecoder_1(t *testing.T) {
+	const password = "VOkJxbRl1RmTxUk/WvJxBt"
+	var d StunDecoder
+
+	// the sample request
+	data := decodeStunHex(t, kStunRfc5769Request)
+	if err := d.Decode(data); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if d.Dtype != STUN_BINDING_REQUEST || len(d.Attrs) != 6 || len(d.UnknownAttrs) != 0 {
+		t.Fatalf("request: 0x%04x, %v, %v", d.Dtype, d.Attrs, d.UnknownAttrs)
+	}
+	if err := d.ValidateMessageIntegrity(password); err != nil {
+		t.Fatalf("request integrity: %v", err)
+	}
+	if err := d.ValidateFingerprint(); err != nil {
+		t.Fatalf("request fingerprint: %v", err)
+	}
+	if value, _ := d.GetBytes(STUN_ATTR_USERNAME); string(value) != "evtj:h6vY" {
+		t.Fatalf("username: %q", value)
+	}
+	if value, _ := d.GetBytes(STUN_ATTR_SOFTWARE); string(value) != "STUN test client" {
+		t.Fatalf("software: %q", value)
+	}
+	if value, ok := d.GetUInt32(STUN_ATTR_PRIORITY); !ok || value != 0x6e0001ff {
+		t.Fatalf("priority: 0x%x", value)
+	}
+	if value, ok := d.GetUInt64(STUN_ATTR_ICE_CONTROLLED); !ok || value != 0x932ff9b151263b36 {
+		t.Fatalf("ice-controlled: 0x%x", value)
+	}
+
+	// the same with StunMessage
+	var msg StunMessage
+	if err := msg.Read(data); err != nil {
+		t.Fatalf("read request: %v", err)
+	}
+	if string(d.TransId()) != msg.TransId || len(msg.OrderAttrs) != len(d.Attrs) {
+		t.Fatalf("read request: %q, %d", msg.TransId, len(msg.OrderAttrs))
+	}
+	if err := msg.ValidateMessageIntegrity(password); err != nil {
+		t.Fatalf("read request integrity: %v", err)
+	}
+	if attr, ok := msg.GetAttribute(STUN_ATTR_ICE_CONTROLLED).(*StunUInt64Attribute); !ok || attr.GetValue() != 0x932ff9b151263b36 {
+		t.Fatalf("read ice-controlled: %v", msg.GetAttribute(STUN_ATTR_ICE_CONTROLLED))
+	}
+
+	// the sample responses
+	checks := []struct {
+		text string
+		ip   string
+	}{
+		{kStunRfc5769Ipv4Response, "192.0.2.1"},
+		{kStunRfc5769Ipv6Response, "2001:db8:1234:5678:11:2233:4455:6677"},
+	}
+	for i, check := range checks {
+		if err := d.Decode(decodeStunHex(t, check.text)); err != nil {
+			t.Fatalf("%d response: %v", i, err)
+		}
+		if err := d.ValidateMessageIntegrity(password); err != nil {
+			t.Fatalf("%d response integrity: %v", i, err)
+		}
+		if err := d.ValidateFingerprint(); err != nil {
+			t.Fatalf("%d response fingerprint: %v", i, err)
+		}
+		ip, port, ok := d.GetXorAddress(STUN_ATTR_XOR_MAPPED_ADDRESS)
+		if !ok || !ip.Equal(net.ParseIP(check.ip)) || port != 32853 {
+			t.Fatalf("%d xor-mapped-address: %v, %d", i, ip, port)
+		}
+	}
+
+	// the sample request with long-term authentication
+	data = decodeStunHex(t, kStunRfc5769LongTermRequest)
+	if err := d.Decode(data); err != nil {
+		t.Fatalf("long-term: %v", err)
+	}
+	username, _ := d.GetBytes(STUN_ATTR_USERNAME)
+	realm, _ := d.GetBytes(STUN_ATTR_REALM)
+	nonce, _ := d.GetBytes(STUN_ATTR_NONCE)
+	if string(username) != "\u30DE\u30C8\u30EA\u30C3\u30AF\u30B9" || string(realm) != "example.org" ||
+		string(nonce) != "f//499k954d6OL34oL9FSTvy64sA" {
+		t.Fatalf("long-term: %q, %q, %q", username, realm, nonce)
+	}
+	key := StunLongTermKey(string(username), string(realm), "TheMatrIX")
+	if err := d.ValidateMessageIntegrity(key); err != nil {
+		t.Fatalf("long-term integrity: %v", err)
+	}
+	if err := d.ValidateFingerprint(); err != ErrStunNoFingerprint {
+		t.Fatalf("long-term fingerprint: %v", err)
+	}
+	msg = StunMessage{}
+	if err := msg.Read(data); err != nil {
+		t.Fatalf("read long-term: %v", err)
+	}
+	if err := msg.ValidateMessageIntegrity(key); err != nil {
+		t.Fatalf("read long-term integrity: %v", err)
+	}
+
+	// the invalid messages
+	data = decodeStunHex(t, kStunRfc5769Request)
+	for i, bad := range [][]byte{data[:kStunHeaderSize-1], data[:len(data)-4], {0x80, 0x01, 0, 0}} {
+		if err := d.Decode(bad); err == nil {
+			t.Fatalf("%d invalid message accepted", i)
+		}
+	}
+}
+
+func TestStunDecoder_2(t *testing.T) {
+	const password = "VOkJxbRl1RmTxUk/WvJxBt"
+	request := decodeStunHex(t, kStunRfc5769Request)
+	response := decodeStunHex(t, kStunRfc5769Ipv6Response)
+
+	var d StunDecoder
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := d.Decode(request); err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		if _, ok := d.GetBytes(STUN_ATTR_USERNAME); !ok {
+			t.Fatalf("no username")
+		}
+		if _, ok := d.GetUInt64(STUN_ATTR_ICE_CONTROLLED); !ok {
+			t.Fatalf("no ice-controlled")
+		}
+		if d.ValidateMessageIntegrity(password) != nil || d.ValidateFingerprint() != nil {
+			t.Fatalf("request validation")
+		}
+		if err := d.Decode(response); err != nil {
+			t.Fatalf("response: %v", err)
+		}
+		if _, _, ok := d.GetXorAddress(STUN_ATTR_XOR_MAPPED_ADDRESS); !ok {
+			t.Fatalf("no xor-mapped-address")
+		}
+		if d.ValidateMessageIntegrity(password) != nil || d.ValidateFingerprint() != nil {
+			t.Fatalf("response validation")
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("allocs: %v", allocs)
+	}
+}
+
+// runStunServer answers the requests of conn by handle until conn is closed,
+// and no response is sent if handle returns nil.
+func runStunServer(conn net.PacketConn, handle func(req *StunMessage, from net.Addr) *StunMessage) {
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var req StunMessage
+			if req.Read(buf[:n]) != nil {
+				continue
+			}
+			if resp := handle(&req, from); resp != nil {
+				var out bytes.Buffer
+				resp.Write(&out)
+				conn.WriteTo(out.Bytes(), from)
+			}
+		}
+	}()
+}
+
+func newStunBindingResponse(req *StunMessage, from net.Addr) *StunMessage {
+	resp := NewStunMessageResponse(req.TransId)
+	attr, _ := NewStunXorAddressAttribute(STUN_ATTR_XOR_MAPPED_ADDRESS, from)
+	resp.AddAttribute(attr)
+	resp.AddFingerprint()
+	return resp
+}
+
+func newStunTestClient(t *testing.T) *StunClient {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	client := NewStunClient(conn)
+	client.RTO = 20 * time.Millisecond
+	return client
+}
+
+func newStunTestServer(t *testing.T, handle func(req *StunMessage, from net.Addr) *StunMessage) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	runStunServer(conn, handle)
+	return conn
+}
+
+func TestStunClient_1(t *testing.T) {
+	client := newStunTestClient(t)
+	defer client.Close()
+	ctx := context.Background()
+
+	// the first two requests are lost
+	var count int32
+	var transIds sync.Map
+	server := newStunTestServer(t, func(req *StunMessage, from net.Addr) *StunMessage {
+		transIds.Store(req.TransId, true)
+		if atomic.AddInt32(&count, 1) <= 2 {
+			return nil
+		}
+		return newStunBindingResponse(req, from)
+	})
+	defer server.Close()
+	mapped, err := client.Binding(ctx, server.LocalAddr())
+	if err != nil {
+		t.Fatalf("binding: %v", err)
+	}
+	if mapped.UDPAddr().String() != client.packetConn.LocalAddr().String() {
+		t.Fatalf("mapped: %v", mapped)
+	}
+	size := 0
+	transIds.Range(func(key, value interface{}) bool { size++; return true })
+	if atomic.LoadInt32(&count) != 3 || size != 1 {
+		t.Fatalf("retransmissions: %d, transIds: %d", count, size)
+	}
+
+	// the concurrent transactions
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Binding(ctx, server.LocalAddr()); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent binding: %v", err)
+	}
+
+	// the error response
+	errorServer := newStunTestServer(t, func(req *StunMessage, from net.Addr) *StunMessage {
+		return NewStunMessageErrorResponse(req.Dtype, req.TransId, 401, "Unauthorized")
+	})
+	defer errorServer.Close()
+	resp, err := client.Do(ctx, NewStunMessageRequest(), errorServer.LocalAddr())
+	var errorAttr *StunErrorCodeAttribute
+	if !errors.As(err, &errorAttr) || errorAttr.Code() != 401 || resp == nil || resp.Dtype != STUN_BINDING_ERROR_RESPONSE {
+		t.Fatalf("error response: %v", err)
+	}
+
+	// redirected by ALTERNATE-SERVER
+	alternateServer := newStunTestServer(t, func(req *StunMessage, from net.Addr) *StunMessage {
+		resp := NewStunMessageErrorResponse(req.Dtype, req.TransId, STUN_ERROR_TRY_ALTERNATE, "Try Alternate")
+		attr, _ := NewStunAddressAttribute(STUN_ATTR_ALTERNATE_SERVER, server.LocalAddr())
+		resp.AddAttribute(attr)
+		return resp
+	})
+	defer alternateServer.Close()
+	if _, err := client.Binding(ctx, alternateServer.LocalAddr()); err != nil {
+		t.Fatalf("alternate: %v", err)
+	}
+	client.MaxRedirects = 0
+	if _, err := client.Binding(ctx, alternateServer.LocalAddr()); err != ErrStunTooManyRedirects {
+		t.Fatalf("redirects: %v", err)
+	}
+}
+
+func TestStunClient_2(t *testing.T) {
+	client := newStunTestClient(t)
+	client.RTO = time.Millisecond
+
+	// no response: Rc requests and Rm*RTO for the last
+	var count int32
+	server := newStunTestServer(t, func(req *StunMessage, from net.Addr) *StunMessage {
+		atomic.AddInt32(&count, 1)
+		return nil
+	})
+	defer server.Close()
+	start := time.Now()
+	if _, err := client.Binding(context.Background(), server.LocalAddr()); err != ErrStunTimeout {
+		t.Fatalf("timeout: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < client.Timeout() {
+		t.Fatalf("timeout elapsed: %v, want %v", elapsed, client.Timeout())
+	}
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadInt32(&count) != int32(client.Rc) {
+		t.Fatalf("requests: %d", count)
+	}
+	if client.Timeout() != 79*time.Millisecond || (&StunClient{RTO: kStunClientRTO, Rc: kStunClientRc, Rm: kStunClientRm}).Timeout() != 39500*time.Millisecond {
+		t.Fatalf("timeout: %v", client.Timeout())
+	}
+
+	// the cancelled context
+	client.RTO = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.Binding(ctx, server.LocalAddr()); err != context.DeadlineExceeded {
+		t.Fatalf("cancel: %v", err)
+	}
+
+	// the pending transaction is closed
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.Close()
+	}()
+	if _, err := client.Binding(context.Background(), server.LocalAddr()); err != ErrStunClientClosed {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := client.Binding(context.Background(), server.LocalAddr()); err != ErrStunClientClosed {
+		t.Fatalf("closed: %v", err)
+	}
+}
+
+func TestStunClient_3(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var header [kStunHeaderSize]byte
+		for {
+			if _, err := io.ReadFull(conn, header[:]); err != nil {
+				return
+			}
+			data := make([]byte, kStunHeaderSize+int(binary.BigEndian.Uint16(header[2:])))
+			copy(data, header[:])
+			if _, err := io.ReadFull(conn, data[kStunHeaderSize:]); err != nil {
+				return
+			}
+			var req StunMessage
+			req.Read(data)
+			var out bytes.Buffer
+			newStunBindingResponse(&req, conn.RemoteAddr()).Write(&out)
+			// the response is split into two writes
+			conn.Write(out.Bytes()[:10])
+			time.Sleep(time.Millisecond)
+			conn.Write(out.Bytes()[10:])
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	client := NewStunStreamClient(conn)
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		mapped, err := client.Binding(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("%d binding: %v", i, err)
+		}
+		if mapped.TCPAddr().String() != conn.LocalAddr().String() {
+			t.Fatalf("%d mapped: %v", i, mapped)
+		}
+	}
+}
+
+func TestStunClient_4(t *testing.T) {
+	client := newStunTestClient(t)
+	defer client.Close()
+	ctx := context.Background()
+
+	// the redirected request is a new transaction
+	var targetTransId, alternateTransId atomic.Value
+	target := newStunTestServer(t, func(req *StunMessage, from net.Addr) *StunMessage {
+		targetTransId.Store(req.TransId)
+		return newStunBindingResponse(req, from)
+	})
+	defer target.Close()
+	alternateServer := newStunTestServer(t, func(req *StunMessage, from net.Addr) *StunMessage {
+		alternateTransId.Store(req.TransId)
+		resp := NewStunMessageErrorResponse(req.Dtype, req.TransId, STUN_ERROR_TRY_ALTERNATE, "Try Alternate")
+		attr, _ := NewStunAddressAttribute(STUN_ATTR_ALTERNATE_SERVER, target.LocalAddr())
+		resp.AddAttribute(attr)
+		return resp
+	})
+	defer alternateServer.Close()
+	req := NewStunMessageRequest()
+	req.AddFingerprint()
+	resp, err := client.Do(ctx, req, alternateServer.LocalAddr())
+	if err != nil || resp.TransId != targetTransId.Load() {
+		t.Fatalf("alternate: %v", err)
+	}
+	if alternateTransId.Load() != req.TransId || targetTransId.Load() == req.TransId {
+		t.Fatalf("transIds: %v, %v", alternateTransId.Load(), targetTransId.Load())
+	}
+
+	// the request with MESSAGE-INTEGRITY is redirected with the same credentials
+	var integrityErr atomic.Value
+	integrityTarget := newStunTestServer(t, func(req *StunMessage, from net.Addr) *StunMessage {
+		err := req.ValidateMessageIntegrity("password")
+		if err == nil {
+			err = req.ValidateFingerprint()
+		}
+		if err != nil {
+			integrityErr.Store(err)
+		}
+		return newStunBindingResponse(req, from)
+	})
+	defer integrityTarget.Close()
+	integrityAlternate := newStunTestServer(t, func(req *StunMessage, from net.Addr) *StunMessage {
+		resp := NewStunMessageErrorResponse(req.Dtype, req.TransId, STUN_ERROR_TRY_ALTERNATE, "Try Alternate")
+		attr, _ := NewStunAddressAttribute(STUN_ATTR_ALTERNATE_SERVER, integrityTarget.LocalAddr())
+		resp.AddAttribute(attr)
+		return resp
+	})
+	defer integrityAlternate.Close()
+	req = NewStunMessageRequest()
+	req.AddAttribute(NewStunByteStringAttribute(STUN_ATTR_USERNAME, []byte("user")))
+	req.AddMessageIntegrity("password")
+	req.AddFingerprint()
+	if _, err := client.Do(ctx, req, integrityAlternate.LocalAddr()); err == nil {
+		t.Fatalf("integrity redirected without key")
+	} else if attr, ok := err.(*StunErrorCodeAttribute); !ok || attr.Code() != STUN_ERROR_TRY_ALTERNATE {
+		t.Fatalf("integrity without key: %v", err)
+	}
+	if _, err := client.DoWithKey(ctx, req, integrityAlternate.LocalAddr(), "password"); err != nil {
+		t.Fatalf("integrity alternate: %v", err)
+	}
+	if err := integrityErr.Load(); err != nil {
+		t.Fatalf("integrity renewed: %v", err)
+	}
+
+	// the server already tried is not redirected again
+	var loopServer net.PacketConn
+	loopServer = newStunTestServer(t, func(req *StunMessage, from net.Addr) *StunMessage {
+		resp := NewStunMessageErrorResponse(req.Dtype, req.TransId, STUN_ERROR_TRY_ALTERNATE, "Try Alternate")
+		attr, _ := NewStunAddressAttribute(STUN_ATTR_ALTERNATE_SERVER, loopServer.LocalAddr())
+		resp.AddAttribute(attr)
+		return resp
+	})
+	defer loopServer.Close()
+	if _, err := client.Binding(ctx, loopServer.LocalAddr()); err != ErrStunRedirectLoop {
+		t.Fatalf("loop: %v", err)
+	}
+
+	// the response from other address is dropped
+	spoofer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer spoofer.Close()
+	server := newStunTestServer(t, func(req *StunMessage, from net.Addr) *StunMessage {
+		var out bytes.Buffer
+		newStunBindingResponse(req, from).Write(&out)
+		spoofer.WriteTo(out.Bytes(), from)
+		return nil
+	})
+	defer server.Close()
+	client.RTO = time.Millisecond
+	if _, err := client.Binding(ctx, server.LocalAddr()); err != ErrStunTimeout {
+		t.Fatalf("spoofed: %v", err)
+	}
+}
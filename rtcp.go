@@ -0,0 +1,1007 @@
+package goutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+/*
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |V=2|P|    RC   |   PT=SR=200   |             length            |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                         SSRC of sender                        |
+ * +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+ */
+
+const (
+	kRtcpVersion      = 2
+	kRtcpHeaderLength = 4
+	kRtcpSsrcLength   = 4
+
+	kRtcpVersionShift = 6
+	kRtcpVersionMask  = 0x3
+	kRtcpPaddingShift = 5
+	kRtcpPaddingMask  = 0x1
+	kRtcpCountMask    = 0x1F
+	kRtcpMaxCount     = 0x1F
+
+	kRtcpReportBlockLength = 24
+	kRtcpSenderInfoLength  = 20
+)
+
+// RtcpPacketType 1byte
+type RtcpPacketType uint8
+
+// These are the types of RTCP packets defined in RFC 3550 and RFC 4585.
+const (
+	RTCP_SR    RtcpPacketType = 200
+	RTCP_RR    RtcpPacketType = 201
+	RTCP_SDES  RtcpPacketType = 202
+	RTCP_BYE   RtcpPacketType = 203
+	RTCP_APP   RtcpPacketType = 204
+	RTCP_RTPFB RtcpPacketType = 205
+	RTCP_PSFB  RtcpPacketType = 206
+	RTCP_XR    RtcpPacketType = 207
+)
+
+func (t RtcpPacketType) String() string {
+	switch t {
+	case RTCP_SR:
+		return "SR"
+	case RTCP_RR:
+		return "RR"
+	case RTCP_SDES:
+		return "SDES"
+	case RTCP_BYE:
+		return "BYE"
+	case RTCP_APP:
+		return "APP"
+	case RTCP_RTPFB:
+		return "RTPFB"
+	case RTCP_PSFB:
+		return "PSFB"
+	case RTCP_XR:
+		return "XR"
+	}
+	return fmt.Sprintf("RTCP(%d)", uint8(t))
+}
+
+// RtcpHeader is the common header of all RTCP packets.
+type RtcpHeader struct {
+	Padding bool
+	Count   uint8 // RC/SC/FMT/subtype
+	Type    RtcpPacketType
+	Length  uint16 // in 32-bit words minus one
+}
+
+// Unmarshal parses the 4-bytes header
+func (h *RtcpHeader) Unmarshal(rawPacket []byte) error {
+	if len(rawPacket) < kRtcpHeaderLength {
+		return fmt.Errorf("RTCP header size insufficient: %d", len(rawPacket))
+	}
+	if version := rawPacket[0] >> kRtcpVersionShift & kRtcpVersionMask; version != kRtcpVersion {
+		return fmt.Errorf("RTCP invalid version: %d", version)
+	}
+	h.Padding = (rawPacket[0] >> kRtcpPaddingShift & kRtcpPaddingMask) > 0
+	h.Count = rawPacket[0] & kRtcpCountMask
+	h.Type = RtcpPacketType(rawPacket[1])
+	h.Length = binary.BigEndian.Uint16(rawPacket[2:4])
+	return nil
+}
+
+// MarshalTo serializes the 4-bytes header
+func (h *RtcpHeader) MarshalTo(buf []byte) (int, error) {
+	if len(buf) < kRtcpHeaderLength {
+		return 0, io.ErrShortBuffer
+	}
+	if h.Count > kRtcpMaxCount {
+		return 0, fmt.Errorf("RTCP invalid count: %d", h.Count)
+	}
+	buf[0] = kRtcpVersion<<kRtcpVersionShift | h.Count
+	if h.Padding {
+		buf[0] |= 1 << kRtcpPaddingShift
+	}
+	buf[1] = uint8(h.Type)
+	binary.BigEndian.PutUint16(buf[2:4], h.Length)
+	return kRtcpHeaderLength, nil
+}
+
+// PacketSize returns the whole size of the packet in bytes.
+func (h *RtcpHeader) PacketSize() int {
+	return (int(h.Length) + 1) * 4
+}
+
+// RtcpPacket is the base interface of all RTCP packets.
+type RtcpPacket interface {
+	// Unmarshal parses one whole packet(including header)
+	Unmarshal(rawPacket []byte) error
+	// MarshalTo serializes the packet(including header) into buf
+	MarshalTo(buf []byte) (int, error)
+	// MarshalSize returns the size of the packet once marshaled.
+	MarshalSize() int
+	// Header returns the header of the packet once marshaled.
+	Header() RtcpHeader
+	// DestinationSsrc returns the ssrcs which this packet refers to.
+	DestinationSsrc() []uint32
+}
+
+// MarshalRtcpPacket serializes one RTCP packet into bytes.
+func MarshalRtcpPacket(p RtcpPacket) ([]byte, error) {
+	buf := make([]byte, p.MarshalSize())
+	if n, err := p.MarshalTo(buf); err != nil {
+		return nil, err
+	} else {
+		return buf[:n], nil
+	}
+}
+
+// MarshalRtcpPackets serializes packets back-to-back without compound checking,
+// e.g. for reduced-size RTCP(a=rtcp-rsize, RFC 5506).
+func MarshalRtcpPackets(packets []RtcpPacket) ([]byte, error) {
+	size := 0
+	for _, p := range packets {
+		size += p.MarshalSize()
+	}
+	buf := make([]byte, size)
+	offset := 0
+	for _, p := range packets {
+		n, err := p.MarshalTo(buf[offset:])
+		if err != nil {
+			return nil, err
+		}
+		offset += n
+	}
+	return buf[:offset], nil
+}
+
+// UnmarshalRtcpPackets splits raw data into RTCP packets following the
+// validity checks of RFC 3550 A.2: the version of each packet must be 2, the
+// padding bit is only allowed in the last packet and the lengths must sum up
+// to the total size. Unknown packet types are returned as RtcpRawPacket.
+func UnmarshalRtcpPackets(rawData []byte) ([]RtcpPacket, error) {
+	var packets []RtcpPacket
+	for offset := 0; offset < len(rawData); {
+		var header RtcpHeader
+		if err := header.Unmarshal(rawData[offset:]); err != nil {
+			return nil, err
+		}
+		size := header.PacketSize()
+		if offset+size > len(rawData) {
+			return nil, fmt.Errorf("RTCP packet size insufficient: %d < %d", len(rawData)-offset, size)
+		}
+		if header.Padding && offset+size != len(rawData) {
+			return nil, NewError("RTCP padding only allowed in the last packet")
+		}
+
+		packet := newRtcpPacket(&header, rawData[offset:offset+size])
+		if err := packet.Unmarshal(rawData[offset : offset+size]); err != nil {
+			return nil, err
+		}
+		packets = append(packets, packet)
+		offset += size
+	}
+	if len(packets) == 0 {
+		return nil, NewError("RTCP packet empty")
+	}
+	return packets, nil
+}
+
+// newRtcpPacket returns an empty packet for the header type.
+func newRtcpPacket(header *RtcpHeader, rawPacket []byte) RtcpPacket {
+	switch header.Type {
+	case RTCP_SR:
+		return &RtcpSenderReport{}
+	case RTCP_RR:
+		return &RtcpReceiverReport{}
+	case RTCP_SDES:
+		return &RtcpSourceDescription{}
+	case RTCP_BYE:
+		return &RtcpGoodbye{}
+	case RTCP_APP:
+		return &RtcpApplicationDefined{}
+	case RTCP_RTPFB:
+		switch header.Count {
+		case RTCP_FMT_NACK:
+			return &RtcpNack{}
+		case RTCP_FMT_TRANSPORT_CC:
+			return &RtcpTransportCC{}
+		}
+	case RTCP_PSFB:
+		switch header.Count {
+		case RTCP_FMT_PLI:
+			return &RtcpPli{}
+		case RTCP_FMT_FIR:
+			return &RtcpFir{}
+		case RTCP_FMT_AFB:
+			if IsRtcpRembPacket(rawPacket) {
+				return &RtcpRemb{}
+			}
+		}
+	}
+	return &RtcpRawPacket{}
+}
+
+// RtcpCompoundPacket is a compound RTCP packet of RFC 3550 section 6.1.
+type RtcpCompoundPacket []RtcpPacket
+
+// Validate checks that the first packet is SR/RR and there is one SDES with CNAME.
+func (c RtcpCompoundPacket) Validate() error {
+	if len(c) == 0 {
+		return NewError("RTCP compound packet empty")
+	}
+
+	switch c[0].(type) {
+	case *RtcpSenderReport, *RtcpReceiverReport:
+	default:
+		return NewError("RTCP compound packet must begin with SR or RR")
+	}
+
+	for _, p := range c[1:] {
+		switch p := p.(type) {
+		case *RtcpSenderReport, *RtcpReceiverReport:
+			// additional RR are allowed before SDES
+		case *RtcpSourceDescription:
+			if p.CName() != "" {
+				return nil
+			}
+			return NewError("RTCP compound packet SDES without CNAME")
+		default:
+			return NewError("RTCP compound packet missing SDES before ", p.Header().Type)
+		}
+	}
+	return NewError("RTCP compound packet missing SDES CNAME")
+}
+
+// Unmarshal parses and validates a compound packet.
+func (c *RtcpCompoundPacket) Unmarshal(rawData []byte) error {
+	packets, err := UnmarshalRtcpPackets(rawData)
+	if err != nil {
+		return err
+	}
+	compound := RtcpCompoundPacket(packets)
+	if err := compound.Validate(); err != nil {
+		return err
+	}
+	*c = compound
+	return nil
+}
+
+// Marshal validates and serializes a compound packet.
+func (c RtcpCompoundPacket) Marshal() ([]byte, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return MarshalRtcpPackets(c)
+}
+
+// CName returns the CNAME of the first SDES, or empty.
+func (c RtcpCompoundPacket) CName() string {
+	for _, p := range c {
+		if sdes, ok := p.(*RtcpSourceDescription); ok {
+			return sdes.CName()
+		}
+	}
+	return ""
+}
+
+// rtcpPayload checks header type and returns the packet body without header and padding.
+func rtcpPayload(header *RtcpHeader, rawPacket []byte, ptype RtcpPacketType) ([]byte, error) {
+	if err := header.Unmarshal(rawPacket); err != nil {
+		return nil, err
+	}
+	if header.Type != ptype {
+		return nil, fmt.Errorf("RTCP wrong packet type: %v != %v", header.Type, ptype)
+	}
+	if header.PacketSize() != len(rawPacket) {
+		return nil, fmt.Errorf("RTCP packet length mismatch: %d != %d", header.PacketSize(), len(rawPacket))
+	}
+	payload := rawPacket[kRtcpHeaderLength:]
+	if header.Padding {
+		if len(payload) == 0 {
+			return nil, NewError("RTCP invalid padding")
+		}
+		padding := int(payload[len(payload)-1])
+		if padding == 0 || padding > len(payload) {
+			return nil, fmt.Errorf("RTCP invalid padding length: %d", padding)
+		}
+		payload = payload[:len(payload)-padding]
+	}
+	return payload, nil
+}
+
+// rtcpMarshalHeader checks buf size and writes header of the packet.
+func rtcpMarshalHeader(p RtcpPacket, buf []byte) (int, error) {
+	size := p.MarshalSize()
+	if size > len(buf) {
+		return 0, io.ErrShortBuffer
+	}
+	if size%4 != 0 {
+		return 0, fmt.Errorf("RTCP packet not 32-bit aligned: %d", size)
+	}
+	header := p.Header()
+	return header.MarshalTo(buf)
+}
+
+func rtcpLength(size int) uint16 {
+	return uint16(size/4 - 1)
+}
+
+/*
+ * RFC 3550 report block:
+ * +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+ * |                 SSRC_1 (SSRC of first source)                 |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * | fraction lost |       cumulative number of packets lost       |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |           extended highest sequence number received           |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                      interarrival jitter                      |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                         last SR (LSR)                         |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                   delay since last SR (DLSR)                  |
+ * +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+ */
+type RtcpReportBlock struct {
+	SSRC             uint32
+	FractionLost     uint8
+	TotalLost        int32 // signed 24-bit
+	LastSequence     uint32
+	Jitter           uint32
+	LastSenderReport uint32 // compact ntp of last SR
+	Delay            uint32 // in units of 1/65536 seconds
+}
+
+// SetLastSenderReport sets LSR from the NtpTime of last SR, and DLSR from the
+// delay(milliseconds) since that SR was received.
+func (b *RtcpReportBlock) SetLastSenderReport(ntp *NtpTime, delayMs int64) {
+	b.LastSenderReport = CompactNtp(ntp)
+	if b.LastSenderReport == 0 || delayMs < 0 {
+		b.Delay = 0
+	} else {
+		b.Delay = uint32(DivideRoundToNearest(delayMs*(1<<16), 1000))
+	}
+}
+
+// RttMs returns the round-trip time(milliseconds) at the receive time(ntp) of this block,
+// or 0 if no LSR.
+func (b *RtcpReportBlock) RttMs(ntp *NtpTime) int64 {
+	if b.LastSenderReport == 0 {
+		return 0
+	}
+	return CompactNtpRttToMs(CompactNtp(ntp) - b.LastSenderReport - b.Delay)
+}
+
+func (b *RtcpReportBlock) unmarshal(rawBlock []byte) error {
+	if len(rawBlock) < kRtcpReportBlockLength {
+		return io.ErrUnexpectedEOF
+	}
+	b.SSRC = binary.BigEndian.Uint32(rawBlock[0:4])
+	b.FractionLost = rawBlock[4]
+	lost := uint32(rawBlock[5])<<16 | uint32(rawBlock[6])<<8 | uint32(rawBlock[7])
+	if lost&0x800000 != 0 {
+		b.TotalLost = int32(lost) - 0x1000000
+	} else {
+		b.TotalLost = int32(lost)
+	}
+	b.LastSequence = binary.BigEndian.Uint32(rawBlock[8:12])
+	b.Jitter = binary.BigEndian.Uint32(rawBlock[12:16])
+	b.LastSenderReport = binary.BigEndian.Uint32(rawBlock[16:20])
+	b.Delay = binary.BigEndian.Uint32(rawBlock[20:24])
+	return nil
+}
+
+func (b *RtcpReportBlock) marshalTo(buf []byte) int {
+	binary.BigEndian.PutUint32(buf[0:4], b.SSRC)
+	buf[4] = b.FractionLost
+	lost := b.TotalLost
+	if lost > 0x7FFFFF {
+		lost = 0x7FFFFF
+	} else if lost < -0x800000 {
+		lost = -0x800000
+	}
+	ulost := uint32(lost) & 0xFFFFFF
+	buf[5] = uint8(ulost >> 16)
+	buf[6] = uint8(ulost >> 8)
+	buf[7] = uint8(ulost)
+	binary.BigEndian.PutUint32(buf[8:12], b.LastSequence)
+	binary.BigEndian.PutUint32(buf[12:16], b.Jitter)
+	binary.BigEndian.PutUint32(buf[16:20], b.LastSenderReport)
+	binary.BigEndian.PutUint32(buf[20:24], b.Delay)
+	return kRtcpReportBlockLength
+}
+
+func unmarshalRtcpReportBlocks(count int, data []byte) ([]RtcpReportBlock, []byte, error) {
+	if len(data) < count*kRtcpReportBlockLength {
+		return nil, nil, fmt.Errorf("RTCP report blocks insufficient: %d < %d", len(data), count*kRtcpReportBlockLength)
+	}
+	reports := make([]RtcpReportBlock, count)
+	for i := range reports {
+		reports[i].unmarshal(data[i*kRtcpReportBlockLength:])
+	}
+	return reports, data[count*kRtcpReportBlockLength:], nil
+}
+
+/*
+ * RFC 3550 SR:
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |V=2|P|    RC   |   PT=SR=200   |             length            |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                         SSRC of sender                        |
+ * +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+ * |              NTP timestamp, most significant word             |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |             NTP timestamp, least significant word             |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                         RTP timestamp                         |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                     sender's packet count                     |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                      sender's octet count                     |
+ * +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+ * |                 report blocks(RC * 24 bytes)                  |
+ * +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+ * |                  profile-specific extensions                  |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ */
+type RtcpSenderReport struct {
+	SSRC              uint32
+	NtpTime           NtpTime
+	RtpTime           uint32
+	PacketCount       uint32
+	OctetCount        uint32
+	Reports           []RtcpReportBlock
+	ProfileExtensions []byte
+}
+
+func (p *RtcpSenderReport) Header() RtcpHeader {
+	return RtcpHeader{
+		Count:  uint8(len(p.Reports)),
+		Type:   RTCP_SR,
+		Length: rtcpLength(p.MarshalSize()),
+	}
+}
+
+func (p *RtcpSenderReport) MarshalSize() int {
+	return kRtcpHeaderLength + kRtcpSsrcLength + kRtcpSenderInfoLength +
+		len(p.Reports)*kRtcpReportBlockLength + len(p.ProfileExtensions)
+}
+
+func (p *RtcpSenderReport) DestinationSsrc() []uint32 {
+	ssrcs := make([]uint32, 0, len(p.Reports)+1)
+	for _, report := range p.Reports {
+		ssrcs = append(ssrcs, report.SSRC)
+	}
+	return append(ssrcs, p.SSRC)
+}
+
+func (p *RtcpSenderReport) Marshal() ([]byte, error) {
+	return MarshalRtcpPacket(p)
+}
+
+func (p *RtcpSenderReport) MarshalTo(buf []byte) (int, error) {
+	n, err := rtcpMarshalHeader(p, buf)
+	if err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint32(buf[n:], p.SSRC)
+	binary.BigEndian.PutUint32(buf[n+4:], p.NtpTime.Seconds())
+	binary.BigEndian.PutUint32(buf[n+8:], p.NtpTime.Fractions())
+	binary.BigEndian.PutUint32(buf[n+12:], p.RtpTime)
+	binary.BigEndian.PutUint32(buf[n+16:], p.PacketCount)
+	binary.BigEndian.PutUint32(buf[n+20:], p.OctetCount)
+	n += kRtcpSsrcLength + kRtcpSenderInfoLength
+	for i := range p.Reports {
+		n += p.Reports[i].marshalTo(buf[n:])
+	}
+	n += copy(buf[n:], p.ProfileExtensions)
+	return n, nil
+}
+
+func (p *RtcpSenderReport) Unmarshal(rawPacket []byte) error {
+	var header RtcpHeader
+	payload, err := rtcpPayload(&header, rawPacket, RTCP_SR)
+	if err != nil {
+		return err
+	}
+	if len(payload) < kRtcpSsrcLength+kRtcpSenderInfoLength {
+		return fmt.Errorf("RTCP SR size insufficient: %d", len(payload))
+	}
+	p.SSRC = binary.BigEndian.Uint32(payload[0:4])
+	p.NtpTime.Set(binary.BigEndian.Uint32(payload[4:8]), binary.BigEndian.Uint32(payload[8:12]))
+	p.RtpTime = binary.BigEndian.Uint32(payload[12:16])
+	p.PacketCount = binary.BigEndian.Uint32(payload[16:20])
+	p.OctetCount = binary.BigEndian.Uint32(payload[20:24])
+	p.Reports, payload, err = unmarshalRtcpReportBlocks(int(header.Count), payload[24:])
+	if err != nil {
+		return err
+	}
+	p.ProfileExtensions = nil
+	if len(payload) > 0 {
+		p.ProfileExtensions = payload
+	}
+	return nil
+}
+
+func (p RtcpSenderReport) String() string {
+	out := fmt.Sprintf("RTCP SR: ssrc=%d, ntp=%d.%d, rtp=%d, packets=%d, octets=%d\n",
+		p.SSRC, p.NtpTime.Seconds(), p.NtpTime.Fractions(), p.RtpTime, p.PacketCount, p.OctetCount)
+	for _, report := range p.Reports {
+		out += fmt.Sprintf("\t%+v\n", report)
+	}
+	return out
+}
+
+/*
+ * RFC 3550 RR:
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |V=2|P|    RC   |   PT=RR=201   |             length            |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                     SSRC of packet sender                     |
+ * +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+ * |                 report blocks(RC * 24 bytes)                  |
+ * +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+ * |                  profile-specific extensions                  |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ */
+type RtcpReceiverReport struct {
+	SSRC              uint32
+	Reports           []RtcpReportBlock
+	ProfileExtensions []byte
+}
+
+func (p *RtcpReceiverReport) Header() RtcpHeader {
+	return RtcpHeader{
+		Count:  uint8(len(p.Reports)),
+		Type:   RTCP_RR,
+		Length: rtcpLength(p.MarshalSize()),
+	}
+}
+
+func (p *RtcpReceiverReport) MarshalSize() int {
+	return kRtcpHeaderLength + kRtcpSsrcLength +
+		len(p.Reports)*kRtcpReportBlockLength + len(p.ProfileExtensions)
+}
+
+func (p *RtcpReceiverReport) DestinationSsrc() []uint32 {
+	ssrcs := make([]uint32, 0, len(p.Reports))
+	for _, report := range p.Reports {
+		ssrcs = append(ssrcs, report.SSRC)
+	}
+	return ssrcs
+}
+
+func (p *RtcpReceiverReport) Marshal() ([]byte, error) {
+	return MarshalRtcpPacket(p)
+}
+
+func (p *RtcpReceiverReport) MarshalTo(buf []byte) (int, error) {
+	n, err := rtcpMarshalHeader(p, buf)
+	if err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint32(buf[n:], p.SSRC)
+	n += kRtcpSsrcLength
+	for i := range p.Reports {
+		n += p.Reports[i].marshalTo(buf[n:])
+	}
+	n += copy(buf[n:], p.ProfileExtensions)
+	return n, nil
+}
+
+func (p *RtcpReceiverReport) Unmarshal(rawPacket []byte) error {
+	var header RtcpHeader
+	payload, err := rtcpPayload(&header, rawPacket, RTCP_RR)
+	if err != nil {
+		return err
+	}
+	if len(payload) < kRtcpSsrcLength {
+		return fmt.Errorf("RTCP RR size insufficient: %d", len(payload))
+	}
+	p.SSRC = binary.BigEndian.Uint32(payload[0:4])
+	p.Reports, payload, err = unmarshalRtcpReportBlocks(int(header.Count), payload[4:])
+	if err != nil {
+		return err
+	}
+	p.ProfileExtensions = nil
+	if len(payload) > 0 {
+		p.ProfileExtensions = payload
+	}
+	return nil
+}
+
+func (p RtcpReceiverReport) String() string {
+	out := fmt.Sprintf("RTCP RR: ssrc=%d\n", p.SSRC)
+	for _, report := range p.Reports {
+		out += fmt.Sprintf("\t%+v\n", report)
+	}
+	return out
+}
+
+// RtcpSdesType 1byte
+type RtcpSdesType uint8
+
+// These are the SDES item types defined in RFC 3550.
+const (
+	RTCP_SDES_END   RtcpSdesType = 0
+	RTCP_SDES_CNAME RtcpSdesType = 1
+	RTCP_SDES_NAME  RtcpSdesType = 2
+	RTCP_SDES_EMAIL RtcpSdesType = 3
+	RTCP_SDES_PHONE RtcpSdesType = 4
+	RTCP_SDES_LOC   RtcpSdesType = 5
+	RTCP_SDES_TOOL  RtcpSdesType = 6
+	RTCP_SDES_NOTE  RtcpSdesType = 7
+	RTCP_SDES_PRIV  RtcpSdesType = 8
+)
+
+// RtcpSdesItem is one item(type, text) of SDES chunk.
+type RtcpSdesItem struct {
+	Type RtcpSdesType
+	Text string
+}
+
+// RtcpSdesChunk is the items of one source.
+type RtcpSdesChunk struct {
+	Source uint32
+	Items  []RtcpSdesItem
+}
+
+func (c *RtcpSdesChunk) marshalSize() int {
+	size := kRtcpSsrcLength
+	for _, item := range c.Items {
+		size += 2 + len(item.Text)
+	}
+	// the END item and padding to 32-bit
+	size += 1
+	if remainder := size % 4; remainder > 0 {
+		size += 4 - remainder
+	}
+	return size
+}
+
+/*
+ * RFC 3550 SDES:
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |V=2|P|    SC   |  PT=SDES=202  |             length            |
+ * +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+ * |                          SSRC/CSRC_1                          |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                           SDES items                          |
+ * |                              ...                              |
+ * +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+ */
+type RtcpSourceDescription struct {
+	Chunks []RtcpSdesChunk
+}
+
+// NewRtcpSourceDescription returns a SDES with one CNAME chunk.
+func NewRtcpSourceDescription(ssrc uint32, cname string) *RtcpSourceDescription {
+	return &RtcpSourceDescription{
+		Chunks: []RtcpSdesChunk{{ssrc, []RtcpSdesItem{{RTCP_SDES_CNAME, cname}}}},
+	}
+}
+
+// CName returns the first CNAME, or empty.
+func (p *RtcpSourceDescription) CName() string {
+	for _, chunk := range p.Chunks {
+		for _, item := range chunk.Items {
+			if item.Type == RTCP_SDES_CNAME {
+				return item.Text
+			}
+		}
+	}
+	return ""
+}
+
+func (p *RtcpSourceDescription) Header() RtcpHeader {
+	return RtcpHeader{
+		Count:  uint8(len(p.Chunks)),
+		Type:   RTCP_SDES,
+		Length: rtcpLength(p.MarshalSize()),
+	}
+}
+
+func (p *RtcpSourceDescription) MarshalSize() int {
+	size := kRtcpHeaderLength
+	for i := range p.Chunks {
+		size += p.Chunks[i].marshalSize()
+	}
+	return size
+}
+
+func (p *RtcpSourceDescription) DestinationSsrc() []uint32 {
+	ssrcs := make([]uint32, len(p.Chunks))
+	for i, chunk := range p.Chunks {
+		ssrcs[i] = chunk.Source
+	}
+	return ssrcs
+}
+
+func (p *RtcpSourceDescription) Marshal() ([]byte, error) {
+	return MarshalRtcpPacket(p)
+}
+
+func (p *RtcpSourceDescription) MarshalTo(buf []byte) (int, error) {
+	if len(p.Chunks) > kRtcpMaxCount {
+		return 0, fmt.Errorf("RTCP SDES too many chunks: %d", len(p.Chunks))
+	}
+	for _, chunk := range p.Chunks {
+		for _, item := range chunk.Items {
+			if item.Type == RTCP_SDES_END || len(item.Text) > 0xFF {
+				return 0, fmt.Errorf("RTCP SDES invalid item: %d, len=%d", item.Type, len(item.Text))
+			}
+		}
+	}
+
+	n, err := rtcpMarshalHeader(p, buf)
+	if err != nil {
+		return 0, err
+	}
+	for i := range p.Chunks {
+		chunk := &p.Chunks[i]
+		end := n + chunk.marshalSize()
+		binary.BigEndian.PutUint32(buf[n:], chunk.Source)
+		n += kRtcpSsrcLength
+		for _, item := range chunk.Items {
+			buf[n] = uint8(item.Type)
+			buf[n+1] = uint8(len(item.Text))
+			n += 2
+			n += copy(buf[n:], item.Text)
+		}
+		// END item and padding
+		for ; n < end; n++ {
+			buf[n] = 0
+		}
+	}
+	return n, nil
+}
+
+func (p *RtcpSourceDescription) Unmarshal(rawPacket []byte) error {
+	var header RtcpHeader
+	payload, err := rtcpPayload(&header, rawPacket, RTCP_SDES)
+	if err != nil {
+		return err
+	}
+
+	p.Chunks = make([]RtcpSdesChunk, 0, header.Count)
+	for offset := 0; len(p.Chunks) < int(header.Count); {
+		if offset+kRtcpSsrcLength > len(payload) {
+			return fmt.Errorf("RTCP SDES chunk insufficient: %d", len(payload)-offset)
+		}
+		chunk := RtcpSdesChunk{Source: binary.BigEndian.Uint32(payload[offset:])}
+		offset += kRtcpSsrcLength
+		for {
+			if offset >= len(payload) {
+				return NewError("RTCP SDES chunk without END item")
+			}
+			itype := RtcpSdesType(payload[offset])
+			if itype == RTCP_SDES_END {
+				// skip END and padding to the next 32-bit boundary
+				offset += 4 - (offset % 4)
+				break
+			}
+			if offset+2 > len(payload) {
+				return NewError("RTCP SDES item header insufficient")
+			}
+			length := int(payload[offset+1])
+			offset += 2
+			if offset+length > len(payload) {
+				return fmt.Errorf("RTCP SDES item insufficient: %d < %d", len(payload)-offset, length)
+			}
+			chunk.Items = append(chunk.Items, RtcpSdesItem{itype, string(payload[offset : offset+length])})
+			offset += length
+		}
+		p.Chunks = append(p.Chunks, chunk)
+	}
+	return nil
+}
+
+/*
+ * RFC 3550 BYE:
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |V=2|P|    SC   |   PT=BYE=203  |             length            |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                           SSRC/CSRC                           |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * :                              ...                              :
+ * +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+ * |     length    |               reason for leaving            ... (opt)
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ */
+type RtcpGoodbye struct {
+	Sources []uint32
+	Reason  string
+}
+
+func (p *RtcpGoodbye) Header() RtcpHeader {
+	return RtcpHeader{
+		Count:  uint8(len(p.Sources)),
+		Type:   RTCP_BYE,
+		Length: rtcpLength(p.MarshalSize()),
+	}
+}
+
+func (p *RtcpGoodbye) MarshalSize() int {
+	size := kRtcpHeaderLength + len(p.Sources)*kRtcpSsrcLength
+	if len(p.Reason) > 0 {
+		size += 1 + len(p.Reason)
+		if remainder := size % 4; remainder > 0 {
+			size += 4 - remainder
+		}
+	}
+	return size
+}
+
+func (p *RtcpGoodbye) DestinationSsrc() []uint32 {
+	return p.Sources
+}
+
+func (p *RtcpGoodbye) Marshal() ([]byte, error) {
+	return MarshalRtcpPacket(p)
+}
+
+func (p *RtcpGoodbye) MarshalTo(buf []byte) (int, error) {
+	if len(p.Sources) > kRtcpMaxCount {
+		return 0, fmt.Errorf("RTCP BYE too many sources: %d", len(p.Sources))
+	}
+	if len(p.Reason) > 0xFF {
+		return 0, fmt.Errorf("RTCP BYE too long reason: %d", len(p.Reason))
+	}
+
+	n, err := rtcpMarshalHeader(p, buf)
+	if err != nil {
+		return 0, err
+	}
+	for _, ssrc := range p.Sources {
+		binary.BigEndian.PutUint32(buf[n:], ssrc)
+		n += kRtcpSsrcLength
+	}
+	if len(p.Reason) > 0 {
+		end := p.MarshalSize()
+		buf[n] = uint8(len(p.Reason))
+		n += 1
+		n += copy(buf[n:], p.Reason)
+		for ; n < end; n++ {
+			buf[n] = 0
+		}
+	}
+	return n, nil
+}
+
+func (p *RtcpGoodbye) Unmarshal(rawPacket []byte) error {
+	var header RtcpHeader
+	payload, err := rtcpPayload(&header, rawPacket, RTCP_BYE)
+	if err != nil {
+		return err
+	}
+	count := int(header.Count)
+	if len(payload) < count*kRtcpSsrcLength {
+		return fmt.Errorf("RTCP BYE size insufficient: %d < %d", len(payload), count*kRtcpSsrcLength)
+	}
+	p.Sources = make([]uint32, count)
+	for i := range p.Sources {
+		p.Sources[i] = binary.BigEndian.Uint32(payload[i*kRtcpSsrcLength:])
+	}
+	payload = payload[count*kRtcpSsrcLength:]
+	p.Reason = ""
+	if len(payload) > 0 {
+		length := int(payload[0])
+		if 1+length > len(payload) {
+			return fmt.Errorf("RTCP BYE reason insufficient: %d < %d", len(payload)-1, length)
+		}
+		p.Reason = string(payload[1 : 1+length])
+	}
+	return nil
+}
+
+/*
+ * RFC 3550 APP:
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |V=2|P| subtype |   PT=APP=204  |             length            |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                           SSRC/CSRC                           |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                          name (ASCII)                         |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                   application-dependent data                ...
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ */
+type RtcpApplicationDefined struct {
+	SubType uint8
+	SSRC    uint32
+	Name    string // 4 ASCII chars
+	Data    []byte // multiple of 4 bytes
+}
+
+func (p *RtcpApplicationDefined) Header() RtcpHeader {
+	return RtcpHeader{
+		Count:  p.SubType,
+		Type:   RTCP_APP,
+		Length: rtcpLength(p.MarshalSize()),
+	}
+}
+
+func (p *RtcpApplicationDefined) MarshalSize() int {
+	return kRtcpHeaderLength + kRtcpSsrcLength + 4 + len(p.Data)
+}
+
+func (p *RtcpApplicationDefined) DestinationSsrc() []uint32 {
+	return []uint32{p.SSRC}
+}
+
+func (p *RtcpApplicationDefined) Marshal() ([]byte, error) {
+	return MarshalRtcpPacket(p)
+}
+
+func (p *RtcpApplicationDefined) MarshalTo(buf []byte) (int, error) {
+	if len(p.Name) != 4 {
+		return 0, fmt.Errorf("RTCP APP invalid name: %s", p.Name)
+	}
+	n, err := rtcpMarshalHeader(p, buf)
+	if err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint32(buf[n:], p.SSRC)
+	n += kRtcpSsrcLength
+	n += copy(buf[n:], p.Name)
+	n += copy(buf[n:], p.Data)
+	return n, nil
+}
+
+func (p *RtcpApplicationDefined) Unmarshal(rawPacket []byte) error {
+	var header RtcpHeader
+	payload, err := rtcpPayload(&header, rawPacket, RTCP_APP)
+	if err != nil {
+		return err
+	}
+	if len(payload) < kRtcpSsrcLength+4 {
+		return fmt.Errorf("RTCP APP size insufficient: %d", len(payload))
+	}
+	p.SubType = header.Count
+	p.SSRC = binary.BigEndian.Uint32(payload[0:4])
+	p.Name = string(payload[4:8])
+	p.Data = payload[8:]
+	return nil
+}
+
+// RtcpRawPacket keeps one unknown RTCP packet as it is.
+type RtcpRawPacket struct {
+	Raw []byte
+}
+
+func (p *RtcpRawPacket) Header() RtcpHeader {
+	var header RtcpHeader
+	header.Unmarshal(p.Raw)
+	return header
+}
+
+func (p *RtcpRawPacket) MarshalSize() int {
+	return len(p.Raw)
+}
+
+func (p *RtcpRawPacket) DestinationSsrc() []uint32 {
+	return nil
+}
+
+func (p *RtcpRawPacket) Marshal() ([]byte, error) {
+	return MarshalRtcpPacket(p)
+}
+
+func (p *RtcpRawPacket) MarshalTo(buf []byte) (int, error) {
+	if len(p.Raw) > len(buf) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(buf, p.Raw), nil
+}
+
+func (p *RtcpRawPacket) Unmarshal(rawPacket []byte) error {
+	var header RtcpHeader
+	if err := header.Unmarshal(rawPacket); err != nil {
+		return err
+	}
+	p.Raw = rawPacket
+	return nil
+}
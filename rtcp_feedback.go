@@ -0,0 +1,759 @@
+package goutil
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+ * RFC 4585 common feedback packet:
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |V=2|P|   FMT   |       PT      |          length               |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                  SSRC of packet sender                        |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                  SSRC of media source                         |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * :            Feedback Control Information (FCI)                 :
+ * :                                                               :
+ */
+
+const (
+	kRtcpFeedbackHeaderLength = kRtcpHeaderLength + 2*kRtcpSsrcLength
+	kRtcpNackItemLength       = 4
+	kRtcpFirItemLength        = 8
+	kRtcpRembUniqueId         = "REMB"
+)
+
+// These are the FMT values of RTPFB(transport layer) and PSFB(payload-specific) feedback.
+const (
+	// RTPFB
+	RTCP_FMT_NACK         uint8 = 1
+	RTCP_FMT_TRANSPORT_CC uint8 = 15
+
+	// PSFB
+	RTCP_FMT_PLI uint8 = 1
+	RTCP_FMT_FIR uint8 = 4
+	RTCP_FMT_AFB uint8 = 15 // application layer feedback, e.g. REMB
+)
+
+// rtcpFeedbackPayload checks the header and returns (sender ssrc, media ssrc, FCI).
+func rtcpFeedbackPayload(rawPacket []byte, ptype RtcpPacketType, fmtType uint8) (uint32, uint32, []byte, error) {
+	var header RtcpHeader
+	payload, err := rtcpPayload(&header, rawPacket, ptype)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if header.Count != fmtType {
+		return 0, 0, nil, fmt.Errorf("RTCP %v wrong fmt: %d != %d", ptype, header.Count, fmtType)
+	}
+	if len(payload) < 2*kRtcpSsrcLength {
+		return 0, 0, nil, fmt.Errorf("RTCP %v size insufficient: %d", ptype, len(payload))
+	}
+	sender := binary.BigEndian.Uint32(payload[0:4])
+	media := binary.BigEndian.Uint32(payload[4:8])
+	return sender, media, payload[8:], nil
+}
+
+// rtcpMarshalFeedbackHeader writes the common header and ssrcs.
+func rtcpMarshalFeedbackHeader(p RtcpPacket, buf []byte, sender, media uint32) (int, error) {
+	n, err := rtcpMarshalHeader(p, buf)
+	if err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint32(buf[n:], sender)
+	binary.BigEndian.PutUint32(buf[n+4:], media)
+	return n + 2*kRtcpSsrcLength, nil
+}
+
+/*
+ * RFC 4585 Generic NACK FCI:
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |            PID                |             BLP               |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ */
+type RtcpNackPair struct {
+	PacketID    uint16 // PID
+	LostPackets uint16 // BLP, bit i means PID+i+1 lost
+}
+
+// PacketList returns all lost sequence numbers of this pair.
+func (n RtcpNackPair) PacketList() []uint16 {
+	seqs := []uint16{n.PacketID}
+	for i := uint16(0); i < 16; i++ {
+		if (n.LostPackets & (1 << i)) != 0 {
+			seqs = append(seqs, n.PacketID+i+1)
+		}
+	}
+	return seqs
+}
+
+// NewRtcpNackPairs packs the ascending lost sequence numbers into PID/BLP pairs.
+func NewRtcpNackPairs(seqs []uint16) []RtcpNackPair {
+	var pairs []RtcpNackPair
+	for _, seq := range seqs {
+		if len(pairs) > 0 {
+			last := &pairs[len(pairs)-1]
+			diff := uint16(seq - last.PacketID)
+			if diff == 0 {
+				continue
+			}
+			if diff <= 16 {
+				last.LostPackets |= 1 << (diff - 1)
+				continue
+			}
+		}
+		pairs = append(pairs, RtcpNackPair{PacketID: seq})
+	}
+	return pairs
+}
+
+// RtcpNack is the RTPFB Generic NACK(FMT=1).
+type RtcpNack struct {
+	SenderSSRC uint32
+	MediaSSRC  uint32
+	Nacks      []RtcpNackPair
+}
+
+// NewRtcpNack returns a Generic NACK of lost sequence numbers.
+func NewRtcpNack(sender, media uint32, seqs []uint16) *RtcpNack {
+	return &RtcpNack{sender, media, NewRtcpNackPairs(seqs)}
+}
+
+// PacketList returns all lost sequence numbers.
+func (p *RtcpNack) PacketList() []uint16 {
+	var seqs []uint16
+	for _, pair := range p.Nacks {
+		seqs = append(seqs, pair.PacketList()...)
+	}
+	return seqs
+}
+
+func (p *RtcpNack) Header() RtcpHeader {
+	return RtcpHeader{
+		Count:  RTCP_FMT_NACK,
+		Type:   RTCP_RTPFB,
+		Length: rtcpLength(p.MarshalSize()),
+	}
+}
+
+func (p *RtcpNack) MarshalSize() int {
+	return kRtcpFeedbackHeaderLength + len(p.Nacks)*kRtcpNackItemLength
+}
+
+func (p *RtcpNack) DestinationSsrc() []uint32 {
+	return []uint32{p.MediaSSRC}
+}
+
+func (p *RtcpNack) Marshal() ([]byte, error) {
+	return MarshalRtcpPacket(p)
+}
+
+func (p *RtcpNack) MarshalTo(buf []byte) (int, error) {
+	n, err := rtcpMarshalFeedbackHeader(p, buf, p.SenderSSRC, p.MediaSSRC)
+	if err != nil {
+		return 0, err
+	}
+	for _, pair := range p.Nacks {
+		binary.BigEndian.PutUint16(buf[n:], pair.PacketID)
+		binary.BigEndian.PutUint16(buf[n+2:], pair.LostPackets)
+		n += kRtcpNackItemLength
+	}
+	return n, nil
+}
+
+func (p *RtcpNack) Unmarshal(rawPacket []byte) error {
+	sender, media, fci, err := rtcpFeedbackPayload(rawPacket, RTCP_RTPFB, RTCP_FMT_NACK)
+	if err != nil {
+		return err
+	}
+	if len(fci)%kRtcpNackItemLength != 0 {
+		return fmt.Errorf("RTCP NACK invalid FCI length: %d", len(fci))
+	}
+	p.SenderSSRC = sender
+	p.MediaSSRC = media
+	p.Nacks = make([]RtcpNackPair, len(fci)/kRtcpNackItemLength)
+	for i := range p.Nacks {
+		offset := i * kRtcpNackItemLength
+		p.Nacks[i].PacketID = binary.BigEndian.Uint16(fci[offset:])
+		p.Nacks[i].LostPackets = binary.BigEndian.Uint16(fci[offset+2:])
+	}
+	return nil
+}
+
+// RtcpPli is the PSFB Picture Loss Indication(FMT=1), without FCI.
+type RtcpPli struct {
+	SenderSSRC uint32
+	MediaSSRC  uint32
+}
+
+func (p *RtcpPli) Header() RtcpHeader {
+	return RtcpHeader{
+		Count:  RTCP_FMT_PLI,
+		Type:   RTCP_PSFB,
+		Length: rtcpLength(p.MarshalSize()),
+	}
+}
+
+func (p *RtcpPli) MarshalSize() int {
+	return kRtcpFeedbackHeaderLength
+}
+
+func (p *RtcpPli) DestinationSsrc() []uint32 {
+	return []uint32{p.MediaSSRC}
+}
+
+func (p *RtcpPli) Marshal() ([]byte, error) {
+	return MarshalRtcpPacket(p)
+}
+
+func (p *RtcpPli) MarshalTo(buf []byte) (int, error) {
+	return rtcpMarshalFeedbackHeader(p, buf, p.SenderSSRC, p.MediaSSRC)
+}
+
+func (p *RtcpPli) Unmarshal(rawPacket []byte) error {
+	sender, media, _, err := rtcpFeedbackPayload(rawPacket, RTCP_PSFB, RTCP_FMT_PLI)
+	if err != nil {
+		return err
+	}
+	p.SenderSSRC = sender
+	p.MediaSSRC = media
+	return nil
+}
+
+/*
+ * RFC 5104 FIR FCI:
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                              SSRC                             |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * | Seq nr.       |    Reserved                                   |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ */
+type RtcpFirEntry struct {
+	SSRC           uint32
+	SequenceNumber uint8
+}
+
+// RtcpFir is the PSFB Full Intra Request(FMT=4), the media ssrc is always 0.
+type RtcpFir struct {
+	SenderSSRC uint32
+	MediaSSRC  uint32
+	Entries    []RtcpFirEntry
+}
+
+func (p *RtcpFir) Header() RtcpHeader {
+	return RtcpHeader{
+		Count:  RTCP_FMT_FIR,
+		Type:   RTCP_PSFB,
+		Length: rtcpLength(p.MarshalSize()),
+	}
+}
+
+func (p *RtcpFir) MarshalSize() int {
+	return kRtcpFeedbackHeaderLength + len(p.Entries)*kRtcpFirItemLength
+}
+
+func (p *RtcpFir) DestinationSsrc() []uint32 {
+	ssrcs := make([]uint32, len(p.Entries))
+	for i, entry := range p.Entries {
+		ssrcs[i] = entry.SSRC
+	}
+	return ssrcs
+}
+
+func (p *RtcpFir) Marshal() ([]byte, error) {
+	return MarshalRtcpPacket(p)
+}
+
+func (p *RtcpFir) MarshalTo(buf []byte) (int, error) {
+	n, err := rtcpMarshalFeedbackHeader(p, buf, p.SenderSSRC, p.MediaSSRC)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range p.Entries {
+		binary.BigEndian.PutUint32(buf[n:], entry.SSRC)
+		buf[n+4] = entry.SequenceNumber
+		buf[n+5], buf[n+6], buf[n+7] = 0, 0, 0
+		n += kRtcpFirItemLength
+	}
+	return n, nil
+}
+
+func (p *RtcpFir) Unmarshal(rawPacket []byte) error {
+	sender, media, fci, err := rtcpFeedbackPayload(rawPacket, RTCP_PSFB, RTCP_FMT_FIR)
+	if err != nil {
+		return err
+	}
+	if len(fci)%kRtcpFirItemLength != 0 {
+		return fmt.Errorf("RTCP FIR invalid FCI length: %d", len(fci))
+	}
+	p.SenderSSRC = sender
+	p.MediaSSRC = media
+	p.Entries = make([]RtcpFirEntry, len(fci)/kRtcpFirItemLength)
+	for i := range p.Entries {
+		offset := i * kRtcpFirItemLength
+		p.Entries[i].SSRC = binary.BigEndian.Uint32(fci[offset:])
+		p.Entries[i].SequenceNumber = fci[offset+4]
+	}
+	return nil
+}
+
+/*
+ * draft-alvestrand-rmcat-remb-03 REMB FCI:
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |  Unique identifier 'R' 'E' 'M' 'B'                            |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |  Num SSRC     | BR Exp    |  BR Mantissa                      |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |   SSRC feedback                                               |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |  ...                                                          |
+ */
+type RtcpRemb struct {
+	SenderSSRC uint32
+	MediaSSRC  uint32
+	Bitrate    uint64 // bps
+	SSRCs      []uint32
+}
+
+// IsRtcpRembPacket returns whether one PSFB/AFB packet is REMB.
+func IsRtcpRembPacket(rawPacket []byte) bool {
+	offset := kRtcpFeedbackHeaderLength
+	if len(rawPacket) < offset+4 {
+		return false
+	}
+	return string(rawPacket[offset:offset+4]) == kRtcpRembUniqueId
+}
+
+func (p *RtcpRemb) Header() RtcpHeader {
+	return RtcpHeader{
+		Count:  RTCP_FMT_AFB,
+		Type:   RTCP_PSFB,
+		Length: rtcpLength(p.MarshalSize()),
+	}
+}
+
+func (p *RtcpRemb) MarshalSize() int {
+	return kRtcpFeedbackHeaderLength + 8 + len(p.SSRCs)*kRtcpSsrcLength
+}
+
+func (p *RtcpRemb) DestinationSsrc() []uint32 {
+	return p.SSRCs
+}
+
+func (p *RtcpRemb) Marshal() ([]byte, error) {
+	return MarshalRtcpPacket(p)
+}
+
+func (p *RtcpRemb) MarshalTo(buf []byte) (int, error) {
+	if len(p.SSRCs) > 0xFF {
+		return 0, fmt.Errorf("RTCP REMB too many ssrcs: %d", len(p.SSRCs))
+	}
+	n, err := rtcpMarshalFeedbackHeader(p, buf, p.SenderSSRC, p.MediaSSRC)
+	if err != nil {
+		return 0, err
+	}
+
+	// 6-bit exponent and 18-bit mantissa
+	exp := uint32(0)
+	mantissa := p.Bitrate
+	for mantissa > 0x3FFFF {
+		mantissa >>= 1
+		exp += 1
+	}
+
+	n += copy(buf[n:], kRtcpRembUniqueId)
+	binary.BigEndian.PutUint32(buf[n:], uint32(len(p.SSRCs))<<24|exp<<18|uint32(mantissa))
+	n += 4
+	for _, ssrc := range p.SSRCs {
+		binary.BigEndian.PutUint32(buf[n:], ssrc)
+		n += kRtcpSsrcLength
+	}
+	return n, nil
+}
+
+func (p *RtcpRemb) Unmarshal(rawPacket []byte) error {
+	sender, media, fci, err := rtcpFeedbackPayload(rawPacket, RTCP_PSFB, RTCP_FMT_AFB)
+	if err != nil {
+		return err
+	}
+	if len(fci) < 8 || string(fci[0:4]) != kRtcpRembUniqueId {
+		return NewError("RTCP REMB invalid unique identifier")
+	}
+
+	value := binary.BigEndian.Uint32(fci[4:8])
+	num := int(value >> 24)
+	exp := (value >> 18) & 0x3F
+	mantissa := uint64(value & 0x3FFFF)
+	if len(fci) < 8+num*kRtcpSsrcLength {
+		return fmt.Errorf("RTCP REMB ssrcs insufficient: %d < %d", len(fci)-8, num*kRtcpSsrcLength)
+	}
+
+	p.SenderSSRC = sender
+	p.MediaSSRC = media
+	if mantissa != 0 && exp > 46 {
+		// overflow of 64-bit
+		p.Bitrate = ^uint64(0)
+	} else {
+		p.Bitrate = mantissa << exp
+	}
+	p.SSRCs = make([]uint32, num)
+	for i := range p.SSRCs {
+		p.SSRCs[i] = binary.BigEndian.Uint32(fci[8+i*kRtcpSsrcLength:])
+	}
+	return nil
+}
+
+// RtcpTwccStatus is the packet status symbol of transport-wide cc feedback.
+type RtcpTwccStatus uint8
+
+// These are the packet status symbols of draft-holmer-rmcat-transport-wide-cc-extensions-01.
+const (
+	RTCP_TWCC_NOT_RECEIVED RtcpTwccStatus = 0
+	RTCP_TWCC_SMALL_DELTA  RtcpTwccStatus = 1
+	RTCP_TWCC_LARGE_DELTA  RtcpTwccStatus = 2
+)
+
+const (
+	kTwccDeltaScaleUs         = 250
+	kTwccReferenceTimeScaleUs = 64000
+	kTwccMaxRunLength         = 0x1FFF
+	kTwccOneBitVectorSize     = 14
+	kTwccTwoBitVectorSize     = 7
+	kTwccMaxStatusCount       = 0xFFFF
+)
+
+// RtcpTwccPacket is the receive result of one transport sequence number.
+type RtcpTwccPacket struct {
+	SequenceNumber uint16
+	Received       bool
+	ArrivalUs      int64 // arrival time in microseconds(base of reference time)
+}
+
+/*
+ * draft-holmer-rmcat-transport-wide-cc-extensions-01:
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |V=2|P|  FMT=15 |    PT=205     |           length              |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                     SSRC of packet sender                     |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                      SSRC of media source                     |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |      base sequence number     |      packet status count      |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                 reference time                | fb pkt. count |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |          packet chunk         |         packet chunk          |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * .                                                               .
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |         packet chunk          |  recv delta   |  recv delta   |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * .                                                               .
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * run length chunk:   |T=0| S |       Run Length(13bits)       |
+ * status vector chunk:|T=1|S=0| symbol list(14 x 1bit)         |
+ *                     |T=1|S=1| symbol list(7 x 2bits)         |
+ */
+type RtcpTransportCC struct {
+	SenderSSRC         uint32
+	MediaSSRC          uint32
+	BaseSequenceNumber uint16
+	ReferenceTime      int32 // signed 24-bit, in multiples of 64ms
+	FbPktCount         uint8
+	PacketStatuses     []RtcpTwccStatus // one per packet from BaseSequenceNumber
+	RecvDeltas         []int32          // one per received packet, in multiples of 250us
+
+	lastArrivalUs int64
+}
+
+// NewRtcpTransportCC returns an empty feedback for AddReceivedPacket.
+func NewRtcpTransportCC(sender, media uint32, fbPktCount uint8) *RtcpTransportCC {
+	return &RtcpTransportCC{SenderSSRC: sender, MediaSSRC: media, FbPktCount: fbPktCount}
+}
+
+// AddReceivedPacket records one received packet in ascending transport sequence order.
+// It returns false if the packet can't be added(old sequence, too large delta or too
+// many packets), then a new feedback should be started.
+func (p *RtcpTransportCC) AddReceivedPacket(seq uint16, arrivalUs int64) bool {
+	if len(p.PacketStatuses) == 0 {
+		p.BaseSequenceNumber = seq
+		p.ReferenceTime = int32((arrivalUs / kTwccReferenceTimeScaleUs) & 0xFFFFFF)
+		if p.ReferenceTime&0x800000 != 0 {
+			p.ReferenceTime -= 0x1000000
+		}
+		p.lastArrivalUs = (arrivalUs / kTwccReferenceTimeScaleUs) * kTwccReferenceTimeScaleUs
+	} else {
+		next := p.BaseSequenceNumber + uint16(len(p.PacketStatuses))
+		if !IsNewerRtpSeq(seq, next-1) {
+			return false
+		}
+	}
+
+	gap := int(uint16(seq - p.BaseSequenceNumber - uint16(len(p.PacketStatuses))))
+	if len(p.PacketStatuses)+gap+1 > kTwccMaxStatusCount {
+		return false
+	}
+
+	deltaUs := arrivalUs - p.lastArrivalUs
+	var delta int64
+	if deltaUs >= 0 {
+		delta = (deltaUs + kTwccDeltaScaleUs/2) / kTwccDeltaScaleUs
+	} else {
+		delta = (deltaUs - kTwccDeltaScaleUs/2) / kTwccDeltaScaleUs
+	}
+	if delta < -0x8000 || delta > 0x7FFF {
+		return false
+	}
+
+	for i := 0; i < gap; i++ {
+		p.PacketStatuses = append(p.PacketStatuses, RTCP_TWCC_NOT_RECEIVED)
+	}
+	if delta >= 0 && delta <= 0xFF {
+		p.PacketStatuses = append(p.PacketStatuses, RTCP_TWCC_SMALL_DELTA)
+	} else {
+		p.PacketStatuses = append(p.PacketStatuses, RTCP_TWCC_LARGE_DELTA)
+	}
+	p.RecvDeltas = append(p.RecvDeltas, int32(delta))
+	p.lastArrivalUs += delta * kTwccDeltaScaleUs
+	return true
+}
+
+// Packets returns the receive results of all packets.
+func (p *RtcpTransportCC) Packets() []RtcpTwccPacket {
+	packets := make([]RtcpTwccPacket, len(p.PacketStatuses))
+	arrivalUs := int64(p.ReferenceTime) * kTwccReferenceTimeScaleUs
+	idx := 0
+	for i, status := range p.PacketStatuses {
+		packets[i].SequenceNumber = p.BaseSequenceNumber + uint16(i)
+		if status != RTCP_TWCC_NOT_RECEIVED && idx < len(p.RecvDeltas) {
+			arrivalUs += int64(p.RecvDeltas[idx]) * kTwccDeltaScaleUs
+			idx += 1
+			packets[i].Received = true
+			packets[i].ArrivalUs = arrivalUs
+		}
+	}
+	return packets
+}
+
+// encodeChunks packs PacketStatuses into run length and status vector chunks.
+func (p *RtcpTransportCC) encodeChunks() []uint16 {
+	var chunks []uint16
+	statuses := p.PacketStatuses
+	for i := 0; i < len(statuses); {
+		run := 1
+		for i+run < len(statuses) && statuses[i+run] == statuses[i] && run < kTwccMaxRunLength {
+			run += 1
+		}
+		if run >= kTwccOneBitVectorSize {
+			chunks = append(chunks, uint16(statuses[i])<<13|uint16(run))
+			i += run
+			continue
+		}
+
+		oneBit := true
+		end := Min(i+kTwccOneBitVectorSize, len(statuses))
+		for k := i; k < end; k++ {
+			if statuses[k] > RTCP_TWCC_SMALL_DELTA {
+				oneBit = false
+				break
+			}
+		}
+		if oneBit {
+			chunk := uint16(0x8000)
+			for k := i; k < end; k++ {
+				chunk |= uint16(statuses[k]) << uint(kTwccOneBitVectorSize-1-(k-i))
+			}
+			chunks = append(chunks, chunk)
+			i = end
+			continue
+		}
+
+		if run >= kTwccTwoBitVectorSize {
+			chunks = append(chunks, uint16(statuses[i])<<13|uint16(run))
+			i += run
+			continue
+		}
+
+		chunk := uint16(0xC000)
+		end = Min(i+kTwccTwoBitVectorSize, len(statuses))
+		for k := i; k < end; k++ {
+			chunk |= uint16(statuses[k]) << uint(2*(kTwccTwoBitVectorSize-1-(k-i)))
+		}
+		chunks = append(chunks, chunk)
+		i = end
+	}
+	return chunks
+}
+
+func (p *RtcpTransportCC) deltasSize() int {
+	size := 0
+	for _, status := range p.PacketStatuses {
+		if status == RTCP_TWCC_SMALL_DELTA {
+			size += 1
+		} else if status == RTCP_TWCC_LARGE_DELTA {
+			size += 2
+		}
+	}
+	return size
+}
+
+func (p *RtcpTransportCC) unpaddedSize() int {
+	return kRtcpFeedbackHeaderLength + 8 + len(p.encodeChunks())*2 + p.deltasSize()
+}
+
+// Header returns the header without P bit, since the chunks and deltas are
+// zero padded to 32-bit(as libwebrtc) to be valid in the compound packet.
+func (p *RtcpTransportCC) Header() RtcpHeader {
+	return RtcpHeader{
+		Count:  RTCP_FMT_TRANSPORT_CC,
+		Type:   RTCP_RTPFB,
+		Length: rtcpLength(p.MarshalSize()),
+	}
+}
+
+func (p *RtcpTransportCC) MarshalSize() int {
+	size := p.unpaddedSize()
+	if remainder := size % 4; remainder > 0 {
+		size += 4 - remainder
+	}
+	return size
+}
+
+func (p *RtcpTransportCC) DestinationSsrc() []uint32 {
+	return []uint32{p.MediaSSRC}
+}
+
+func (p *RtcpTransportCC) Marshal() ([]byte, error) {
+	return MarshalRtcpPacket(p)
+}
+
+func (p *RtcpTransportCC) MarshalTo(buf []byte) (int, error) {
+	if len(p.PacketStatuses) > kTwccMaxStatusCount {
+		return 0, fmt.Errorf("RTCP TWCC too many packets: %d", len(p.PacketStatuses))
+	}
+	n, err := rtcpMarshalFeedbackHeader(p, buf, p.SenderSSRC, p.MediaSSRC)
+	if err != nil {
+		return 0, err
+	}
+
+	binary.BigEndian.PutUint16(buf[n:], p.BaseSequenceNumber)
+	binary.BigEndian.PutUint16(buf[n+2:], uint16(len(p.PacketStatuses)))
+	reference := uint32(p.ReferenceTime) & 0xFFFFFF
+	binary.BigEndian.PutUint32(buf[n+4:], reference<<8|uint32(p.FbPktCount))
+	n += 8
+
+	for _, chunk := range p.encodeChunks() {
+		binary.BigEndian.PutUint16(buf[n:], chunk)
+		n += 2
+	}
+
+	idx := 0
+	for _, status := range p.PacketStatuses {
+		if status == RTCP_TWCC_NOT_RECEIVED {
+			continue
+		}
+		if idx >= len(p.RecvDeltas) {
+			return 0, NewError("RTCP TWCC recv deltas insufficient")
+		}
+		delta := p.RecvDeltas[idx]
+		idx += 1
+		if status == RTCP_TWCC_SMALL_DELTA {
+			if delta < 0 || delta > 0xFF {
+				return 0, fmt.Errorf("RTCP TWCC invalid small delta: %d", delta)
+			}
+			buf[n] = uint8(delta)
+			n += 1
+		} else {
+			if delta < -0x8000 || delta > 0x7FFF {
+				return 0, fmt.Errorf("RTCP TWCC invalid large delta: %d", delta)
+			}
+			binary.BigEndian.PutUint16(buf[n:], uint16(int16(delta)))
+			n += 2
+		}
+	}
+
+	// zero padding to 32-bit
+	for size := p.MarshalSize(); n < size; n++ {
+		buf[n] = 0
+	}
+	return n, nil
+}
+
+func (p *RtcpTransportCC) Unmarshal(rawPacket []byte) error {
+	sender, media, fci, err := rtcpFeedbackPayload(rawPacket, RTCP_RTPFB, RTCP_FMT_TRANSPORT_CC)
+	if err != nil {
+		return err
+	}
+	if len(fci) < 8 {
+		return fmt.Errorf("RTCP TWCC size insufficient: %d", len(fci))
+	}
+
+	p.SenderSSRC = sender
+	p.MediaSSRC = media
+	p.BaseSequenceNumber = binary.BigEndian.Uint16(fci[0:2])
+	count := int(binary.BigEndian.Uint16(fci[2:4]))
+	value := binary.BigEndian.Uint32(fci[4:8])
+	p.ReferenceTime = int32(value >> 8)
+	if p.ReferenceTime&0x800000 != 0 {
+		p.ReferenceTime -= 0x1000000
+	}
+	p.FbPktCount = uint8(value)
+
+	offset := 8
+	p.PacketStatuses = make([]RtcpTwccStatus, 0, count)
+	for len(p.PacketStatuses) < count {
+		if offset+2 > len(fci) {
+			return NewError("RTCP TWCC packet chunks insufficient")
+		}
+		chunk := binary.BigEndian.Uint16(fci[offset:])
+		offset += 2
+
+		remain := count - len(p.PacketStatuses)
+		if chunk&0x8000 == 0 {
+			// run length chunk
+			status := RtcpTwccStatus((chunk >> 13) & 0x3)
+			run := Min(int(chunk&kTwccMaxRunLength), remain)
+			for i := 0; i < run; i++ {
+				p.PacketStatuses = append(p.PacketStatuses, status)
+			}
+		} else if chunk&0x4000 == 0 {
+			// status vector chunk with 1-bit symbols
+			for i := 0; i < kTwccOneBitVectorSize && i < remain; i++ {
+				status := RtcpTwccStatus((chunk >> uint(kTwccOneBitVectorSize-1-i)) & 0x1)
+				p.PacketStatuses = append(p.PacketStatuses, status)
+			}
+		} else {
+			// status vector chunk with 2-bit symbols
+			for i := 0; i < kTwccTwoBitVectorSize && i < remain; i++ {
+				status := RtcpTwccStatus((chunk >> uint(2*(kTwccTwoBitVectorSize-1-i))) & 0x3)
+				p.PacketStatuses = append(p.PacketStatuses, status)
+			}
+		}
+	}
+
+	p.RecvDeltas = nil
+	for _, status := range p.PacketStatuses {
+		switch status {
+		case RTCP_TWCC_SMALL_DELTA:
+			if offset+1 > len(fci) {
+				return NewError("RTCP TWCC recv deltas insufficient")
+			}
+			p.RecvDeltas = append(p.RecvDeltas, int32(fci[offset]))
+			offset += 1
+		case RTCP_TWCC_LARGE_DELTA:
+			if offset+2 > len(fci) {
+				return NewError("RTCP TWCC recv deltas insufficient")
+			}
+			p.RecvDeltas = append(p.RecvDeltas, int32(int16(binary.BigEndian.Uint16(fci[offset:]))))
+			offset += 2
+		case RTCP_TWCC_NOT_RECEIVED:
+		default:
+			return fmt.Errorf("RTCP TWCC invalid status symbol: %d", status)
+		}
+	}
+	return nil
+}
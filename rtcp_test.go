@@ -0,0 +1,208 @@
+package goutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRtcp_1(t *testing.T) {
+	sr := &RtcpSenderReport{
+		SSRC:        0x902f9e2e,
+		RtpTime:     0x12345678,
+		PacketCount: 100,
+		OctetCount:  12000,
+		Reports: []RtcpReportBlock{{
+			SSRC:             0xbc5e9a40,
+			FractionLost:     10,
+			TotalLost:        -3,
+			LastSequence:     0x10046,
+			Jitter:           273,
+			LastSenderReport: 0x9f36432,
+			Delay:            150137,
+		}},
+	}
+	sr.NtpTime.Set(0xda8bd1fc, 0xdddda05a)
+	sdes := NewRtcpSourceDescription(0x902f9e2e, "{9c00eb92-1afb-9d49-a47d-91f64eee69f5}")
+	bye := &RtcpGoodbye{Sources: []uint32{0x902f9e2e}, Reason: "bye"}
+	app := &RtcpApplicationDefined{SubType: 1, SSRC: 0x902f9e2e, Name: "NAME", Data: []byte{1, 2, 3, 4}}
+
+	compound := RtcpCompoundPacket{sr, sdes, bye, app}
+	data, err := compound.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out RtcpCompoundPacket
+	if err := out.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, compound) {
+		t.Fatalf("compound mismatch:\n%v\n%v", out, compound)
+	}
+	if out.CName() != sdes.CName() {
+		t.Fatalf("invalid cname: %s", out.CName())
+	}
+}
+
+func TestRtcp_2(t *testing.T) {
+	rr := &RtcpReceiverReport{SSRC: 1}
+	bye := &RtcpGoodbye{Sources: []uint32{1}}
+
+	// RR must be followed by SDES in a compound packet
+	if _, err := (RtcpCompoundPacket{rr, bye}).Marshal(); err == nil {
+		t.Fatal("expect error without SDES")
+	}
+	if _, err := (RtcpCompoundPacket{bye}).Marshal(); err == nil {
+		t.Fatal("expect error without SR/RR")
+	}
+
+	// reduced-size packets are allowed by UnmarshalRtcpPackets
+	data, err := MarshalRtcpPackets([]RtcpPacket{bye, rr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets, err := UnmarshalRtcpPackets(data)
+	if err != nil || len(packets) != 2 {
+		t.Fatal("invalid packets:", packets, err)
+	}
+
+	// padding bit is only allowed in the last packet
+	data[0] |= 1 << kRtcpPaddingShift
+	if _, err := UnmarshalRtcpPackets(data); err == nil {
+		t.Fatal("expect error with padding in the first packet")
+	}
+
+	// the unknown type is kept raw
+	raw := []byte{0x80, 210, 0x00, 0x01, 0x01, 0x02, 0x03, 0x04}
+	packets, err = UnmarshalRtcpPackets(raw)
+	if err != nil || len(packets) != 1 {
+		t.Fatal("invalid raw packet:", err)
+	}
+	if _, ok := packets[0].(*RtcpRawPacket); !ok {
+		t.Fatalf("invalid raw packet type: %T", packets[0])
+	}
+}
+
+func TestRtcp_3(t *testing.T) {
+	var ntp NtpTime
+	ntp.Set(0x10000, 0x80000000)
+
+	var block RtcpReportBlock
+	block.SetLastSenderReport(&ntp, 1000)
+	if block.LastSenderReport != 0x00008000 || block.Delay != 1<<16 {
+		t.Fatalf("invalid lsr/dlsr: %x, %d", block.LastSenderReport, block.Delay)
+	}
+
+	// received 1.5s later than SR: rtt = 1.5s - 1s
+	var now NtpTime
+	now.Set(0x10002, 0)
+	if rtt := block.RttMs(&now); rtt != 500 {
+		t.Fatalf("invalid rtt: %d", rtt)
+	}
+}
+
+func TestRtcpFeedback_1(t *testing.T) {
+	seqs := []uint16{65534, 65535, 0, 5, 17, 18, 40}
+	nack := NewRtcpNack(1, 2, seqs)
+	if len(nack.Nacks) != 3 {
+		t.Fatalf("invalid nack pairs: %+v", nack.Nacks)
+	}
+	if !reflect.DeepEqual(nack.PacketList(), seqs) {
+		t.Fatalf("invalid nack list: %v", nack.PacketList())
+	}
+
+	remb := &RtcpRemb{SenderSSRC: 1, Bitrate: 12345678, SSRCs: []uint32{3, 4}}
+	fir := &RtcpFir{SenderSSRC: 1, Entries: []RtcpFirEntry{{3, 7}}}
+	pli := &RtcpPli{SenderSSRC: 1, MediaSSRC: 3}
+	data, err := MarshalRtcpPackets([]RtcpPacket{nack, remb, fir, pli})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets, err := UnmarshalRtcpPackets(data)
+	if err != nil || len(packets) != 4 {
+		t.Fatal("invalid packets:", err)
+	}
+	if !reflect.DeepEqual(packets[0], nack) || !reflect.DeepEqual(packets[2], fir) ||
+		!reflect.DeepEqual(packets[3], pli) {
+		t.Fatalf("feedback mismatch: %+v", packets)
+	}
+	// 18-bit mantissa loses the low bits
+	out := packets[1].(*RtcpRemb)
+	if out.Bitrate > remb.Bitrate || remb.Bitrate-out.Bitrate >= 1<<6 || !reflect.DeepEqual(out.SSRCs, remb.SSRCs) {
+		t.Fatalf("invalid remb: %+v", out)
+	}
+}
+
+func TestRtcpFeedback_2(t *testing.T) {
+	twcc := NewRtcpTransportCC(1, 2, 9)
+	arrivals := map[uint16]int64{
+		65530: 1000000,
+		65531: 1000250,
+		65533: 1010000, // large delta
+		0:     1009000, // negative delta
+		1:     1009500,
+		30:    1020000,
+	}
+	order := []uint16{65530, 65531, 65533, 0, 1, 30}
+	for _, seq := range order {
+		if !twcc.AddReceivedPacket(seq, arrivals[seq]) {
+			t.Fatal("fail to add seq:", seq)
+		}
+	}
+	if twcc.AddReceivedPacket(65533, 1030000) {
+		t.Fatal("expect failure for old seq")
+	}
+
+	data, err := twcc.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets, err := UnmarshalRtcpPackets(data)
+	if err != nil || len(packets) != 1 {
+		t.Fatal("invalid twcc:", err)
+	}
+	out := packets[0].(*RtcpTransportCC)
+	if out.BaseSequenceNumber != 65530 || len(out.PacketStatuses) != 37 || out.FbPktCount != 9 {
+		t.Fatalf("invalid twcc: %+v", out)
+	}
+
+	received := 0
+	for _, pkt := range out.Packets() {
+		if arrival, ok := arrivals[pkt.SequenceNumber]; ok {
+			if !pkt.Received || pkt.ArrivalUs != arrival {
+				t.Fatalf("invalid packet: %+v", pkt)
+			}
+			received += 1
+		} else if pkt.Received {
+			t.Fatalf("unexpected packet: %+v", pkt)
+		}
+	}
+	if received != len(arrivals) {
+		t.Fatal("invalid received:", received)
+	}
+}
+
+func TestRtcpFeedback_3(t *testing.T) {
+	// the unaligned chunks and deltas(16+8+2+1 bytes) in compound packet
+	twcc := NewRtcpTransportCC(1, 2, 3)
+	twcc.AddReceivedPacket(100, 1000000)
+	if twcc.MarshalSize()%4 != 0 || twcc.unpaddedSize()%4 == 0 || twcc.Header().Padding {
+		t.Fatalf("invalid twcc size: %d", twcc.unpaddedSize())
+	}
+	pli := &RtcpPli{SenderSSRC: 1, MediaSSRC: 2}
+	data, err := MarshalRtcpPackets([]RtcpPacket{twcc, pli})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets, err := UnmarshalRtcpPackets(data)
+	if err != nil || len(packets) != 2 {
+		t.Fatal("invalid compound:", err)
+	}
+	out, ok := packets[0].(*RtcpTransportCC)
+	if !ok || out.BaseSequenceNumber != 100 || len(out.RecvDeltas) != 1 || out.FbPktCount != 3 {
+		t.Fatalf("invalid twcc: %+v", packets[0])
+	}
+	if out, ok := packets[1].(*RtcpPli); !ok || out.MediaSSRC != 2 {
+		t.Fatalf("invalid pli: %+v", packets[1])
+	}
+}
@@ -1,19 +1,9 @@
 package goutil
 
-/*
-#cgo CFLAGS: -Wno-deprecated -std=c99
-
-#include "src/h264_parser.c"
-
-typedef uint8_t* uint8ptr_t;
-*/
-import "C"
-
 import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"unsafe"
 )
 
 /*
@@ -70,6 +60,13 @@ type RtpHeader struct {
 	ExtensionProfile uint16
 	ExtensionPayload []byte
 
+	// The RFC 8285 elements of ExtensionPayload(one-byte or two-byte profile).
+	ExtensionElements []RtpExtensionElement
+	// Optional id<->type mapping(a=extmap) between ExtensionElements and RtpExtension.
+	ExtensionMap *RtpExtensionMap
+	// The ExtensionMap which RtpExtension is decoded with.
+	decodedMap *RtpExtensionMap
+
 	RtpExtension     RtpExtension
 	HeaderLength     uint32
 	PaddingLength    uint32
@@ -102,10 +99,17 @@ type RtpExtension struct {
 	// For identifying the media section used to interpret this RTP packet. See
 	// https://tools.ietf.org/html/draft-ietf-mmusic-sdp-bundle-negotiation-38
 	Mid string
+
+	// The raw AV1 Dependency Descriptor(string to keep this struct comparable),
+	// which is parsed by RtpDependencyDescriptorReader with previous structure.
+	DependencyDescriptor string
 }
 
 // Marshal serializes the header into bytes.
 func (h *RtpHeader) Marshal() (buf []byte, err error) {
+	if err := h.UpdateExtensionPayload(); err != nil {
+		return nil, err
+	}
 	buf = make([]byte, h.MarshalSize())
 	if n, err := h.MarshalTo(buf); err != nil {
 		return nil, err
@@ -116,7 +120,8 @@ func (h *RtpHeader) Marshal() (buf []byte, err error) {
 
 // MarshalSize returns the size of the header once marshaled.
 func (h *RtpHeader) MarshalSize() int {
-	// NOTE: Be careful to match the MarshalTo() method.
+	// NOTE: Be careful to match the MarshalTo() method, and RtpExtension is
+	// applied by UpdateExtensionPayload.
 	size := kRtpHeaderLength + (len(h.CSRC) * 4)
 	if h.Extension {
 		size += 4 + len(h.ExtensionPayload)
@@ -173,6 +178,15 @@ func (h *RtpHeader) Unmarshal(rawPacket []byte) error {
 
 		h.ExtensionPayload = rawPacket[currOffset : currOffset+extensionLength]
 		currOffset += len(h.ExtensionPayload)
+
+		if err := h.parseExtensionPayload(); err != nil {
+			return err
+		}
+	} else {
+		h.ExtensionProfile = 0
+		h.ExtensionPayload = nil
+		h.ExtensionElements = nil
+		h.RtpExtension = RtpExtension{}
 	}
 	h.PayloadOffset = currOffset
 	h.HeaderLength = uint32(currOffset)
@@ -181,6 +195,9 @@ func (h *RtpHeader) Unmarshal(rawPacket []byte) error {
 }
 
 func (h *RtpHeader) MarshalTo(buf []byte) (n int, err error) {
+	if err := h.UpdateExtensionPayload(); err != nil {
+		return 0, err
+	}
 	size := h.MarshalSize()
 	if size > len(buf) {
 		return 0, io.ErrShortBuffer
@@ -264,6 +281,9 @@ func (p *RtpPacket) Unmarshal(rawPacket []byte) error {
 
 // Marshal serializes the packet into bytes.
 func (p *RtpPacket) Marshal() (buf []byte, err error) {
+	if err := p.UpdateExtensionPayload(); err != nil {
+		return nil, err
+	}
 	buf = make([]byte, p.MarshalSize())
 	if n, err := p.MarshalTo(buf); err != nil {
 		return nil, err
@@ -294,22 +314,40 @@ func (p *RtpPacket) MarshalSize() int {
 	return p.RtpHeader.MarshalSize() + len(p.Payload)
 }
 
+// ParseVideo parses the H.264 payload(single NAL or STAP-A/B) for the SPS info.
 func (p *RtpPacket) ParseVideo() *RtpVideo {
-	nal_data := C.uint8ptr_t(unsafe.Pointer(&p.Raw[0]))
-	nal_size := C.size_t(len(p.Raw))
-	ntype := C.uint8_t(0)
-	width := C.int(0)
-	height := C.int(0)
-	spsid := C.uint32_t(0)
-	C.parse_rtp_video(nal_data, nal_size, &ntype, &width, &height, &spsid)
-	return &RtpVideo{uint8(ntype), int(width), int(height), uint32(spsid)}
+	video := &RtpVideo{}
+	payload := rtpPayloadWithoutPadding(p)
+	if len(payload) == 0 {
+		return video
+	}
+	video.NalType = GetH264NalType(payload)
+
+	nalus, err := ParseH264Payload(payload)
+	if err != nil {
+		return video
+	}
+	for _, nalu := range nalus {
+		if GetH264NalType(nalu) != H264_NAL_SPS {
+			continue
+		}
+		if sps, err := ParseH264Sps(nalu); err == nil {
+			video.Width = sps.Width
+			video.Height = sps.Height
+			video.SpsId = sps.SpsId
+			video.Sps = sps
+		}
+	}
+	return video
 }
 
+// RtpVideo is the H.264 info of one RTP packet, Sps is nil if no SPS found.
 type RtpVideo struct {
-	nalType uint8
-	width   int
-	height  int
-	spsId   uint32
+	NalType uint8 // the NAL type of RTP payload, e.g. STAP-A
+	Width   int
+	Height  int
+	SpsId   uint32
+	Sps     *H264Sps
 }
 
 /// Some Common RTP Tools
@@ -478,3 +516,62 @@ func IsRtpSeqInRange(seqn, start, size uint16) bool {
 	var e int = s + int(size)
 	return (s <= n && n < e) || (s <= nh && nh < e)
 }
+
+// SeqUnwrapper unwraps the 16-bit RTP sequence numbers into int64, e.g.
+// 65534, 65535, 0, 1 => 65534, 65535, 65536, 65537. Each value is unwrapped
+// relative to the last one, so reordering within half range(0x8000) is kept,
+// and the older values across the wrap boundary are restored too.
+type SeqUnwrapper struct {
+	lastValue int64
+	hasLast   bool
+}
+
+// Unwrap returns the unwrapped value of seq and records it as the last.
+func (u *SeqUnwrapper) Unwrap(seq uint16) int64 {
+	u.lastValue = u.PeekUnwrap(seq)
+	u.hasLast = true
+	return u.lastValue
+}
+
+// PeekUnwrap returns the unwrapped value of seq without updating the state.
+func (u *SeqUnwrapper) PeekUnwrap(seq uint16) int64 {
+	if !u.hasLast {
+		return int64(seq)
+	}
+	diff := int16(seq - uint16(u.lastValue))
+	return u.lastValue + int64(diff)
+}
+
+// Reset forgets the last value.
+func (u *SeqUnwrapper) Reset() {
+	u.lastValue = 0
+	u.hasLast = false
+}
+
+// TimestampUnwrapper unwraps the 32-bit RTP timestamps into int64 like SeqUnwrapper.
+type TimestampUnwrapper struct {
+	lastValue int64
+	hasLast   bool
+}
+
+// Unwrap returns the unwrapped value of ts and records it as the last.
+func (u *TimestampUnwrapper) Unwrap(ts uint32) int64 {
+	u.lastValue = u.PeekUnwrap(ts)
+	u.hasLast = true
+	return u.lastValue
+}
+
+// PeekUnwrap returns the unwrapped value of ts without updating the state.
+func (u *TimestampUnwrapper) PeekUnwrap(ts uint32) int64 {
+	if !u.hasLast {
+		return int64(ts)
+	}
+	diff := int32(ts - uint32(u.lastValue))
+	return u.lastValue + int64(diff)
+}
+
+// Reset forgets the last value.
+func (u *TimestampUnwrapper) Reset() {
+	u.lastValue = 0
+	u.hasLast = false
+}
@@ -0,0 +1,333 @@
+package goutil
+
+/*
+ * AV1 aggregation header(AV1 RTP spec 4.4):
+ *
+ *  0 1 2 3 4 5 6 7
+ * +-+-+-+-+-+-+-+-+
+ * |Z|Y| W |N|-|-|-|
+ * +-+-+-+-+-+-+-+-+
+ *
+ * Z: the first OBU element is the continuation of the previous packet.
+ * Y: the last OBU element will continue in the next packet.
+ * W: the number of OBU elements, 0 for all elements with length field, or
+ *    1..3 elements and the last one without length field.
+ * N: the first packet of a coded video sequence.
+ *
+ * OBU elements: [leb128 length] OBU(without obu_size)
+ */
+
+const (
+	kAv1ZBit           = 0x80
+	kAv1YBit           = 0x40
+	kAv1WMask          = 0x30
+	kAv1NBit           = 0x08
+	kAv1MaxWElements   = 3
+	kAv1AggrHeaderSize = 1
+	kAv1DefaultMtu     = 1200
+)
+
+// Av1AggregationHeader is the first byte of AV1 RTP payload.
+type Av1AggregationHeader struct {
+	Z bool
+	Y bool
+	W uint8
+	N bool
+}
+
+func (h Av1AggregationHeader) marshal() uint8 {
+	value := (h.W << 4) & kAv1WMask
+	if h.Z {
+		value |= kAv1ZBit
+	}
+	if h.Y {
+		value |= kAv1YBit
+	}
+	if h.N {
+		value |= kAv1NBit
+	}
+	return value
+}
+
+// ParseAv1Payload parses the aggregation header and the OBU elements of one
+// RTP payload. The elements are OBU fragments if Z or Y is set.
+func ParseAv1Payload(payload []byte) (Av1AggregationHeader, [][]byte, error) {
+	var h Av1AggregationHeader
+	if len(payload) < kAv1AggrHeaderSize {
+		return h, nil, NewErrorf("AV1 payload insufficient: %d", len(payload))
+	}
+	h.Z = payload[0]&kAv1ZBit != 0
+	h.Y = payload[0]&kAv1YBit != 0
+	h.W = (payload[0] & kAv1WMask) >> 4
+	h.N = payload[0]&kAv1NBit != 0
+	if h.Z && h.N {
+		return h, nil, NewErrorf("AV1 aggregation header invalid: Z and N")
+	}
+
+	var elements [][]byte
+	data := payload[kAv1AggrHeaderSize:]
+	for len(data) > 0 {
+		if h.W > 0 && len(elements) == int(h.W)-1 {
+			// the last element without length field
+			elements = append(elements, data)
+			break
+		}
+		size, n, err := ReadLeb128(data)
+		if err != nil {
+			return h, nil, NewError2(err, "AV1 OBU element length invalid")
+		}
+		data = data[n:]
+		if uint64(len(data)) < size {
+			return h, nil, NewErrorf("AV1 OBU element insufficient: %d < %d", len(data), size)
+		}
+		elements = append(elements, data[:size])
+		data = data[size:]
+	}
+	if h.W > 0 && len(elements) != int(h.W) {
+		return h, nil, NewErrorf("AV1 OBU element number mismatch: %d != %d", len(elements), h.W)
+	}
+	return h, elements, nil
+}
+
+// Av1Packetizer splits AV1 temporal units into RTP packets(AV1 RTP spec). The
+// temporal delimiter, tile list and padding OBUs are dropped, and obu_size is
+// removed from the OBUs.
+type Av1Packetizer struct {
+	rtpPacketizer
+}
+
+// NewAv1Packetizer creates a packetizer, and the mtu works as newRtpPacketizer.
+func NewAv1Packetizer(ssrc uint32, ptype uint8, mtu int) *Av1Packetizer {
+	return &Av1Packetizer{
+		rtpPacketizer: newRtpPacketizer(ssrc, ptype, mtu, kAv1DefaultMtu),
+	}
+}
+
+// Packetize splits one temporal unit(low overhead bitstream format) into RTP
+// packets of timestamp, and the last packet has the marker bit.
+func (p *Av1Packetizer) Packetize(tu []byte, timestamp uint32) ([]*RtpPacket, error) {
+	obus, err := SplitAv1Obus(tu)
+	if err != nil {
+		return nil, err
+	}
+	return p.PacketizeObus(obus, timestamp)
+}
+
+// av1PacketBuilder collects the OBU elements of one RTP payload.
+type av1PacketBuilder struct {
+	header   Av1AggregationHeader
+	elements [][]byte
+	size     int // the payload size with all length fields
+}
+
+// payload returns the RTP payload, and the last length field is omitted if W > 0.
+func (b *av1PacketBuilder) payload() []byte {
+	header := b.header
+	if len(b.elements) <= kAv1MaxWElements {
+		header.W = uint8(len(b.elements))
+	}
+	buf := make([]byte, 0, b.size)
+	buf = append(buf, header.marshal())
+	for i, element := range b.elements {
+		if header.W == 0 || i < len(b.elements)-1 {
+			buf = AppendLeb128(buf, uint64(len(element)))
+		}
+		buf = append(buf, element...)
+	}
+	return buf
+}
+
+// PacketizeObus is like Packetize with the OBUs of one temporal unit.
+func (p *Av1Packetizer) PacketizeObus(obus []*Av1Obu, timestamp uint32) ([]*RtpPacket, error) {
+	// the aggregation header, one length byte and one data byte at least
+	if p.Mtu <= kAv1AggrHeaderSize+2 {
+		return nil, NewErrorf("AV1 mtu too small: %d", p.Mtu)
+	}
+
+	var payloads [][]byte
+	builder := &av1PacketBuilder{size: kAv1AggrHeaderSize}
+	flush := func() {
+		payloads = append(payloads, builder.payload())
+		builder = &av1PacketBuilder{size: kAv1AggrHeaderSize}
+	}
+
+	newSequence := false
+	for _, obu := range obus {
+		switch obu.Type {
+		case AV1_OBU_TEMPORAL_DELIMITER, AV1_OBU_TILE_LIST, AV1_OBU_PADDING:
+			continue
+		case AV1_OBU_SEQUENCE_HEADER:
+			newSequence = true
+		}
+
+		data := obu.Bytes(false)
+		for len(data) > 0 {
+			left := p.Mtu - builder.size
+			if left < 2 {
+				flush()
+				continue
+			}
+			size := len(data)
+			if size+Leb128Size(uint64(size)) > left {
+				// fragment to fill this packet
+				size = left - Leb128Size(uint64(left))
+			}
+			builder.elements = append(builder.elements, data[:size])
+			builder.size += Leb128Size(uint64(size)) + size
+			data = data[size:]
+			if len(data) > 0 {
+				builder.header.Y = true
+				flush()
+				builder.header.Z = true
+			}
+		}
+	}
+	if len(builder.elements) > 0 {
+		flush()
+	}
+	if len(payloads) == 0 {
+		return nil, NewErrorf("AV1 temporal unit empty")
+	}
+	if newSequence {
+		payloads[0][0] |= kAv1NBit
+	}
+	return p.packetize(payloads, timestamp), nil
+}
+
+// Av1Frame is one temporal unit rebuilt from RTP packets.
+type Av1Frame struct {
+	Timestamp   uint32
+	Obus        []*Av1Obu
+	NewSequence bool // N bit
+	Keyframe    bool // KEY_FRAME with sequence header
+	Width       int  // max frame size of sequence header, zero if unknown
+	Height      int
+}
+
+// Bytes returns the temporal unit in low overhead bitstream format, starting
+// with a temporal delimiter.
+func (f *Av1Frame) Bytes() []byte {
+	delimiter := &Av1Obu{Type: AV1_OBU_TEMPORAL_DELIMITER}
+	buf := delimiter.Bytes(true)
+	for _, obu := range f.Obus {
+		buf = append(buf, obu.Bytes(true)...)
+	}
+	return buf
+}
+
+// Av1Depacketizer rebuilds AV1 temporal units from in-order RTP packets(e.g.
+// from JitterBuffer), and the frames with lost packets are dropped.
+type Av1Depacketizer struct {
+	rtpFrameAssembler
+	frame    *Av1Frame
+	frames   []*Av1Frame // completed in Push
+	fragment []byte
+	seq      *Av1SequenceHeader   // the last sequence header
+	header   Av1AggregationHeader // of the current packet
+	elements [][]byte
+}
+
+func NewAv1Depacketizer() *Av1Depacketizer {
+	return &Av1Depacketizer{}
+}
+
+// Push adds one RTP packet, and returns the frames completed by marker bit or
+// timestamp change.
+func (d *Av1Depacketizer) Push(pkt *RtpPacket) ([]*Av1Frame, error) {
+	err := d.push(d, pkt)
+	frames := d.frames
+	d.frames = nil
+	return frames, err
+}
+
+func (d *Av1Depacketizer) parsePacket(pkt *RtpPacket) (bool, bool, error) {
+	var err error
+	d.header, d.elements, err = ParseAv1Payload(rtpPayloadWithoutPadding(pkt))
+	return false, false, err
+}
+
+func (d *Av1Depacketizer) newFrame(pkt *RtpPacket, lost bool) bool {
+	d.frame = &Av1Frame{Timestamp: pkt.Timestamp}
+	d.fragment = nil
+	// the lost packets might be the head of this frame, unless N is set
+	return !d.header.Z && (!lost || d.header.N)
+}
+
+func (d *Av1Depacketizer) addPacket(pkt *RtpPacket) error {
+	if d.header.N {
+		d.frame.NewSequence = true
+	}
+	return d.pushElements(d.header, d.elements)
+}
+
+func (d *Av1Depacketizer) pushElements(header Av1AggregationHeader, elements [][]byte) error {
+	for i, element := range elements {
+		data := element
+		if i == 0 && header.Z {
+			if d.fragment == nil {
+				return NewErrorf("AV1 OBU fragment without head")
+			}
+			data = append(d.fragment, element...)
+			d.fragment = nil
+		} else if d.fragment != nil {
+			return NewErrorf("AV1 OBU fragment without tail")
+		}
+		if i == len(elements)-1 && header.Y {
+			d.fragment = append([]byte(nil), data...)
+			break
+		}
+		if err := d.addObu(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Av1Depacketizer) addObu(data []byte) error {
+	obu, n, err := ParseAv1Obu(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return NewErrorf("AV1 OBU element size mismatch: %d != %d", n, len(data))
+	}
+	obu.Payload = append([]byte(nil), obu.Payload...)
+	switch obu.Type {
+	case AV1_OBU_TEMPORAL_DELIMITER, AV1_OBU_TILE_LIST, AV1_OBU_PADDING:
+		return nil
+	case AV1_OBU_SEQUENCE_HEADER:
+		seq, err := ParseAv1SequenceHeader(obu.Payload)
+		if err != nil {
+			return err
+		}
+		d.seq = seq
+	}
+	d.frame.Obus = append(d.frame.Obus, obu)
+	return nil
+}
+
+func (d *Av1Depacketizer) finishFrame(broken bool) bool {
+	frame := d.frame
+	d.frame = nil
+	if broken || d.fragment != nil || len(frame.Obus) == 0 {
+		d.fragment = nil
+		return false
+	}
+
+	hasSeq := false
+	for _, obu := range frame.Obus {
+		if obu.Type == AV1_OBU_SEQUENCE_HEADER {
+			hasSeq = true
+		} else if obu.Type == AV1_OBU_FRAME || obu.Type == AV1_OBU_FRAME_HEADER {
+			ftype, err := GetAv1FrameType(obu.Payload, d.seq)
+			frame.Keyframe = hasSeq && err == nil && ftype == AV1_KEY_FRAME
+			break
+		}
+	}
+	if d.seq != nil {
+		frame.Width = d.seq.MaxWidth
+		frame.Height = d.seq.MaxHeight
+	}
+	d.frames = append(d.frames, frame)
+	return true
+}
@@ -0,0 +1,283 @@
+package goutil
+
+/*
+ * Dependency Descriptor RTP header extension(AV1 RTP spec Appendix A):
+ *
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |S|E| template_id |          frame_number         | ext fields..|
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * The mandatory fields(3 bytes) refer to one frame dependency template, and
+ * the templates are defined by the template dependency structure which is
+ * sent in the extended fields of key frames.
+ */
+
+// Decode target indications(AV1 RTP spec A.8.3).
+const (
+	RTP_DTI_NOT_PRESENT = 0
+	RTP_DTI_DISCARDABLE = 1
+	RTP_DTI_SWITCH      = 2
+	RTP_DTI_REQUIRED    = 3
+)
+
+const (
+	kRtpDdMandatorySize = 3
+	kRtpDdMaxTemplates  = 64
+	kRtpDdMaxSpatialId  = 3
+	kRtpDdMaxTemporalId = 7
+	kRtpDdNextLayerTid  = 1
+	kRtpDdNextLayerSid  = 2
+	kRtpDdNextLayerNone = 3
+)
+
+// RtpFrameDependencyTemplate is one template of frame dependency structure.
+type RtpFrameDependencyTemplate struct {
+	SpatialId  int
+	TemporalId int
+	Dtis       []uint8 // RTP_DTI_xx of each decode target
+	FrameDiffs []int
+	ChainDiffs []int
+}
+
+// RtpFrameDependencyStructure is the template_dependency_structure().
+type RtpFrameDependencyStructure struct {
+	TemplateIdOffset int
+	DecodeTargets    int
+	Templates        []RtpFrameDependencyTemplate
+	Chains           int
+	ProtectedBy      []int // the chain of each decode target
+	Widths           []int // render resolutions of spatial layers, empty if not present
+	Heights          []int
+
+	DecodeTargetSpatialId     []int
+	DecodeTargetMaxTemporalId []int
+}
+
+// RtpDependencyDescriptor is the parsed Dependency Descriptor of one RTP packet.
+type RtpDependencyDescriptor struct {
+	StartOfFrame bool
+	EndOfFrame   bool
+	TemplateId   int
+	FrameNumber  uint16
+
+	// the latest structure, and AttachedStructure if it is in this packet
+	Structure         *RtpFrameDependencyStructure
+	AttachedStructure bool
+
+	// bitmask of active decode targets, kept until the next update
+	ActiveDecodeTargets uint32
+
+	SpatialId  int
+	TemporalId int
+	Dtis       []uint8
+	FrameDiffs []int
+	ChainDiffs []int
+	Width      int // zero if the structure has no resolutions
+	Height     int
+}
+
+// IsInDecodeTarget checks whether the frame is needed by decode target dt, so
+// that an SFU can drop the packets of others.
+func (d *RtpDependencyDescriptor) IsInDecodeTarget(dt int) bool {
+	if dt < 0 || dt >= len(d.Dtis) {
+		return false
+	}
+	return d.Dtis[dt] != RTP_DTI_NOT_PRESENT && d.ActiveDecodeTargets&(1<<uint(dt)) != 0
+}
+
+// readRtpDdNs reads one ns(n), the non-symmetric unsigned number(AV1 spec 4.10.7).
+func readRtpDdNs(r *BitReader, n int) int {
+	w := 0
+	for x := n; x != 0; x >>= 1 {
+		w++
+	}
+	m := (1 << uint(w)) - n
+	v := int(r.ReadBits(w - 1))
+	if v < m {
+		return v
+	}
+	return (v << 1) - m + int(r.ReadBit())
+}
+
+// RtpDependencyDescriptorReader parses the Dependency Descriptors of one RTP
+// stream, and keeps the latest frame dependency structure.
+type RtpDependencyDescriptorReader struct {
+	Structure           *RtpFrameDependencyStructure
+	ActiveDecodeTargets uint32
+}
+
+func NewRtpDependencyDescriptorReader() *RtpDependencyDescriptorReader {
+	return &RtpDependencyDescriptorReader{}
+}
+
+// Read parses the extension data of one packet. The packets before the first
+// structure can't be parsed.
+func (rd *RtpDependencyDescriptorReader) Read(data []byte) (*RtpDependencyDescriptor, error) {
+	if len(data) < kRtpDdMandatorySize {
+		return nil, NewErrorf("RTP dependency descriptor insufficient: %d", len(data))
+	}
+	r := NewBitReader(data)
+	d := &RtpDependencyDescriptor{}
+	d.StartOfFrame = r.ReadFlag()
+	d.EndOfFrame = r.ReadFlag()
+	d.TemplateId = int(r.ReadBits(6))
+	d.FrameNumber = uint16(r.ReadBits(16))
+
+	var customDtis, customFdiffs, customChains, activePresent bool
+	if len(data) > kRtpDdMandatorySize {
+		structurePresent := r.ReadFlag()
+		activePresent = r.ReadFlag()
+		customDtis = r.ReadFlag()
+		customFdiffs = r.ReadFlag()
+		customChains = r.ReadFlag()
+		if structurePresent {
+			structure, err := parseRtpFrameDependencyStructure(r)
+			if err != nil {
+				return nil, err
+			}
+			rd.Structure = structure
+			rd.ActiveDecodeTargets = uint32((uint64(1) << uint(structure.DecodeTargets)) - 1)
+			d.AttachedStructure = true
+		}
+	}
+
+	structure := rd.Structure
+	if structure == nil {
+		return nil, NewErrorf("RTP dependency descriptor without structure")
+	}
+	d.Structure = structure
+	if activePresent {
+		rd.ActiveDecodeTargets = r.ReadBits(structure.DecodeTargets)
+	}
+	d.ActiveDecodeTargets = rd.ActiveDecodeTargets
+
+	// frame_dependency_definition()
+	index := (d.TemplateId + kRtpDdMaxTemplates - structure.TemplateIdOffset) % kRtpDdMaxTemplates
+	if index >= len(structure.Templates) {
+		return nil, NewErrorf("RTP dependency descriptor template invalid: %d", d.TemplateId)
+	}
+	template := &structure.Templates[index]
+	d.SpatialId = template.SpatialId
+	d.TemporalId = template.TemporalId
+
+	if customDtis {
+		d.Dtis = make([]uint8, structure.DecodeTargets)
+		for i := range d.Dtis {
+			d.Dtis[i] = uint8(r.ReadBits(2))
+		}
+	} else {
+		d.Dtis = template.Dtis
+	}
+	if customFdiffs {
+		for size := r.ReadBits(2); size != 0 && r.Err() == nil; size = r.ReadBits(2) {
+			d.FrameDiffs = append(d.FrameDiffs, int(r.ReadBits(4*int(size)))+1)
+		}
+	} else {
+		d.FrameDiffs = template.FrameDiffs
+	}
+	if customChains {
+		d.ChainDiffs = make([]int, structure.Chains)
+		for i := range d.ChainDiffs {
+			d.ChainDiffs[i] = int(r.ReadBits(8))
+		}
+	} else {
+		d.ChainDiffs = template.ChainDiffs
+	}
+	if len(structure.Widths) > d.SpatialId {
+		d.Width = structure.Widths[d.SpatialId]
+		d.Height = structure.Heights[d.SpatialId]
+	}
+
+	if r.Err() != nil {
+		return nil, NewError2(r.Err(), "RTP dependency descriptor invalid")
+	}
+	return d, nil
+}
+
+// parseRtpFrameDependencyStructure parses template_dependency_structure().
+func parseRtpFrameDependencyStructure(r *BitReader) (*RtpFrameDependencyStructure, error) {
+	s := &RtpFrameDependencyStructure{}
+	s.TemplateIdOffset = int(r.ReadBits(6))
+	s.DecodeTargets = int(r.ReadBits(5)) + 1
+
+	// template_layers()
+	spatialId, temporalId := 0, 0
+	for {
+		if len(s.Templates) >= kRtpDdMaxTemplates {
+			return nil, NewErrorf("RTP dependency structure too many templates")
+		}
+		s.Templates = append(s.Templates, RtpFrameDependencyTemplate{
+			SpatialId:  spatialId,
+			TemporalId: temporalId,
+		})
+		next := r.ReadBits(2)
+		if r.Err() != nil {
+			return nil, NewError2(r.Err(), "RTP dependency structure invalid")
+		}
+		if next == kRtpDdNextLayerTid {
+			temporalId++
+		} else if next == kRtpDdNextLayerSid {
+			temporalId = 0
+			spatialId++
+		} else if next == kRtpDdNextLayerNone {
+			break
+		}
+		if spatialId > kRtpDdMaxSpatialId || temporalId > kRtpDdMaxTemporalId {
+			return nil, NewErrorf("RTP dependency structure layer invalid: %d, %d", spatialId, temporalId)
+		}
+	}
+	maxSpatialId := spatialId
+
+	// template_dtis()
+	for i := range s.Templates {
+		s.Templates[i].Dtis = make([]uint8, s.DecodeTargets)
+		for j := range s.Templates[i].Dtis {
+			s.Templates[i].Dtis[j] = uint8(r.ReadBits(2))
+		}
+	}
+	// template_fdiffs()
+	for i := range s.Templates {
+		for r.Err() == nil && r.ReadFlag() {
+			s.Templates[i].FrameDiffs = append(s.Templates[i].FrameDiffs, int(r.ReadBits(4))+1)
+		}
+	}
+	// template_chains()
+	s.Chains = readRtpDdNs(r, s.DecodeTargets+1)
+	if s.Chains > 0 {
+		s.ProtectedBy = make([]int, s.DecodeTargets)
+		for i := range s.ProtectedBy {
+			s.ProtectedBy[i] = readRtpDdNs(r, s.Chains)
+		}
+		for i := range s.Templates {
+			s.Templates[i].ChainDiffs = make([]int, s.Chains)
+			for j := range s.Templates[i].ChainDiffs {
+				s.Templates[i].ChainDiffs[j] = int(r.ReadBits(4))
+			}
+		}
+	}
+	// decode_target_layers()
+	s.DecodeTargetSpatialId = make([]int, s.DecodeTargets)
+	s.DecodeTargetMaxTemporalId = make([]int, s.DecodeTargets)
+	for dt := 0; dt < s.DecodeTargets; dt++ {
+		for _, template := range s.Templates {
+			if template.Dtis[dt] != RTP_DTI_NOT_PRESENT {
+				s.DecodeTargetSpatialId[dt] = Max(s.DecodeTargetSpatialId[dt], template.SpatialId)
+				s.DecodeTargetMaxTemporalId[dt] = Max(s.DecodeTargetMaxTemporalId[dt], template.TemporalId)
+			}
+		}
+	}
+	// render_resolutions()
+	if r.ReadFlag() {
+		for sid := 0; sid <= maxSpatialId; sid++ {
+			s.Widths = append(s.Widths, int(r.ReadBits(16))+1)
+			s.Heights = append(s.Heights, int(r.ReadBits(16))+1)
+		}
+	}
+
+	if r.Err() != nil {
+		return nil, NewError2(r.Err(), "RTP dependency structure invalid")
+	}
+	return s, nil
+}
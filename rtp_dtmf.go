@@ -0,0 +1,314 @@
+package goutil
+
+import (
+	"encoding/binary"
+)
+
+/*
+ * Telephone-event payload format(RFC 4733 2.3):
+ *
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |     event     |E|R| volume    |          duration             |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * All packets of one event have the same timestamp(the event start), the first
+ * one has the marker bit, and the final packet with E bit is sent three times.
+ */
+
+// DTMF events(RFC 4733 3.2) besides 0-9 digits.
+const (
+	RTP_DTMF_STAR  uint8 = 10
+	RTP_DTMF_POUND uint8 = 11
+	RTP_DTMF_A     uint8 = 12
+	RTP_DTMF_B     uint8 = 13
+	RTP_DTMF_C     uint8 = 14
+	RTP_DTMF_D     uint8 = 15
+	RTP_DTMF_FLASH uint8 = 16
+)
+
+const (
+	kRtpDtmfSize             = 4
+	kRtpDtmfEndBit           = 0x80
+	kRtpDtmfVolumeMask       = 0x3F
+	kRtpDtmfDefaultVolume    = 10 // -10 dBm0
+	kRtpDtmfDefaultInterval  = 50 // ms
+	kRtpDtmfEndRetransmits   = 3
+	kRtpDtmfDefaultClockRate = 8000
+	kRtpDtmfMaxDuration      = 0xFFFF
+	kRtpDtmfDigits           = "0123456789*#ABCD"
+)
+
+// RtpDtmfEventOfDigit returns the event of one DTMF digit(0-9, *, #, A-D).
+func RtpDtmfEventOfDigit(digit byte) (uint8, error) {
+	if digit >= 'a' && digit <= 'd' {
+		digit -= 'a' - 'A'
+	}
+	for i := 0; i < len(kRtpDtmfDigits); i++ {
+		if kRtpDtmfDigits[i] == digit {
+			return uint8(i), nil
+		}
+	}
+	return 0, NewErrorf("DTMF digit invalid: %c", digit)
+}
+
+// RtpDtmfDigitOfEvent returns the DTMF digit of event, and 0 for other events.
+func RtpDtmfDigitOfEvent(event uint8) byte {
+	if int(event) < len(kRtpDtmfDigits) {
+		return kRtpDtmfDigits[event]
+	}
+	return 0
+}
+
+// RtpTelephoneEvent is the payload of one telephone-event packet.
+type RtpTelephoneEvent struct {
+	Event    uint8
+	End      bool
+	Volume   uint8  // 0 to 63, in -dBm0
+	Duration uint16 // in RTP timestamp units since the event start
+}
+
+// MarshalSize returns the size of one event.
+func (e *RtpTelephoneEvent) MarshalSize() int {
+	return kRtpDtmfSize
+}
+
+// MarshalTo writes the event into buf, and returns the size.
+func (e *RtpTelephoneEvent) MarshalTo(buf []byte) (int, error) {
+	if len(buf) < kRtpDtmfSize {
+		return 0, NewErrorf("telephone-event buffer insufficient: %d < %d", len(buf), kRtpDtmfSize)
+	}
+	buf[0] = e.Event
+	buf[1] = e.Volume & kRtpDtmfVolumeMask
+	if e.End {
+		buf[1] |= kRtpDtmfEndBit
+	}
+	binary.BigEndian.PutUint16(buf[2:], e.Duration)
+	return kRtpDtmfSize, nil
+}
+
+// Marshal returns the payload of one event.
+func (e *RtpTelephoneEvent) Marshal() []byte {
+	buf := make([]byte, kRtpDtmfSize)
+	e.MarshalTo(buf)
+	return buf
+}
+
+// Unmarshal parses the first event of payload, and returns its size.
+func (e *RtpTelephoneEvent) Unmarshal(payload []byte) (int, error) {
+	if len(payload) < kRtpDtmfSize {
+		return 0, NewErrorf("telephone-event insufficient: %d", len(payload))
+	}
+	e.Event = payload[0]
+	e.End = payload[1]&kRtpDtmfEndBit != 0
+	e.Volume = payload[1] & kRtpDtmfVolumeMask
+	e.Duration = binary.BigEndian.Uint16(payload[2:])
+	return kRtpDtmfSize, nil
+}
+
+// RtpDtmfSender generates the telephone-event packets of one stream: one start
+// packet with marker bit, the updates of increasing duration and three end packets.
+// The event over 0xFFFF timestamp units is split into segments(RFC 4733 2.5.1.3).
+type RtpDtmfSender struct {
+	PayloadType    uint8
+	SSRC           uint32
+	SequenceNumber uint16 // the sequence number of the next packet
+	ClockRate      int
+	Volume         uint8
+	IntervalMs     int // the interval of update packets
+
+	event     uint8
+	timestamp uint32 // the start of current segment
+	offset    int    // the duration of previous segments
+	active    bool
+}
+
+// NewRtpDtmfSender creates a sender, and clockRate(<=0 for 8000) is that of telephone-event in SDP.
+func NewRtpDtmfSender(ssrc uint32, ptype uint8, clockRate int) *RtpDtmfSender {
+	if clockRate <= 0 {
+		clockRate = kRtpDtmfDefaultClockRate
+	}
+	return &RtpDtmfSender{
+		PayloadType:    ptype,
+		SSRC:           ssrc,
+		SequenceNumber: uint16(RandomUint32()),
+		ClockRate:      clockRate,
+		Volume:         kRtpDtmfDefaultVolume,
+		IntervalMs:     kRtpDtmfDefaultInterval,
+	}
+}
+
+// newPackets returns the packets of durationMs since the event start, and the
+// segment of max duration is finished before a new one begins.
+func (s *RtpDtmfSender) newPackets(end bool, durationMs int, marker bool) []*RtpPacket {
+	var pkts []*RtpPacket
+	duration := Max(durationMs*s.ClockRate/1000-s.offset, 0)
+	for duration > kRtpDtmfMaxDuration {
+		pkts = append(pkts, s.newPacket(false, kRtpDtmfMaxDuration, marker))
+		marker = false
+		s.timestamp += kRtpDtmfMaxDuration
+		s.offset += kRtpDtmfMaxDuration
+		duration -= kRtpDtmfMaxDuration
+	}
+	return append(pkts, s.newPacket(end, duration, marker))
+}
+
+func (s *RtpDtmfSender) newPacket(end bool, duration int, marker bool) *RtpPacket {
+	event := RtpTelephoneEvent{
+		Event:    s.event,
+		End:      end,
+		Volume:   s.Volume,
+		Duration: uint16(duration),
+	}
+	pkt := &RtpPacket{
+		RtpHeader: RtpHeader{
+			Version:        kRtpVersion,
+			Marker:         marker,
+			PayloadType:    s.PayloadType,
+			SequenceNumber: s.SequenceNumber,
+			Timestamp:      s.timestamp,
+			SSRC:           s.SSRC,
+		},
+		Payload: event.Marshal(),
+	}
+	s.SequenceNumber += 1
+	return pkt
+}
+
+// Start begins one event of digit at timestamp, and returns the first packet.
+func (s *RtpDtmfSender) Start(digit byte, timestamp uint32) (*RtpPacket, error) {
+	if s.active {
+		return nil, NewErrorf("DTMF event in progress: %c", RtpDtmfDigitOfEvent(s.event))
+	}
+	event, err := RtpDtmfEventOfDigit(digit)
+	if err != nil {
+		return nil, err
+	}
+	s.event = event
+	s.timestamp = timestamp
+	s.offset = 0
+	s.active = true
+	return s.newPacket(false, 0, true), nil
+}
+
+// Update returns the packet of the current event after durationMs, which is
+// preceded by the last one of previous segment if a new segment begins.
+func (s *RtpDtmfSender) Update(durationMs int) ([]*RtpPacket, error) {
+	if !s.active {
+		return nil, NewErrorf("DTMF event not started")
+	}
+	return s.newPackets(false, durationMs, false), nil
+}
+
+// End finishes the current event of durationMs, and returns the three end packets
+// like Update.
+func (s *RtpDtmfSender) End(durationMs int) ([]*RtpPacket, error) {
+	if !s.active {
+		return nil, NewErrorf("DTMF event not started")
+	}
+	var pkts []*RtpPacket
+	for i := 0; i < kRtpDtmfEndRetransmits; i++ {
+		pkts = append(pkts, s.newPackets(true, durationMs, false)...)
+	}
+	s.active = false
+	return pkts, nil
+}
+
+// Generate returns all packets of digit which lasts durationMs from timestamp,
+// with one update every IntervalMs.
+func (s *RtpDtmfSender) Generate(digit byte, timestamp uint32, durationMs int) ([]*RtpPacket, error) {
+	pkt, err := s.Start(digit, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	pkts := []*RtpPacket{pkt}
+	for ms := s.IntervalMs; ms < durationMs && s.IntervalMs > 0; ms += s.IntervalMs {
+		updates, _ := s.Update(ms)
+		pkts = append(pkts, updates...)
+	}
+	ends, _ := s.End(durationMs)
+	return append(pkts, ends...), nil
+}
+
+// RtpDtmfEvent is one telephone-event reported by RtpDtmfReceiver.
+type RtpDtmfEvent struct {
+	Event      uint8
+	Digit      byte // 0 for the events other than DTMF digits
+	Timestamp  uint32
+	Duration   uint16 // in RTP timestamp units
+	DurationMs int
+	Volume     uint8
+	Ended      bool // false if the end packets are lost
+}
+
+// RtpDtmfReceiver rebuilds the telephone-events of one stream, which drops the
+// redundant end packets and reports each event once.
+type RtpDtmfReceiver struct {
+	ClockRate int
+
+	current    *RtpDtmfEvent
+	lastEndTs  uint32
+	hasLastEnd bool
+}
+
+// NewRtpDtmfReceiver creates a receiver, and clockRate(<=0 for 8000) is that of telephone-event in SDP.
+func NewRtpDtmfReceiver(clockRate int) *RtpDtmfReceiver {
+	if clockRate <= 0 {
+		clockRate = kRtpDtmfDefaultClockRate
+	}
+	return &RtpDtmfReceiver{ClockRate: clockRate}
+}
+
+// Push handles one telephone-event packet, and returns the finished events.
+func (r *RtpDtmfReceiver) Push(pkt *RtpPacket) ([]*RtpDtmfEvent, error) {
+	var te RtpTelephoneEvent
+	if _, err := te.Unmarshal(rtpPayloadWithoutPadding(pkt)); err != nil {
+		return nil, err
+	}
+
+	// the redundant end packets or the late ones of finished events
+	if r.hasLastEnd && !IsNewerRtpTimestamp(pkt.Timestamp, r.lastEndTs) {
+		return nil, nil
+	}
+
+	var events []*RtpDtmfEvent
+	if r.current != nil && r.current.Timestamp != pkt.Timestamp {
+		// the end packets of current event are lost
+		events = append(events, r.finishEvent(false))
+	}
+	if r.current == nil {
+		r.current = &RtpDtmfEvent{
+			Event:     te.Event,
+			Digit:     RtpDtmfDigitOfEvent(te.Event),
+			Timestamp: pkt.Timestamp,
+		}
+	}
+	if te.Duration > r.current.Duration {
+		r.current.Duration = te.Duration
+	}
+	r.current.Volume = te.Volume
+	if te.End {
+		events = append(events, r.finishEvent(true))
+	}
+	return events, nil
+}
+
+// Flush finishes the current event without end packets, e.g. when the stream
+// is timeout, and returns nil if no event is in progress.
+func (r *RtpDtmfReceiver) Flush() *RtpDtmfEvent {
+	if r.current == nil {
+		return nil
+	}
+	return r.finishEvent(false)
+}
+
+func (r *RtpDtmfReceiver) finishEvent(ended bool) *RtpDtmfEvent {
+	event := r.current
+	r.current = nil
+	event.Ended = ended
+	event.DurationMs = int(event.Duration) * 1000 / r.ClockRate
+	r.lastEndTs = event.Timestamp
+	r.hasLastEnd = true
+	return event
+}
@@ -0,0 +1,489 @@
+package goutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+/*
+ * RFC 8285, one-byte header(profile 0xBEDE):
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |       0xBE    |    0xDE       |           length=3            |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |  ID   | L=0   |     data      |  ID   |  L=1  |   data...
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * RFC 8285, two-byte header(profile 0x100X):
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |       0x10    |    0x00       |           length=3            |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |      ID       |     L=0       |     ID        |     L=1       |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ */
+
+const (
+	kRtpOneByteProfile     uint16 = 0xBEDE
+	kRtpTwoByteProfile     uint16 = 0x1000
+	kRtpTwoByteProfileMask uint16 = 0xFFF0
+
+	kRtpOneByteMaxId     = 14
+	kRtpOneByteMaxLength = 16
+	kRtpOneByteStopId    = 15
+	kRtpTwoByteMaxId     = 255
+	kRtpTwoByteMaxLength = 255
+)
+
+// RtpExtensionType is the typed meaning of one a=extmap id.
+type RtpExtensionType int
+
+// These are the header extensions known by RtpExtension.
+const (
+	RTP_EXT_NONE RtpExtensionType = iota
+	RTP_EXT_TRANSMISSION_TIME_OFFSET
+	RTP_EXT_ABSOLUTE_SEND_TIME
+	RTP_EXT_TRANSPORT_SEQUENCE_NUMBER
+	RTP_EXT_AUDIO_LEVEL
+	RTP_EXT_VIDEO_TIMING
+	RTP_EXT_FRAME_MARKING
+	RTP_EXT_MID
+	RTP_EXT_RTP_STREAM_ID
+	RTP_EXT_REPAIRED_RTP_STREAM_ID
+	RTP_EXT_DEPENDENCY_DESCRIPTOR
+)
+
+// The a=extmap uris of RtpExtensionType.
+const (
+	RtpExtUriTransmissionTimeOffset  = "urn:ietf:params:rtp-hdrext:toffset"
+	RtpExtUriAbsoluteSendTime        = "http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time"
+	RtpExtUriTransportSequenceNumber = "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01"
+	RtpExtUriAudioLevel              = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+	RtpExtUriVideoTiming             = "http://www.webrtc.org/experiments/rtp-hdrext/video-timing"
+	RtpExtUriFrameMarking            = "http://tools.ietf.org/html/draft-ietf-avtext-framemarking-07"
+	RtpExtUriMid                     = "urn:ietf:params:rtp-hdrext:sdes:mid"
+	RtpExtUriRtpStreamId             = "urn:ietf:params:rtp-hdrext:sdes:rtp-stream-id"
+	RtpExtUriRepairedRtpStreamId     = "urn:ietf:params:rtp-hdrext:sdes:repaired-rtp-stream-id"
+	RtpExtUriDependencyDescriptor    = "https://aomediacodec.github.io/av1-rtp-spec/#dependency-descriptor-rtp-header-extension"
+)
+
+// GetRtpExtensionType returns the extension type of a=extmap uri.
+func GetRtpExtensionType(uri string) RtpExtensionType {
+	// NOTE: check repaired-rtp-stream-id before rtp-stream-id
+	if strings.Contains(uri, "rtp-hdrext:toffset") {
+		return RTP_EXT_TRANSMISSION_TIME_OFFSET
+	} else if strings.Contains(uri, "rtp-hdrext/abs-send-time") {
+		return RTP_EXT_ABSOLUTE_SEND_TIME
+	} else if strings.Contains(uri, "transport-wide-cc-extensions") {
+		return RTP_EXT_TRANSPORT_SEQUENCE_NUMBER
+	} else if strings.Contains(uri, "rtp-hdrext:ssrc-audio-level") {
+		return RTP_EXT_AUDIO_LEVEL
+	} else if strings.Contains(uri, "rtp-hdrext/video-timing") {
+		return RTP_EXT_VIDEO_TIMING
+	} else if strings.Contains(uri, "ietf-avtext-framemarking") {
+		return RTP_EXT_FRAME_MARKING
+	} else if strings.Contains(uri, "sdes:mid") {
+		return RTP_EXT_MID
+	} else if strings.Contains(uri, "sdes:repaired-rtp-stream-id") {
+		return RTP_EXT_REPAIRED_RTP_STREAM_ID
+	} else if strings.Contains(uri, "sdes:rtp-stream-id") {
+		return RTP_EXT_RTP_STREAM_ID
+	} else if strings.Contains(uri, "dependency-descriptor-rtp-header-extension") {
+		return RTP_EXT_DEPENDENCY_DESCRIPTOR
+	}
+	return RTP_EXT_NONE
+}
+
+// RtpExtensionElement is one RFC 8285 element(id, data) of rtp header extension.
+type RtpExtensionElement struct {
+	Id   uint8
+	Data []byte
+}
+
+// RtpExtensionMap maps the a=extmap ids to extension types, and vice versa.
+type RtpExtensionMap struct {
+	types map[uint8]RtpExtensionType
+	ids   map[RtpExtensionType]uint8
+}
+
+func NewRtpExtensionMap() *RtpExtensionMap {
+	return &RtpExtensionMap{
+		types: make(map[uint8]RtpExtensionType),
+		ids:   make(map[RtpExtensionType]uint8),
+	}
+}
+
+// Register binds id with etype, return false if id is invalid.
+func (m *RtpExtensionMap) Register(id int, etype RtpExtensionType) bool {
+	if id <= 0 || id > kRtpTwoByteMaxId || etype == RTP_EXT_NONE {
+		return false
+	}
+	if old, ok := m.types[uint8(id)]; ok {
+		delete(m.ids, old)
+	}
+	m.types[uint8(id)] = etype
+	m.ids[etype] = uint8(id)
+	return true
+}
+
+// RegisterUri binds id with the type of a=extmap uri.
+func (m *RtpExtensionMap) RegisterUri(id int, uri string) bool {
+	return m.Register(id, GetRtpExtensionType(uri))
+}
+
+// GetType returns the extension type of id, or RTP_EXT_NONE.
+func (m *RtpExtensionMap) GetType(id uint8) RtpExtensionType {
+	if etype, ok := m.types[id]; ok {
+		return etype
+	}
+	return RTP_EXT_NONE
+}
+
+// GetId returns the id of extension type, or 0 if not registered.
+func (m *RtpExtensionMap) GetId(etype RtpExtensionType) uint8 {
+	return m.ids[etype]
+}
+
+// IsRtpExtensionOneByte returns whether profile is RFC 8285 one-byte header.
+func IsRtpExtensionOneByte(profile uint16) bool {
+	return profile == kRtpOneByteProfile
+}
+
+// IsRtpExtensionTwoByte returns whether profile is RFC 8285 two-byte header.
+func IsRtpExtensionTwoByte(profile uint16) bool {
+	return (profile & kRtpTwoByteProfileMask) == kRtpTwoByteProfile
+}
+
+// ParseRtpExtensionElements parses the RFC 8285 elements from extension payload.
+func ParseRtpExtensionElements(profile uint16, payload []byte) ([]RtpExtensionElement, error) {
+	var elements []RtpExtensionElement
+	if IsRtpExtensionOneByte(profile) {
+		for idx := 0; idx < len(payload); {
+			if payload[idx] == 0 { // padding
+				idx += 1
+				continue
+			}
+			id := payload[idx] >> 4
+			length := int(payload[idx]&0xF) + 1
+			if id == kRtpOneByteStopId {
+				break
+			}
+			idx += 1
+			if idx+length > len(payload) {
+				return nil, fmt.Errorf("RTP one-byte extension(id=%d) insufficient: %d < %d", id, len(payload), idx+length)
+			}
+			elements = append(elements, RtpExtensionElement{id, payload[idx : idx+length]})
+			idx += length
+		}
+	} else if IsRtpExtensionTwoByte(profile) {
+		for idx := 0; idx < len(payload); {
+			if payload[idx] == 0 { // padding
+				idx += 1
+				continue
+			}
+			if idx+2 > len(payload) {
+				return nil, fmt.Errorf("RTP two-byte extension header insufficient: %d", len(payload)-idx)
+			}
+			id := payload[idx]
+			length := int(payload[idx+1])
+			idx += 2
+			if idx+length > len(payload) {
+				return nil, fmt.Errorf("RTP two-byte extension(id=%d) insufficient: %d < %d", id, len(payload), idx+length)
+			}
+			elements = append(elements, RtpExtensionElement{id, payload[idx : idx+length]})
+			idx += length
+		}
+	}
+	return elements, nil
+}
+
+// MarshalRtpExtensionElements packs elements into (profile, payload) with 32-bit padding.
+// The one-byte header is used if possible, otherwise the two-byte header.
+func MarshalRtpExtensionElements(elements []RtpExtensionElement) (uint16, []byte, error) {
+	return marshalRtpExtensionElements(elements, false)
+}
+
+// marshalRtpExtensionElements packs elements, with the two-byte header if twoByte is set.
+func marshalRtpExtensionElements(elements []RtpExtensionElement, twoByte bool) (uint16, []byte, error) {
+	oneByte := !twoByte
+	for _, elem := range elements {
+		if elem.Id == 0 {
+			return 0, nil, NewError("invalid RTP extension id=0")
+		}
+		if len(elem.Data) > kRtpTwoByteMaxLength {
+			return 0, nil, NewError("too long RTP extension, id=", elem.Id, ", len=", len(elem.Data))
+		}
+		if elem.Id > kRtpOneByteMaxId || len(elem.Data) == 0 || len(elem.Data) > kRtpOneByteMaxLength {
+			oneByte = false
+		}
+	}
+
+	var profile uint16
+	var payload []byte
+	if oneByte {
+		profile = kRtpOneByteProfile
+		for _, elem := range elements {
+			payload = append(payload, elem.Id<<4|uint8(len(elem.Data)-1))
+			payload = append(payload, elem.Data...)
+		}
+	} else {
+		profile = kRtpTwoByteProfile
+		for _, elem := range elements {
+			payload = append(payload, elem.Id, uint8(len(elem.Data)))
+			payload = append(payload, elem.Data...)
+		}
+	}
+	if remainder := len(payload) % 4; remainder > 0 {
+		payload = append(payload, make([]byte, 4-remainder)...)
+	}
+	return profile, payload, nil
+}
+
+// GetExtension returns the data of extension element id, or nil.
+func (h *RtpHeader) GetExtension(id uint8) []byte {
+	for _, elem := range h.ExtensionElements {
+		if elem.Id == id {
+			return elem.Data
+		}
+	}
+	return nil
+}
+
+// SetExtension adds or replaces the extension element id, and returns error
+// (with the header unchanged) if the element could not be packed.
+func (h *RtpHeader) SetExtension(id uint8, data []byte) error {
+	elements := append([]RtpExtensionElement(nil), h.ExtensionElements...)
+	for i := range elements {
+		if elements[i].Id == id {
+			elements[i].Data = data
+			return h.setExtensionElements(elements)
+		}
+	}
+	elements = append(elements, RtpExtensionElement{id, data})
+	return h.setExtensionElements(elements)
+}
+
+// DelExtension removes the extension element id.
+func (h *RtpHeader) DelExtension(id uint8) error {
+	for i := range h.ExtensionElements {
+		if h.ExtensionElements[i].Id == id {
+			elements := append([]RtpExtensionElement(nil), h.ExtensionElements[:i]...)
+			elements = append(elements, h.ExtensionElements[i+1:]...)
+			return h.setExtensionElements(elements)
+		}
+	}
+	return nil
+}
+
+// setExtensionElements replaces ExtensionElements and rebuilds ExtensionPayload,
+// or keeps the old ones if failed.
+func (h *RtpHeader) setExtensionElements(elements []RtpExtensionElement) error {
+	old := h.ExtensionElements
+	h.ExtensionElements = elements
+	if err := h.rebuildExtensionPayload(); err != nil {
+		h.ExtensionElements = old
+		return err
+	}
+	return nil
+}
+
+// parseExtensionPayload parses ExtensionElements, and RtpExtension if ExtensionMap is set.
+func (h *RtpHeader) parseExtensionPayload() error {
+	elements, err := ParseRtpExtensionElements(h.ExtensionProfile, h.ExtensionPayload)
+	if err != nil {
+		return err
+	}
+	h.ExtensionElements = elements
+	h.RtpExtension = RtpExtension{}
+	h.decodedMap = h.ExtensionMap
+	if h.ExtensionMap != nil {
+		for _, elem := range h.ExtensionElements {
+			h.RtpExtension.decode(h.ExtensionMap.GetType(elem.Id), elem.Data)
+		}
+	}
+	return nil
+}
+
+// rebuildExtensionPayload packs ExtensionElements into ExtensionPayload. The
+// two-byte profile(with its appbits) is kept if received.
+func (h *RtpHeader) rebuildExtensionPayload() error {
+	if h.Extension && len(h.ExtensionPayload) > 0 &&
+		!IsRtpExtensionOneByte(h.ExtensionProfile) && !IsRtpExtensionTwoByte(h.ExtensionProfile) {
+		// not RFC 8285, keep it
+		return nil
+	}
+
+	if len(h.ExtensionElements) == 0 {
+		h.Extension = false
+		h.ExtensionProfile = 0
+		h.ExtensionPayload = nil
+		return nil
+	}
+
+	twoByte := h.Extension && IsRtpExtensionTwoByte(h.ExtensionProfile)
+	profile, payload, err := marshalRtpExtensionElements(h.ExtensionElements, twoByte)
+	if err != nil {
+		return err
+	}
+	if twoByte {
+		profile = h.ExtensionProfile
+	}
+	h.Extension = true
+	h.ExtensionProfile = profile
+	h.ExtensionPayload = payload
+	return nil
+}
+
+// UpdateExtensionPayload syncs RtpExtension into ExtensionElements/ExtensionPayload
+// through ExtensionMap, where the typed fields take precedence over SetExtension.
+// The elements of unknown/untyped extensions are kept as they are, and the typed
+// element is removed only if its field is cleared after decoded with ExtensionMap.
+// It is called by Marshal/MarshalTo, and should be called before MarshalSize if
+// the typed fields are changed.
+func (h *RtpHeader) UpdateExtensionPayload() error {
+	if h.ExtensionMap == nil {
+		return nil
+	}
+
+	if h.decodedMap != h.ExtensionMap {
+		// ExtensionMap is attached after Unmarshal, so decode the unset fields first
+		for _, elem := range h.ExtensionElements {
+			etype := h.ExtensionMap.GetType(elem.Id)
+			if _, ok := h.RtpExtension.encode(etype); !ok {
+				h.RtpExtension.decode(etype, elem.Data)
+			}
+		}
+		h.decodedMap = h.ExtensionMap
+	}
+
+	elements := append([]RtpExtensionElement(nil), h.ExtensionElements...)
+	changed := false
+	for etype := RTP_EXT_TRANSMISSION_TIME_OFFSET; etype <= RTP_EXT_DEPENDENCY_DESCRIPTOR; etype++ {
+		id := h.ExtensionMap.GetId(etype)
+		if id == 0 || etype == RTP_EXT_VIDEO_TIMING || etype == RTP_EXT_FRAME_MARKING {
+			continue
+		}
+		data, ok := h.RtpExtension.encode(etype)
+		index := -1
+		for i := range elements {
+			if elements[i].Id == id {
+				index = i
+				break
+			}
+		}
+		if !ok {
+			if index >= 0 {
+				elements = append(elements[:index], elements[index+1:]...)
+				changed = true
+			}
+			continue
+		}
+		if index >= 0 {
+			if string(elements[index].Data) == string(data) {
+				continue
+			}
+			elements[index].Data = data
+		} else {
+			elements = append(elements, RtpExtensionElement{id, data})
+		}
+		changed = true
+	}
+
+	if changed {
+		return h.setExtensionElements(elements)
+	}
+	return nil
+}
+
+// decode fills the typed field of etype from extension data.
+func (e *RtpExtension) decode(etype RtpExtensionType, data []byte) {
+	switch etype {
+	case RTP_EXT_TRANSMISSION_TIME_OFFSET:
+		if len(data) == 3 {
+			// 24-bit signed integer
+			value := int32(uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2]))
+			if value&0x800000 != 0 {
+				value -= 0x1000000
+			}
+			e.HasTransmissionTimeOffset = true
+			e.TransmissionTimeOffset = value
+		}
+	case RTP_EXT_ABSOLUTE_SEND_TIME:
+		if len(data) == 3 {
+			// 24-bit 6.18 fixed point seconds
+			e.HasAbsoluteSendTime = true
+			e.AbsoluteSendTime = uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2])
+		}
+	case RTP_EXT_TRANSPORT_SEQUENCE_NUMBER:
+		if len(data) == 2 {
+			e.HasTransportSequenceNumber = true
+			e.TransportSequenceNumber = binary.BigEndian.Uint16(data)
+		}
+	case RTP_EXT_AUDIO_LEVEL:
+		if len(data) >= 1 {
+			e.HasAudioLevel = true
+			e.VoiceActivity = (data[0] & 0x80) != 0
+			e.AudioLevel = data[0] & 0x7F
+		}
+	case RTP_EXT_VIDEO_TIMING:
+		e.Has_video_timing = true
+	case RTP_EXT_FRAME_MARKING:
+		e.Has_frame_marking = true
+	case RTP_EXT_MID:
+		e.Mid = string(data)
+	case RTP_EXT_RTP_STREAM_ID:
+		e.Stream_id = string(data)
+	case RTP_EXT_REPAIRED_RTP_STREAM_ID:
+		e.Repaired_stream_id = string(data)
+	case RTP_EXT_DEPENDENCY_DESCRIPTOR:
+		e.DependencyDescriptor = string(data)
+	}
+}
+
+// encode returns the extension data of etype, or false if not present.
+func (e *RtpExtension) encode(etype RtpExtensionType) ([]byte, bool) {
+	switch etype {
+	case RTP_EXT_TRANSMISSION_TIME_OFFSET:
+		if e.HasTransmissionTimeOffset {
+			value := uint32(e.TransmissionTimeOffset) & 0xFFFFFF
+			return []byte{uint8(value >> 16), uint8(value >> 8), uint8(value)}, true
+		}
+	case RTP_EXT_ABSOLUTE_SEND_TIME:
+		if e.HasAbsoluteSendTime {
+			value := e.AbsoluteSendTime & 0xFFFFFF
+			return []byte{uint8(value >> 16), uint8(value >> 8), uint8(value)}, true
+		}
+	case RTP_EXT_TRANSPORT_SEQUENCE_NUMBER:
+		if e.HasTransportSequenceNumber {
+			data := make([]byte, 2)
+			binary.BigEndian.PutUint16(data, e.TransportSequenceNumber)
+			return data, true
+		}
+	case RTP_EXT_AUDIO_LEVEL:
+		if e.HasAudioLevel {
+			value := e.AudioLevel & 0x7F
+			if e.VoiceActivity {
+				value |= 0x80
+			}
+			return []byte{value}, true
+		}
+	case RTP_EXT_MID:
+		if len(e.Mid) > 0 {
+			return []byte(e.Mid), true
+		}
+	case RTP_EXT_RTP_STREAM_ID:
+		if len(e.Stream_id) > 0 {
+			return []byte(e.Stream_id), true
+		}
+	case RTP_EXT_REPAIRED_RTP_STREAM_ID:
+		if len(e.Repaired_stream_id) > 0 {
+			return []byte(e.Repaired_stream_id), true
+		}
+	case RTP_EXT_DEPENDENCY_DESCRIPTOR:
+		if len(e.DependencyDescriptor) > 0 {
+			return []byte(e.DependencyDescriptor), true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,184 @@
+package goutil
+
+import (
+	"encoding/binary"
+)
+
+// The common XOR parity(RFC 5109 and FlexFEC) of ULPFEC and FlexFEC.
+
+const (
+	kRtpFecRecoveryMask    = 0x3F // P/X/CC of the first byte
+	kRtpFecMaxFecPackets   = 64
+	kRtpFecDefaultCapacity = 256
+)
+
+// rtpFecRecovery is the XOR of the protected packets' P/X/CC/M/PT, timestamp,
+// length(after the 12-byte header) and the bytes after the 12-byte header.
+type rtpFecRecovery struct {
+	flags     uint8 // P/X/CC
+	markerPt  uint8 // M/PT
+	timestamp uint32
+	length    uint16
+	payload   []byte // the protected bytes
+}
+
+// xor adds one raw packet, and the bytes beyond payload are not protected.
+func (r *rtpFecRecovery) xor(raw []byte) {
+	r.flags ^= raw[0] & kRtpFecRecoveryMask
+	r.markerPt ^= raw[1]
+	r.timestamp ^= binary.BigEndian.Uint32(raw[kRtpTimestampOffset:])
+	r.length ^= uint16(len(raw) - kRtpHeaderLength)
+	for i, value := range raw[kRtpHeaderLength:] {
+		if i >= len(r.payload) {
+			break
+		}
+		r.payload[i] ^= value
+	}
+}
+
+// newRtpFecRecovery returns the recovery of raws, which protects all bytes.
+func newRtpFecRecovery(raws [][]byte) *rtpFecRecovery {
+	size := 0
+	for _, raw := range raws {
+		size = Max(size, len(raw)-kRtpHeaderLength)
+	}
+	r := &rtpFecRecovery{payload: make([]byte, size)}
+	for _, raw := range raws {
+		r.xor(raw)
+	}
+	return r
+}
+
+// rawPacket returns the raw packet of seq/ssrc after the XOR of all other protected packets.
+func (r *rtpFecRecovery) rawPacket(seq uint16, ssrc uint32) []byte {
+	length := int(r.length)
+	if length > len(r.payload) {
+		return nil
+	}
+	raw := make([]byte, kRtpHeaderLength+length)
+	raw[0] = kRtpVersion<<kRtpVersionShift | r.flags&kRtpFecRecoveryMask
+	raw[1] = r.markerPt
+	binary.BigEndian.PutUint16(raw[kRtpSeqNumOffset:], seq)
+	binary.BigEndian.PutUint32(raw[kRtpTimestampOffset:], r.timestamp)
+	binary.BigEndian.PutUint32(raw[kRtpSsrcOffset:], ssrc)
+	copy(raw[kRtpHeaderLength:], r.payload[:length])
+	return raw
+}
+
+func (r *rtpFecRecovery) clone() *rtpFecRecovery {
+	copied := *r
+	copied.payload = append([]byte(nil), r.payload...)
+	return &copied
+}
+
+// marshalRtpFecPackets returns the raw packets of pkts.
+func marshalRtpFecPackets(pkts []*RtpPacket) ([][]byte, error) {
+	raws := make([][]byte, len(pkts))
+	for i, pkt := range pkts {
+		raw, err := pkt.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		raws[i] = raw
+	}
+	return raws, nil
+}
+
+func rtpFecKey(ssrc uint32, seq uint16) uint64 {
+	return uint64(ssrc)<<16 | uint64(seq)
+}
+
+// rtpFecPacket is one received FEC packet.
+type rtpFecPacket struct {
+	keys     []uint64 // the protected ssrc/seq
+	recovery *rtpFecRecovery
+}
+
+// rtpFecRecoverer keeps the recent media and FEC packets, and recovers the lost
+// media packets which are the only missing one of some FEC packet.
+type rtpFecRecoverer struct {
+	capacity  int
+	media     map[uint64][]byte // the raw packets of received or recovered
+	order     []uint64
+	fecs      []*rtpFecPacket
+	recovered int
+}
+
+func newRtpFecRecoverer(capacity int) *rtpFecRecoverer {
+	if capacity <= 0 {
+		capacity = kRtpFecDefaultCapacity
+	}
+	return &rtpFecRecoverer{
+		capacity: capacity,
+		media:    make(map[uint64][]byte),
+	}
+}
+
+func (r *rtpFecRecoverer) addMedia(key uint64, raw []byte) {
+	if _, ok := r.media[key]; !ok {
+		r.order = append(r.order, key)
+	}
+	r.media[key] = raw
+	for len(r.order) > r.capacity {
+		delete(r.media, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+func (r *rtpFecRecoverer) addFec(fec *rtpFecPacket) {
+	r.fecs = append(r.fecs, fec)
+	if len(r.fecs) > kRtpFecMaxFecPackets {
+		r.fecs = r.fecs[len(r.fecs)-kRtpFecMaxFecPackets:]
+	}
+}
+
+// recover tries all FEC packets until no more packets can be recovered.
+func (r *rtpFecRecoverer) recover() []*RtpPacket {
+	var pkts []*RtpPacket
+	for progress := true; progress; {
+		progress = false
+		fecs := r.fecs[:0]
+		for _, fec := range r.fecs {
+			missing := -1
+			count := 0
+			for i, key := range fec.keys {
+				if _, ok := r.media[key]; !ok {
+					missing = i
+					count++
+				}
+			}
+			if count == 1 {
+				if pkt := r.recoverPacket(fec, fec.keys[missing]); pkt != nil {
+					pkts = append(pkts, pkt)
+					progress = true
+				}
+				continue
+			}
+			if count > 1 {
+				fecs = append(fecs, fec)
+			}
+		}
+		r.fecs = fecs
+	}
+	return pkts
+}
+
+func (r *rtpFecRecoverer) recoverPacket(fec *rtpFecPacket, key uint64) *RtpPacket {
+	recovery := fec.recovery.clone()
+	for _, other := range fec.keys {
+		if other != key {
+			recovery.xor(r.media[other])
+		}
+	}
+	raw := recovery.rawPacket(uint16(key), uint32(key>>16))
+	if raw == nil {
+		return nil
+	}
+	pkt := &RtpPacket{}
+	if err := pkt.Unmarshal(raw); err != nil {
+		return nil
+	}
+	r.addMedia(key, raw)
+	r.recovered += 1
+	return pkt
+}
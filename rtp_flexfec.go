@@ -0,0 +1,471 @@
+package goutil
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+/*
+ * FlexFEC header(draft-ietf-payload-flexible-fec-scheme-03, flexfec-03 of
+ * WebRTC), only the flexible mask mode(R=0, F=0):
+ *
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |R|F|P|X|  CC   |M| PT recovery |        length recovery        |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                          TS recovery                          |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |   SSRCCount   |                    reserved                   |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                             SSRC_i                            |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |           SN base_i           |k|          Mask [0-14]        |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |k|                   Mask [15-45] (optional)                   |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |k|                                                             |
+ * +-+                   Mask [46-108] (optional)                  |
+ * |                                                               |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * The FEC packets are sent in a separate stream(a=ssrc-group:FEC-FR media fec)
+ * with its own SSRC and sequences, and the repair payload follows the header.
+ */
+
+const (
+	kFlexfecBaseHeaderSize = 12
+	kFlexfecRBit           = 0x80
+	kFlexfecFBit           = 0x40
+	kFlexfecKBit           = 0x80
+	kFlexfecMaskBits0      = 15
+	kFlexfecMaskBits1      = 46  // with the second part
+	kFlexfecMaxMaskBits    = 109 // with the third part
+	kFlexfecMaskSize0      = 2
+	kFlexfecMaskSize1      = 6
+	kFlexfecMaskSize2      = 14
+	kFlexfecDefaultL       = 10
+)
+
+// RtpFlexfecStream is the protected packets of one media SSRC in FlexFEC header.
+type RtpFlexfecStream struct {
+	SSRC    uint32
+	SeqBase uint16
+	Mask    BitSet // bit i for the packet of SeqBase+i, at most 109 bits
+}
+
+func (s *RtpFlexfecStream) maskSize() int {
+	last := -1
+	for i := range s.Mask {
+		if s.Mask[i] {
+			last = i
+		}
+	}
+	if last < kFlexfecMaskBits0 {
+		return kFlexfecMaskSize0
+	} else if last < kFlexfecMaskBits1 {
+		return kFlexfecMaskSize1
+	}
+	return kFlexfecMaskSize2
+}
+
+// flexfecMaskBits returns the number of mask bits in maskSize bytes.
+func flexfecMaskBits(maskSize int) int {
+	switch maskSize {
+	case kFlexfecMaskSize0:
+		return kFlexfecMaskBits0
+	case kFlexfecMaskSize1:
+		return kFlexfecMaskBits1
+	}
+	return kFlexfecMaxMaskBits
+}
+
+// RtpFlexfecHeader is the FlexFEC header with the recovery fields(XOR of the
+// protected packets) and the protected streams.
+type RtpFlexfecHeader struct {
+	RecoveryFlags     uint8 // P/X/CC recovery
+	RecoveryMarkerPt  uint8 // M/PT recovery
+	LengthRecovery    uint16
+	TimestampRecovery uint32
+	Streams           []RtpFlexfecStream
+}
+
+// MarshalSize returns the size of the header.
+func (h *RtpFlexfecHeader) MarshalSize() int {
+	size := kFlexfecBaseHeaderSize
+	for i := range h.Streams {
+		size += 4 + 2 + h.Streams[i].maskSize()
+	}
+	return size
+}
+
+// MarshalTo writes the header into buf, and returns the size.
+func (h *RtpFlexfecHeader) MarshalTo(buf []byte) (int, error) {
+	size := h.MarshalSize()
+	if len(buf) < size {
+		return 0, NewErrorf("FlexFEC header buffer insufficient: %d < %d", len(buf), size)
+	}
+	if len(h.Streams) == 0 || len(h.Streams) > 0xFF {
+		return 0, NewErrorf("FlexFEC streams invalid: %d", len(h.Streams))
+	}
+	buf[0] = h.RecoveryFlags & kRtpFecRecoveryMask
+	buf[1] = h.RecoveryMarkerPt
+	binary.BigEndian.PutUint16(buf[2:], h.LengthRecovery)
+	binary.BigEndian.PutUint32(buf[4:], h.TimestampRecovery)
+	buf[8] = uint8(len(h.Streams))
+	buf[9], buf[10], buf[11] = 0, 0, 0
+
+	offset := kFlexfecBaseHeaderSize
+	for i := range h.Streams {
+		stream := &h.Streams[i]
+		if len(stream.Mask) > kFlexfecMaxMaskBits && stream.Mask[kFlexfecMaxMaskBits:].Any() {
+			return 0, NewErrorf("FlexFEC mask too long: %d", len(stream.Mask))
+		}
+		binary.BigEndian.PutUint32(buf[offset:], stream.SSRC)
+		binary.BigEndian.PutUint16(buf[offset+4:], stream.SeqBase)
+		offset += 6
+
+		maskSize := stream.maskSize()
+		mask := buf[offset : offset+maskSize]
+		for j := range mask {
+			mask[j] = 0
+		}
+		// the mask bits skip the k bits at bit 0, 16 and 48
+		for bit := 0; bit < flexfecMaskBits(maskSize); bit++ {
+			if stream.Mask.Test(bit) {
+				pos := bit + 1
+				if bit >= kFlexfecMaskBits1 {
+					pos += 2
+				} else if bit >= kFlexfecMaskBits0 {
+					pos += 1
+				}
+				mask[pos/8] |= 0x80 >> uint(pos%8)
+			}
+		}
+		switch maskSize {
+		case kFlexfecMaskSize0:
+			mask[0] |= kFlexfecKBit
+		case kFlexfecMaskSize1:
+			mask[2] |= kFlexfecKBit
+		default:
+			mask[6] |= kFlexfecKBit
+		}
+		offset += maskSize
+	}
+	return offset, nil
+}
+
+// Unmarshal parses the header of one FEC payload, and returns the header size.
+func (h *RtpFlexfecHeader) Unmarshal(data []byte) (int, error) {
+	if len(data) < kFlexfecBaseHeaderSize {
+		return 0, NewErrorf("FlexFEC header insufficient: %d", len(data))
+	}
+	if data[0]&kFlexfecRBit != 0 {
+		return 0, NewErrorf("FlexFEC retransmission unsupported")
+	}
+	if data[0]&kFlexfecFBit != 0 {
+		return 0, NewErrorf("FlexFEC fixed mask unsupported")
+	}
+	h.RecoveryFlags = data[0] & kRtpFecRecoveryMask
+	h.RecoveryMarkerPt = data[1]
+	h.LengthRecovery = binary.BigEndian.Uint16(data[2:])
+	h.TimestampRecovery = binary.BigEndian.Uint32(data[4:])
+	count := int(data[8])
+	if count == 0 {
+		return 0, NewErrorf("FlexFEC SSRC count zero")
+	}
+
+	h.Streams = nil
+	offset := kFlexfecBaseHeaderSize
+	for i := 0; i < count; i++ {
+		if offset+6+kFlexfecMaskSize0 > len(data) {
+			return 0, NewErrorf("FlexFEC stream %d insufficient", i)
+		}
+		stream := RtpFlexfecStream{
+			SSRC:    binary.BigEndian.Uint32(data[offset:]),
+			SeqBase: binary.BigEndian.Uint16(data[offset+4:]),
+		}
+		offset += 6
+
+		maskSize := kFlexfecMaskSize0
+		if data[offset]&kFlexfecKBit == 0 {
+			maskSize = kFlexfecMaskSize1
+			if offset+maskSize > len(data) {
+				return 0, NewErrorf("FlexFEC mask insufficient")
+			}
+			if data[offset+2]&kFlexfecKBit == 0 {
+				maskSize = kFlexfecMaskSize2
+			}
+		}
+		if offset+maskSize > len(data) {
+			return 0, NewErrorf("FlexFEC mask insufficient")
+		}
+		mask := data[offset : offset+maskSize]
+		stream.Mask = NewBitSet(flexfecMaskBits(maskSize))
+		for bit := range stream.Mask {
+			pos := bit + 1
+			if bit >= kFlexfecMaskBits1 {
+				pos += 2
+			} else if bit >= kFlexfecMaskBits0 {
+				pos += 1
+			}
+			if mask[pos/8]&(0x80>>uint(pos%8)) != 0 {
+				stream.Mask.Set(bit)
+			}
+		}
+		h.Streams = append(h.Streams, stream)
+		offset += maskSize
+	}
+	return offset, nil
+}
+
+// RtpFlexfecRowMasks returns the masks of 1D row protection over n packets,
+// and each FEC packet protects l consecutive ones.
+func RtpFlexfecRowMasks(n, l int) []BitSet {
+	var masks []BitSet
+	for start := 0; start < n && l > 0; start += l {
+		mask := NewBitSet(n)
+		for i := start; i < Min(start+l, n); i++ {
+			mask.Set(i)
+		}
+		masks = append(masks, mask)
+	}
+	return masks
+}
+
+// RtpFlexfecColumnMasks returns the masks of 1D column protection over n
+// packets(rows of l), and each FEC packet protects the ones of every l.
+func RtpFlexfecColumnMasks(n, l int) []BitSet {
+	var masks []BitSet
+	for col := 0; col < Min(n, l); col++ {
+		mask := NewBitSet(n)
+		for i := col; i < n; i += l {
+			mask.Set(i)
+		}
+		masks = append(masks, mask)
+	}
+	return masks
+}
+
+// EncodeRtpFlexfec generates one FEC payload(header and repair payload) which
+// protects pkts of one media SSRC within 109 sequences.
+func EncodeRtpFlexfec(pkts []*RtpPacket) ([]byte, error) {
+	if len(pkts) == 0 {
+		return nil, NewErrorf("FlexFEC packets empty")
+	}
+	stream := RtpFlexfecStream{SSRC: pkts[0].SSRC, SeqBase: pkts[0].SequenceNumber}
+	for _, pkt := range pkts {
+		if pkt.SSRC != stream.SSRC {
+			return nil, NewErrorf("FlexFEC SSRC mismatch: %d, %d", pkt.SSRC, stream.SSRC)
+		}
+		if IsNewerRtpSeq(stream.SeqBase, pkt.SequenceNumber) {
+			stream.SeqBase = pkt.SequenceNumber
+		}
+	}
+	stream.Mask = NewBitSet(kFlexfecMaxMaskBits)
+	for _, pkt := range pkts {
+		offset := int(uint16(pkt.SequenceNumber - stream.SeqBase))
+		if offset >= kFlexfecMaxMaskBits {
+			return nil, NewErrorf("FlexFEC sequence out of mask: %d, %d", pkt.SequenceNumber, stream.SeqBase)
+		}
+		stream.Mask.Set(offset)
+	}
+
+	raws, err := marshalRtpFecPackets(pkts)
+	if err != nil {
+		return nil, err
+	}
+	recovery := newRtpFecRecovery(raws)
+	header := RtpFlexfecHeader{
+		RecoveryFlags:     recovery.flags,
+		RecoveryMarkerPt:  recovery.markerPt,
+		LengthRecovery:    recovery.length,
+		TimestampRecovery: recovery.timestamp,
+		Streams:           []RtpFlexfecStream{stream},
+	}
+	headerSize := header.MarshalSize()
+	buf := make([]byte, headerSize+len(recovery.payload))
+	if _, err := header.MarshalTo(buf); err != nil {
+		return nil, err
+	}
+	copy(buf[headerSize:], recovery.payload)
+	return buf, nil
+}
+
+// RtpFlexfecEncoder generates the FlexFEC packets of one media stream for each
+// block of L*D packets(L columns and D rows), with 1D row and/or column protection.
+type RtpFlexfecEncoder struct {
+	SSRC           uint32 // the FEC SSRC
+	PayloadType    uint8
+	SequenceNumber uint16 // the sequence number of the next FEC packet
+	L              int
+	D              int
+	Row            bool // one FEC packet for each row of L packets
+	Column         bool // one FEC packet for each column of D packets
+
+	packets []*RtpPacket
+}
+
+// NewRtpFlexfecEncoder creates an encoder of FEC ssrc/ptype. The row protection
+// is used if d <= 1, otherwise the column protection(for burst loss).
+func NewRtpFlexfecEncoder(ssrc uint32, ptype uint8, l, d int) *RtpFlexfecEncoder {
+	if l <= 0 {
+		l = kFlexfecDefaultL
+	}
+	l = Min(l, kFlexfecMaxMaskBits)
+	d = Min(Max(d, 1), kFlexfecMaxMaskBits/l)
+	return &RtpFlexfecEncoder{
+		SSRC:           ssrc,
+		PayloadType:    ptype,
+		SequenceNumber: uint16(RandomUint32()),
+		L:              l,
+		D:              d,
+		Row:            d <= 1,
+		Column:         d > 1,
+	}
+}
+
+// Push adds one media packet, and returns the FEC packets if one block is full.
+func (e *RtpFlexfecEncoder) Push(pkt *RtpPacket) ([]*RtpPacket, error) {
+	var fecs []*RtpPacket
+	if len(e.packets) > 0 && (pkt.SSRC != e.packets[0].SSRC ||
+		uint16(pkt.SequenceNumber-e.packets[0].SequenceNumber) >= kFlexfecMaxMaskBits) {
+		var err error
+		if fecs, err = e.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	e.packets = append(e.packets, pkt)
+	if len(e.packets) >= e.L*e.D {
+		more, err := e.Flush()
+		if err != nil {
+			return nil, err
+		}
+		fecs = append(fecs, more...)
+	}
+	return fecs, nil
+}
+
+// Flush returns the FEC packets of current packets(e.g. at the end of frame).
+func (e *RtpFlexfecEncoder) Flush() ([]*RtpPacket, error) {
+	pkts := e.packets
+	e.packets = nil
+	if len(pkts) == 0 {
+		return nil, nil
+	}
+	var masks []BitSet
+	if e.Row {
+		masks = append(masks, RtpFlexfecRowMasks(len(pkts), e.L)...)
+	}
+	if e.Column {
+		masks = append(masks, RtpFlexfecColumnMasks(len(pkts), e.L)...)
+	}
+
+	var fecs []*RtpPacket
+	for _, mask := range masks {
+		var group []*RtpPacket
+		for i, pkt := range pkts {
+			if mask.Test(i) {
+				group = append(group, pkt)
+			}
+		}
+		payload, err := EncodeRtpFlexfec(group)
+		if err != nil {
+			return nil, err
+		}
+		fecs = append(fecs, &RtpPacket{
+			RtpHeader: RtpHeader{
+				Version:        kRtpVersion,
+				PayloadType:    e.PayloadType,
+				SequenceNumber: e.SequenceNumber,
+				Timestamp:      pkts[len(pkts)-1].Timestamp,
+				SSRC:           e.SSRC,
+			},
+			Payload: payload,
+		})
+		e.SequenceNumber += 1
+	}
+	return fecs, nil
+}
+
+// RtpFlexfecReceiver recovers the lost packets of one media stream with the
+// FEC stream of a=ssrc-group:FEC-FR.
+type RtpFlexfecReceiver struct {
+	sync.Mutex
+	ProtectedSsrc uint32
+	FecSsrc       uint32
+	recoverer     *rtpFecRecoverer
+
+	Recovered int
+}
+
+// NewRtpFlexfecReceiver creates a receiver which keeps capacity(<=0 for default) media packets.
+func NewRtpFlexfecReceiver(protectedSsrc, fecSsrc uint32, capacity int) *RtpFlexfecReceiver {
+	return &RtpFlexfecReceiver{
+		ProtectedSsrc: protectedSsrc,
+		FecSsrc:       fecSsrc,
+		recoverer:     newRtpFecRecoverer(capacity),
+	}
+}
+
+// NewRtpFlexfecReceiverFromSdp creates a receiver of SdpSsrc.Main and SdpSsrc.Fec,
+// and returns nil if FlexFEC is not used.
+func NewRtpFlexfecReceiverFromSdp(ssrc *SdpSsrc, capacity int) *RtpFlexfecReceiver {
+	if ssrc == nil || ssrc.Fec == 0 {
+		return nil
+	}
+	return NewRtpFlexfecReceiver(ssrc.Main, ssrc.Fec, capacity)
+}
+
+// Push adds one packet of media or FEC SSRC, and returns the recovered media
+// packets. The packets of other SSRCs are ignored.
+func (r *RtpFlexfecReceiver) Push(pkt *RtpPacket) ([]*RtpPacket, error) {
+	switch pkt.SSRC {
+	case r.ProtectedSsrc:
+		raw, err := pkt.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		r.Lock()
+		defer r.Unlock()
+		r.recoverer.addMedia(rtpFecKey(pkt.SSRC, pkt.SequenceNumber), raw)
+		return r.recover(), nil
+	case r.FecSsrc:
+		payload := rtpPayloadWithoutPadding(pkt)
+		var header RtpFlexfecHeader
+		n, err := header.Unmarshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		fec := &rtpFecPacket{
+			recovery: &rtpFecRecovery{
+				flags:     header.RecoveryFlags,
+				markerPt:  header.RecoveryMarkerPt,
+				timestamp: header.TimestampRecovery,
+				length:    header.LengthRecovery,
+				payload:   append([]byte(nil), payload[n:]...),
+			},
+		}
+		for _, stream := range header.Streams {
+			for i := range stream.Mask {
+				if stream.Mask[i] {
+					fec.keys = append(fec.keys, rtpFecKey(stream.SSRC, stream.SeqBase+uint16(i)))
+				}
+			}
+		}
+		if len(fec.keys) == 0 {
+			return nil, NewErrorf("FlexFEC mask empty")
+		}
+		r.Lock()
+		defer r.Unlock()
+		r.recoverer.addFec(fec)
+		return r.recover(), nil
+	}
+	return nil, nil
+}
+
+func (r *RtpFlexfecReceiver) recover() []*RtpPacket {
+	pkts := r.recoverer.recover()
+	r.Recovered = r.recoverer.recovered
+	return pkts
+}
@@ -0,0 +1,475 @@
+package goutil
+
+import (
+	"encoding/binary"
+)
+
+// H.264 NAL unit types(RFC 6184 5.2).
+const (
+	H264_NAL_SLICE        uint8 = 1
+	H264_NAL_SLICE_DPA    uint8 = 2
+	H264_NAL_SLICE_DPB    uint8 = 3
+	H264_NAL_SLICE_DPC    uint8 = 4
+	H264_NAL_IDR          uint8 = 5
+	H264_NAL_SEI          uint8 = 6
+	H264_NAL_SPS          uint8 = 7
+	H264_NAL_PPS          uint8 = 8
+	H264_NAL_AUD          uint8 = 9
+	H264_NAL_END_SEQUENCE uint8 = 10
+	H264_NAL_END_STREAM   uint8 = 11
+	H264_NAL_FILLER       uint8 = 12
+	H264_NAL_STAP_A       uint8 = 24
+	H264_NAL_STAP_B       uint8 = 25
+	H264_NAL_MTAP16       uint8 = 26
+	H264_NAL_MTAP24       uint8 = 27
+	H264_NAL_FU_A         uint8 = 28
+	H264_NAL_FU_B         uint8 = 29
+)
+
+const (
+	kH264NalTypeMask     = 0x1F
+	kH264NalNriMask      = 0x60
+	kH264NalFMask        = 0x80
+	kH264FuStartBit      = 0x80
+	kH264FuEndBit        = 0x40
+	kH264FuHeaderSize    = 2
+	kH264StapAHeaderSize = 1
+	kH264NalLengthSize   = 2
+	kH264DefaultMtu      = 1200
+)
+
+// H.264 packetization modes(a=fmtp packetization-mode).
+const (
+	H264_MODE_SINGLE_NAL     = 0
+	H264_MODE_NON_INTERLEAVE = 1
+)
+
+var kH264StartCode = []byte{0, 0, 0, 1}
+
+// GetH264NalType returns the type of one NAL unit.
+func GetH264NalType(nalu []byte) uint8 {
+	if len(nalu) == 0 {
+		return 0
+	}
+	return nalu[0] & kH264NalTypeMask
+}
+
+// SplitH264AnnexB splits an Annex-B byte stream(00 00 01 or 00 00 00 01 start
+// codes) into NAL units(without start codes). Data without any start code is
+// returned as one NAL unit.
+func SplitH264AnnexB(data []byte) [][]byte {
+	var nalus [][]byte
+	start := -1
+	for i := 0; i+2 < len(data); {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			if start >= 0 {
+				end := i
+				// the 4-byte start code or trailing zeros
+				for end > start && data[end-1] == 0 {
+					end--
+				}
+				if end > start {
+					nalus = append(nalus, data[start:end])
+				}
+			}
+			i += 3
+			start = i
+			continue
+		}
+		i++
+	}
+	if start < 0 {
+		if len(data) > 0 {
+			nalus = append(nalus, data)
+		}
+	} else if start < len(data) {
+		nalus = append(nalus, data[start:])
+	}
+	return nalus
+}
+
+// H264Packetizer splits H.264 access units into RTP packets(RFC 6184): single
+// NAL unit packets, STAP-A for SPS/PPS and FU-A for the NAL units over Mtu.
+type H264Packetizer struct {
+	rtpPacketizer
+	PacketizationMode int
+}
+
+// NewH264Packetizer creates a packetizer of packetization-mode, and the mtu
+// works as newRtpPacketizer.
+func NewH264Packetizer(ssrc uint32, ptype uint8, mtu int, mode int) *H264Packetizer {
+	return &H264Packetizer{
+		rtpPacketizer:     newRtpPacketizer(ssrc, ptype, mtu, kH264DefaultMtu),
+		PacketizationMode: mode,
+	}
+}
+
+// NewH264PacketizerFromSdp creates a packetizer with the negotiated ptype and
+// its a=fmtp packetization-mode(0 if absent).
+func NewH264PacketizerFromSdp(ssrc uint32, ptype *SdpPtype, mtu int) *H264Packetizer {
+	mode := H264_MODE_SINGLE_NAL
+	if value, ok := ptype.Fmtp["packetization-mode"]; ok {
+		mode = value
+	}
+	return NewH264Packetizer(ssrc, ptype.Ptype, mtu, mode)
+}
+
+// Packetize splits one Annex-B access unit into RTP packets of timestamp, and
+// the last packet has the marker bit. AUD and filler data are dropped.
+func (p *H264Packetizer) Packetize(au []byte, timestamp uint32) ([]*RtpPacket, error) {
+	return p.PacketizeNalus(SplitH264AnnexB(au), timestamp)
+}
+
+// PacketizeNalus is like Packetize with the NAL units of one access unit.
+func (p *H264Packetizer) PacketizeNalus(nalus [][]byte, timestamp uint32) ([]*RtpPacket, error) {
+	if p.Mtu <= kH264FuHeaderSize {
+		return nil, NewErrorf("H264 mtu too small: %d", p.Mtu)
+	}
+	if p.PacketizationMode != H264_MODE_SINGLE_NAL && p.PacketizationMode != H264_MODE_NON_INTERLEAVE {
+		return nil, NewErrorf("H264 packetization-mode unsupported: %d", p.PacketizationMode)
+	}
+
+	var payloads [][]byte
+	var pending [][]byte // SPS/PPS to aggregate
+	flush := func() {
+		if len(pending) == 1 {
+			payloads = append(payloads, pending[0])
+		} else if len(pending) > 1 {
+			payloads = append(payloads, buildH264StapA(pending))
+		}
+		pending = nil
+	}
+
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		ntype := GetH264NalType(nalu)
+		if ntype == H264_NAL_AUD || ntype == H264_NAL_FILLER {
+			continue
+		}
+
+		if p.PacketizationMode == H264_MODE_SINGLE_NAL {
+			if len(nalu) > p.Mtu {
+				return nil, NewErrorf("H264 NAL unit over mtu in single NAL mode: %d > %d", len(nalu), p.Mtu)
+			}
+			payloads = append(payloads, nalu)
+			continue
+		}
+
+		if ntype == H264_NAL_SPS || ntype == H264_NAL_PPS {
+			if h264StapASize(pending)+kH264NalLengthSize+len(nalu) <= p.Mtu {
+				pending = append(pending, nalu)
+				continue
+			}
+			flush()
+			if kH264StapAHeaderSize+kH264NalLengthSize+len(nalu) <= p.Mtu {
+				pending = append(pending, nalu)
+				continue
+			}
+		}
+		flush()
+
+		if len(nalu) <= p.Mtu {
+			payloads = append(payloads, nalu)
+		} else {
+			payloads = append(payloads, buildH264FuA(nalu, p.Mtu)...)
+		}
+	}
+	flush()
+	return p.packetize(payloads, timestamp), nil
+}
+
+// h264StapASize returns the STAP-A size of nalus.
+func h264StapASize(nalus [][]byte) int {
+	size := kH264StapAHeaderSize
+	for _, nalu := range nalus {
+		size += kH264NalLengthSize + len(nalu)
+	}
+	return size
+}
+
+/*
+ * STAP-A(RFC 6184 5.7.1):
+ *
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |STAP-A NAL HDR |         NALU 1 Size           | NALU 1 HDR    |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                         NALU 1 Data                           |
+ * :                                                               :
+ * +               +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |               | NALU 2 Size                   | NALU 2 HDR    |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                         NALU 2 Data                           |
+ * :                                                               :
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ */
+func buildH264StapA(nalus [][]byte) []byte {
+	buf := make([]byte, h264StapASize(nalus))
+	var nri uint8
+	n := kH264StapAHeaderSize
+	for _, nalu := range nalus {
+		if nalu[0]&kH264NalNriMask > nri {
+			nri = nalu[0] & kH264NalNriMask
+		}
+		binary.BigEndian.PutUint16(buf[n:], uint16(len(nalu)))
+		n += kH264NalLengthSize
+		n += copy(buf[n:], nalu)
+	}
+	buf[0] = nri | H264_NAL_STAP_A
+	return buf
+}
+
+/*
+ * FU-A(RFC 6184 5.8):
+ *
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * | FU indicator  |   FU header   |                               |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+                               |
+ * |                         FU payload                            |
+ * |                                                               |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * FU indicator: |F|NRI|Type=28|, FU header: |S|E|R|Type|
+ */
+func buildH264FuA(nalu []byte, mtu int) [][]byte {
+	indicator := (nalu[0] & (kH264NalFMask | kH264NalNriMask)) | H264_NAL_FU_A
+	ntype := nalu[0] & kH264NalTypeMask
+	fragments := splitRtpFragments(nalu[1:], mtu-kH264FuHeaderSize, 0)
+
+	payloads := make([][]byte, 0, len(fragments))
+	for idx, fragment := range fragments {
+		header := ntype
+		if idx == 0 {
+			header |= kH264FuStartBit
+		}
+		if idx == len(fragments)-1 {
+			header |= kH264FuEndBit
+		}
+		payload := make([]byte, kH264FuHeaderSize+len(fragment))
+		payload[0] = indicator
+		payload[1] = header
+		copy(payload[kH264FuHeaderSize:], fragment)
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+// H.264 access unit formats.
+const (
+	H264_FORMAT_ANNEXB = 0 // start code prefixed
+	H264_FORMAT_AVCC   = 1 // 4-byte length prefixed
+)
+
+// H264Frame is one access unit rebuilt from RTP packets.
+type H264Frame struct {
+	Timestamp uint32
+	Nalus     [][]byte
+	HasSps    bool
+	HasPps    bool
+	HasIdr    bool
+	Keyframe  bool // has IDR slices
+}
+
+func (f *H264Frame) addNalu(nalu []byte) {
+	if len(nalu) == 0 {
+		return
+	}
+	switch GetH264NalType(nalu) {
+	case H264_NAL_SPS:
+		f.HasSps = true
+	case H264_NAL_PPS:
+		f.HasPps = true
+	case H264_NAL_IDR:
+		f.HasIdr = true
+		f.Keyframe = true
+	}
+	f.Nalus = append(f.Nalus, nalu)
+}
+
+// IsDecodable checks whether the frame has SPS+PPS+IDR, e.g. for late joiners.
+func (f *H264Frame) IsDecodable() bool {
+	return f.HasSps && f.HasPps && f.HasIdr
+}
+
+// Bytes returns the access unit in format(H264_FORMAT_ANNEXB/AVCC).
+func (f *H264Frame) Bytes(format int) []byte {
+	return joinNalUnits(f.Nalus, format == H264_FORMAT_AVCC)
+}
+
+// joinNalUnits joins NAL units with start codes or 4-byte lengths.
+func joinNalUnits(nalus [][]byte, lengthPrefixed bool) []byte {
+	size := 0
+	for _, nalu := range nalus {
+		size += len(kH264StartCode) + len(nalu)
+	}
+	buf := make([]byte, 0, size)
+	for _, nalu := range nalus {
+		if lengthPrefixed {
+			var length [4]byte
+			binary.BigEndian.PutUint32(length[:], uint32(len(nalu)))
+			buf = append(buf, length[:]...)
+		} else {
+			buf = append(buf, kH264StartCode...)
+		}
+		buf = append(buf, nalu...)
+	}
+	return buf
+}
+
+// AnnexB returns the access unit with start codes.
+func (f *H264Frame) AnnexB() []byte {
+	return f.Bytes(H264_FORMAT_ANNEXB)
+}
+
+// Avcc returns the access unit with 4-byte lengths.
+func (f *H264Frame) Avcc() []byte {
+	return f.Bytes(H264_FORMAT_AVCC)
+}
+
+// ParseH264Payload returns the complete NAL units of one RTP payload(single NAL,
+// STAP-A/B or MTAP16/24). The FU-A/B payloads are handled by H264Depacketizer.
+func ParseH264Payload(payload []byte) ([][]byte, error) {
+	if len(payload) == 0 {
+		return nil, NewErrorf("H264 payload empty")
+	}
+
+	ntype := payload[0] & kH264NalTypeMask
+	switch {
+	case ntype >= H264_NAL_SLICE && ntype < H264_NAL_STAP_A:
+		return [][]byte{payload}, nil
+	case ntype == H264_NAL_STAP_A:
+		return parseH264Aggregation(payload[1:], 0)
+	case ntype == H264_NAL_STAP_B:
+		if len(payload) < 3 {
+			return nil, NewErrorf("H264 STAP-B insufficient: %d", len(payload))
+		}
+		return parseH264Aggregation(payload[3:], 0) // skip DON
+	case ntype == H264_NAL_MTAP16:
+		if len(payload) < 3 {
+			return nil, NewErrorf("H264 MTAP16 insufficient: %d", len(payload))
+		}
+		return parseH264Aggregation(payload[3:], 3) // skip DONB, DOND+TS offset(16)
+	case ntype == H264_NAL_MTAP24:
+		if len(payload) < 3 {
+			return nil, NewErrorf("H264 MTAP24 insufficient: %d", len(payload))
+		}
+		return parseH264Aggregation(payload[3:], 4) // skip DONB, DOND+TS offset(24)
+	}
+	return nil, NewErrorf("H264 payload type unsupported: %d", ntype)
+}
+
+// parseH264Aggregation parses the units of [16-bit size, skip bytes, NAL unit].
+func parseH264Aggregation(data []byte, skip int) ([][]byte, error) {
+	var nalus [][]byte
+	for len(data) > 0 {
+		if len(data) < kH264NalLengthSize {
+			return nil, NewErrorf("H264 aggregation size insufficient: %d", len(data))
+		}
+		size := int(binary.BigEndian.Uint16(data))
+		data = data[kH264NalLengthSize:]
+		if size < skip || size > len(data) {
+			return nil, NewErrorf("H264 aggregation unit invalid: %d of %d", size, len(data))
+		}
+		if size > skip {
+			nalus = append(nalus, data[skip:size])
+		}
+		data = data[size:]
+	}
+	return nalus, nil
+}
+
+// H264Depacketizer rebuilds H.264 access units from in-order RTP packets(e.g.
+// from JitterBuffer), and the frames with lost packets are dropped.
+type H264Depacketizer struct {
+	rtpFrameAssembler
+	frame    *H264Frame
+	frames   []*H264Frame // completed in Push
+	fuBuffer []byte
+}
+
+func NewH264Depacketizer() *H264Depacketizer {
+	return &H264Depacketizer{}
+}
+
+// Push adds one RTP packet, and returns the frames completed by marker bit or
+// timestamp change.
+func (d *H264Depacketizer) Push(pkt *RtpPacket) ([]*H264Frame, error) {
+	err := d.push(d, pkt)
+	frames := d.frames
+	d.frames = nil
+	return frames, err
+}
+
+func (d *H264Depacketizer) parsePacket(pkt *RtpPacket) (bool, bool, error) {
+	return false, false, nil
+}
+
+func (d *H264Depacketizer) newFrame(pkt *RtpPacket, lost bool) bool {
+	d.frame = &H264Frame{Timestamp: pkt.Timestamp}
+	d.fuBuffer = nil
+	// the lost packets might be the head of this frame
+	return !lost
+}
+
+func (d *H264Depacketizer) addPacket(pkt *RtpPacket) error {
+	return d.pushPayload(rtpPayloadWithoutPadding(pkt))
+}
+
+func (d *H264Depacketizer) pushPayload(payload []byte) error {
+	if len(payload) == 0 {
+		return NewErrorf("H264 payload empty")
+	}
+
+	ntype := payload[0] & kH264NalTypeMask
+	if ntype != H264_NAL_FU_A && ntype != H264_NAL_FU_B {
+		nalus, err := ParseH264Payload(payload)
+		if err != nil {
+			return err
+		}
+		for _, nalu := range nalus {
+			d.frame.addNalu(append([]byte(nil), nalu...))
+		}
+		return nil
+	}
+
+	if len(payload) < kH264FuHeaderSize {
+		return NewErrorf("H264 FU insufficient: %d", len(payload))
+	}
+	indicator, header := payload[0], payload[1]
+	data := payload[kH264FuHeaderSize:]
+	if ntype == H264_NAL_FU_B {
+		if len(data) < 2 {
+			return NewErrorf("H264 FU-B insufficient: %d", len(payload))
+		}
+		data = data[2:] // skip DON
+	}
+
+	if header&kH264FuStartBit != 0 {
+		d.fuBuffer = make([]byte, 0, 1+len(data))
+		d.fuBuffer = append(d.fuBuffer, (indicator&(kH264NalFMask|kH264NalNriMask))|(header&kH264NalTypeMask))
+	} else if d.fuBuffer == nil {
+		// the start fragment is lost
+		return nil
+	}
+	d.fuBuffer = append(d.fuBuffer, data...)
+	if header&kH264FuEndBit != 0 {
+		d.frame.addNalu(d.fuBuffer)
+		d.fuBuffer = nil
+	}
+	return nil
+}
+
+func (d *H264Depacketizer) finishFrame(broken bool) bool {
+	frame := d.frame
+	d.frame = nil
+	if broken || d.fuBuffer != nil || len(frame.Nalus) == 0 {
+		return false
+	}
+	d.frames = append(d.frames, frame)
+	return true
+}
@@ -0,0 +1,339 @@
+package goutil
+
+import (
+	"encoding/binary"
+)
+
+/*
+ * H.265 NAL unit header / RTP payload header(RFC 7798 1.1.4):
+ *
+ * +---------------+---------------+
+ * |0|1|2|3|4|5|6|7|0|1|2|3|4|5|6|7|
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |F|   Type    |  LayerId  | TID |
+ * +-------------+-----------------+
+ */
+
+const (
+	kH265FMask          = 0x80
+	kH265TypeHeaderMask = 0x7E00 // of 16-bit header
+	kH265LayerIdMask    = 0x01F8 // of 16-bit header
+	kH265TidMask        = 0x07
+	kH265FuHeaderSize   = 3 // payload header + FU header
+	kH265FuStartBit     = 0x80
+	kH265FuEndBit       = 0x40
+	kH265FuTypeMask     = 0x3F
+	kH265NalLengthSize  = 2
+	kH265DefaultMtu     = 1200
+	kH265ApHeaderSize   = kH265NalHeaderSize
+	kH265MaxLayerIdTid  = 0xFFFF
+	kH265LayerIdTidMask = kH265LayerIdMask | kH265TidMask
+)
+
+// H265Packetizer splits H.265 access units into RTP packets(RFC 7798): single
+// NAL unit packets, AP for VPS/SPS/PPS and FU for the NAL units over Mtu. DONL
+// is not used(sprop-max-don-diff=0).
+type H265Packetizer struct {
+	rtpPacketizer
+}
+
+// NewH265Packetizer creates a packetizer, and the mtu works as newRtpPacketizer.
+func NewH265Packetizer(ssrc uint32, ptype uint8, mtu int) *H265Packetizer {
+	return &H265Packetizer{
+		rtpPacketizer: newRtpPacketizer(ssrc, ptype, mtu, kH265DefaultMtu),
+	}
+}
+
+// Packetize splits one Annex-B access unit into RTP packets of timestamp, and
+// the last packet has the marker bit. AUD and filler data are dropped.
+func (p *H265Packetizer) Packetize(au []byte, timestamp uint32) ([]*RtpPacket, error) {
+	// the same start codes as H.264
+	return p.PacketizeNalus(SplitH264AnnexB(au), timestamp)
+}
+
+// PacketizeNalus is like Packetize with the NAL units of one access unit.
+func (p *H265Packetizer) PacketizeNalus(nalus [][]byte, timestamp uint32) ([]*RtpPacket, error) {
+	if p.Mtu <= kH265FuHeaderSize {
+		return nil, NewErrorf("H265 mtu too small: %d", p.Mtu)
+	}
+
+	var payloads [][]byte
+	var pending [][]byte // VPS/SPS/PPS to aggregate
+	flush := func() {
+		if len(pending) == 1 {
+			payloads = append(payloads, pending[0])
+		} else if len(pending) > 1 {
+			payloads = append(payloads, buildH265Ap(pending))
+		}
+		pending = nil
+	}
+
+	for _, nalu := range nalus {
+		if len(nalu) < kH265NalHeaderSize {
+			continue
+		}
+		ntype := GetH265NalType(nalu)
+		if ntype == H265_NAL_AUD || ntype == H265_NAL_FD {
+			continue
+		}
+
+		if ntype == H265_NAL_VPS || ntype == H265_NAL_SPS || ntype == H265_NAL_PPS {
+			if h265ApSize(pending)+kH265NalLengthSize+len(nalu) <= p.Mtu {
+				pending = append(pending, nalu)
+				continue
+			}
+			flush()
+			if kH265ApHeaderSize+kH265NalLengthSize+len(nalu) <= p.Mtu {
+				pending = append(pending, nalu)
+				continue
+			}
+		}
+		flush()
+
+		if len(nalu) <= p.Mtu {
+			payloads = append(payloads, nalu)
+		} else {
+			payloads = append(payloads, buildH265Fu(nalu, p.Mtu)...)
+		}
+	}
+	flush()
+	return p.packetize(payloads, timestamp), nil
+}
+
+// h265ApSize returns the AP size of nalus.
+func h265ApSize(nalus [][]byte) int {
+	size := kH265ApHeaderSize
+	for _, nalu := range nalus {
+		size += kH265NalLengthSize + len(nalu)
+	}
+	return size
+}
+
+/*
+ * AP(RFC 7798 4.4.2):
+ *
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |    PayloadHdr (Type=48)       |         NALU 1 Size           |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |          NALU 1 HDR           |                               |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+         NALU 1 Data           |
+ * |                   . . .                                       |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |  . . .        | NALU 2 Size                   | NALU 2 HDR    |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * | NALU 2 HDR    |                                               |
+ * +-+-+-+-+-+-+-+-+              NALU 2 Data                      |
+ * |                   . . .                                       |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ */
+func buildH265Ap(nalus [][]byte) []byte {
+	buf := make([]byte, h265ApSize(nalus))
+	var fbit uint8
+	var layerIdTid uint16 = kH265MaxLayerIdTid
+	n := kH265ApHeaderSize
+	for _, nalu := range nalus {
+		fbit |= nalu[0] & kH265FMask
+		// the lowest LayerId and TID
+		header := binary.BigEndian.Uint16(nalu)
+		if header&kH265LayerIdMask < layerIdTid&kH265LayerIdMask {
+			layerIdTid = (layerIdTid &^ kH265LayerIdMask) | (header & kH265LayerIdMask)
+		}
+		if header&kH265TidMask < layerIdTid&kH265TidMask {
+			layerIdTid = (layerIdTid &^ kH265TidMask) | (header & kH265TidMask)
+		}
+		binary.BigEndian.PutUint16(buf[n:], uint16(len(nalu)))
+		n += kH265NalLengthSize
+		n += copy(buf[n:], nalu)
+	}
+	header := uint16(fbit)<<8 | uint16(H265_NAL_AP)<<9 | (layerIdTid & kH265LayerIdTidMask)
+	binary.BigEndian.PutUint16(buf, header)
+	return buf
+}
+
+/*
+ * FU(RFC 7798 4.4.3):
+ *
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |    PayloadHdr (Type=49)       |   FU header   |               |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+               |
+ * |                         FU payload                            |
+ * |                                                               |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * FU header: |S|E|  FuType   |
+ */
+func buildH265Fu(nalu []byte, mtu int) [][]byte {
+	header := binary.BigEndian.Uint16(nalu)
+	payloadHdr := (header &^ kH265TypeHeaderMask) | uint16(H265_NAL_FU)<<9
+	ntype := GetH265NalType(nalu)
+	fragments := splitRtpFragments(nalu[kH265NalHeaderSize:], mtu-kH265FuHeaderSize, 0)
+
+	payloads := make([][]byte, 0, len(fragments))
+	for idx, fragment := range fragments {
+		fuHeader := ntype
+		if idx == 0 {
+			fuHeader |= kH265FuStartBit
+		}
+		if idx == len(fragments)-1 {
+			fuHeader |= kH265FuEndBit
+		}
+		payload := make([]byte, kH265FuHeaderSize+len(fragment))
+		binary.BigEndian.PutUint16(payload, payloadHdr)
+		payload[2] = fuHeader
+		copy(payload[kH265FuHeaderSize:], fragment)
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+// ParseH265Payload returns the complete NAL units of one RTP payload(single NAL
+// or AP without DONL). The FU payloads are handled by H265Depacketizer.
+func ParseH265Payload(payload []byte) ([][]byte, error) {
+	if len(payload) < kH265NalHeaderSize {
+		return nil, NewErrorf("H265 payload insufficient: %d", len(payload))
+	}
+
+	ntype := GetH265NalType(payload)
+	switch {
+	case ntype < H265_NAL_AP:
+		return [][]byte{payload}, nil
+	case ntype == H265_NAL_AP:
+		nalus, err := parseH264Aggregation(payload[kH265ApHeaderSize:], 0)
+		if err != nil {
+			return nil, NewError2(err, "H265 AP invalid")
+		}
+		return nalus, nil
+	}
+	return nil, NewErrorf("H265 payload type unsupported: %d", ntype)
+}
+
+// H265Frame is one access unit rebuilt from RTP packets.
+type H265Frame struct {
+	Timestamp uint32
+	Nalus     [][]byte
+	HasVps    bool
+	HasSps    bool
+	HasPps    bool
+	Keyframe  bool // has IRAP pictures
+}
+
+func (f *H265Frame) addNalu(nalu []byte) {
+	if len(nalu) < kH265NalHeaderSize {
+		return
+	}
+	switch ntype := GetH265NalType(nalu); {
+	case ntype == H265_NAL_VPS:
+		f.HasVps = true
+	case ntype == H265_NAL_SPS:
+		f.HasSps = true
+	case ntype == H265_NAL_PPS:
+		f.HasPps = true
+	case IsH265Irap(ntype):
+		f.Keyframe = true
+	}
+	f.Nalus = append(f.Nalus, nalu)
+}
+
+// IsDecodable checks whether the frame has VPS+SPS+PPS+IRAP, e.g. for late joiners.
+func (f *H265Frame) IsDecodable() bool {
+	return f.HasVps && f.HasSps && f.HasPps && f.Keyframe
+}
+
+// AnnexB returns the access unit with start codes.
+func (f *H265Frame) AnnexB() []byte {
+	return joinNalUnits(f.Nalus, false)
+}
+
+// Hvcc returns the access unit with 4-byte lengths.
+func (f *H265Frame) Hvcc() []byte {
+	return joinNalUnits(f.Nalus, true)
+}
+
+// H265Depacketizer rebuilds H.265 access units from in-order RTP packets(e.g.
+// from JitterBuffer), and the frames with lost packets are dropped.
+type H265Depacketizer struct {
+	rtpFrameAssembler
+	frame    *H265Frame
+	frames   []*H265Frame // completed in Push
+	fuBuffer []byte
+}
+
+func NewH265Depacketizer() *H265Depacketizer {
+	return &H265Depacketizer{}
+}
+
+// Push adds one RTP packet, and returns the frames completed by marker bit or
+// timestamp change.
+func (d *H265Depacketizer) Push(pkt *RtpPacket) ([]*H265Frame, error) {
+	err := d.push(d, pkt)
+	frames := d.frames
+	d.frames = nil
+	return frames, err
+}
+
+func (d *H265Depacketizer) parsePacket(pkt *RtpPacket) (bool, bool, error) {
+	return false, false, nil
+}
+
+func (d *H265Depacketizer) newFrame(pkt *RtpPacket, lost bool) bool {
+	d.frame = &H265Frame{Timestamp: pkt.Timestamp}
+	d.fuBuffer = nil
+	// the lost packets might be the head of this frame
+	return !lost
+}
+
+func (d *H265Depacketizer) addPacket(pkt *RtpPacket) error {
+	return d.pushPayload(rtpPayloadWithoutPadding(pkt))
+}
+
+func (d *H265Depacketizer) pushPayload(payload []byte) error {
+	if len(payload) < kH265NalHeaderSize {
+		return NewErrorf("H265 payload insufficient: %d", len(payload))
+	}
+
+	if GetH265NalType(payload) != H265_NAL_FU {
+		nalus, err := ParseH265Payload(payload)
+		if err != nil {
+			return err
+		}
+		for _, nalu := range nalus {
+			d.frame.addNalu(append([]byte(nil), nalu...))
+		}
+		return nil
+	}
+
+	if len(payload) < kH265FuHeaderSize {
+		return NewErrorf("H265 FU insufficient: %d", len(payload))
+	}
+	fuHeader := payload[2]
+	if fuHeader&kH265FuStartBit != 0 {
+		// rebuild NAL header with FuType
+		header := binary.BigEndian.Uint16(payload)
+		header = (header &^ kH265TypeHeaderMask) | uint16(fuHeader&kH265FuTypeMask)<<9
+		d.fuBuffer = make([]byte, kH265NalHeaderSize, len(payload))
+		binary.BigEndian.PutUint16(d.fuBuffer, header)
+	} else if d.fuBuffer == nil {
+		// the start fragment is lost
+		return nil
+	}
+	d.fuBuffer = append(d.fuBuffer, payload[kH265FuHeaderSize:]...)
+	if fuHeader&kH265FuEndBit != 0 {
+		d.frame.addNalu(d.fuBuffer)
+		d.fuBuffer = nil
+	}
+	return nil
+}
+
+func (d *H265Depacketizer) finishFrame(broken bool) bool {
+	frame := d.frame
+	d.frame = nil
+	if broken || d.fuBuffer != nil || len(frame.Nalus) == 0 {
+		return false
+	}
+	d.frames = append(d.frames, frame)
+	return true
+}
@@ -0,0 +1,366 @@
+package goutil
+
+import (
+	"sync"
+)
+
+const (
+	kJitterDefaultMinDelayMs   int64   = 20
+	kJitterDefaultMaxDelayMs   int64   = 500
+	kJitterDefaultMaxLatencyMs int64   = 1000
+	kJitterDelayFactor         float64 = 3.0
+	kJitterMaxMissingSeqs              = 1000
+	kJitterMaxPacketNum                = 4096
+)
+
+// JitterBufferStats is the counters of JitterBuffer.
+type JitterBufferStats struct {
+	Inserted   uint32 // packets inserted
+	Late       uint32 // packets arrived after its sequence was released
+	Duplicates uint32 // packets already in buffer
+	Dropped    uint32 // packets dropped by max-latency or incomplete frames
+	Lost       uint32 // sequences skipped without packet
+}
+
+// JitterFrame is one complete frame(same timestamp, ending with marker bit).
+type JitterFrame struct {
+	Timestamp uint32
+	Packets   []*RtpPacket
+}
+
+type jitterPacket struct {
+	pkt       *RtpPacket
+	seq       int64 // unwrapped sequence number
+	ts        int64 // unwrapped timestamp
+	arrivalMs int64
+}
+
+// JitterBuffer reorders RTP packets of one stream and releases them in order,
+// packet by packet(PopPackets) or frame by frame(PopFrame), after the target
+// delay. The target delay adapts to the measured interarrival jitter within
+// [minDelay, maxDelay], and the packets older than max latency are dropped.
+// NOTE: the packets are kept by reference, so their buffers must not be reused.
+type JitterBuffer struct {
+	sync.Mutex
+	frequency    uint32
+	minDelayMs   int64
+	maxDelayMs   int64
+	maxLatencyMs int64
+
+	seqUnwrapper SeqUnwrapper
+	tsUnwrapper  TimestampUnwrapper
+	packets      map[int64]*jitterPacket
+	nextSeq      int64 // the next sequence to release
+	highestSeq   int64
+	started      bool
+	released     bool
+
+	// interarrival jitter(ms) as RFC 3550 A.8
+	jitterMs   float64
+	transitMs  float64
+	hasTransit bool
+	targetMs   int64
+
+	stats JitterBufferStats
+}
+
+// NewJitterBuffer creates a jitter buffer for the clock rate(frequency),
+// zero values of delays are replaced by the defaults.
+func NewJitterBuffer(frequency uint32, minDelayMs, maxDelayMs, maxLatencyMs int64) *JitterBuffer {
+	if minDelayMs <= 0 {
+		minDelayMs = kJitterDefaultMinDelayMs
+	}
+	if maxDelayMs <= 0 {
+		maxDelayMs = kJitterDefaultMaxDelayMs
+	}
+	if maxDelayMs < minDelayMs {
+		maxDelayMs = minDelayMs
+	}
+	if maxLatencyMs <= 0 {
+		maxLatencyMs = kJitterDefaultMaxLatencyMs
+	}
+	if maxLatencyMs < maxDelayMs {
+		maxLatencyMs = maxDelayMs
+	}
+	return &JitterBuffer{
+		frequency:    frequency,
+		minDelayMs:   minDelayMs,
+		maxDelayMs:   maxDelayMs,
+		maxLatencyMs: maxLatencyMs,
+		packets:      make(map[int64]*jitterPacket),
+		targetMs:     minDelayMs,
+	}
+}
+
+// Insert adds one packet with its arrival time(ms), and returns false if it is
+// late or duplicated.
+func (j *JitterBuffer) Insert(pkt *RtpPacket, arrivalMs int64) bool {
+	j.Lock()
+	defer j.Unlock()
+
+	seq := j.seqUnwrapper.Unwrap(pkt.SequenceNumber)
+	ts := j.tsUnwrapper.Unwrap(pkt.Timestamp)
+	if !j.started {
+		j.started = true
+		j.nextSeq = seq
+		j.highestSeq = seq
+	}
+
+	if j.nextSeq-seq > kJitterMaxMissingSeqs || seq-j.highestSeq >= kJitterMaxPacketNum {
+		// the sender is restarted or jumps, so restart at seq
+		j.reset(seq)
+	} else if seq < j.nextSeq {
+		if j.released {
+			j.stats.Late += 1
+			return false
+		}
+		// reordered before the first packet
+		j.nextSeq = seq
+	}
+	if _, ok := j.packets[seq]; ok {
+		j.stats.Duplicates += 1
+		return false
+	}
+
+	j.packets[seq] = &jitterPacket{pkt, seq, ts, arrivalMs}
+	j.stats.Inserted += 1
+	if seq > j.highestSeq {
+		j.highestSeq = seq
+		j.updateJitter(ts, arrivalMs)
+	}
+	j.dropExpired()
+	return true
+}
+
+// reset drops the buffered packets and restarts at seq.
+func (j *JitterBuffer) reset(seq int64) {
+	j.stats.Dropped += uint32(len(j.packets))
+	j.packets = make(map[int64]*jitterPacket)
+	j.nextSeq = seq
+	j.highestSeq = seq
+	j.released = false
+	j.hasTransit = false
+}
+
+// updateJitter updates the interarrival jitter and target delay with in-order packets.
+func (j *JitterBuffer) updateJitter(ts int64, arrivalMs int64) {
+	if j.frequency == 0 {
+		return
+	}
+	transitMs := float64(arrivalMs) - float64(ts)*1000/float64(j.frequency)
+	if j.hasTransit {
+		d := transitMs - j.transitMs
+		if d < 0 {
+			d = -d
+		}
+		j.jitterMs += (d - j.jitterMs) / 16.0
+	}
+	j.transitMs = transitMs
+	j.hasTransit = true
+
+	target := int64(j.jitterMs * kJitterDelayFactor)
+	if target < j.minDelayMs {
+		target = j.minDelayMs
+	} else if target > j.maxDelayMs {
+		target = j.maxDelayMs
+	}
+	j.targetMs = target
+}
+
+// dropExpired drops the oldest packets when the buffered timestamp span exceeds
+// the max latency, or too many packets are buffered. The highest packet is kept.
+func (j *JitterBuffer) dropExpired() {
+	highest, ok := j.packets[j.highestSeq]
+	if !ok {
+		return
+	}
+	maxSpan := j.maxLatencyMs * int64(j.frequency) / 1000
+	for len(j.packets) > 1 {
+		oldest := j.oldestPacket()
+		if oldest == nil || oldest == highest {
+			break
+		}
+		if len(j.packets) <= kJitterMaxPacketNum &&
+			(j.frequency == 0 || highest.ts-oldest.ts <= maxSpan) &&
+			j.highestSeq-oldest.seq < kJitterMaxPacketNum {
+			break
+		}
+		j.skipTo(oldest.seq)
+		delete(j.packets, oldest.seq)
+		j.stats.Dropped += 1
+		j.nextSeq = oldest.seq + 1
+		j.released = true
+	}
+}
+
+// oldestPacket returns the buffered packet of the lowest sequence.
+func (j *JitterBuffer) oldestPacket() *jitterPacket {
+	if len(j.packets) == 0 {
+		return nil
+	}
+	for seq := j.nextSeq; seq <= j.highestSeq; seq++ {
+		if item, ok := j.packets[seq]; ok {
+			return item
+		}
+	}
+	return nil
+}
+
+// skipTo moves nextSeq forward to seq, counting the lost sequences.
+func (j *JitterBuffer) skipTo(seq int64) {
+	for ; j.nextSeq < seq; j.nextSeq++ {
+		if _, ok := j.packets[j.nextSeq]; ok {
+			delete(j.packets, j.nextSeq)
+			j.stats.Dropped += 1
+		} else {
+			j.stats.Lost += 1
+		}
+	}
+}
+
+// release removes the packet of nextSeq and moves forward.
+func (j *JitterBuffer) release() *RtpPacket {
+	item := j.packets[j.nextSeq]
+	delete(j.packets, j.nextSeq)
+	j.nextSeq += 1
+	j.released = true
+	return item.pkt
+}
+
+// PopPackets returns the in-order packets whose target delay has passed. A gap
+// is skipped(as lost) when the oldest packet after it has waited the target delay.
+func (j *JitterBuffer) PopPackets(nowMs int64) []*RtpPacket {
+	j.Lock()
+	defer j.Unlock()
+
+	var pkts []*RtpPacket
+	for len(j.packets) > 0 {
+		if item, ok := j.packets[j.nextSeq]; ok {
+			if nowMs-item.arrivalMs < j.targetMs {
+				break
+			}
+			pkts = append(pkts, j.release())
+			continue
+		}
+
+		oldest := j.oldestPacket()
+		if oldest == nil || nowMs-oldest.arrivalMs < j.targetMs {
+			break
+		}
+		j.skipTo(oldest.seq)
+	}
+	return pkts
+}
+
+// PopFrame returns the next complete frame whose target delay has passed, or nil.
+// An incomplete frame is dropped when a newer frame has waited the target delay.
+func (j *JitterBuffer) PopFrame(nowMs int64) *JitterFrame {
+	j.Lock()
+	defer j.Unlock()
+
+	for len(j.packets) > 0 {
+		if end, ok := j.completeFrame(j.nextSeq); ok {
+			first := j.packets[j.nextSeq]
+			if nowMs-first.arrivalMs < j.targetMs {
+				return nil
+			}
+			frame := &JitterFrame{Timestamp: first.pkt.Timestamp}
+			for j.nextSeq <= end {
+				frame.Packets = append(frame.Packets, j.release())
+			}
+			return frame
+		}
+
+		next, ok := j.nextFrameStart(j.nextSeq + 1)
+		if !ok {
+			return nil
+		}
+		if item := j.packets[next]; nowMs-item.arrivalMs < j.targetMs {
+			return nil
+		}
+		j.skipTo(next)
+	}
+	return nil
+}
+
+// completeFrame returns the last sequence of the complete frame starting at seq.
+func (j *JitterBuffer) completeFrame(seq int64) (int64, bool) {
+	first, ok := j.packets[seq]
+	if !ok {
+		return 0, false
+	}
+	for end := seq; end <= j.highestSeq; end++ {
+		item, ok := j.packets[end]
+		if !ok {
+			return 0, false
+		}
+		if item.ts != first.ts {
+			// the previous frame ended without marker bit
+			return end - 1, true
+		}
+		if item.pkt.Marker {
+			return end, true
+		}
+	}
+	return 0, false
+}
+
+// nextFrameStart returns the first buffered sequence(>= seq) which begins a frame,
+// i.e. the previous packet is present with marker bit or a different timestamp.
+func (j *JitterBuffer) nextFrameStart(seq int64) (int64, bool) {
+	for ; seq <= j.highestSeq; seq++ {
+		item, ok := j.packets[seq]
+		if !ok {
+			continue
+		}
+		if prev, ok := j.packets[seq-1]; ok {
+			if prev.pkt.Marker || prev.ts != item.ts {
+				return seq, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// MissingSeqs returns the sequences not received between the released and the
+// highest, e.g. for NACK requests.
+func (j *JitterBuffer) MissingSeqs() []uint16 {
+	j.Lock()
+	defer j.Unlock()
+
+	var seqs []uint16
+	for seq := j.nextSeq; seq < j.highestSeq && len(seqs) < kJitterMaxMissingSeqs; seq++ {
+		if _, ok := j.packets[seq]; !ok {
+			seqs = append(seqs, uint16(seq))
+		}
+	}
+	return seqs
+}
+
+// TargetDelayMs returns the current adaptive playout delay.
+func (j *JitterBuffer) TargetDelayMs() int64 {
+	j.Lock()
+	defer j.Unlock()
+	return j.targetMs
+}
+
+// JitterMs returns the measured interarrival jitter in milliseconds.
+func (j *JitterBuffer) JitterMs() float64 {
+	j.Lock()
+	defer j.Unlock()
+	return j.jitterMs
+}
+
+// Len returns the number of buffered packets.
+func (j *JitterBuffer) Len() int {
+	j.Lock()
+	defer j.Unlock()
+	return len(j.packets)
+}
+
+// Stats returns the counters.
+func (j *JitterBuffer) Stats() JitterBufferStats {
+	j.Lock()
+	defer j.Unlock()
+	return j.stats
+}
@@ -0,0 +1,177 @@
+package goutil
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	kNackDefaultMaxRetries = 10
+	kNackDefaultRttMs      = 100
+	kNackMinRetryMs        = 10    // the min interval between two requests of one packet
+	kNackMaxListSize       = 1000  // the max number of missing packets tracked
+	kNackMaxPacketAge      = 10000 // the max sequence distance from the newest packet
+)
+
+type nackInfo struct {
+	sentMs  int64 // the last request time
+	retries int   // the number of requests sent
+}
+
+// NackGenerator tracks the gaps of one received RTP stream, and produces the
+// Generic NACK lists(RFC 4585) with RTT-based retry timing.
+type NackGenerator struct {
+	sync.Mutex
+	senderSsrc uint32
+	mediaSsrc  uint32
+	maxRetries int
+	rttMs      int64
+
+	unwrapper SeqUnwrapper
+	newestSeq int64
+	started   bool
+	nacks     map[int64]*nackInfo
+
+	Recovered uint32 // missing packets received later
+	GaveUp    uint32 // missing packets dropped after max retries or too old
+}
+
+// NewNackGenerator creates a NACK generator for mediaSsrc, and maxRetries(<=0
+// for default) limits the requests of each missing packet.
+func NewNackGenerator(senderSsrc, mediaSsrc uint32, maxRetries int) *NackGenerator {
+	if maxRetries <= 0 {
+		maxRetries = kNackDefaultMaxRetries
+	}
+	return &NackGenerator{
+		senderSsrc: senderSsrc,
+		mediaSsrc:  mediaSsrc,
+		maxRetries: maxRetries,
+		rttMs:      kNackDefaultRttMs,
+		nacks:      make(map[int64]*nackInfo),
+	}
+}
+
+// UpdateRtt sets the round trip time used as the retry interval.
+func (n *NackGenerator) UpdateRtt(rttMs int64) {
+	n.Lock()
+	defer n.Unlock()
+	if rttMs > 0 {
+		n.rttMs = rttMs
+	}
+}
+
+// OnRtpPacket records one received sequence number(including the ones recovered
+// by RTX or FEC), and returns true if it was a missing packet.
+func (n *NackGenerator) OnRtpPacket(seq uint16) bool {
+	n.Lock()
+	defer n.Unlock()
+
+	ext := n.unwrapper.Unwrap(seq)
+	if !n.started {
+		n.started = true
+		n.newestSeq = ext
+		return false
+	}
+
+	if ext <= n.newestSeq {
+		if _, ok := n.nacks[ext]; ok {
+			delete(n.nacks, ext)
+			n.Recovered += 1
+			return true
+		}
+		return false
+	}
+
+	// only the last kNackMaxListSize ones of a large gap are tracked
+	start := n.newestSeq + 1
+	if start < ext-kNackMaxListSize {
+		n.GaveUp += uint32(ext - kNackMaxListSize - start)
+		start = ext - kNackMaxListSize
+	}
+	for missing := start; missing < ext; missing++ {
+		n.nacks[missing] = &nackInfo{}
+	}
+	n.newestSeq = ext
+	n.removeOld()
+	return false
+}
+
+// removeOld drops the missing packets too old or beyond the list size.
+func (n *NackGenerator) removeOld() {
+	if len(n.nacks) == 0 {
+		return
+	}
+	seqs := n.sortedSeqs()
+	for idx, seq := range seqs {
+		if n.newestSeq-seq <= kNackMaxPacketAge && len(seqs)-idx <= kNackMaxListSize {
+			break
+		}
+		delete(n.nacks, seq)
+		n.GaveUp += 1
+	}
+}
+
+func (n *NackGenerator) sortedSeqs() []int64 {
+	seqs := make([]int64, 0, len(n.nacks))
+	for seq := range n.nacks {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+	return seqs
+}
+
+// GetNackList returns the missing sequences to request now: the new ones, and
+// the ones requested one RTT ago. The packets requested maxRetries times are dropped.
+func (n *NackGenerator) GetNackList(nowMs int64) []uint16 {
+	n.Lock()
+	defer n.Unlock()
+
+	retryMs := n.rttMs
+	if retryMs < kNackMinRetryMs {
+		retryMs = kNackMinRetryMs
+	}
+	var list []uint16
+	for _, seq := range n.sortedSeqs() {
+		info := n.nacks[seq]
+		if info.retries > 0 && nowMs-info.sentMs < retryMs {
+			continue
+		}
+		if info.retries >= n.maxRetries {
+			delete(n.nacks, seq)
+			n.GaveUp += 1
+			continue
+		}
+		info.retries += 1
+		info.sentMs = nowMs
+		list = append(list, uint16(seq))
+	}
+	return list
+}
+
+// GetNackPacket returns the RTCP NACK of GetNackList, or nil if nothing to request.
+func (n *NackGenerator) GetNackPacket(nowMs int64) *RtcpNack {
+	list := n.GetNackList(nowMs)
+	if len(list) == 0 {
+		return nil
+	}
+	return NewRtcpNack(n.senderSsrc, n.mediaSsrc, list)
+}
+
+// MissingCount returns the number of missing packets being tracked.
+func (n *NackGenerator) MissingCount() int {
+	n.Lock()
+	defer n.Unlock()
+	return len(n.nacks)
+}
+
+// Reset clears all state, e.g. after the stream restarted.
+func (n *NackGenerator) Reset() {
+	n.Lock()
+	defer n.Unlock()
+	n.unwrapper.Reset()
+	n.started = false
+	n.newestSeq = 0
+	n.nacks = make(map[int64]*nackInfo)
+}
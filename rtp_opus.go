@@ -0,0 +1,207 @@
+package goutil
+
+/*
+ * Opus TOC byte(RFC 6716 3.1):
+ *
+ *  0 1 2 3 4 5 6 7
+ * +-+-+-+-+-+-+-+-+
+ * | config  |s| c |
+ * +-+-+-+-+-+-+-+-+
+ *
+ * config: mode, bandwidth and frame duration.
+ * s: stereo.
+ * c: 0 for 1 frame, 1 for 2 CBR frames, 2 for 2 VBR frames, 3 for arbitrary frames.
+ */
+
+// Opus modes of config.
+const (
+	OPUS_MODE_SILK   = 0
+	OPUS_MODE_HYBRID = 1
+	OPUS_MODE_CELT   = 2
+)
+
+// Opus audio bandwidths.
+const (
+	OPUS_BANDWIDTH_NB  = 0 // narrowband, 4kHz
+	OPUS_BANDWIDTH_MB  = 1 // medium-band, 6kHz
+	OPUS_BANDWIDTH_WB  = 2 // wideband, 8kHz
+	OPUS_BANDWIDTH_SWB = 3 // super-wideband, 12kHz
+	OPUS_BANDWIDTH_FB  = 4 // fullband, 20kHz
+)
+
+const (
+	kOpusMaxFrameSize    = 1275
+	kOpusMaxDurationUs   = 120000
+	kOpusMaxDtxSize      = 2 // the DTX packets of libopus are TOC only, or 2 bytes
+	kOpusClockFrequency  = 48000
+	kOpusFrameCountMask  = 0x3F
+	kOpusVbrBit          = 0x80
+	kOpusPaddingBit      = 0x40
+	kOpusTwoByteSizeBase = 252
+)
+
+// the frame duration(us) of config % 4 for SILK, or config % 2 for Hybrid, or config % 4 for CELT.
+var (
+	kOpusSilkDurations   = []int{10000, 20000, 40000, 60000}
+	kOpusHybridDurations = []int{10000, 20000}
+	kOpusCeltDurations   = []int{2500, 5000, 10000, 20000}
+)
+
+// OpusPacketInfo is the TOC and frame info of one Opus packet.
+type OpusPacketInfo struct {
+	Config          uint8
+	Mode            int // OPUS_MODE_xx
+	Bandwidth       int // OPUS_BANDWIDTH_xx
+	Stereo          bool
+	FrameCode       uint8 // c of TOC
+	FrameDurationUs int   // the duration of one frame in microseconds
+	FrameCount      int
+	FrameSizes      []int // zero size for DTX or lost frame
+	Padding         int
+	Dtx             bool // DTX(comfort noise) packet
+}
+
+// DurationUs returns the total duration of the packet in microseconds.
+func (p *OpusPacketInfo) DurationUs() int {
+	return p.FrameDurationUs * p.FrameCount
+}
+
+// Samples returns the number of samples per channel at 48kHz(RTP clock).
+func (p *OpusPacketInfo) Samples() int {
+	return p.DurationUs() * kOpusClockFrequency / 1000000
+}
+
+// parseToc fills the fields of TOC byte.
+func (p *OpusPacketInfo) parseToc(toc uint8) {
+	p.Config = toc >> 3
+	p.Stereo = toc&0x04 != 0
+	p.FrameCode = toc & 0x03
+	switch config := int(p.Config); {
+	case config < 12:
+		p.Mode = OPUS_MODE_SILK
+		p.Bandwidth = OPUS_BANDWIDTH_NB + config/4
+		p.FrameDurationUs = kOpusSilkDurations[config%4]
+	case config < 16:
+		p.Mode = OPUS_MODE_HYBRID
+		p.Bandwidth = OPUS_BANDWIDTH_SWB + (config-12)/2
+		p.FrameDurationUs = kOpusHybridDurations[config%2]
+	default:
+		p.Mode = OPUS_MODE_CELT
+		p.Bandwidth = []int{OPUS_BANDWIDTH_NB, OPUS_BANDWIDTH_WB, OPUS_BANDWIDTH_SWB, OPUS_BANDWIDTH_FB}[(config-16)/4]
+		p.FrameDurationUs = kOpusCeltDurations[config%4]
+	}
+}
+
+// readOpusFrameSize reads one frame length of 1 or 2 bytes(RFC 6716 3.2.1).
+func readOpusFrameSize(data []byte) (int, int, error) {
+	if len(data) < 1 {
+		return 0, 0, NewErrorf("Opus frame size insufficient")
+	}
+	if data[0] < kOpusTwoByteSizeBase {
+		return int(data[0]), 1, nil
+	}
+	if len(data) < 2 {
+		return 0, 0, NewErrorf("Opus frame size insufficient")
+	}
+	return int(data[1])*4 + int(data[0]), 2, nil
+}
+
+// ParseOpusPacket parses the TOC and frame sizes of one Opus packet(RTP payload).
+func ParseOpusPacket(payload []byte) (*OpusPacketInfo, error) {
+	if len(payload) < 1 {
+		return nil, NewErrorf("Opus packet empty")
+	}
+	p := &OpusPacketInfo{}
+	p.parseToc(payload[0])
+	p.Dtx = len(payload) <= kOpusMaxDtxSize
+	data := payload[1:]
+
+	switch p.FrameCode {
+	case 0:
+		p.FrameCount = 1
+		p.FrameSizes = []int{len(data)}
+	case 1:
+		if len(data)%2 != 0 {
+			return nil, NewErrorf("Opus code 1 size invalid: %d", len(data))
+		}
+		p.FrameCount = 2
+		p.FrameSizes = []int{len(data) / 2, len(data) / 2}
+	case 2:
+		size, n, err := readOpusFrameSize(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+		if size > len(data) {
+			return nil, NewErrorf("Opus code 2 size invalid: %d > %d", size, len(data))
+		}
+		p.FrameCount = 2
+		p.FrameSizes = []int{size, len(data) - size}
+	case 3:
+		if len(data) < 1 {
+			return nil, NewErrorf("Opus code 3 insufficient")
+		}
+		flags := data[0]
+		data = data[1:]
+		p.FrameCount = int(flags & kOpusFrameCountMask)
+		if p.FrameCount == 0 || p.FrameCount*p.FrameDurationUs > kOpusMaxDurationUs {
+			return nil, NewErrorf("Opus code 3 frame count invalid: %d", p.FrameCount)
+		}
+		if flags&kOpusPaddingBit != 0 {
+			// the padding length: 255 for 254 bytes and more
+			for {
+				if len(data) < 1 {
+					return nil, NewErrorf("Opus padding insufficient")
+				}
+				value := int(data[0])
+				data = data[1:]
+				if value == 255 {
+					p.Padding += 254
+				} else {
+					p.Padding += value
+					break
+				}
+			}
+		}
+		if p.Padding > len(data) {
+			return nil, NewErrorf("Opus padding invalid: %d > %d", p.Padding, len(data))
+		}
+		data = data[:len(data)-p.Padding]
+		if flags&kOpusVbrBit != 0 {
+			total := 0
+			for i := 0; i < p.FrameCount-1; i++ {
+				size, n, err := readOpusFrameSize(data)
+				if err != nil {
+					return nil, err
+				}
+				data = data[n:]
+				p.FrameSizes = append(p.FrameSizes, size)
+				total += size
+			}
+			if total > len(data) {
+				return nil, NewErrorf("Opus code 3 VBR size invalid: %d > %d", total, len(data))
+			}
+			p.FrameSizes = append(p.FrameSizes, len(data)-total)
+		} else {
+			if len(data)%p.FrameCount != 0 {
+				return nil, NewErrorf("Opus code 3 CBR size invalid: %d", len(data))
+			}
+			for i := 0; i < p.FrameCount; i++ {
+				p.FrameSizes = append(p.FrameSizes, len(data)/p.FrameCount)
+			}
+		}
+	}
+
+	for _, size := range p.FrameSizes {
+		if size > kOpusMaxFrameSize {
+			return nil, NewErrorf("Opus frame too large: %d", size)
+		}
+	}
+	return p, nil
+}
+
+// IsOpusDtx checks whether one Opus payload is a DTX packet, i.e. comfort noise
+// or silence which needn't be decoded or forwarded with high priority.
+func IsOpusDtx(payload []byte) bool {
+	return len(payload) <= kOpusMaxDtxSize
+}
@@ -0,0 +1,140 @@
+package goutil
+
+// rtpPacketizer is the common part of video packetizers.
+type rtpPacketizer struct {
+	Mtu            int // the max payload size of one RTP packet
+	PayloadType    uint8
+	SSRC           uint32
+	SequenceNumber uint16 // the sequence number of the next packet
+}
+
+// newRtpPacketizer returns the packetizer with random sequence number, and
+// mtu(<=0 for defaultMtu) is the max payload size.
+func newRtpPacketizer(ssrc uint32, ptype uint8, mtu, defaultMtu int) rtpPacketizer {
+	if mtu <= 0 {
+		mtu = defaultMtu
+	}
+	return rtpPacketizer{
+		Mtu:            mtu,
+		PayloadType:    ptype,
+		SSRC:           ssrc,
+		SequenceNumber: uint16(RandomUint32()),
+	}
+}
+
+// packetize returns the RTP packets of payloads for one frame, and the last
+// packet has the marker bit.
+func (p *rtpPacketizer) packetize(payloads [][]byte, timestamp uint32) []*RtpPacket {
+	pkts := make([]*RtpPacket, 0, len(payloads))
+	for idx, payload := range payloads {
+		pkt := &RtpPacket{
+			RtpHeader: RtpHeader{
+				Version:        kRtpVersion,
+				Marker:         idx == len(payloads)-1,
+				PayloadType:    p.PayloadType,
+				SequenceNumber: p.SequenceNumber,
+				Timestamp:      timestamp,
+				SSRC:           p.SSRC,
+			},
+			Payload: payload,
+		}
+		p.SequenceNumber += 1
+		pkts = append(pkts, pkt)
+	}
+	return pkts
+}
+
+// splitRtpFragments splits data into the fragments of nearly equal size, which
+// are at most maxSize bytes, and the first one has extra bytes less(e.g. for
+// its larger header).
+func splitRtpFragments(data []byte, maxSize, extra int) [][]byte {
+	num := (len(data) + extra + maxSize - 1) / maxSize
+	size := (len(data) + extra + num - 1) / num
+
+	fragments := make([][]byte, 0, num)
+	for offset := 0; offset < len(data); {
+		end := Min(offset+size, len(data))
+		if offset == 0 && extra > 0 {
+			end = Min(Max(size-extra, 1), len(data))
+		}
+		fragments = append(fragments, data[offset:end])
+		offset = end
+	}
+	return fragments
+}
+
+// rtpFrameCodec is the codec part of rtpFrameAssembler, which owns the frame
+// being rebuilt and the completed ones.
+type rtpFrameCodec interface {
+	// parsePacket parses the payload descriptor of pkt, and returns whether pkt
+	// starts or ends a frame by it, besides the timestamp and marker bit.
+	parsePacket(pkt *RtpPacket) (start, end bool, err error)
+
+	// newFrame begins the frame of pkt, and returns whether its head is not
+	// lost, where lost is whether the packets before pkt are lost.
+	newFrame(pkt *RtpPacket, lost bool) bool
+
+	// addPacket adds the payload of pkt to the frame.
+	addPacket(pkt *RtpPacket) error
+
+	// finishFrame ends the frame, and returns whether it's complete(and kept).
+	finishFrame(broken bool) bool
+}
+
+// rtpFrameAssembler rebuilds frames from in-order RTP packets(e.g. from
+// JitterBuffer) by the sequence gaps and frame boundaries, and the frames with
+// lost packets are dropped.
+type rtpFrameAssembler struct {
+	Dropped   uint32 // frames dropped for packet loss or invalid payload
+	Completed uint32
+
+	endRequired bool // the frame without end(e.g. VP9 E bit) is dropped
+	inFrame     bool
+	timestamp   uint32
+	broken      bool // current frame lost some packets
+	lastSeq     uint16
+	hasLast     bool
+}
+
+// push adds one RTP packet to codec, and the frames are ended by marker bit,
+// the end of parsePacket, or the next frame.
+func (a *rtpFrameAssembler) push(codec rtpFrameCodec, pkt *RtpPacket) error {
+	lost := a.hasLast && pkt.SequenceNumber != a.lastSeq+1
+	a.lastSeq = pkt.SequenceNumber
+	a.hasLast = true
+
+	start, end, err := codec.parsePacket(pkt)
+	if a.inFrame && (a.timestamp != pkt.Timestamp || start) {
+		// the previous frame without end, which might lose its tail
+		a.broken = a.broken || lost || a.endRequired
+		a.finish(codec)
+	}
+
+	if !a.inFrame {
+		a.inFrame = true
+		a.timestamp = pkt.Timestamp
+		head := codec.newFrame(pkt, lost)
+		a.broken = err != nil || !head
+	} else if lost || err != nil {
+		a.broken = true
+	}
+	if !a.broken {
+		if err = codec.addPacket(pkt); err != nil {
+			a.broken = true
+		}
+	}
+
+	if end || pkt.Marker {
+		a.finish(codec)
+	}
+	return err
+}
+
+func (a *rtpFrameAssembler) finish(codec rtpFrameCodec) {
+	a.inFrame = false
+	if codec.finishFrame(a.broken) {
+		a.Completed += 1
+	} else {
+		a.Dropped += 1
+	}
+}
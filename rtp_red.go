@@ -0,0 +1,192 @@
+package goutil
+
+import (
+	"encoding/binary"
+)
+
+/*
+ * RED payload format(RFC 2198):
+ *
+ * The header of one redundant block:
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |F|   block PT  |  timestamp offset         |   block length    |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * The header of the final(primary) block:
+ *  0 1 2 3 4 5 6 7
+ * +-+-+-+-+-+-+-+-+
+ * |0|   Block PT  |
+ * +-+-+-+-+-+-+-+-+
+ *
+ * The block data follow all headers in the same order.
+ */
+
+const (
+	kRtpRedHeaderSize        = 4
+	kRtpRedPrimaryHeaderSize = 1
+	kRtpRedFBit              = 0x80
+	kRtpRedMaxTsOffset       = 0x3FFF
+	kRtpRedMaxBlockLength    = 0x3FF
+	kRtpRedDefaultDistance   = 1
+)
+
+// RtpRedBlock is one block of RED payload, and the primary one has zero TimestampOffset.
+type RtpRedBlock struct {
+	PayloadType     uint8
+	TimestampOffset uint16
+	Payload         []byte
+}
+
+// ParseRtpRedPayload parses the blocks of one RED payload, and the last one is primary.
+func ParseRtpRedPayload(payload []byte) ([]RtpRedBlock, error) {
+	var blocks []RtpRedBlock
+	var lengths []int
+	offset := 0
+	for {
+		if offset >= len(payload) {
+			return nil, NewErrorf("RED header insufficient: %d", len(payload))
+		}
+		if payload[offset]&kRtpRedFBit == 0 {
+			blocks = append(blocks, RtpRedBlock{PayloadType: payload[offset] & kRtpPtMask})
+			offset += kRtpRedPrimaryHeaderSize
+			break
+		}
+		if offset+kRtpRedHeaderSize > len(payload) {
+			return nil, NewErrorf("RED header insufficient: %d", len(payload))
+		}
+		value := binary.BigEndian.Uint32(payload[offset:])
+		blocks = append(blocks, RtpRedBlock{
+			PayloadType:     uint8(value>>24) & kRtpPtMask,
+			TimestampOffset: uint16(value>>10) & kRtpRedMaxTsOffset,
+		})
+		lengths = append(lengths, int(value&kRtpRedMaxBlockLength))
+		offset += kRtpRedHeaderSize
+	}
+
+	for i, length := range lengths {
+		if offset+length > len(payload) {
+			return nil, NewErrorf("RED block %d insufficient: %d > %d", i, length, len(payload)-offset)
+		}
+		blocks[i].Payload = payload[offset : offset+length]
+		offset += length
+	}
+	blocks[len(blocks)-1].Payload = payload[offset:]
+	return blocks, nil
+}
+
+// MarshalRtpRedPayload packs the blocks into one RED payload, and the last one is primary.
+func MarshalRtpRedPayload(blocks []RtpRedBlock) ([]byte, error) {
+	if len(blocks) == 0 {
+		return nil, NewErrorf("RED blocks empty")
+	}
+	size := kRtpRedPrimaryHeaderSize + (len(blocks)-1)*kRtpRedHeaderSize
+	for _, block := range blocks {
+		size += len(block.Payload)
+	}
+	buf := make([]byte, 0, size)
+	for i, block := range blocks {
+		if i == len(blocks)-1 {
+			buf = append(buf, block.PayloadType&kRtpPtMask)
+			break
+		}
+		if block.TimestampOffset > kRtpRedMaxTsOffset || len(block.Payload) > kRtpRedMaxBlockLength {
+			return nil, NewErrorf("RED block %d invalid: %d, %d", i, block.TimestampOffset, len(block.Payload))
+		}
+		value := uint32(kRtpRedFBit|block.PayloadType&kRtpPtMask)<<24 |
+			uint32(block.TimestampOffset)<<10 | uint32(len(block.Payload))
+		buf = append(buf, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(buf[len(buf)-kRtpRedHeaderSize:], value)
+	}
+	for _, block := range blocks {
+		buf = append(buf, block.Payload...)
+	}
+	return buf, nil
+}
+
+// RtpRedEncoder wraps the packets of one stream into RED packets, with the
+// payloads of previous Distance packets as redundancy(e.g. for audio). The
+// video packets(and ULPFEC) of WebRTC use zero Distance.
+type RtpRedEncoder struct {
+	PayloadType uint8 // RED ptype
+	Distance    int
+
+	history []*RtpPacket
+}
+
+// NewRtpRedEncoder creates an encoder of RED ptype, and distance(<0 for default 1) of redundancy.
+func NewRtpRedEncoder(ptype uint8, distance int) *RtpRedEncoder {
+	if distance < 0 {
+		distance = kRtpRedDefaultDistance
+	}
+	return &RtpRedEncoder{PayloadType: ptype, Distance: distance}
+}
+
+// Encode returns the RED packet of pkt, which has the same header except ptype.
+func (e *RtpRedEncoder) Encode(pkt *RtpPacket) (*RtpPacket, error) {
+	payload := rtpPayloadWithoutPadding(pkt)
+	var blocks []RtpRedBlock
+	for _, prev := range e.history {
+		offset := pkt.Timestamp - prev.Timestamp
+		prevPayload := rtpPayloadWithoutPadding(prev)
+		// skip the too old or too large ones
+		if !IsNewerRtpTimestamp(pkt.Timestamp, prev.Timestamp) || offset > kRtpRedMaxTsOffset ||
+			len(prevPayload) > kRtpRedMaxBlockLength {
+			continue
+		}
+		blocks = append(blocks, RtpRedBlock{
+			PayloadType:     prev.PayloadType,
+			TimestampOffset: uint16(offset),
+			Payload:         prevPayload,
+		})
+	}
+	blocks = append(blocks, RtpRedBlock{PayloadType: pkt.PayloadType, Payload: payload})
+	redPayload, err := MarshalRtpRedPayload(blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Distance > 0 {
+		e.history = append(e.history, &RtpPacket{
+			RtpHeader: RtpHeader{PayloadType: pkt.PayloadType, Timestamp: pkt.Timestamp},
+			Payload:   append([]byte(nil), payload...),
+		})
+		if len(e.history) > e.Distance {
+			e.history = e.history[len(e.history)-e.Distance:]
+		}
+	}
+
+	red := &RtpPacket{RtpHeader: copyRtpHeader(&pkt.RtpHeader)}
+	red.PayloadType = e.PayloadType
+	red.Padding = false
+	red.PaddingLength = 0
+	red.Payload = redPayload
+	return red, nil
+}
+
+// DecodeRtpRedPacket unwraps one RED packet into the packets of its blocks, in
+// the order of the redundant ones and the primary one. The sequence number of
+// the redundant block i(of n) is assumed to be seq-(n-i), as RED doesn't carry it.
+func DecodeRtpRedPacket(pkt *RtpPacket) ([]*RtpPacket, error) {
+	blocks, err := ParseRtpRedPayload(rtpPayloadWithoutPadding(pkt))
+	if err != nil {
+		return nil, err
+	}
+	pkts := make([]*RtpPacket, 0, len(blocks))
+	for i, block := range blocks {
+		distance := len(blocks) - 1 - i
+		out := &RtpPacket{RtpHeader: copyRtpHeader(&pkt.RtpHeader)}
+		out.PayloadType = block.PayloadType
+		out.SequenceNumber = pkt.SequenceNumber - uint16(distance)
+		out.Timestamp = pkt.Timestamp - uint32(block.TimestampOffset)
+		out.Padding = false
+		out.PaddingLength = 0
+		if distance > 0 {
+			out.Marker = false
+		}
+		out.Payload = block.Payload
+		pkts = append(pkts, out)
+	}
+	return pkts, nil
+}
@@ -0,0 +1,310 @@
+package goutil
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+/*
+ * RTX payload format(RFC 4588):
+ *
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                         RTP Header                            |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |            OSN                |                               |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+                               |
+ * |                  Original RTP Packet Payload                  |
+ * |                                                               |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ */
+
+const (
+	kRtxOsnLength          = 2
+	kRtxDefaultHistorySize = 600
+)
+
+// copyRtpHeader returns a copy of h which does not share the slices.
+func copyRtpHeader(h *RtpHeader) RtpHeader {
+	copied := *h
+	copied.CSRC = append([]uint32(nil), h.CSRC...)
+	copied.ExtensionPayload = append([]byte(nil), h.ExtensionPayload...)
+	copied.ExtensionElements = append([]RtpExtensionElement(nil), h.ExtensionElements...)
+	return copied
+}
+
+// rtpPayloadWithoutPadding returns the payload of pkt without the padding bytes.
+func rtpPayloadWithoutPadding(pkt *RtpPacket) []byte {
+	payload := pkt.Payload
+	if pkt.Padding && int(pkt.PaddingLength) <= len(payload) {
+		payload = payload[:len(payload)-int(pkt.PaddingLength)]
+	}
+	return payload
+}
+
+// NewRtxPacket wraps the original packet into a RTX packet of rtxSsrc/rtxPtype/rtxSeq,
+// with the original sequence number(OSN) before the original payload.
+func NewRtxPacket(pkt *RtpPacket, rtxSsrc uint32, rtxPtype uint8, rtxSeq uint16) *RtpPacket {
+	payload := rtpPayloadWithoutPadding(pkt)
+	rtx := &RtpPacket{RtpHeader: copyRtpHeader(&pkt.RtpHeader)}
+	rtx.SSRC = rtxSsrc
+	rtx.PayloadType = rtxPtype
+	rtx.SequenceNumber = rtxSeq
+	rtx.Padding = false
+	rtx.PaddingLength = 0
+	rtx.Payload = make([]byte, kRtxOsnLength+len(payload))
+	binary.BigEndian.PutUint16(rtx.Payload, pkt.SequenceNumber)
+	copy(rtx.Payload[kRtxOsnLength:], payload)
+	return rtx
+}
+
+type rtxHistoryPacket struct {
+	pkt       *RtpPacket
+	sentMs    int64
+	resentMs  int64
+	resentNum int
+}
+
+// RtpPacketHistory keeps the recently sent packets of one stream, and answers
+// NACKs with the RFC 4588 RTX packets(or the original ones if RTX is not used).
+type RtpPacketHistory struct {
+	sync.Mutex
+	capacity int
+	packets  map[uint16]*rtxHistoryPacket
+	order    []uint16 // the sequences in sending order
+	rttMs    int64
+
+	rtxSsrc   uint32
+	rtxPtypes map[uint8]uint8 // main ptype => rtx ptype
+	rtxSeq    uint16
+}
+
+// NewRtpPacketHistory creates a history of capacity(<=0 for default) packets.
+func NewRtpPacketHistory(capacity int) *RtpPacketHistory {
+	if capacity <= 0 {
+		capacity = kRtxDefaultHistorySize
+	}
+	return &RtpPacketHistory{
+		capacity:  capacity,
+		packets:   make(map[uint16]*rtxHistoryPacket),
+		rttMs:     kNackDefaultRttMs,
+		rtxPtypes: make(map[uint8]uint8),
+		rtxSeq:    uint16(RandomUint32()),
+	}
+}
+
+// SetRtx enables RTX with rtxSsrc and the mapping of main ptype => rtx ptype.
+func (h *RtpPacketHistory) SetRtx(rtxSsrc uint32, rtxPtypes map[uint8]uint8) {
+	h.Lock()
+	defer h.Unlock()
+	h.rtxSsrc = rtxSsrc
+	h.rtxPtypes = make(map[uint8]uint8)
+	for ptype, rtxPtype := range rtxPtypes {
+		h.rtxPtypes[ptype] = rtxPtype
+	}
+}
+
+// SetRtxFromSdp enables RTX with SdpSsrc.Rtx and the apt ptypes of sdp, e.g.
+// from the sending SSRC of MediaDesc.GetVideoAttrs().
+func (h *RtpPacketHistory) SetRtxFromSdp(ssrc *SdpSsrc, ptypes map[uint8]*SdpPtype) {
+	if ssrc == nil || ssrc.Rtx == 0 {
+		return
+	}
+	rtxPtypes := make(map[uint8]uint8)
+	for ptype, item := range ptypes {
+		if item.Codec != "rtx" && item.AptPtype != 0 {
+			rtxPtypes[ptype] = item.AptPtype
+		}
+	}
+	h.SetRtx(ssrc.Rtx, rtxPtypes)
+}
+
+// UpdateRtt sets the round trip time, and one packet is resent at most once per RTT.
+func (h *RtpPacketHistory) UpdateRtt(rttMs int64) {
+	h.Lock()
+	defer h.Unlock()
+	if rttMs > 0 {
+		h.rttMs = rttMs
+	}
+}
+
+// Put stores a copy of the sent packet.
+func (h *RtpPacketHistory) Put(pkt *RtpPacket, sentMs int64) error {
+	raw, err := pkt.Marshal()
+	if err != nil {
+		return err
+	}
+	copied := &RtpPacket{}
+	if err := copied.Unmarshal(raw); err != nil {
+		return err
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if _, ok := h.packets[copied.SequenceNumber]; !ok {
+		h.order = append(h.order, copied.SequenceNumber)
+	}
+	h.packets[copied.SequenceNumber] = &rtxHistoryPacket{pkt: copied, sentMs: sentMs}
+	for len(h.order) > h.capacity {
+		delete(h.packets, h.order[0])
+		h.order = h.order[1:]
+	}
+	return nil
+}
+
+// Get returns the stored packet of seq, or nil.
+func (h *RtpPacketHistory) Get(seq uint16) *RtpPacket {
+	h.Lock()
+	defer h.Unlock()
+	if item, ok := h.packets[seq]; ok {
+		return item.pkt
+	}
+	return nil
+}
+
+// Len returns the number of stored packets.
+func (h *RtpPacketHistory) Len() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.packets)
+}
+
+// ResendPacket returns the packet(RTX if enabled) to retransmit for seq, or nil
+// if it is not stored or has been resent within one RTT.
+func (h *RtpPacketHistory) ResendPacket(seq uint16, nowMs int64) *RtpPacket {
+	h.Lock()
+	defer h.Unlock()
+	return h.resendPacket(seq, nowMs)
+}
+
+func (h *RtpPacketHistory) resendPacket(seq uint16, nowMs int64) *RtpPacket {
+	item, ok := h.packets[seq]
+	if !ok {
+		return nil
+	}
+	if item.resentNum > 0 && nowMs-item.resentMs < h.rttMs {
+		return nil
+	}
+	item.resentMs = nowMs
+	item.resentNum += 1
+
+	if h.rtxSsrc != 0 {
+		if rtxPtype, ok := h.rtxPtypes[item.pkt.PayloadType]; ok {
+			rtx := NewRtxPacket(item.pkt, h.rtxSsrc, rtxPtype, h.rtxSeq)
+			h.rtxSeq += 1
+			return rtx
+		}
+	}
+	return &RtpPacket{
+		RtpHeader: copyRtpHeader(&item.pkt.RtpHeader),
+		Payload:   append([]byte(nil), item.pkt.Payload...),
+	}
+}
+
+// OnNack returns the packets to retransmit for one RTCP NACK.
+func (h *RtpPacketHistory) OnNack(nack *RtcpNack, nowMs int64) []*RtpPacket {
+	h.Lock()
+	defer h.Unlock()
+
+	var pkts []*RtpPacket
+	for _, seq := range nack.PacketList() {
+		if pkt := h.resendPacket(seq, nowMs); pkt != nil {
+			pkts = append(pkts, pkt)
+		}
+	}
+	return pkts
+}
+
+// RtxReceiver restores the original packets from RTX packets, with the mappings
+// of rtx ssrc => main ssrc and rtx ptype => main ptype.
+type RtxReceiver struct {
+	ssrcs    map[uint32]uint32
+	ptypes   map[uint8]uint8
+	mainSsrc uint32 // the single media ssrc of sdp, or 0
+}
+
+// NewRtxReceiver creates a RtxReceiver from the SSRC(a=ssrc-group:FID) and
+// ptype(a=fmtp:rtx apt=) tables of sdp, e.g. MediaDesc.GetVideoAttrs().
+func NewRtxReceiver(attrs *SdpMediaAttrs) *RtxReceiver {
+	r := &RtxReceiver{
+		ssrcs:  make(map[uint32]uint32),
+		ptypes: make(map[uint8]uint8),
+	}
+	for _, ssrc := range attrs.Ssrcs {
+		if ssrc.Rtx != 0 {
+			r.ssrcs[ssrc.Rtx] = ssrc.Main
+		}
+	}
+	if len(attrs.Ssrcs) == 1 {
+		for _, ssrc := range attrs.Ssrcs {
+			r.mainSsrc = ssrc.Main
+		}
+	}
+	for ptype, item := range attrs.Ptypes {
+		if item.Codec == "rtx" && item.AptPtype != 0 {
+			r.ptypes[ptype] = item.AptPtype
+		}
+	}
+	return r
+}
+
+// IsRtx checks whether pkt is a RTX packet by its ssrc or ptype.
+func (r *RtxReceiver) IsRtx(pkt *RtpPacket) bool {
+	if _, ok := r.ssrcs[pkt.SSRC]; ok {
+		return true
+	}
+	_, ok := r.ptypes[pkt.PayloadType]
+	return ok
+}
+
+// Restore returns the original packet of a RTX packet(OSN stripped, ssrc and
+// ptype mapped back), or pkt itself if it is not RTX. Without the FID group of
+// pkt's ssrc, the single media ssrc of sdp is used, or else it fails.
+func (r *RtxReceiver) Restore(pkt *RtpPacket) (*RtpPacket, error) {
+	if !r.IsRtx(pkt) {
+		return pkt, nil
+	}
+	mainSsrc, ok := r.ssrcs[pkt.SSRC]
+	if !ok {
+		if r.mainSsrc == 0 {
+			return nil, NewErrorf("RTX unknown ssrc: %d", pkt.SSRC)
+		}
+		mainSsrc = r.mainSsrc
+	}
+	mainPtype, ok := r.ptypes[pkt.PayloadType]
+	if !ok {
+		return nil, NewErrorf("RTX unknown payload type: %d", pkt.PayloadType)
+	}
+	return RtxDecapsulate(pkt, mainSsrc, mainPtype)
+}
+
+// RtxDecapsulate restores the original packet of mainSsrc/mainPtype from a RTX packet.
+func RtxDecapsulate(rtx *RtpPacket, mainSsrc uint32, mainPtype uint8) (*RtpPacket, error) {
+	payload := rtpPayloadWithoutPadding(rtx)
+	if len(payload) < kRtxOsnLength {
+		// e.g. the padding-only packets for bandwidth probing
+		return nil, NewErrorf("RTX payload insufficient: %d", len(payload))
+	}
+
+	restored := &RtpPacket{RtpHeader: copyRtpHeader(&rtx.RtpHeader)}
+	restored.SSRC = mainSsrc
+	restored.PayloadType = mainPtype
+	restored.SequenceNumber = binary.BigEndian.Uint16(payload)
+	restored.Padding = false
+	restored.PaddingLength = 0
+	restored.Payload = payload[kRtxOsnLength:]
+
+	raw, err := restored.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	pkt := &RtpPacket{}
+	if err := pkt.Unmarshal(raw); err != nil {
+		return nil, err
+	}
+	pkt.ExtensionMap = rtx.ExtensionMap
+	pkt.PayloadFrequency = rtx.PayloadFrequency
+	return pkt, nil
+}
@@ -0,0 +1,316 @@
+package goutil
+
+import (
+	"sort"
+	"sync"
+)
+
+// The RFC 3550 A.1 constants of sequence validation.
+const (
+	kRtpSeqMod           uint32 = 1 << 16
+	kRtpMaxDropout       uint16 = 3000
+	kRtpMaxMisorder      uint16 = 100
+	kRtpMinSequential           = 1 // accept a new source from the first packet
+	kRtpStatsHistorySize        = 1024
+)
+
+// RtpStreamStatistics keeps the RFC 3550 receive state of one SSRC.
+type RtpStreamStatistics struct {
+	SSRC       uint32
+	Received   uint32 // valid packets received(excluding duplicates)
+	Duplicates uint32
+	Reordered  uint32
+
+	maxSeq        uint16 // highest seq. number seen
+	cycles        uint32 // shifted count of seq. number cycles
+	baseSeq       uint32 // base seq number
+	badSeq        uint32 // last 'bad' seq number + 1
+	probation     int    // sequ. packets till source is valid
+	expectedPrior uint32 // packet expected at last interval
+	receivedPrior uint32 // packet received at last interval
+
+	frequency  uint32
+	transit    int64   // relative trans time for prev pkt
+	jitter     float64 // estimated jitter
+	hasTransit bool
+
+	history [kRtpStatsHistorySize]int64 // extended seq + 1 of recent packets
+
+	lastSrCompact uint32 // compact ntp of last SR
+	lastSrRecvMs  int64  // arrival time of last SR
+}
+
+func newRtpStreamStatistics(ssrc uint32, seq uint16) *RtpStreamStatistics {
+	s := &RtpStreamStatistics{SSRC: ssrc}
+	s.initSeq(seq)
+	s.maxSeq = seq - 1
+	s.probation = kRtpMinSequential
+	return s
+}
+
+// initSeq is init_seq() of RFC 3550 A.1.
+func (s *RtpStreamStatistics) initSeq(seq uint16) {
+	s.baseSeq = uint32(seq)
+	s.maxSeq = seq
+	s.badSeq = kRtpSeqMod + 1 // so seq == bad_seq is false
+	s.cycles = 0
+	s.Received = 0
+	s.receivedPrior = 0
+	s.expectedPrior = 0
+	s.hasTransit = false
+	for i := range s.history {
+		s.history[i] = 0
+	}
+}
+
+// updateSeq is update_seq() of RFC 3550 A.1, returns (valid, inOrder).
+func (s *RtpStreamStatistics) updateSeq(seq uint16) (bool, bool) {
+	udelta := seq - s.maxSeq
+
+	// Source is not valid until kRtpMinSequential packets with
+	// sequential sequence numbers have been received.
+	if s.probation > 0 {
+		if seq == s.maxSeq+1 {
+			s.probation -= 1
+			s.maxSeq = seq
+			if s.probation == 0 {
+				s.initSeq(seq)
+				return true, true
+			}
+		} else {
+			s.probation = kRtpMinSequential - 1
+			s.maxSeq = seq
+		}
+		return false, false
+	} else if udelta < kRtpMaxDropout {
+		// in order, with permissible gap
+		if seq < s.maxSeq {
+			// Sequence number wrapped - count another 64K cycle.
+			s.cycles += kRtpSeqMod
+		}
+		s.maxSeq = seq
+		return true, true
+	} else if uint32(udelta) <= kRtpSeqMod-uint32(kRtpMaxMisorder) {
+		// the sequence number made a very large jump
+		if uint32(seq) == s.badSeq {
+			// Two sequential packets -- assume that the other side
+			// restarted without telling us so just re-sync
+			// (i.e., pretend this was the first packet).
+			s.initSeq(seq)
+			return true, true
+		} else {
+			s.badSeq = (uint32(seq) + 1) & (kRtpSeqMod - 1)
+			return false, false
+		}
+	}
+	// duplicate or reordered packet
+	return true, false
+}
+
+// extendedSeq returns the extended sequence number of seq relative to maxSeq.
+func (s *RtpStreamStatistics) extendedSeq(seq uint16) int64 {
+	ext := int64(s.cycles) + int64(seq)
+	if seq > s.maxSeq && !IsNewerRtpSeq(seq, s.maxSeq) {
+		// old packet before wrap
+		ext -= int64(kRtpSeqMod)
+	} else if seq < s.maxSeq && IsNewerRtpSeq(seq, s.maxSeq) {
+		ext += int64(kRtpSeqMod)
+	}
+	return ext
+}
+
+func (s *RtpStreamStatistics) onRtpPacket(h *RtpHeader, arrivalMs int64) {
+	if s.probation == 0 && s.Received > 0 {
+		ext := s.extendedSeq(h.SequenceNumber)
+		if ext >= 0 && s.history[ext%kRtpStatsHistorySize] == ext+1 {
+			s.Duplicates += 1
+			return
+		}
+	}
+
+	valid, inOrder := s.updateSeq(h.SequenceNumber)
+	if !valid {
+		return
+	}
+	s.Received += 1
+	if !inOrder {
+		s.Reordered += 1
+	}
+	ext := s.extendedSeq(h.SequenceNumber)
+	if ext >= 0 {
+		s.history[ext%kRtpStatsHistorySize] = ext + 1
+	}
+
+	if h.PayloadFrequency > 0 {
+		s.frequency = h.PayloadFrequency
+	}
+	if inOrder && s.frequency > 0 {
+		// interarrival jitter in timestamp units, RFC 3550 A.8
+		arrival := arrivalMs * int64(s.frequency) / 1000
+		transit := arrival - int64(h.Timestamp)
+		if s.hasTransit {
+			d := transit - s.transit
+			if d < 0 {
+				d = -d
+			}
+			// NOTE: ignore the timestamp wrapping or stream restart
+			if d < int64(s.frequency)*10 {
+				s.jitter += (float64(d) - s.jitter) / 16.0
+			}
+		}
+		s.transit = transit
+		s.hasTransit = true
+	}
+}
+
+// ExtendedHighestSequence returns the cycles and the highest sequence number.
+func (s *RtpStreamStatistics) ExtendedHighestSequence() uint32 {
+	return s.cycles + uint32(s.maxSeq)
+}
+
+// PacketsExpected returns the number of packets expected from the base sequence.
+func (s *RtpStreamStatistics) PacketsExpected() uint32 {
+	if s.probation > 0 {
+		return 0
+	}
+	return s.ExtendedHighestSequence() - s.baseSeq + 1
+}
+
+// PacketsLost returns the cumulative number of packets lost.
+func (s *RtpStreamStatistics) PacketsLost() int64 {
+	return int64(s.PacketsExpected()) - int64(s.Received)
+}
+
+// Jitter returns the interarrival jitter in timestamp units.
+func (s *RtpStreamStatistics) Jitter() uint32 {
+	return uint32(s.jitter)
+}
+
+// JitterMs returns the interarrival jitter in milliseconds.
+func (s *RtpStreamStatistics) JitterMs() int64 {
+	if s.frequency == 0 {
+		return 0
+	}
+	return int64(s.jitter * 1000 / float64(s.frequency))
+}
+
+// ReportBlock returns the report block and resets the interval of fraction lost.
+func (s *RtpStreamStatistics) ReportBlock(nowMs int64) RtcpReportBlock {
+	expected := s.PacketsExpected()
+	expectedInterval := expected - s.expectedPrior
+	s.expectedPrior = expected
+	receivedInterval := s.Received - s.receivedPrior
+	s.receivedPrior = s.Received
+	lostInterval := int64(expectedInterval) - int64(receivedInterval)
+
+	var fraction uint8
+	if expectedInterval > 0 && lostInterval > 0 {
+		fraction = uint8((lostInterval << 8) / int64(expectedInterval))
+	}
+
+	lost := s.PacketsLost()
+	if lost > 0x7FFFFF {
+		lost = 0x7FFFFF
+	} else if lost < -0x800000 {
+		lost = -0x800000
+	}
+
+	block := RtcpReportBlock{
+		SSRC:             s.SSRC,
+		FractionLost:     fraction,
+		TotalLost:        int32(lost),
+		LastSequence:     s.ExtendedHighestSequence(),
+		Jitter:           s.Jitter(),
+		LastSenderReport: s.lastSrCompact,
+	}
+	if s.lastSrCompact != 0 && nowMs >= s.lastSrRecvMs {
+		block.Delay = uint32(DivideRoundToNearest((nowMs-s.lastSrRecvMs)*(1<<16), 1000))
+	}
+	return block
+}
+
+// ReceiveStatistics keeps the RFC 3550 receive state of all SSRCs.
+type ReceiveStatistics struct {
+	sync.Mutex
+	streams map[uint32]*RtpStreamStatistics
+}
+
+func NewReceiveStatistics() *ReceiveStatistics {
+	return &ReceiveStatistics{
+		streams: make(map[uint32]*RtpStreamStatistics),
+	}
+}
+
+// OnRtpPacket updates the statistics with one RTP header and its arrival time(ms).
+// The jitter requires RtpHeader.PayloadFrequency.
+func (r *ReceiveStatistics) OnRtpPacket(h *RtpHeader, arrivalMs int64) {
+	r.Lock()
+	defer r.Unlock()
+
+	stream, ok := r.streams[h.SSRC]
+	if !ok {
+		stream = newRtpStreamStatistics(h.SSRC, h.SequenceNumber)
+		r.streams[h.SSRC] = stream
+	}
+	stream.onRtpPacket(h, arrivalMs)
+}
+
+// OnSenderReport records the LSR of one SR for the DLSR of report blocks.
+func (r *ReceiveStatistics) OnSenderReport(sr *RtcpSenderReport, arrivalMs int64) {
+	r.Lock()
+	defer r.Unlock()
+
+	if stream, ok := r.streams[sr.SSRC]; ok {
+		stream.lastSrCompact = CompactNtp(&sr.NtpTime)
+		stream.lastSrRecvMs = arrivalMs
+	}
+}
+
+// GetStatistics returns a copy of the statistics of ssrc, or nil.
+func (r *ReceiveStatistics) GetStatistics(ssrc uint32) *RtpStreamStatistics {
+	r.Lock()
+	defer r.Unlock()
+
+	if stream, ok := r.streams[ssrc]; ok {
+		copied := *stream
+		return &copied
+	}
+	return nil
+}
+
+// RemoveStream drops the statistics of ssrc, e.g. after RTCP BYE.
+func (r *ReceiveStatistics) RemoveStream(ssrc uint32) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.streams, ssrc)
+}
+
+// ReportBlocks returns the report blocks(sorted by ssrc) of all valid streams.
+func (r *ReceiveStatistics) ReportBlocks(nowMs int64) []RtcpReportBlock {
+	r.Lock()
+	defer r.Unlock()
+
+	var blocks []RtcpReportBlock
+	for _, stream := range r.streams {
+		if stream.probation == 0 {
+			blocks = append(blocks, stream.ReportBlock(nowMs))
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].SSRC < blocks[j].SSRC
+	})
+	return blocks
+}
+
+// ReceiverReport returns RR packets of all valid streams, at most 31 blocks per RR.
+func (r *ReceiveStatistics) ReceiverReport(senderSsrc uint32, nowMs int64) []*RtcpReceiverReport {
+	blocks := r.ReportBlocks(nowMs)
+	var reports []*RtcpReceiverReport
+	for len(blocks) > 0 {
+		count := Min(len(blocks), kRtcpMaxCount)
+		reports = append(reports, &RtcpReceiverReport{SSRC: senderSsrc, Reports: blocks[:count]})
+		blocks = blocks[count:]
+	}
+	return reports
+}
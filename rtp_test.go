@@ -0,0 +1,1982 @@
+package goutil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestRtpExtension_1(t *testing.T) {
+	extmap := NewRtpExtensionMap()
+	extmap.RegisterUri(1, RtpExtUriAudioLevel)
+	extmap.RegisterUri(3, RtpExtUriAbsoluteSendTime)
+	extmap.RegisterUri(4, RtpExtUriMid)
+	extmap.RegisterUri(5, RtpExtUriTransportSequenceNumber)
+	extmap.RegisterUri(6, RtpExtUriTransmissionTimeOffset)
+
+	pkt := &RtpPacket{}
+	pkt.PayloadType = 111
+	pkt.SequenceNumber = 100
+	pkt.Timestamp = 12345
+	pkt.SSRC = 0x11223344
+	pkt.ExtensionMap = extmap
+	pkt.RtpExtension.HasAudioLevel = true
+	pkt.RtpExtension.VoiceActivity = true
+	pkt.RtpExtension.AudioLevel = 42
+	pkt.RtpExtension.HasAbsoluteSendTime = true
+	pkt.RtpExtension.AbsoluteSendTime = 0x123456
+	pkt.RtpExtension.HasTransportSequenceNumber = true
+	pkt.RtpExtension.TransportSequenceNumber = 0xABCD
+	pkt.RtpExtension.HasTransmissionTimeOffset = true
+	pkt.RtpExtension.TransmissionTimeOffset = -2
+	pkt.RtpExtension.Mid = "video0"
+	pkt.Payload = []byte{1, 2, 3, 4}
+
+	buf, err := pkt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.ExtensionProfile != kRtpOneByteProfile || len(pkt.ExtensionPayload)%4 != 0 {
+		t.Fatalf("invalid extension: %x, %d", pkt.ExtensionProfile, len(pkt.ExtensionPayload))
+	}
+
+	var out RtpPacket
+	out.ExtensionMap = extmap
+	if err := out.Unmarshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	if out.RtpExtension != pkt.RtpExtension {
+		t.Fatalf("extension mismatch: %+v != %+v", out.RtpExtension, pkt.RtpExtension)
+	}
+	if !bytes.Equal(out.Payload, pkt.Payload) {
+		t.Fatalf("payload mismatch: %v", out.Payload)
+	}
+
+	// remove one typed extension
+	out.RtpExtension.Mid = ""
+	buf2, err := out.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out2 RtpPacket
+	out2.ExtensionMap = extmap
+	if err := out2.Unmarshal(buf2); err != nil {
+		t.Fatal(err)
+	}
+	if out2.RtpExtension.Mid != "" || out2.GetExtension(4) != nil || len(out2.ExtensionElements) != 4 {
+		t.Fatalf("mid not removed: %+v", out2.ExtensionElements)
+	}
+}
+
+func TestRtpExtension_2(t *testing.T) {
+	// two-byte header: id > 14 and zero-length element
+	var h RtpHeader
+	h.SetExtension(20, []byte("abc"))
+	h.SetExtension(2, []byte{})
+	if !h.Extension || !IsRtpExtensionTwoByte(h.ExtensionProfile) {
+		t.Fatalf("invalid profile: %x", h.ExtensionProfile)
+	}
+
+	buf, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out RtpHeader
+	if err := out.Unmarshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.ExtensionElements) != 2 || string(out.GetExtension(20)) != "abc" || out.GetExtension(2) == nil {
+		t.Fatalf("invalid elements: %+v", out.ExtensionElements)
+	}
+
+	// invalid one-byte element length
+	payload := []byte{0x13, 0x01, 0x00, 0x00}
+	if _, err := ParseRtpExtensionElements(kRtpOneByteProfile, payload[:2]); err == nil {
+		t.Fatal("expect error for truncated element")
+	}
+}
+
+func TestRtpExtension_3(t *testing.T) {
+	extmap := NewRtpExtensionMap()
+	extmap.RegisterUri(1, RtpExtUriAudioLevel)
+	extmap.RegisterUri(4, RtpExtUriMid)
+
+	pkt := &RtpPacket{Payload: []byte{1}}
+	pkt.ExtensionMap = extmap
+	pkt.RtpExtension.HasAudioLevel = true
+	pkt.RtpExtension.AudioLevel = 10
+	pkt.RtpExtension.Mid = "a"
+
+	// MarshalSize is read-only
+	if size := pkt.MarshalSize(); size != kRtpHeaderLength+1 || pkt.Extension {
+		t.Fatalf("MarshalSize updated extension: %d, %v", size, pkt.ExtensionPayload)
+	}
+	buf, err := pkt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ExtensionMap is attached after Unmarshal
+	var out RtpPacket
+	if err := out.Unmarshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	out.ExtensionMap = extmap
+	buf2, err := out.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.ExtensionElements) != 2 || out.RtpExtension.Mid != "a" || !bytes.Equal(buf, buf2) {
+		t.Fatalf("extensions removed: %+v", out.ExtensionElements)
+	}
+
+	// the invalid element is rejected with header unchanged
+	payload := append([]byte(nil), out.ExtensionPayload...)
+	if err := out.SetExtension(2, make([]byte, 256)); err == nil {
+		t.Fatalf("too long extension accepted")
+	}
+	if err := out.SetExtension(0, []byte{1}); err == nil {
+		t.Fatalf("extension id=0 accepted")
+	}
+	if len(out.ExtensionElements) != 2 || !bytes.Equal(out.ExtensionPayload, payload) {
+		t.Fatalf("header changed: %+v", out.ExtensionElements)
+	}
+
+	// the two-byte profile with appbits is kept
+	var h RtpHeader
+	raw := []byte{0x90, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0x10, 0x03, 0, 1, 5, 1, 0xAA, 0}
+	if err := h.Unmarshal(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetExtension(6, []byte{0xBB}); err != nil {
+		t.Fatal(err)
+	}
+	if h.ExtensionProfile != 0x1003 || len(h.ExtensionElements) != 2 {
+		t.Fatalf("two-byte profile lost: 0x%x, %+v", h.ExtensionProfile, h.ExtensionElements)
+	}
+}
+
+func TestRtpStats_1(t *testing.T) {
+	stats := NewReceiveStatistics()
+	h := &RtpHeader{SSRC: 1234, PayloadFrequency: 90000}
+
+	// 65530..65535, 0..9 with 3 lost, 1 duplicate and 1 reordered
+	seqs := []uint16{65530, 65531, 65533, 65532, 65534, 65535, 0, 1, 1, 2, 4, 5, 7, 9}
+	for i, seq := range seqs {
+		h.SequenceNumber = seq
+		h.Timestamp = uint32(seq) * 3000
+		stats.OnRtpPacket(h, int64(i)*33)
+	}
+
+	s := stats.GetStatistics(1234)
+	if s == nil {
+		t.Fatal("no statistics")
+	}
+	if s.ExtendedHighestSequence() != 65536+9 || s.PacketsExpected() != 16 {
+		t.Fatalf("invalid sequence: %d, %d", s.ExtendedHighestSequence(), s.PacketsExpected())
+	}
+	if s.Received != 13 || s.Duplicates != 1 || s.Reordered != 1 || s.PacketsLost() != 3 {
+		t.Fatalf("invalid counters: %+v", s)
+	}
+
+	var sr RtcpSenderReport
+	sr.SSRC = 1234
+	sr.NtpTime.Set(0x12345, 0x80000000)
+	stats.OnSenderReport(&sr, 1000)
+
+	reports := stats.ReceiverReport(1, 1500)
+	if len(reports) != 1 || len(reports[0].Reports) != 1 {
+		t.Fatal("invalid reports:", reports)
+	}
+	block := reports[0].Reports[0]
+	if block.FractionLost != 3*256/16 || block.TotalLost != 3 || block.LastSequence != 65536+9 {
+		t.Fatalf("invalid block: %+v", block)
+	}
+	if block.LastSenderReport != 0x23458000 || block.Delay != 1<<15 {
+		t.Fatalf("invalid lsr/dlsr: %+v", block)
+	}
+
+	// no loss in the next interval
+	block = stats.ReportBlocks(2000)[0]
+	if block.FractionLost != 0 || block.TotalLost != 3 {
+		t.Fatalf("invalid block: %+v", block)
+	}
+}
+
+func TestSeqUnwrapper_1(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []uint16
+		output []int64
+	}{
+		{"forward", []uint16{1, 2, 3}, []int64{1, 2, 3}},
+		{"wrap", []uint16{0xFFFE, 0xFFFF, 0x0000, 0x0001}, []int64{0xFFFE, 0xFFFF, 0x10000, 0x10001}},
+		{"reorder across wrap", []uint16{0xFFFF, 0x0001, 0x0000, 0xFFFE, 0x0002}, []int64{0xFFFF, 0x10001, 0x10000, 0xFFFE, 0x10002}},
+		{"backward across wrap", []uint16{0x0001, 0xFFFF, 0x0000}, []int64{1, -1, 0}},
+		{"max gap", []uint16{0xFFF0, 0x7FEF, 0xFFF0}, []int64{0xFFF0, 0x17FEF, 0xFFF0}},
+		{"half range", []uint16{0x0000, 0x8000}, []int64{0, -0x8000}},
+		{"multiple wraps", []uint16{0x0000, 0x7FFF, 0xFFFE, 0x7FFD, 0xFFFC}, []int64{0, 0x7FFF, 0xFFFE, 0x17FFD, 0x1FFFC}},
+	}
+	for _, tt := range tests {
+		var u SeqUnwrapper
+		for i, seq := range tt.input {
+			if peek := u.PeekUnwrap(seq); peek != tt.output[i] {
+				t.Errorf("%s: peek %d => %d, want %d", tt.name, seq, peek, tt.output[i])
+			}
+			if got := u.Unwrap(seq); got != tt.output[i] {
+				t.Errorf("%s: unwrap %d => %d, want %d", tt.name, seq, got, tt.output[i])
+			}
+		}
+	}
+}
+
+func TestTimestampUnwrapper_1(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []uint32
+		output []int64
+	}{
+		{"forward", []uint32{100, 3100, 6100}, []int64{100, 3100, 6100}},
+		{"wrap", []uint32{0xFFFFF448, 0x00000690, 0x000012C8}, []int64{0xFFFFF448, 0x100000690, 0x1000012C8}},
+		{"reorder across wrap", []uint32{0xFFFFFFFF, 0x00000BB8, 0xFFFFF448}, []int64{0xFFFFFFFF, 0x100000BB8, 0xFFFFF448}},
+		{"backward across wrap", []uint32{0x00000001, 0xFFFFFFFF}, []int64{1, -1}},
+	}
+	for _, tt := range tests {
+		var u TimestampUnwrapper
+		for i, ts := range tt.input {
+			if got := u.Unwrap(ts); got != tt.output[i] {
+				t.Errorf("%s: unwrap %d => %d, want %d", tt.name, ts, got, tt.output[i])
+			}
+		}
+	}
+}
+
+func TestJitterBuffer_1(t *testing.T) {
+	jb := NewJitterBuffer(90000, 20, 200, 1000)
+	newPkt := func(seq uint16, ts uint32, marker bool) *RtpPacket {
+		return &RtpPacket{RtpHeader: RtpHeader{SequenceNumber: seq, Timestamp: ts, Marker: marker}}
+	}
+
+	// frame 1: 0xFFFE-0xFFFF, frame 2: 0x0000-0x0001(wrap), out of order
+	jb.Insert(newPkt(0xFFFE, 3000, false), 0)
+	jb.Insert(newPkt(0x0000, 6000, false), 1)
+	jb.Insert(newPkt(0x0001, 6000, true), 2)
+	if seqs := jb.MissingSeqs(); len(seqs) != 1 || seqs[0] != 0xFFFF {
+		t.Fatalf("missing seqs: %v", seqs)
+	}
+	if frame := jb.PopFrame(100); frame != nil {
+		t.Fatalf("incomplete frame should not be released")
+	}
+	if !jb.Insert(newPkt(0xFFFF, 3000, true), 3) || jb.Insert(newPkt(0xFFFF, 3000, true), 3) {
+		t.Fatalf("insert or duplicate check failed")
+	}
+	if frame := jb.PopFrame(10); frame != nil {
+		t.Fatalf("frame released before target delay")
+	}
+	frame := jb.PopFrame(100)
+	if frame == nil || frame.Timestamp != 3000 || len(frame.Packets) != 2 {
+		t.Fatalf("frame 1 failed: %v", frame)
+	}
+	frame = jb.PopFrame(100)
+	if frame == nil || frame.Timestamp != 6000 || len(frame.Packets) != 2 ||
+		frame.Packets[0].SequenceNumber != 0 {
+		t.Fatalf("frame 2 failed: %v", frame)
+	}
+	if jb.Insert(newPkt(0xFFFD, 0, false), 200) {
+		t.Fatalf("late packet should be rejected")
+	}
+
+	// lost packet: 3 is skipped after target delay
+	jb.Insert(newPkt(2, 9000, true), 300)
+	jb.Insert(newPkt(4, 12000, true), 310)
+	delay := jb.TargetDelayMs()
+	if pkts := jb.PopPackets(300 + delay); len(pkts) != 1 || pkts[0].SequenceNumber != 2 {
+		t.Fatalf("pop packets failed: %v", pkts)
+	}
+	if pkts := jb.PopPackets(310 + delay - 1); len(pkts) != 0 {
+		t.Fatalf("gap skipped before target delay: %v", pkts)
+	}
+	if pkts := jb.PopPackets(310 + delay); len(pkts) != 1 || pkts[0].SequenceNumber != 4 {
+		t.Fatalf("pop packets after gap failed: %v", pkts)
+	}
+	if stats := jb.Stats(); stats.Lost != 1 || stats.Late != 1 || stats.Duplicates != 1 {
+		t.Fatalf("stats failed: %+v", stats)
+	}
+}
+
+func TestJitterBuffer_2(t *testing.T) {
+	jb := NewJitterBuffer(8000, 20, 100, 200)
+	// max latency: 200ms = 1600 ts units, 20ms per packet
+	for i := 0; i < 20; i++ {
+		pkt := &RtpPacket{RtpHeader: RtpHeader{SequenceNumber: uint16(i), Timestamp: uint32(i * 160), Marker: true}}
+		jb.Insert(pkt, int64(i*20))
+	}
+	if jb.Len() != 11 || jb.Stats().Dropped != 9 {
+		t.Fatalf("max latency drop failed: len=%d, stats=%+v", jb.Len(), jb.Stats())
+	}
+
+	// jittery arrival increases target delay
+	jb = NewJitterBuffer(8000, 20, 100, 1000)
+	for i := 0; i < 50; i++ {
+		arrival := int64(i * 20)
+		if i%2 == 1 {
+			arrival += 30
+		}
+		pkt := &RtpPacket{RtpHeader: RtpHeader{SequenceNumber: uint16(i), Timestamp: uint32(i * 160)}}
+		jb.Insert(pkt, arrival)
+		jb.PopPackets(arrival)
+	}
+	if delay := jb.TargetDelayMs(); delay <= 20 || delay > 100 {
+		t.Fatalf("adaptive delay failed: %d, jitter=%f", delay, jb.JitterMs())
+	}
+}
+
+func TestJitterBuffer_3(t *testing.T) {
+	jb := NewJitterBuffer(8000, 20, 100, 1000)
+	newPkt := func(seq uint16, ts uint32) *RtpPacket {
+		return &RtpPacket{RtpHeader: RtpHeader{SequenceNumber: seq, Timestamp: ts, Marker: true}}
+	}
+
+	// the forward jump resyncs at the new packet
+	if !jb.Insert(newPkt(100, 1000), 0) || !jb.Insert(newPkt(5100, 2000), 20) {
+		t.Fatalf("insert jump failed")
+	}
+	if pkts := jb.PopPackets(200); len(pkts) != 1 || pkts[0].SequenceNumber != 5100 {
+		t.Fatalf("pop after jump failed: %v", pkts)
+	}
+	if stats := jb.Stats(); stats.Lost != 0 || stats.Dropped != 1 {
+		t.Fatalf("jump stats failed: %+v", stats)
+	}
+
+	// the sender restarts with older sequences
+	if !jb.Insert(newPkt(3000, 3000), 300) {
+		t.Fatalf("insert after restart failed")
+	}
+	if !jb.Insert(newPkt(3001, 3160), 320) {
+		t.Fatalf("insert next after restart failed")
+	}
+	if pkts := jb.PopPackets(500); len(pkts) != 2 || pkts[0].SequenceNumber != 3000 {
+		t.Fatalf("pop after restart failed: %v", pkts)
+	}
+	if stats := jb.Stats(); stats.Late != 0 {
+		t.Fatalf("restart stats failed: %+v", stats)
+	}
+}
+
+func TestNackGenerator_1(t *testing.T) {
+	gen := NewNackGenerator(1, 2, 2)
+	gen.UpdateRtt(50)
+	for _, seq := range []uint16{0xFFFD, 0xFFFE, 0x0001, 0x0003} {
+		gen.OnRtpPacket(seq)
+	}
+	if gen.MissingCount() != 3 {
+		t.Fatalf("missing count: %d", gen.MissingCount())
+	}
+	nack := gen.GetNackPacket(0)
+	if nack == nil || nack.MediaSSRC != 2 {
+		t.Fatalf("nack failed: %v", nack)
+	}
+	if list := nack.PacketList(); len(list) != 3 || list[0] != 0xFFFF || list[1] != 0 || list[2] != 2 {
+		t.Fatalf("nack list failed: %v", list)
+	}
+	if !gen.OnRtpPacket(0) || gen.Recovered != 1 {
+		t.Fatalf("recovered packet failed")
+	}
+	if list := gen.GetNackList(40); len(list) != 0 {
+		t.Fatalf("retried before rtt: %v", list)
+	}
+	if list := gen.GetNackList(50); len(list) != 2 {
+		t.Fatalf("retry failed: %v", list)
+	}
+	if list := gen.GetNackList(100); len(list) != 0 || gen.GaveUp != 2 || gen.MissingCount() != 0 {
+		t.Fatalf("max retries failed: %v, %d", list, gen.GaveUp)
+	}
+}
+
+func TestNackGenerator_2(t *testing.T) {
+	// a large jump tracks only the last kNackMaxListSize missing packets
+	gen := NewNackGenerator(1, 2, 0)
+	gen.OnRtpPacket(0)
+	gen.OnRtpPacket(30001)
+	if gen.MissingCount() != kNackMaxListSize || gen.GaveUp != 30000-kNackMaxListSize {
+		t.Fatalf("large jump: %d, %d", gen.MissingCount(), gen.GaveUp)
+	}
+	if list := gen.GetNackList(0); len(list) != kNackMaxListSize || list[0] != 30001-kNackMaxListSize {
+		t.Fatalf("large jump list: %d, %v", len(list), list[:1])
+	}
+}
+
+func TestRtpPacketHistory_1(t *testing.T) {
+	sdpPtypes := map[uint8]*SdpPtype{
+		96: {Ptype: 96, AptPtype: 97, Codec: "H264"},
+		97: {Ptype: 97, AptPtype: 96, Codec: "rtx"},
+	}
+	history := NewRtpPacketHistory(2)
+	history.SetRtxFromSdp(&SdpSsrc{Main: 1000, Rtx: 2000}, sdpPtypes)
+	history.UpdateRtt(100)
+
+	for seq := uint16(10); seq < 13; seq++ {
+		pkt := &RtpPacket{
+			RtpHeader: RtpHeader{PayloadType: 96, SequenceNumber: seq, Timestamp: 3000, SSRC: 1000},
+			Payload:   []byte{0x65, byte(seq)},
+		}
+		if err := history.Put(pkt, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if history.Len() != 2 || history.Get(10) != nil {
+		t.Fatalf("history capacity failed")
+	}
+
+	pkts := history.OnNack(NewRtcpNack(1, 1000, []uint16{10, 11, 12}), 10)
+	if len(pkts) != 2 {
+		t.Fatalf("resend failed: %d", len(pkts))
+	}
+	rtx := pkts[0]
+	if rtx.SSRC != 2000 || rtx.PayloadType != 97 || rtx.Timestamp != 3000 ||
+		!bytes.Equal(rtx.Payload, []byte{0, 11, 0x65, 11}) || pkts[1].SequenceNumber != rtx.SequenceNumber+1 {
+		t.Fatalf("rtx packet failed: %v", rtx)
+	}
+	if pkt := history.ResendPacket(11, 50); pkt != nil {
+		t.Fatalf("resent within rtt")
+	}
+	if pkt := history.ResendPacket(11, 110); pkt == nil {
+		t.Fatalf("resend after rtt failed")
+	}
+}
+
+func TestRtxReceiver_1(t *testing.T) {
+	attrs := NewSdpMediaAttrs()
+	attrs.Ssrcs[1000] = &SdpSsrc{Main: 1000, Rtx: 2000, Num: 1}
+	attrs.Ptypes[96] = &SdpPtype{Ptype: 96, AptPtype: 97, Codec: "h264"}
+	attrs.Ptypes[97] = &SdpPtype{Ptype: 97, AptPtype: 96, Codec: "rtx"}
+	receiver := NewRtxReceiver(attrs)
+
+	orig := &RtpPacket{
+		RtpHeader: RtpHeader{Marker: true, PayloadType: 96, SequenceNumber: 0xFFFF, Timestamp: 90000, SSRC: 1000},
+		Payload:   []byte{0x65, 0x88, 0x84},
+	}
+	orig.SetExtension(1, []byte{0x12})
+	if pkt, err := receiver.Restore(orig); err != nil || pkt != orig {
+		t.Fatalf("non-rtx packet should be returned as is")
+	}
+
+	rtx := NewRtxPacket(orig, 2000, 97, 7)
+	rtx.Padding = true
+	rtx.PaddingLength = 4
+	rtx.Payload = append(rtx.Payload, 0, 0, 0, 4)
+	raw, err := rtx.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := &RtpPacket{}
+	if err := received.Unmarshal(raw); err != nil {
+		t.Fatal(err)
+	}
+	if !receiver.IsRtx(received) {
+		t.Fatalf("rtx packet not detected")
+	}
+	pkt, err := receiver.Restore(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.SSRC != 1000 || pkt.PayloadType != 96 || pkt.SequenceNumber != 0xFFFF || !pkt.Marker ||
+		pkt.Timestamp != 90000 || pkt.Padding || !bytes.Equal(pkt.Payload, orig.Payload) ||
+		!bytes.Equal(pkt.GetExtension(1), []byte{0x12}) {
+		t.Fatalf("restore failed: %v", pkt)
+	}
+
+	received.Payload = received.Payload[:1+4]
+	received.PaddingLength = 4
+	if _, err := receiver.Restore(received); err == nil {
+		t.Fatalf("padding-only rtx should fail")
+	}
+}
+
+func TestRtxReceiver_2(t *testing.T) {
+	// no a=ssrc-group:FID, only the single media ssrc
+	attrs := NewSdpMediaAttrs()
+	attrs.Ssrcs[1000] = &SdpSsrc{Main: 1000, Num: 1}
+	attrs.Ptypes[96] = &SdpPtype{Ptype: 96, AptPtype: 97, Codec: "h264"}
+	attrs.Ptypes[97] = &SdpPtype{Ptype: 97, AptPtype: 96, Codec: "rtx"}
+	receiver := NewRtxReceiver(attrs)
+
+	orig := &RtpPacket{
+		RtpHeader: RtpHeader{PayloadType: 96, SequenceNumber: 100, Timestamp: 90000, SSRC: 1000},
+		Payload:   []byte{0x65, 0x88},
+	}
+	rtx := NewRtxPacket(orig, 3000, 97, 7)
+	pkt, err := receiver.Restore(rtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.SSRC != 1000 || pkt.PayloadType != 96 || pkt.SequenceNumber != 100 || !bytes.Equal(pkt.Payload, orig.Payload) {
+		t.Fatalf("restore failed: %v", pkt)
+	}
+
+	// multiple media ssrcs without FID: unknown media ssrc
+	attrs.Ssrcs[1001] = &SdpSsrc{Main: 1001, Num: 1}
+	receiver = NewRtxReceiver(attrs)
+	if pkt, err := receiver.Restore(rtx); err == nil || pkt != nil {
+		t.Fatalf("rtx without FID should fail")
+	}
+}
+
+func TestH264Packetizer_1(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xe0, 0x1f}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := make([]byte, 2500)
+	idr[0] = 0x65
+	for i := 1; i < len(idr); i++ {
+		idr[i] = byte(i)
+	}
+	var au []byte
+	au = append(au, 0, 0, 0, 1, 0x09, 0xf0) // AUD
+	au = append(au, 0, 0, 0, 1)
+	au = append(au, sps...)
+	au = append(au, 0, 0, 1)
+	au = append(au, pps...)
+	au = append(au, 0, 0, 0, 1)
+	au = append(au, idr...)
+
+	ptype := &SdpPtype{Ptype: 102, Codec: "h264", Fmtp: map[string]int{"packetization-mode": 1}}
+	packetizer := NewH264PacketizerFromSdp(1234, ptype, 1000)
+	packetizer.SequenceNumber = 0xFFFF
+	pkts, err := packetizer.Packetize(au, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkts) != 4 {
+		t.Fatalf("packet number: %d", len(pkts))
+	}
+	stapA := []byte{0x78, 0, 4, 0x67, 0x42, 0xe0, 0x1f, 0, 4, 0x68, 0xce, 0x3c, 0x80}
+	if !bytes.Equal(pkts[0].Payload, stapA) || pkts[0].Marker || pkts[0].SequenceNumber != 0xFFFF {
+		t.Fatalf("stap-a failed: %x", pkts[0].Payload)
+	}
+	var data []byte
+	for i, pkt := range pkts[1:] {
+		if len(pkt.Payload) > 1000 || pkt.Payload[0] != 0x7c || pkt.SequenceNumber != uint16(i) {
+			t.Fatalf("fu-a %d failed: %x", i, pkt.Payload[:2])
+		}
+		data = append(data, pkt.Payload[2:]...)
+	}
+	if pkts[1].Payload[1] != 0x85 || pkts[2].Payload[1] != 0x05 || pkts[3].Payload[1] != 0x45 || !pkts[3].Marker {
+		t.Fatalf("fu-a header failed")
+	}
+	if !bytes.Equal(data, idr[1:]) || packetizer.SequenceNumber != 3 {
+		t.Fatalf("fu-a payload failed")
+	}
+
+	single := NewH264Packetizer(1234, 102, 1000, H264_MODE_SINGLE_NAL)
+	if _, err := single.Packetize(au, 3000); err == nil {
+		t.Fatalf("single NAL mode should reject large NAL unit")
+	}
+	pkts, err = single.PacketizeNalus([][]byte{sps, pps}, 3000)
+	if err != nil || len(pkts) != 2 || !pkts[1].Marker || !bytes.Equal(pkts[0].Payload, sps) {
+		t.Fatalf("single NAL mode failed: %v", err)
+	}
+}
+
+func TestH264Depacketizer_1(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xe0, 0x1f}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := make([]byte, 3000)
+	idr[0] = 0x65
+	for i := 1; i < len(idr); i++ {
+		idr[i] = byte(i)
+	}
+	slice := []byte{0x41, 0x9a, 0x02}
+
+	packetizer := NewH264Packetizer(1234, 102, 1200, H264_MODE_NON_INTERLEAVE)
+	pkts1, _ := packetizer.PacketizeNalus([][]byte{sps, pps, idr}, 3000)
+	pkts2, _ := packetizer.PacketizeNalus([][]byte{slice}, 6000)
+
+	depacketizer := NewH264Depacketizer()
+	var frames []*H264Frame
+	for _, pkt := range append(pkts1, pkts2...) {
+		out, err := depacketizer.Push(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, out...)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("frame number: %d", len(frames))
+	}
+	if !frames[0].Keyframe || !frames[0].IsDecodable() || frames[1].Keyframe || frames[0].Timestamp != 3000 {
+		t.Fatalf("keyframe detection failed")
+	}
+	var annexb []byte
+	for _, nalu := range [][]byte{sps, pps, idr} {
+		annexb = append(annexb, 0, 0, 0, 1)
+		annexb = append(annexb, nalu...)
+	}
+	if !bytes.Equal(frames[0].AnnexB(), annexb) {
+		t.Fatalf("annexb failed")
+	}
+	if avcc := frames[1].Avcc(); !bytes.Equal(avcc, []byte{0, 0, 0, 3, 0x41, 0x9a, 0x02}) {
+		t.Fatalf("avcc failed: %x", avcc)
+	}
+
+	// lost one FU-A fragment
+	depacketizer = NewH264Depacketizer()
+	pkts1, _ = packetizer.PacketizeNalus([][]byte{sps, pps, idr}, 9000)
+	pkts2, _ = packetizer.PacketizeNalus([][]byte{slice}, 12000)
+	frames = nil
+	for i, pkt := range append(pkts1, pkts2...) {
+		if i == 2 {
+			continue
+		}
+		out, _ := depacketizer.Push(pkt)
+		frames = append(frames, out...)
+	}
+	if len(frames) != 1 || frames[0].Timestamp != 12000 || depacketizer.Dropped != 1 {
+		t.Fatalf("lost packet failed: %d, %d", len(frames), depacketizer.Dropped)
+	}
+}
+
+func TestH264Payload_1(t *testing.T) {
+	// STAP-B with DON
+	nalus, err := ParseH264Payload([]byte{0x19, 0, 1, 0, 2, 0x67, 0x42, 0, 1, 0x68})
+	if err != nil || len(nalus) != 2 || !bytes.Equal(nalus[0], []byte{0x67, 0x42}) {
+		t.Fatalf("stap-b failed: %v", err)
+	}
+	// MTAP16 with DONB, then size/DOND/TS offset
+	nalus, err = ParseH264Payload([]byte{0x1a, 0, 1, 0, 5, 0, 0, 10, 0x41, 0x9a})
+	if err != nil || len(nalus) != 1 || !bytes.Equal(nalus[0], []byte{0x41, 0x9a}) {
+		t.Fatalf("mtap16 failed: %v", err)
+	}
+	if _, err := ParseH264Payload([]byte{0x18, 0, 9, 0x67}); err == nil {
+		t.Fatalf("invalid stap-a should fail")
+	}
+
+	// FU-B start then FU-A
+	depacketizer := NewH264Depacketizer()
+	depacketizer.Push(&RtpPacket{RtpHeader: RtpHeader{SequenceNumber: 1, Timestamp: 1}, Payload: []byte{0x7d, 0x85, 0, 1, 0xaa}})
+	frames, err := depacketizer.Push(&RtpPacket{RtpHeader: RtpHeader{SequenceNumber: 2, Timestamp: 1, Marker: true}, Payload: []byte{0x7c, 0x45, 0xbb}})
+	if err != nil || len(frames) != 1 || !bytes.Equal(frames[0].Nalus[0], []byte{0x65, 0xaa, 0xbb}) {
+		t.Fatalf("fu-b failed: %v", err)
+	}
+}
+
+func TestH264Parser_1(t *testing.T) {
+	sps := []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0xc0, 0x5a, 0x80, 0x80,
+		0x80, 0xa0, 0x00, 0x00, 0x7d, 0x20, 0x00, 0x1d, 0x4c, 0x11, 0xe1, 0x10, 0x8b, 0x2c}
+	pps := []byte{0x68, 0xeb, 0x8c, 0xb2, 0x2c}
+	idr := []byte{0x65, 0x88, 0x82, 0x00, 0x3f, 0xab}
+	slice := []byte{0x41, 0x16, 0x69, 0x8d, 0x59, 0x49, 0x5a, 0x22, 0xab}
+
+	parser := NewH264Parser()
+	for _, nalu := range [][]byte{sps, pps} {
+		if _, err := parser.ParseNalu(nalu); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := parser.Sps[0]
+	if s.ProfileIdc != 100 || s.LevelIdc != 40 || s.ChromaFormatIdc != 1 || s.Width != 1920 || s.Height != 1080 ||
+		s.MaxNumRefFrames != 4 || s.Log2MaxPocLsb != 6 || !s.FrameCropping || s.CropBottom != 4 {
+		t.Fatalf("sps failed: %+v", s)
+	}
+	v := s.Vui
+	if v.SarWidth != 1 || v.SarHeight != 1 || v.VideoFormat != 5 || v.ColourPrimaries != 1 ||
+		v.MatrixCoefficients != 1 || !v.FixedFrameRate || v.MaxNumReorderFrames != 2 || v.MaxDecFrameBuffering != 4 {
+		t.Fatalf("vui failed: %+v", v)
+	}
+	if rate := s.FrameRate(); rate < 29.97 || rate > 29.98 {
+		t.Fatalf("frame rate failed: %f", rate)
+	}
+	p := parser.Pps[0]
+	if !p.EntropyCodingMode || p.NumRefIdxL0Active != 3 || p.ChromaQpIndexOffset != -2 ||
+		!p.DeblockingFilterControl || !p.Transform8x8Mode || p.SecondChromaQpIndexOffset != -2 {
+		t.Fatalf("pps failed: %+v", p)
+	}
+
+	h, err := parser.ParseNalu(idr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.IsIdr() || !h.IsIntra() || h.IdrPicId != 1 || h.SliceQpDelta != -3 || h.NalRefIdc != 3 {
+		t.Fatalf("idr slice failed: %+v", h)
+	}
+	h, err = parser.ParseNalu(slice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.SliceType != H264_SLICE_P || h.FirstMbInSlice != 10 || h.FrameNum != 3 || h.PicOrderCntLsb != 6 ||
+		h.NumRefIdxL0Active != 2 || !h.AdaptiveRefPicMarking || h.CabacInitIdc != 1 || h.SliceQpDelta != 2 ||
+		h.DisableDeblockingFilterIdc != 1 {
+		t.Fatalf("p slice failed: %+v", h)
+	}
+
+	if _, err := ParseH264Sps(sps[:8]); err == nil {
+		t.Fatalf("truncated sps should fail")
+	}
+	if s, err := ParseH264Sps(sps[:20]); err != nil || s.Width != 1920 || s.Height != 1080 ||
+		s.ProfileIdc != 100 || s.VuiPresent || s.Vui.ColourPrimaries != 2 || s.Vui.TimingInfoPresent {
+		t.Fatalf("truncated vui should be absent: %v", err)
+	}
+
+	// ParseVideo with STAP-A
+	pkts, _ := NewH264Packetizer(1, 102, 1200, H264_MODE_NON_INTERLEAVE).PacketizeNalus([][]byte{sps, pps, idr}, 0)
+	video := pkts[0].ParseVideo()
+	if video.NalType != H264_NAL_STAP_A || video.Width != 1920 || video.Height != 1080 || video.Sps == nil {
+		t.Fatalf("parse video failed: %+v", video)
+	}
+}
+
+func TestBitReader_1(t *testing.T) {
+	rbsp := RemoveEmulationPrevention([]byte{0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x03, 0x00, 0x03})
+	if !bytes.Equal(rbsp, []byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x03}) {
+		t.Fatalf("emulation prevention failed: %x", rbsp)
+	}
+
+	// 1 | 010 | 011 | 00100 | 00101 | 0001000 ...
+	r := NewBitReader([]byte{0xa6, 0x42, 0x88, 0x80})
+	if r.ReadUe() != 0 || r.ReadUe() != 1 || r.ReadUe() != 2 || r.ReadSe() != 2 || r.ReadSe() != -2 || r.ReadUe() != 7 {
+		t.Fatalf("exp-Golomb failed: %v", r.Err())
+	}
+	if r.MoreRbspData() || r.Err() != nil {
+		t.Fatalf("more rbsp data failed")
+	}
+	r.ReadBits(9)
+	if r.Err() == nil {
+		t.Fatalf("overflow should fail")
+	}
+}
+
+func TestH265Parser_1(t *testing.T) {
+	vps := []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00,
+		0x03, 0x00, 0x00, 0x03, 0x00, 0x5d, 0x17, 0x02, 0x40}
+	sps := []byte{0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00,
+		0x03, 0x00, 0x5d, 0xa0, 0x02, 0x80, 0x80, 0x2e, 0x1f, 0x13, 0x95}
+	pps := []byte{0x44, 0x01, 0xc0, 0xf2, 0xb0, 0x60}
+
+	v, err := ParseH265Vps(vps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.MaxSubLayers != 1 || v.Ptl.ProfileIdc != 1 || v.Ptl.LevelIdc != 93 {
+		t.Fatalf("vps failed: %+v", v)
+	}
+	s, err := ParseH265Sps(sps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Width != 1280 || s.Height != 720 || s.PicHeight != 736 || s.ChromaFormatIdc != 1 || s.BitDepthLuma != 8 {
+		t.Fatalf("sps failed: %+v", s)
+	}
+	if s.Ptl.ProfileIdc != 1 || s.Ptl.CompatibilityFlags != 0x60000000 || s.Log2MaxPocLsb != 8 {
+		t.Fatalf("sps ptl failed: %+v", s.Ptl)
+	}
+	p, err := ParseH265Pps(pps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.PpsId != 0 || !p.CabacInitPresent || !p.CuQpDeltaEnabled || p.DiffCuQpDeltaDepth != 1 || !p.EntropyCodingSync {
+		t.Fatalf("pps failed: %+v", p)
+	}
+	if _, err := ParseH265Sps(vps); err == nil {
+		t.Fatalf("mismatched NAL type should fail")
+	}
+	if !IsH265Irap(GetH265NalType([]byte{0x26, 0x01})) || IsH265Irap(GetH265NalType([]byte{0x02, 0x01})) {
+		t.Fatalf("irap detection failed")
+	}
+}
+
+func TestH265Packetizer_1(t *testing.T) {
+	vps := []byte{0x40, 0x01, 0x0c}
+	sps := []byte{0x42, 0x01, 0x01}
+	pps := []byte{0x44, 0x01, 0xc0}
+	idr := make([]byte, 2500)
+	idr[0], idr[1] = 0x26, 0x01
+	for i := 2; i < len(idr); i++ {
+		idr[i] = byte(i)
+	}
+	trail := []byte{0x02, 0x01, 0xd0}
+
+	var au []byte
+	au = append(au, 0, 0, 0, 1, 0x46, 0x01, 0x50) // AUD
+	for _, nalu := range [][]byte{vps, sps, pps, idr} {
+		au = append(au, 0, 0, 0, 1)
+		au = append(au, nalu...)
+	}
+
+	packetizer := NewH265Packetizer(1234, 96, 1000)
+	packetizer.SequenceNumber = 0xFFFF
+	pkts, err := packetizer.Packetize(au, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkts) != 4 {
+		t.Fatalf("packet number: %d", len(pkts))
+	}
+	ap := []byte{0x60, 0x01, 0, 3, 0x40, 0x01, 0x0c, 0, 3, 0x42, 0x01, 0x01, 0, 3, 0x44, 0x01, 0xc0}
+	if !bytes.Equal(pkts[0].Payload, ap) || pkts[0].Marker {
+		t.Fatalf("ap failed: %x", pkts[0].Payload)
+	}
+	for i, pkt := range pkts[1:] {
+		if len(pkt.Payload) > 1000 || pkt.Payload[0] != 0x62 || pkt.Payload[1] != 0x01 || pkt.SequenceNumber != uint16(i) {
+			t.Fatalf("fu %d failed: %x", i, pkt.Payload[:3])
+		}
+	}
+	if pkts[1].Payload[2] != 0x93 || pkts[2].Payload[2] != 0x13 || pkts[3].Payload[2] != 0x53 || !pkts[3].Marker {
+		t.Fatalf("fu header failed")
+	}
+
+	depacketizer := NewH265Depacketizer()
+	pkts2, _ := packetizer.PacketizeNalus([][]byte{trail}, 6000)
+	var frames []*H265Frame
+	for _, pkt := range append(pkts, pkts2...) {
+		out, err := depacketizer.Push(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, out...)
+	}
+	if len(frames) != 2 || !frames[0].Keyframe || !frames[0].IsDecodable() || frames[1].Keyframe {
+		t.Fatalf("keyframe detection failed: %d", len(frames))
+	}
+	if !bytes.Equal(frames[0].AnnexB(), au[7:]) {
+		t.Fatalf("annexb failed")
+	}
+	if hvcc := frames[1].Hvcc(); !bytes.Equal(hvcc, []byte{0, 0, 0, 3, 0x02, 0x01, 0xd0}) {
+		t.Fatalf("hvcc failed: %x", hvcc)
+	}
+
+	// lost one FU fragment
+	depacketizer = NewH265Depacketizer()
+	pkts, _ = packetizer.Packetize(au, 9000)
+	pkts2, _ = packetizer.PacketizeNalus([][]byte{trail}, 12000)
+	frames = nil
+	for i, pkt := range append(pkts, pkts2...) {
+		if i == 2 {
+			continue
+		}
+		out, _ := depacketizer.Push(pkt)
+		frames = append(frames, out...)
+	}
+	if len(frames) != 1 || frames[0].Timestamp != 12000 || depacketizer.Dropped != 1 {
+		t.Fatalf("lost packet failed: %d, %d", len(frames), depacketizer.Dropped)
+	}
+
+	if _, err := ParseH265Payload([]byte{0x64, 0x01, 0x00}); err == nil {
+		t.Fatalf("paci should be unsupported")
+	}
+	if _, err := ParseH265Payload([]byte{0x60, 0x01, 0, 9, 0x40}); err == nil {
+		t.Fatalf("invalid ap should fail")
+	}
+}
+
+func TestVp8Descriptor_1(t *testing.T) {
+	desc := Vp8Descriptor{
+		NonReference:     true,
+		Start:            true,
+		PictureIdPresent: true,
+		PictureIdLong:    true,
+		PictureId:        0x1234,
+		Tl0PicIdxPresent: true,
+		Tl0PicIdx:        7,
+		TidPresent:       true,
+		Tid:              2,
+		LayerSync:        true,
+		KeyIdxPresent:    true,
+		KeyIdx:           5,
+	}
+	buf := make([]byte, desc.MarshalSize())
+	if n, err := desc.MarshalTo(buf); err != nil || n != 6 {
+		t.Fatalf("marshal failed: %d, %v", n, err)
+	}
+	if !bytes.Equal(buf, []byte{0xb0, 0xf0, 0x92, 0x34, 0x07, 0xa5}) {
+		t.Fatalf("marshal failed: %x", buf)
+	}
+	var desc2 Vp8Descriptor
+	if n, err := desc2.Unmarshal(buf); err != nil || n != 6 || desc2 != desc {
+		t.Fatalf("unmarshal failed: %+v, %v", desc2, err)
+	}
+	// 7-bit PictureID
+	if n, err := desc2.Unmarshal([]byte{0x90, 0x80, 0x05, 0xff}); err != nil || n != 3 || desc2.PictureId != 5 || desc2.PictureIdLong {
+		t.Fatalf("short PictureID failed: %+v, %v", desc2, err)
+	}
+	if _, err := desc2.Unmarshal([]byte{0x90, 0x80, 0x85}); err == nil {
+		t.Fatalf("insufficient PictureID should fail")
+	}
+}
+
+func TestVp8Packetizer_1(t *testing.T) {
+	key := make([]byte, 2500)
+	copy(key, []byte{0x90, 0x0c, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0x68, 0x01})
+	inter := []byte{0x91, 0x0c, 0x00, 0x01, 0x02}
+
+	packetizer := NewVp8Packetizer(1234, 96, 1000)
+	packetizer.PictureId = kVp8MaxPictureId
+	pkts1, err := packetizer.Packetize(key, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkts2, _ := packetizer.Packetize(inter, 6000)
+	if len(pkts1) != 3 || len(pkts2) != 1 || !pkts1[2].Marker || pkts1[1].Marker {
+		t.Fatalf("packet number: %d, %d", len(pkts1), len(pkts2))
+	}
+	var desc Vp8Descriptor
+	for i, pkt := range pkts1 {
+		if _, err := desc.Unmarshal(pkt.Payload); err != nil || desc.Start != (i == 0) || desc.PictureId != kVp8MaxPictureId {
+			t.Fatalf("descriptor %d failed: %+v", i, desc)
+		}
+		if len(pkt.Payload) > 1000 {
+			t.Fatalf("mtu failed: %d", len(pkt.Payload))
+		}
+	}
+	if desc.Unmarshal(pkts2[0].Payload); desc.PictureId != 0 {
+		t.Fatalf("PictureID wrap failed: %d", desc.PictureId)
+	}
+
+	depacketizer := NewVp8Depacketizer()
+	var frames []*Vp8Frame
+	for _, pkt := range append(pkts1, pkts2...) {
+		out, err := depacketizer.Push(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, out...)
+	}
+	if len(frames) != 2 || !frames[0].Keyframe || frames[1].Keyframe || frames[1].PictureId != 0 {
+		t.Fatalf("keyframe detection failed: %d", len(frames))
+	}
+	if frames[0].Width != 640 || frames[0].Height != 360 || !bytes.Equal(frames[0].Data, key) || !bytes.Equal(frames[1].Data, inter) {
+		t.Fatalf("frame data failed: %dx%d", frames[0].Width, frames[0].Height)
+	}
+
+	// lost the middle packet of keyframe
+	depacketizer = NewVp8Depacketizer()
+	pkts1, _ = packetizer.Packetize(key, 9000)
+	pkts2, _ = packetizer.Packetize(inter, 12000)
+	frames = nil
+	for i, pkt := range append(pkts1, pkts2...) {
+		if i == 1 {
+			continue
+		}
+		out, _ := depacketizer.Push(pkt)
+		frames = append(frames, out...)
+	}
+	if len(frames) != 1 || frames[0].Timestamp != 12000 || depacketizer.Dropped != 1 {
+		t.Fatalf("lost packet failed: %d, %d", len(frames), depacketizer.Dropped)
+	}
+}
+
+func TestVp9Descriptor_1(t *testing.T) {
+	// flexible mode with two references
+	desc := Vp9Descriptor{
+		PictureIdPresent:      true,
+		PictureIdLong:         true,
+		PictureId:             0x1234,
+		InterPicturePredicted: true,
+		LayerIndicesPresent:   true,
+		FlexibleMode:          true,
+		StartOfFrame:          true,
+		Tid:                   1,
+		SwitchingUp:           true,
+		Sid:                   2,
+		InterLayerDependency:  true,
+		PDiffs:                []uint8{1, 4},
+	}
+	buf := make([]byte, desc.MarshalSize())
+	if n, err := desc.MarshalTo(buf); err != nil || n != 6 {
+		t.Fatalf("marshal failed: %d, %v", n, err)
+	}
+	if !bytes.Equal(buf, []byte{0xf8, 0x92, 0x34, 0x35, 0x03, 0x08}) {
+		t.Fatalf("marshal failed: %x", buf)
+	}
+	var desc2 Vp9Descriptor
+	if n, err := desc2.Unmarshal(buf); err != nil || n != 6 || desc2.Sid != 2 || desc2.Tid != 1 ||
+		!desc2.SwitchingUp || !desc2.InterLayerDependency || !bytes.Equal(desc2.PDiffs, []uint8{1, 4}) {
+		t.Fatalf("unmarshal failed: %+v, %v", desc2, err)
+	}
+
+	// non-flexible mode with SS of two spatial layers and one picture group
+	desc = Vp9Descriptor{
+		PictureIdPresent:    true,
+		PictureId:           5,
+		LayerIndicesPresent: true,
+		StartOfFrame:        true,
+		Tl0PicIdx:           9,
+		Ss: &Vp9ScalabilityStructure{
+			NumSpatialLayers: 2,
+			Widths:           []uint16{320, 640},
+			Heights:          []uint16{180, 360},
+			HasPictureGroups: true,
+			PictureGroups:    []Vp9PictureGroup{{Tid: 0, SwitchingUp: false, PDiffs: []uint8{1}}},
+		},
+	}
+	buf = make([]byte, desc.MarshalSize())
+	if n, err := desc.MarshalTo(buf); err != nil || n != 16 {
+		t.Fatalf("marshal ss failed: %d, %v", n, err)
+	}
+	if n, err := desc2.Unmarshal(buf); err != nil || n != 16 || desc2.Tl0PicIdx != 9 || desc2.Ss == nil {
+		t.Fatalf("unmarshal ss failed: %+v, %v", desc2, err)
+	}
+	if ss := desc2.Ss; ss.NumSpatialLayers != 2 || ss.Widths[1] != 640 || ss.Heights[0] != 180 ||
+		len(ss.PictureGroups) != 1 || !bytes.Equal(ss.PictureGroups[0].PDiffs, []uint8{1}) {
+		t.Fatalf("unmarshal ss failed: %+v", ss)
+	}
+	if _, err := desc2.Unmarshal([]byte{0x52, 0x03, 0x03, 0x03, 0x02}); err == nil {
+		t.Fatalf("too many P_DIFF should fail")
+	}
+}
+
+func TestVp9Packetizer_1(t *testing.T) {
+	key := make([]byte, 2500)
+	copy(key, []byte{0x82, 0x49, 0x83, 0x42, 0x20, 0x27, 0xf0, 0x16, 0x70})
+	inter := []byte{0x86, 0x00, 0x01, 0x02}
+
+	header, err := ParseVp9FrameHeader(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !header.Keyframe || !header.ShowFrame || header.Width != 640 || header.Height != 360 || header.BitDepth != 8 ||
+		header.ColorSpace != VP9_CS_BT_601 || !header.SubsamplingX {
+		t.Fatalf("vp9 header failed: %+v", header)
+	}
+	if header, err = ParseVp9FrameHeader(inter); err != nil || header.Keyframe || header.Width != 0 {
+		t.Fatalf("vp9 inter header failed: %v", err)
+	}
+	if _, err = ParseVp9FrameHeader([]byte{0x02, 0x00}); err == nil {
+		t.Fatalf("invalid frame marker should fail")
+	}
+
+	packetizer := NewVp9Packetizer(1234, 98, 1000)
+	pkts1, err := packetizer.Packetize(key, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkts2, _ := packetizer.Packetize(inter, 6000)
+	if len(pkts1) != 3 || len(pkts2) != 1 || !pkts1[2].Marker {
+		t.Fatalf("packet number: %d, %d", len(pkts1), len(pkts2))
+	}
+	var desc Vp9Descriptor
+	for i, pkt := range pkts1 {
+		if _, err := desc.Unmarshal(pkt.Payload); err != nil || desc.StartOfFrame != (i == 0) || desc.EndOfFrame != (i == 2) {
+			t.Fatalf("descriptor %d failed: %+v", i, desc)
+		}
+		if (desc.Ss != nil) != (i == 0) || desc.InterPicturePredicted || len(pkt.Payload) > 1000 {
+			t.Fatalf("descriptor %d ss failed: %d", i, len(pkt.Payload))
+		}
+	}
+	if desc.Unmarshal(pkts2[0].Payload); !desc.InterPicturePredicted || desc.Ss != nil {
+		t.Fatalf("inter descriptor failed: %+v", desc)
+	}
+
+	depacketizer := NewVp9Depacketizer()
+	var frames []*Vp9Frame
+	for _, pkt := range append(pkts1, pkts2...) {
+		out, err := depacketizer.Push(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, out...)
+	}
+	if len(frames) != 2 || !frames[0].Keyframe || frames[1].Keyframe || !frames[1].EndOfPicture {
+		t.Fatalf("keyframe detection failed: %d", len(frames))
+	}
+	if !bytes.Equal(frames[0].Data, key) || !bytes.Equal(frames[1].Data, inter) {
+		t.Fatalf("frame data failed")
+	}
+	// the inter frame size from SS
+	if frames[1].Width != 640 || frames[1].Height != 360 {
+		t.Fatalf("resolution failed: %dx%d", frames[1].Width, frames[1].Height)
+	}
+
+	// lost the last packet of keyframe
+	depacketizer = NewVp9Depacketizer()
+	pkts1, _ = packetizer.Packetize(key, 9000)
+	pkts2, _ = packetizer.Packetize(inter, 12000)
+	frames = nil
+	for i, pkt := range append(pkts1, pkts2...) {
+		if i == 2 {
+			continue
+		}
+		out, _ := depacketizer.Push(pkt)
+		frames = append(frames, out...)
+	}
+	if len(frames) != 1 || frames[0].Timestamp != 12000 || depacketizer.Dropped != 1 {
+		t.Fatalf("lost packet failed: %d, %d", len(frames), depacketizer.Dropped)
+	}
+}
+
+func TestAv1Obu_1(t *testing.T) {
+	for _, value := range []uint64{0, 127, 128, 300, 1 << 40} {
+		buf := AppendLeb128(nil, value)
+		if v, n, err := ReadLeb128(buf); err != nil || v != value || n != len(buf) || n != Leb128Size(value) {
+			t.Fatalf("leb128 failed: %d, %d, %v", value, v, err)
+		}
+	}
+
+	seq := []byte{0x00, 0x00, 0x00, 0x42, 0xaa, 0x7f, 0xac, 0xf8}
+	header, err := ParseAv1SequenceHeader(seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Profile != 0 || header.LevelIdx != 8 || header.MaxWidth != 1280 || header.MaxHeight != 720 {
+		t.Fatalf("sequence header failed: %+v", header)
+	}
+
+	// temporal unit: TD, sequence header, frame with extension
+	tu := []byte{0x12, 0x00, 0x0a, 0x08}
+	tu = append(tu, seq...)
+	tu = append(tu, 0x36, 0x28, 0x03, 0x10, 0xaa, 0xbb)
+	obus, err := SplitAv1Obus(tu)
+	if err != nil || len(obus) != 3 {
+		t.Fatalf("split failed: %d, %v", len(obus), err)
+	}
+	if obus[2].Type != AV1_OBU_FRAME || !obus[2].HasExtension || obus[2].TemporalId != 1 || obus[2].SpatialId != 1 {
+		t.Fatalf("obu extension failed: %+v", obus[2])
+	}
+	if ftype, err := GetAv1FrameType(obus[2].Payload, header); err != nil || ftype != AV1_KEY_FRAME {
+		t.Fatalf("frame type failed: %d, %v", ftype, err)
+	}
+	if !bytes.Equal(obus[2].Bytes(true), tu[len(tu)-6:]) || !bytes.Equal(obus[2].Bytes(false), []byte{0x34, 0x28, 0x10, 0xaa, 0xbb}) {
+		t.Fatalf("obu bytes failed")
+	}
+	if _, _, err := ParseAv1Obu([]byte{0x32, 0x05, 0x01}); err == nil {
+		t.Fatalf("insufficient obu should fail")
+	}
+}
+
+func TestAv1Packetizer_1(t *testing.T) {
+	seq := []byte{0x00, 0x00, 0x00, 0x42, 0xaa, 0x7f, 0xac, 0xf8}
+	frame := make([]byte, 2500)
+	frame[0] = 0x10 // KEY_FRAME
+	for i := 1; i < len(frame); i++ {
+		frame[i] = byte(i)
+	}
+	var tu []byte
+	tu = append(tu, 0x12, 0x00) // TD
+	tu = append(tu, 0x0a, 0x08)
+	tu = append(tu, seq...)
+	tu = append(tu, 0x32)
+	tu = AppendLeb128(tu, uint64(len(frame)))
+	tu = append(tu, frame...)
+
+	packetizer := NewAv1Packetizer(1234, 45, 1000)
+	pkts, err := packetizer.Packetize(tu, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkts) != 3 || !pkts[2].Marker || pkts[1].Marker {
+		t.Fatalf("packet number: %d", len(pkts))
+	}
+	for i, pkt := range pkts {
+		header, elements, err := ParseAv1Payload(pkt.Payload)
+		if err != nil || len(pkt.Payload) > 1000 {
+			t.Fatalf("packet %d failed: %v", i, err)
+		}
+		if header.N != (i == 0) || header.Z != (i > 0) || header.Y != (i < 2) {
+			t.Fatalf("aggregation header %d failed: %+v", i, header)
+		}
+		if i == 0 && (header.W != 2 || len(elements) != 2 || !bytes.Equal(elements[0][1:], seq)) {
+			t.Fatalf("aggregation %d failed: %+v", i, header)
+		}
+	}
+
+	inter := []byte{0x32, 0x03, 0x30, 0x01, 0x02} // INTER_FRAME
+	pkts2, _ := packetizer.Packetize(inter, 6000)
+	depacketizer := NewAv1Depacketizer()
+	var frames []*Av1Frame
+	for _, pkt := range append(pkts, pkts2...) {
+		out, err := depacketizer.Push(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, out...)
+	}
+	if len(frames) != 2 || !frames[0].Keyframe || !frames[0].NewSequence || frames[1].Keyframe {
+		t.Fatalf("keyframe detection failed: %d", len(frames))
+	}
+	if frames[0].Width != 1280 || frames[0].Height != 720 || !bytes.Equal(frames[0].Obus[1].Payload, frame) {
+		t.Fatalf("frame failed: %dx%d", frames[0].Width, frames[0].Height)
+	}
+	if !bytes.Equal(frames[0].Bytes(), tu) || !bytes.Equal(frames[1].Bytes()[2:], inter) {
+		t.Fatalf("bitstream failed")
+	}
+
+	// lost the middle fragment
+	depacketizer = NewAv1Depacketizer()
+	pkts, _ = packetizer.Packetize(tu, 9000)
+	pkts2, _ = packetizer.Packetize(inter, 12000)
+	frames = nil
+	for i, pkt := range append(pkts, pkts2...) {
+		if i == 1 {
+			continue
+		}
+		out, _ := depacketizer.Push(pkt)
+		frames = append(frames, out...)
+	}
+	if len(frames) != 1 || frames[0].Timestamp != 12000 || depacketizer.Dropped != 1 {
+		t.Fatalf("lost packet failed: %d, %d", len(frames), depacketizer.Dropped)
+	}
+}
+
+func TestRtpDependencyDescriptor_1(t *testing.T) {
+	reader := NewRtpDependencyDescriptorReader()
+	if _, err := reader.Read([]byte{0xc1, 0x00, 0x02}); err == nil {
+		t.Fatalf("descriptor without structure should fail")
+	}
+
+	// L1T2 structure with resolution
+	dd, err := reader.Read([]byte{0xc0, 0x00, 0x01, 0x80, 0x01, 0x7a, 0x18, 0xa0, 0x80, 0x60, 0x4f, 0xe0, 0x2c, 0xe0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	structure := dd.Structure
+	if !dd.AttachedStructure || structure.DecodeTargets != 2 || len(structure.Templates) != 2 || structure.Chains != 1 {
+		t.Fatalf("structure failed: %+v", structure)
+	}
+	if structure.Templates[1].TemporalId != 1 || structure.Templates[0].FrameDiffs[0] != 2 || structure.Templates[1].ChainDiffs[0] != 1 {
+		t.Fatalf("templates failed: %+v", structure.Templates)
+	}
+	if structure.DecodeTargetMaxTemporalId[0] != 0 || structure.DecodeTargetMaxTemporalId[1] != 1 {
+		t.Fatalf("decode targets failed: %+v", structure.DecodeTargetMaxTemporalId)
+	}
+	if !dd.StartOfFrame || !dd.EndOfFrame || dd.FrameNumber != 1 || dd.Width != 640 || dd.Height != 360 ||
+		!dd.IsInDecodeTarget(0) || !dd.IsInDecodeTarget(1) {
+		t.Fatalf("descriptor failed: %+v", dd)
+	}
+
+	// T1 frame is dropped for decode target 0
+	dd, err = reader.Read([]byte{0xc1, 0x00, 0x02})
+	if err != nil || dd.TemporalId != 1 || dd.AttachedStructure || dd.IsInDecodeTarget(0) || !dd.IsInDecodeTarget(1) {
+		t.Fatalf("T1 descriptor failed: %+v, %v", dd, err)
+	}
+
+	// active decode targets and custom frame diffs
+	dd, err = reader.Read([]byte{0x80, 0x00, 0x03, 0x52, 0x90})
+	if err != nil || dd.EndOfFrame || dd.ActiveDecodeTargets != 1 || len(dd.FrameDiffs) != 1 || dd.FrameDiffs[0] != 3 {
+		t.Fatalf("custom descriptor failed: %+v, %v", dd, err)
+	}
+	if dd.IsInDecodeTarget(1) || !dd.IsInDecodeTarget(0) {
+		t.Fatalf("active decode targets failed")
+	}
+
+	// typed extension
+	extmap := NewRtpExtensionMap()
+	extmap.RegisterUri(7, RtpExtUriDependencyDescriptor)
+	pkt := &RtpPacket{}
+	pkt.ExtensionMap = extmap
+	pkt.RtpExtension.DependencyDescriptor = string([]byte{0xc1, 0x00, 0x02})
+	buf, err := pkt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out RtpPacket
+	out.ExtensionMap = extmap
+	if err := out.Unmarshal(buf); err != nil || out.RtpExtension.DependencyDescriptor != pkt.RtpExtension.DependencyDescriptor {
+		t.Fatalf("extension failed: %v", err)
+	}
+}
+
+func TestOpusPacket_1(t *testing.T) {
+	// CELT FB 20ms stereo, code 0
+	info, err := ParseOpusPacket([]byte{0xfc, 0x01, 0x02, 0x03})
+	if err != nil || info.Mode != OPUS_MODE_CELT || info.Bandwidth != OPUS_BANDWIDTH_FB || !info.Stereo ||
+		info.FrameCount != 1 || info.DurationUs() != 20000 || info.Samples() != 960 || info.Dtx {
+		t.Fatalf("code 0 failed: %+v, %v", info, err)
+	}
+
+	// Hybrid FB 20ms, SILK WB 20ms with code 2
+	info, err = ParseOpusPacket([]byte{0x78, 0x01})
+	if err != nil || info.Mode != OPUS_MODE_HYBRID || info.Bandwidth != OPUS_BANDWIDTH_FB || !info.Dtx || !IsOpusDtx([]byte{0x78}) {
+		t.Fatalf("hybrid failed: %+v, %v", info, err)
+	}
+	info, err = ParseOpusPacket([]byte{0x4a, 0x03, 1, 2, 3, 4, 5})
+	if err != nil || info.Mode != OPUS_MODE_SILK || info.Bandwidth != OPUS_BANDWIDTH_WB || info.FrameCount != 2 ||
+		info.FrameSizes[0] != 3 || info.FrameSizes[1] != 2 || info.DurationUs() != 40000 {
+		t.Fatalf("code 2 failed: %+v, %v", info, err)
+	}
+
+	// SILK NB 20ms, code 3 VBR with 3 frames
+	info, err = ParseOpusPacket([]byte{0x0b, 0x83, 0x02, 0x03, 1, 2, 3, 4, 5, 6, 7, 8})
+	if err != nil || info.Bandwidth != OPUS_BANDWIDTH_NB || info.FrameCount != 3 || info.DurationUs() != 60000 ||
+		info.FrameSizes[0] != 2 || info.FrameSizes[1] != 3 || info.FrameSizes[2] != 3 {
+		t.Fatalf("code 3 VBR failed: %+v, %v", info, err)
+	}
+
+	// CELT NB 2.5ms, code 3 CBR with padding
+	info, err = ParseOpusPacket([]byte{0x83, 0x42, 0x02, 1, 2, 3, 4, 0, 0})
+	if err != nil || info.FrameCount != 2 || info.DurationUs() != 5000 || info.Padding != 2 ||
+		info.FrameSizes[0] != 2 || info.FrameSizes[1] != 2 {
+		t.Fatalf("code 3 CBR failed: %+v, %v", info, err)
+	}
+
+	// invalid packets
+	invalids := [][]byte{
+		{},
+		{0xf9, 1, 2, 3},       // code 1 with odd size
+		{0xfb, 0x00},          // code 3 without frames
+		{0xfb, 0x07, 1, 2, 3}, // 140ms
+		{0xfb, 0x41, 0x09, 1}, // padding too large
+	}
+	for i, payload := range invalids {
+		if _, err := ParseOpusPacket(payload); err == nil {
+			t.Fatalf("invalid %d failed", i)
+		}
+	}
+}
+
+func TestG711_1(t *testing.T) {
+	if LinearToUlaw(0) != G711_ULAW_SILENCE || LinearToAlaw(0) != G711_ALAW_SILENCE {
+		t.Fatalf("silence failed")
+	}
+	if LinearToUlaw(32767) != 0x80 || LinearToUlaw(-32768) != 0x00 || UlawToLinear(0x80) != 32124 || UlawToLinear(0x00) != -32124 {
+		t.Fatalf("ulaw range failed")
+	}
+	if LinearToAlaw(32767) != 0xaa || LinearToAlaw(-32768) != 0x2a || AlawToLinear(0xaa) != 32256 || AlawToLinear(0x2a) != -32256 {
+		t.Fatalf("alaw range failed")
+	}
+
+	// all codes are stable after decoding and encoding
+	for i := 0; i < 256; i++ {
+		if LinearToUlaw(UlawToLinear(uint8(i))) != uint8(i) && i != 0x7f {
+			t.Fatalf("ulaw code %x failed", i)
+		}
+		if LinearToAlaw(AlawToLinear(uint8(i))) != uint8(i) {
+			t.Fatalf("alaw code %x failed", i)
+		}
+	}
+
+	// the quantization error is within the segment step
+	for v := -32768; v < 32768; v += 7 {
+		sample := int16(v)
+		if Abs(int(DecodePcmu(EncodePcmu([]int16{sample}))[0])-v) > 1024 {
+			t.Fatalf("ulaw error too large: %d", v)
+		}
+		if Abs(int(DecodePcma(EncodePcma([]int16{sample}))[0])-v) > 1024 {
+			t.Fatalf("alaw error too large: %d", v)
+		}
+	}
+
+	// PCM bytes
+	codec, err := NewG711Codec("PCMA")
+	if err != nil || !codec.Alaw {
+		t.Fatalf("new codec failed: %v", err)
+	}
+	samples := []int16{0, 100, -100, 1000, -1000, 30000}
+	payload, err := codec.Encode(Int16ToByteSlice(samples))
+	if err != nil || len(payload) != len(samples) {
+		t.Fatalf("encode failed: %v", err)
+	}
+	pcm, err := ByteToInt16Slice(codec.Decode(payload))
+	if err != nil || len(pcm) != len(samples) {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if _, err := codec.Encode([]byte{1, 2, 3}); err == nil {
+		t.Fatalf("odd pcm failed")
+	}
+	if !bytes.Equal(PcmuToPcma([]byte{G711_ULAW_SILENCE}), []byte{G711_ALAW_SILENCE}) {
+		t.Fatalf("transcode silence failed")
+	}
+	for i := 0; i < 256; i++ {
+		ulaw := []byte{uint8(i)}
+		if Abs(int(DecodePcma(PcmuToPcma(ulaw))[0])-int(DecodePcmu(ulaw)[0])) > 1024 {
+			t.Fatalf("transcode %x failed", i)
+		}
+		if Abs(int(DecodePcmu(PcmaToPcmu(ulaw))[0])-int(DecodePcma(ulaw)[0])) > 1024 {
+			t.Fatalf("transcode %x failed", i)
+		}
+	}
+	if !bytes.Equal(codec.Silence(2), []byte{0xd5, 0xd5}) {
+		t.Fatalf("silence payload failed")
+	}
+}
+
+func TestRtpDtmf_1(t *testing.T) {
+	event := RtpTelephoneEvent{Event: RTP_DTMF_POUND, End: true, Volume: 10, Duration: 1600}
+	payload := event.Marshal()
+	if !bytes.Equal(payload, []byte{0x0b, 0x8a, 0x06, 0x40}) {
+		t.Fatalf("marshal failed: %x", payload)
+	}
+	var parsed RtpTelephoneEvent
+	if _, err := parsed.Unmarshal(payload); err != nil || parsed != event {
+		t.Fatalf("unmarshal failed: %+v, %v", parsed, err)
+	}
+	if ev, err := RtpDtmfEventOfDigit('b'); err != nil || ev != RTP_DTMF_B || RtpDtmfDigitOfEvent(RTP_DTMF_STAR) != '*' {
+		t.Fatalf("digit failed")
+	}
+	if _, err := RtpDtmfEventOfDigit('x'); err == nil {
+		t.Fatalf("invalid digit failed")
+	}
+
+	// 120ms of digit 5: start, 2 updates and 3 ends
+	sender := NewRtpDtmfSender(1234, 126, 8000)
+	pkts, err := sender.Generate('5', 16000, 120)
+	if err != nil || len(pkts) != 6 {
+		t.Fatalf("generate failed: %d, %v", len(pkts), err)
+	}
+	for i, pkt := range pkts {
+		if pkt.Timestamp != 16000 || pkt.Marker != (i == 0) || pkt.SequenceNumber != pkts[0].SequenceNumber+uint16(i) {
+			t.Fatalf("packet %d failed: %v", i, pkt)
+		}
+	}
+	parsed.Unmarshal(pkts[2].Payload)
+	if parsed.Event != 5 || parsed.End || parsed.Duration != 800 {
+		t.Fatalf("update failed: %+v", parsed)
+	}
+	parsed.Unmarshal(pkts[5].Payload)
+	if !parsed.End || parsed.Duration != 960 {
+		t.Fatalf("end failed: %+v", parsed)
+	}
+	if _, err := sender.Update(10); err == nil {
+		t.Fatalf("update after end failed")
+	}
+
+	// the redundant ends are reported once
+	receiver := NewRtpDtmfReceiver(8000)
+	var events []*RtpDtmfEvent
+	for _, pkt := range pkts {
+		evs, err := receiver.Push(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, evs...)
+	}
+	if len(events) != 1 || events[0].Digit != '5' || events[0].DurationMs != 120 || !events[0].Ended {
+		t.Fatalf("receive failed: %d", len(events))
+	}
+
+	// the next event finishes the one without end packets
+	pkt, _ := sender.Start('#', 20000)
+	upd, _ := sender.Update(60)
+	ends, _ := sender.End(80)
+	evs, _ := receiver.Push(pkt)
+	evs2, _ := receiver.Push(upd[0])
+	if len(evs) != 0 || len(evs2) != 0 {
+		t.Fatalf("pending event failed")
+	}
+	next, _ := sender.Generate('1', 24000, 40)
+	evs, _ = receiver.Push(next[0])
+	if len(evs) != 1 || evs[0].Digit != '#' || evs[0].Ended || evs[0].DurationMs != 60 {
+		t.Fatalf("lost end failed: %+v", evs)
+	}
+	// the late end packets of previous event are dropped
+	if evs, _ = receiver.Push(ends[0]); len(evs) != 0 {
+		t.Fatalf("late end failed")
+	}
+	if ev := receiver.Flush(); ev == nil || ev.Digit != '1' || receiver.Flush() != nil {
+		t.Fatalf("flush failed")
+	}
+}
+
+func TestRtpDtmf_2(t *testing.T) {
+	// 20s of digit 9: 160000 units in segments of 0xFFFF, 0xFFFF and 28930
+	sender := NewRtpDtmfSender(1234, 126, 8000)
+	sender.IntervalMs = 1000
+	pkts, err := sender.Generate('9', 1000, 20000)
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+	var segments []uint32
+	var parsed RtpTelephoneEvent
+	for i, pkt := range pkts {
+		if pkt.Marker != (i == 0) || pkt.SequenceNumber != pkts[0].SequenceNumber+uint16(i) {
+			t.Fatalf("packet %d failed: %v", i, pkt)
+		}
+		if len(segments) == 0 || segments[len(segments)-1] != pkt.Timestamp {
+			if i > 0 {
+				// the previous segment is finished with max duration, but not E bit
+				parsed.Unmarshal(pkts[i-1].Payload)
+				if parsed.End || parsed.Duration != kRtpDtmfMaxDuration {
+					t.Fatalf("segment %d end failed: %+v", len(segments), parsed)
+				}
+			}
+			segments = append(segments, pkt.Timestamp)
+		}
+		parsed.Unmarshal(pkt.Payload)
+		if parsed.End != (i >= len(pkts)-kRtpDtmfEndRetransmits) {
+			t.Fatalf("packet %d end failed: %+v", i, parsed)
+		}
+	}
+	if len(segments) != 3 || segments[1] != 1000+kRtpDtmfMaxDuration || segments[2] != 1000+2*kRtpDtmfMaxDuration {
+		t.Fatalf("segments failed: %v", segments)
+	}
+	if parsed.Event != 9 || parsed.Duration != 28930 {
+		t.Fatalf("final segment failed: %+v", parsed)
+	}
+
+	// the update over max duration begins a new segment
+	sender.Start('1', 0)
+	upd, _ := sender.Update(8200)
+	if len(upd) != 2 || upd[0].Timestamp != 0 || upd[1].Timestamp != kRtpDtmfMaxDuration {
+		t.Fatalf("update failed: %d", len(upd))
+	}
+	parsed.Unmarshal(upd[1].Payload)
+	if parsed.Duration != 8200*8-kRtpDtmfMaxDuration {
+		t.Fatalf("update duration failed: %+v", parsed)
+	}
+}
+
+func TestRtpRed_1(t *testing.T) {
+	blocks := []RtpRedBlock{
+		{PayloadType: 111, TimestampOffset: 960, Payload: []byte{1, 2, 3}},
+		{PayloadType: 111, Payload: []byte{4, 5}},
+	}
+	payload, err := MarshalRtpRedPayload(blocks)
+	if err != nil || !bytes.Equal(payload, []byte{0xef, 0x0f, 0x00, 0x03, 0x6f, 1, 2, 3, 4, 5}) {
+		t.Fatalf("marshal failed: %x, %v", payload, err)
+	}
+	parsed, err := ParseRtpRedPayload(payload)
+	if err != nil || len(parsed) != 2 || parsed[0].TimestampOffset != 960 || !bytes.Equal(parsed[0].Payload, blocks[0].Payload) ||
+		parsed[1].PayloadType != 111 || !bytes.Equal(parsed[1].Payload, blocks[1].Payload) {
+		t.Fatalf("parse failed: %+v, %v", parsed, err)
+	}
+	if _, err := ParseRtpRedPayload([]byte{0xef, 0x0f, 0x00, 0x09, 0x6f, 1}); err == nil {
+		t.Fatalf("invalid block failed")
+	}
+
+	// audio redundancy of distance 1
+	encoder := NewRtpRedEncoder(63, 1)
+	var reds []*RtpPacket
+	for i := 0; i < 3; i++ {
+		pkt := &RtpPacket{
+			RtpHeader: RtpHeader{Version: kRtpVersion, PayloadType: 111, SequenceNumber: uint16(100 + i), Timestamp: uint32(960 * i), SSRC: 1},
+			Payload:   []byte{uint8(i), uint8(i)},
+		}
+		red, err := encoder.Encode(pkt)
+		if err != nil || red.PayloadType != 63 || red.SequenceNumber != pkt.SequenceNumber {
+			t.Fatalf("encode failed: %v", err)
+		}
+		reds = append(reds, red)
+	}
+	pkts, err := DecodeRtpRedPacket(reds[2])
+	if err != nil || len(pkts) != 2 {
+		t.Fatalf("decode failed: %d, %v", len(pkts), err)
+	}
+	if pkts[0].SequenceNumber != 101 || pkts[0].Timestamp != 960 || !bytes.Equal(pkts[0].Payload, []byte{1, 1}) ||
+		pkts[1].SequenceNumber != 102 || pkts[1].Timestamp != 1920 || pkts[1].PayloadType != 111 {
+		t.Fatalf("decode packets failed")
+	}
+	if pkts, _ = DecodeRtpRedPacket(reds[0]); len(pkts) != 1 {
+		t.Fatalf("decode first failed")
+	}
+}
+
+func TestRtpUlpfec_1(t *testing.T) {
+	var pkts []*RtpPacket
+	for i := 0; i < 8; i++ {
+		pkt := &RtpPacket{
+			RtpHeader: RtpHeader{
+				Version:        kRtpVersion,
+				Marker:         i%4 == 3,
+				PayloadType:    96,
+				SequenceNumber: uint16(65530 + i),
+				Timestamp:      uint32(3000 * (i / 4)),
+				SSRC:           0x1234,
+			},
+			Payload: bytes.Repeat([]byte{uint8(i + 1)}, 10+i*7),
+		}
+		pkts = append(pkts, pkt)
+	}
+
+	// 2 interleaved FEC packets for the group of 8
+	encoder := NewRtpUlpfecEncoder(8, 2)
+	var fecs [][]byte
+	for _, pkt := range pkts {
+		out, err := encoder.Push(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fecs = append(fecs, out...)
+	}
+	if len(fecs) != 2 {
+		t.Fatalf("encode failed: %d", len(fecs))
+	}
+
+	// lose the burst of 2 packets
+	decoder := NewRtpUlpfecDecoder(0)
+	for i, pkt := range pkts {
+		if i == 3 || i == 4 {
+			continue
+		}
+		if out, err := decoder.PushMedia(pkt); err != nil || len(out) != 0 {
+			t.Fatalf("push media failed: %v", err)
+		}
+	}
+	var recovered []*RtpPacket
+	for i, fec := range fecs {
+		fecPkt := &RtpPacket{RtpHeader: RtpHeader{Version: kRtpVersion, PayloadType: 117, SequenceNumber: uint16(i), SSRC: 0x1234}, Payload: fec}
+		out, err := decoder.PushFec(fecPkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recovered = append(recovered, out...)
+	}
+	if len(recovered) != 2 || decoder.Recovered != 2 {
+		t.Fatalf("recover failed: %d", len(recovered))
+	}
+	for _, pkt := range recovered {
+		orig := pkts[int(uint16(pkt.SequenceNumber-65530))]
+		if pkt.Marker != orig.Marker || pkt.PayloadType != 96 || pkt.Timestamp != orig.Timestamp ||
+			pkt.SSRC != orig.SSRC || !bytes.Equal(pkt.Payload, orig.Payload) {
+			t.Fatalf("recovered packet failed: %v", pkt)
+		}
+	}
+
+	// the FEC which protects 2 lost packets waits for the other
+	fec, err := EncodeRtpUlpfec(pkts[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder = NewRtpUlpfecDecoder(0)
+	decoder.PushMedia(pkts[0])
+	out, _ := decoder.PushFec(&RtpPacket{RtpHeader: RtpHeader{SSRC: 0x1234}, Payload: fec})
+	if len(out) != 0 {
+		t.Fatalf("recover 2 losses failed")
+	}
+	out, _ = decoder.PushMedia(pkts[2])
+	if len(out) != 1 || out[0].SequenceNumber != pkts[1].SequenceNumber || !bytes.Equal(out[0].Payload, pkts[1].Payload) {
+		t.Fatalf("recover later failed")
+	}
+
+	// long mask
+	fec, err = EncodeRtpUlpfec([]*RtpPacket{pkts[0], {RtpHeader: RtpHeader{Version: kRtpVersion, SequenceNumber: 14}}})
+	if err != nil || fec[0]&0x40 == 0 {
+		t.Fatalf("long mask failed: %v", err)
+	}
+	if _, err = EncodeRtpUlpfec([]*RtpPacket{pkts[0], {RtpHeader: RtpHeader{Version: kRtpVersion, SequenceNumber: 42}}}); err == nil {
+		t.Fatalf("out of mask failed")
+	}
+}
+
+func TestRtpFlexfec_1(t *testing.T) {
+	// header with the masks of 3 sizes
+	header := RtpFlexfecHeader{RecoveryFlags: 0x10, RecoveryMarkerPt: 0xe0, LengthRecovery: 100, TimestampRecovery: 0x12345678}
+	for _, bits := range []int{1, 20, 100} {
+		mask := NewBitSet(bits + 1)
+		mask.Set(0)
+		mask.Set(bits)
+		header.Streams = append(header.Streams, RtpFlexfecStream{SSRC: uint32(bits), SeqBase: uint16(1000 * bits), Mask: mask})
+	}
+	buf := make([]byte, header.MarshalSize())
+	if n, err := header.MarshalTo(buf); err != nil || n != 12+8+12+20 {
+		t.Fatalf("marshal failed: %d, %v", n, err)
+	}
+	if !bytes.Equal(buf[12:20], []byte{0, 0, 0, 1, 0x03, 0xe8, 0xe0, 0x00}) {
+		t.Fatalf("short mask failed: %x", buf[12:20])
+	}
+	var parsed RtpFlexfecHeader
+	if n, err := parsed.Unmarshal(buf); err != nil || n != len(buf) || len(parsed.Streams) != 3 ||
+		parsed.LengthRecovery != 100 || parsed.TimestampRecovery != 0x12345678 || parsed.RecoveryMarkerPt != 0xe0 {
+		t.Fatalf("unmarshal failed: %+v, %v", parsed, err)
+	}
+	for i, bits := range []int{1, 20, 100} {
+		stream := parsed.Streams[i]
+		if stream.SSRC != uint32(bits) || stream.Mask.Count() != 2 || !stream.Mask.Test(0) || !stream.Mask.Test(bits) {
+			t.Fatalf("stream %d failed: %+v", i, stream)
+		}
+	}
+
+	// masks
+	rows := RtpFlexfecRowMasks(7, 3)
+	cols := RtpFlexfecColumnMasks(7, 3)
+	if len(rows) != 3 || rows[2].Count() != 1 || len(cols) != 3 || cols[0].Count() != 3 || !cols[1].Test(4) {
+		t.Fatalf("masks failed")
+	}
+
+	// column protection of 4x3 block for the burst loss
+	var pkts []*RtpPacket
+	for i := 0; i < 12; i++ {
+		pkts = append(pkts, &RtpPacket{
+			RtpHeader: RtpHeader{Version: kRtpVersion, Marker: i == 11, PayloadType: 96, SequenceNumber: uint16(65530 + i), Timestamp: 9000, SSRC: 0x1111},
+			Payload:   bytes.Repeat([]byte{uint8(i)}, 20+i),
+		})
+	}
+	encoder := NewRtpFlexfecEncoder(0x2222, 118, 4, 3)
+	var fecs []*RtpPacket
+	for _, pkt := range pkts {
+		out, err := encoder.Push(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fecs = append(fecs, out...)
+	}
+	if len(fecs) != 4 || fecs[0].SSRC != 0x2222 || fecs[1].SequenceNumber != fecs[0].SequenceNumber+1 {
+		t.Fatalf("encode failed: %d", len(fecs))
+	}
+
+	receiver := NewRtpFlexfecReceiverFromSdp(&SdpSsrc{Main: 0x1111, Fec: 0x2222}, 0)
+	for i, pkt := range pkts {
+		if i >= 5 && i <= 8 {
+			continue
+		}
+		raw, _ := pkt.Marshal()
+		var received RtpPacket
+		received.Unmarshal(raw)
+		if out, err := receiver.Push(&received); err != nil || len(out) != 0 {
+			t.Fatalf("push media failed: %v", err)
+		}
+	}
+	var recovered []*RtpPacket
+	for _, fec := range fecs {
+		out, err := receiver.Push(fec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recovered = append(recovered, out...)
+	}
+	if len(recovered) != 4 || receiver.Recovered != 4 {
+		t.Fatalf("recover failed: %d", len(recovered))
+	}
+	for _, pkt := range recovered {
+		orig := pkts[int(uint16(pkt.SequenceNumber-65530))]
+		if pkt.SSRC != 0x1111 || pkt.Marker != orig.Marker || pkt.Timestamp != 9000 || !bytes.Equal(pkt.Payload, orig.Payload) {
+			t.Fatalf("recovered packet failed: %v", pkt)
+		}
+	}
+	if NewRtpFlexfecReceiverFromSdp(&SdpSsrc{Main: 0x1111}, 0) != nil {
+		t.Fatalf("receiver without fec failed")
+	}
+}
+
+func TestSrtp_1(t *testing.T) {
+	// RFC 3711 B.3
+	masterKey, _ := hex.DecodeString("E1F97A0D3E018BE0D64FA32C06DE4139")
+	masterSalt, _ := hex.DecodeString("0EC675AD498AFEEBB6960B3AABE6")
+	checks := []struct {
+		label uint8
+		size  int
+		want  string
+	}{
+		{kSrtpLabelRtpEncryption, 16, "C61E7A93744F39EE10734AFE3FF7A087"},
+		{kSrtpLabelRtpSalt, 14, "30CBBC08863D8C85D49DB34A9AE1"},
+		{kSrtpLabelRtpAuth, 20, "CEBE321F6FF7716B6FD4AB49AF256A156D38BAA4"},
+	}
+	for _, check := range checks {
+		key, err := srtpDeriveKey(masterKey, masterSalt, check.label, check.size)
+		if err != nil || !strings.EqualFold(hex.EncodeToString(key), check.want) {
+			t.Fatalf("derive label %d: %x, %v", check.label, key, err)
+		}
+	}
+
+	// the test packet of libsrtp
+	ctx, err := NewSrtpContext(SRTP_AES128_CM_HMAC_SHA1_80, masterKey, masterSalt)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	plain, _ := hex.DecodeString("800f1234decafbadcafebabeabababababababababababababababab")
+	want, _ := hex.DecodeString("800f1234decafbadcafebabe4e55dc4ce79978d88ca4d215949d2402b78d6acc99ea179b8dbb")
+	out, err := ctx.EncryptRtp(plain)
+	if err != nil || !bytes.Equal(out, want) {
+		t.Fatalf("encrypt: %x, %v", out, err)
+	}
+}
+
+func TestSrtp_2(t *testing.T) {
+	profiles := []SrtpProtectionProfile{
+		SRTP_AES128_CM_HMAC_SHA1_80,
+		SRTP_AES128_CM_HMAC_SHA1_32,
+		SRTP_AEAD_AES_128_GCM,
+		SRTP_AEAD_AES_256_GCM,
+	}
+	for _, profile := range profiles {
+		masterKey := make([]byte, profile.KeyLen())
+		masterSalt := make([]byte, profile.SaltLen())
+		for i := range masterKey {
+			masterKey[i] = byte(i + 1)
+		}
+		sender, err := NewSrtpContext(profile, masterKey, masterSalt)
+		if err != nil {
+			t.Fatalf("%v new: %v", profile, err)
+		}
+		receiver, _ := NewSrtpContext(profile, masterKey, masterSalt)
+
+		// the sequence wraps, and ROC increases
+		var lastData []byte
+		for i := 0; i < 8; i++ {
+			pkt := &RtpPacket{
+				RtpHeader: RtpHeader{Version: kRtpVersion, PayloadType: 96, SequenceNumber: uint16(65532 + i), Timestamp: uint32(i), SSRC: 1234},
+				Payload:   []byte{1, 2, 3, byte(i)},
+			}
+			data, err := sender.ProtectRtp(pkt)
+			if err != nil {
+				t.Fatalf("%v protect: %v", profile, err)
+			}
+			out, err := receiver.UnprotectRtp(data)
+			if err != nil || out.SequenceNumber != pkt.SequenceNumber || !bytes.Equal(out.Payload, pkt.Payload) {
+				t.Fatalf("%v unprotect %d: %v", profile, i, err)
+			}
+			lastData = data
+		}
+		if sender.Roc(1234) != 1 || receiver.Roc(1234) != 1 {
+			t.Fatalf("%v roc: %d, %d", profile, sender.Roc(1234), receiver.Roc(1234))
+		}
+		if _, err := receiver.UnprotectRtp(lastData); err == nil {
+			t.Fatalf("%v replay accepted", profile)
+		}
+		tampered := append([]byte(nil), lastData...)
+		tampered[kRtpHeaderLength] ^= 1
+		tampered[kRtpSeqNumOffset+1] += 1
+		if _, err := receiver.UnprotectRtp(tampered); err == nil {
+			t.Fatalf("%v tamper accepted", profile)
+		}
+
+		rr := &RtcpReceiverReport{SSRC: 1234}
+		for i := 0; i < 2; i++ {
+			data, err := sender.ProtectRtcp([]RtcpPacket{rr})
+			if err != nil {
+				t.Fatalf("%v protect rtcp: %v", profile, err)
+			}
+			packets, err := receiver.UnprotectRtcp(data)
+			if err != nil || len(packets) != 1 {
+				t.Fatalf("%v unprotect rtcp: %v", profile, err)
+			}
+			if out, ok := packets[0].(*RtcpReceiverReport); !ok || out.SSRC != 1234 {
+				t.Fatalf("%v rtcp: %v", profile, packets[0])
+			}
+			if _, err := receiver.UnprotectRtcp(data); err == nil {
+				t.Fatalf("%v rtcp replay accepted", profile)
+			}
+		}
+	}
+
+	// the initial ROC of one joined session
+	masterKey := make([]byte, 16)
+	masterSalt := make([]byte, 14)
+	sender, _ := NewSrtpContext(SRTP_AES128_CM_HMAC_SHA1_80, masterKey, masterSalt)
+	receiver, _ := NewSrtpContext(SRTP_AES128_CM_HMAC_SHA1_80, masterKey, masterSalt)
+	sender.SetRoc(5, 3)
+	pkt := &RtpPacket{RtpHeader: RtpHeader{Version: kRtpVersion, SequenceNumber: 100, SSRC: 5}, Payload: []byte{1}}
+	data, _ := sender.ProtectRtp(pkt)
+	if _, err := receiver.UnprotectRtp(data); err == nil {
+		t.Fatalf("roc mismatch accepted")
+	}
+	receiver.SetRoc(5, 3)
+	if _, err := receiver.UnprotectRtp(data); err != nil || receiver.Roc(5) != 3 {
+		t.Fatalf("roc: %v, %d", err, receiver.Roc(5))
+	}
+
+	if _, err := NewSrtpContext(SRTP_AEAD_AES_128_GCM, masterKey, masterSalt); err == nil {
+		t.Fatalf("invalid salt accepted")
+	}
+}
+
+// The RFC 7714 section 16 and 17 vectors use the session keys directly.
+func TestSrtp_3(t *testing.T) {
+	unhex := func(text string) []byte {
+		data, err := hex.DecodeString(strings.Join(strings.Fields(text), ""))
+		if err != nil {
+			t.Fatalf("hex: %v", err)
+		}
+		return data
+	}
+	salt := unhex("517569642070726f2071756f")
+	plainRtp := unhex(`8040f17b 8041f8d3 5501a0b2 47616c6c 69612065 7374206f 6d6e6973 20646976
+		69736120 696e2070 61727465 73207472 6573`)
+	plainRtcp := unhex(`81c8000d 4d617273 4e545031 4e545032 52545020 0000042a 0000e930 4c756e61
+		deadbeef deadbeef deadbeef deadbeef deadbeef`)
+	checks := []struct {
+		profile SrtpProtectionProfile
+		key     string
+		srtp    string
+		srtcp   string
+	}{
+		{SRTP_AEAD_AES_128_GCM, "000102030405060708090a0b0c0d0e0f",
+			`8040f17b 8041f8d3 5501a0b2 f24de3a3 fb34de6c acba861c 9d7e4bca be633bd5
+			0d294e6f 42a5f47a 51c7d19b 36de3adf 8833899d 7f27beb1 6a9152cf 765ee439 0cce`,
+			`81c8000d 4d617273 63e94885 dcdab67c a727d766 2f6b7e99 7ff5c0f7 6c06f32d
+			c676a5f1 730d6fda 4ce09b46 86303ded 0bb9275b c84aa458 96cf4d2f c5abf872 45d9eade 800005d4`},
+		{SRTP_AEAD_AES_256_GCM, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			`8040f17b 8041f8d3 5501a0b2 32b1de78 a822fe12 ef9f78fa 332e33aa b1801238
+			9a58e2f3 b50b2a02 76ffae0f 1ba63799 b87b7aa3 db36dfff d6b0f9bb 7878d7a7 6c13`,
+			`81c8000d 4d617273 d50ae4d1 f5ce5d30 4ba297e4 7d470c28 2c3ece5d bffe0a50
+			a2eaa5c1 110555be 8415f658 c61de047 6f1b6fad 1d1eb30c 4446839f 57ff6f6c b26ac3be 800005d4`},
+	}
+	for _, check := range checks {
+		key := unhex(check.key)
+		newContext := func() *SrtpContext {
+			c, err := newSrtpContextWithSessionKeys(check.profile, key, salt, nil, key, salt, nil)
+			if err != nil {
+				t.Fatalf("%v new: %v", check.profile, err)
+			}
+			c.getState(c.rtcpStates, 0x4d617273).rtcpIndex = 0x5d4
+			return c
+		}
+		sender, receiver := newContext(), newContext()
+		wantSrtp, wantSrtcp := unhex(check.srtp), unhex(check.srtcp)
+
+		if out, err := sender.EncryptRtp(plainRtp); err != nil || !bytes.Equal(out, wantSrtp) {
+			t.Fatalf("%v srtp: %v, %x", check.profile, err, out)
+		}
+		if out, err := receiver.DecryptRtp(wantSrtp); err != nil || !bytes.Equal(out, plainRtp) {
+			t.Fatalf("%v decrypt srtp: %v, %x", check.profile, err, out)
+		}
+		if out, err := sender.EncryptRtcp(plainRtcp); err != nil || !bytes.Equal(out, wantSrtcp) {
+			t.Fatalf("%v srtcp: %v, %x", check.profile, err, out)
+		}
+		if out, err := receiver.DecryptRtcp(wantSrtcp); err != nil || !bytes.Equal(out, plainRtcp) {
+			t.Fatalf("%v decrypt srtcp: %v, %x", check.profile, err, out)
+		}
+	}
+}
+
+func TestSrtp_4(t *testing.T) {
+	profile := SRTP_AES128_CM_HMAC_SHA1_80
+	key, salt := make([]byte, profile.KeyLen()), make([]byte, profile.SaltLen())
+	sender, _ := NewSrtpContext(profile, key, salt)
+	receiver, _ := NewSrtpContext(profile, key, salt)
+	protect := func(ssrc uint32, seq uint16) []byte {
+		pkt := &RtpPacket{RtpHeader: RtpHeader{Version: kRtpVersion, SequenceNumber: seq, SSRC: ssrc}, Payload: []byte{1}}
+		data, err := sender.ProtectRtp(pkt)
+		if err != nil {
+			t.Fatalf("protect: %v", err)
+		}
+		return data
+	}
+
+	// the live stream(ssrc 0) is kept when too many SSRCs are tracked
+	receiver.SetRoc(0, 5)
+	for ssrc := uint32(1); ssrc < kSrtpMaxSsrcStates+10; ssrc++ {
+		receiver.getState(receiver.rtpStates, 0)
+		receiver.DecryptRtp(protect(ssrc, 1))
+	}
+	if len(receiver.rtpStates) != kSrtpMaxSsrcStates || receiver.Roc(0) != 5 {
+		t.Fatalf("states: %d, roc=%d", len(receiver.rtpStates), receiver.Roc(0))
+	}
+	if _, ok := receiver.rtpStates[1]; ok {
+		t.Fatalf("least recently used state not evicted")
+	}
+
+	// the forged packet of new SSRC adds no state
+	forged := protect(99999, 1)
+	forged[len(forged)-1] ^= 1
+	if _, err := receiver.DecryptRtp(forged); err == nil {
+		t.Fatalf("forged accepted")
+	}
+	if _, ok := receiver.rtpStates[99999]; ok {
+		t.Fatalf("forged packet added state")
+	}
+}
+
+func TestSrtp_5(t *testing.T) {
+	for _, profile := range []SrtpProtectionProfile{SRTP_AEAD_AES_128_GCM, SRTP_AES128_CM_HMAC_SHA1_80} {
+		key, salt := make([]byte, profile.KeyLen()), make([]byte, profile.SaltLen())
+		receiver, err := NewSrtpContext(profile, key, salt)
+		if err != nil {
+			t.Fatalf("%v new: %v", profile, err)
+		}
+		// the short packets with E=0 and E=1
+		for size := 0; size < kSrtcpEncryptedOffset+kSrtcpIndexLen+profile.rtcpTagLen(); size++ {
+			for _, ebit := range []byte{0x00, 0x80} {
+				data := make([]byte, size)
+				indexOffset := size - kSrtcpIndexLen
+				if !profile.IsAead() {
+					indexOffset -= profile.rtcpTagLen()
+				}
+				if indexOffset >= kSrtcpEncryptedOffset {
+					data[0] = 0x81
+					data[indexOffset] = ebit
+				}
+				if _, err := receiver.DecryptRtcp(data); err == nil {
+					t.Fatalf("%v short srtcp(%d, E=%x) accepted", profile, size, ebit)
+				}
+			}
+		}
+	}
+}
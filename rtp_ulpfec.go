@@ -0,0 +1,248 @@
+package goutil
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+/*
+ * ULPFEC payload format(RFC 5109), with only the level 0 protection:
+ *
+ * FEC header:
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |E|L|P|X|  CC   |M| PT recovery |            SN base            |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                          TS recovery                          |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |        length recovery        |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * ULP level header:
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |       Protection Length       |             mask              |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |              mask cont. (present only when L = 1)             |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * The mask bit i(from MSB) means the packet of "SN base + i" is protected, and
+ * the recovery fields are the XOR of protected packets' P/X/CC/M/PT, timestamp,
+ * length(after the 12-byte header) and the bytes after the 12-byte header.
+ */
+
+const (
+	kUlpfecHeaderSize       = 10
+	kUlpfecLevelHeaderSize  = 4 // with short mask
+	kUlpfecLongMaskSize     = 4 // the mask cont.
+	kUlpfecLBit             = 0x40
+	kUlpfecEBit             = 0x80
+	kUlpfecShortMaskBits    = 16
+	kUlpfecLongMaskBits     = 48
+	kUlpfecDefaultGroupSize = 10
+)
+
+// EncodeRtpUlpfec generates one FEC payload which protects all pkts of one
+// stream, and their sequences must be within 48 from the first one.
+func EncodeRtpUlpfec(pkts []*RtpPacket) ([]byte, error) {
+	if len(pkts) == 0 {
+		return nil, NewErrorf("ULPFEC packets empty")
+	}
+	base := pkts[0].SequenceNumber
+	for _, pkt := range pkts {
+		if IsNewerRtpSeq(base, pkt.SequenceNumber) {
+			base = pkt.SequenceNumber
+		}
+	}
+	var mask uint64
+	for _, pkt := range pkts {
+		offset := uint16(pkt.SequenceNumber - base)
+		if offset >= kUlpfecLongMaskBits {
+			return nil, NewErrorf("ULPFEC sequence out of mask: %d, %d", pkt.SequenceNumber, base)
+		}
+		mask |= uint64(1) << (kUlpfecLongMaskBits - 1 - uint(offset))
+	}
+	longMask := mask&((uint64(1)<<(kUlpfecLongMaskBits-kUlpfecShortMaskBits))-1) != 0
+
+	raws, err := marshalRtpFecPackets(pkts)
+	if err != nil {
+		return nil, err
+	}
+	recovery := newRtpFecRecovery(raws)
+
+	headerSize := kUlpfecHeaderSize + kUlpfecLevelHeaderSize
+	if longMask {
+		headerSize += kUlpfecLongMaskSize
+	}
+	buf := make([]byte, headerSize+len(recovery.payload))
+	buf[0] = recovery.flags
+	if longMask {
+		buf[0] |= kUlpfecLBit
+	}
+	buf[1] = recovery.markerPt
+	binary.BigEndian.PutUint16(buf[2:], base)
+	binary.BigEndian.PutUint32(buf[4:], recovery.timestamp)
+	binary.BigEndian.PutUint16(buf[8:], recovery.length)
+	binary.BigEndian.PutUint16(buf[kUlpfecHeaderSize:], uint16(len(recovery.payload)))
+	binary.BigEndian.PutUint16(buf[kUlpfecHeaderSize+2:], uint16(mask>>(kUlpfecLongMaskBits-kUlpfecShortMaskBits)))
+	if longMask {
+		binary.BigEndian.PutUint32(buf[kUlpfecHeaderSize+4:], uint32(mask))
+	}
+	copy(buf[headerSize:], recovery.payload)
+	return buf, nil
+}
+
+// RtpUlpfecEncoder groups the media packets of one stream, and generates the
+// FEC payloads of each group. The FEC payload j protects the packets i of
+// i%FecNum == j, so that the burst loss of FecNum packets can be recovered.
+// The FEC payloads are sent in RED(ulpfec block ptype) or with ulpfec ptype,
+// and use the sequences of media stream.
+type RtpUlpfecEncoder struct {
+	GroupSize int
+	FecNum    int
+
+	packets []*RtpPacket
+}
+
+// NewRtpUlpfecEncoder creates an encoder of fecNum FEC packets for each
+// groupSize(<=0 for default, <=48) media packets.
+func NewRtpUlpfecEncoder(groupSize, fecNum int) *RtpUlpfecEncoder {
+	if groupSize <= 0 {
+		groupSize = kUlpfecDefaultGroupSize
+	}
+	groupSize = Min(groupSize, kUlpfecLongMaskBits)
+	fecNum = Min(Max(fecNum, 1), groupSize)
+	return &RtpUlpfecEncoder{GroupSize: groupSize, FecNum: fecNum}
+}
+
+// Push adds one media packet, and returns the FEC payloads if one group is full.
+func (e *RtpUlpfecEncoder) Push(pkt *RtpPacket) ([][]byte, error) {
+	var fecs [][]byte
+	if len(e.packets) > 0 && uint16(pkt.SequenceNumber-e.packets[0].SequenceNumber) >= kUlpfecLongMaskBits {
+		var err error
+		if fecs, err = e.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	e.packets = append(e.packets, pkt)
+	if len(e.packets) >= e.GroupSize {
+		more, err := e.Flush()
+		if err != nil {
+			return nil, err
+		}
+		fecs = append(fecs, more...)
+	}
+	return fecs, nil
+}
+
+// Flush returns the FEC payloads of current packets(e.g. at the end of frame).
+func (e *RtpUlpfecEncoder) Flush() ([][]byte, error) {
+	pkts := e.packets
+	e.packets = nil
+	if len(pkts) == 0 {
+		return nil, nil
+	}
+	var fecs [][]byte
+	for j := 0; j < Min(e.FecNum, len(pkts)); j++ {
+		var group []*RtpPacket
+		for i := j; i < len(pkts); i += e.FecNum {
+			group = append(group, pkts[i])
+		}
+		fec, err := EncodeRtpUlpfec(group)
+		if err != nil {
+			return nil, err
+		}
+		fecs = append(fecs, fec)
+	}
+	return fecs, nil
+}
+
+// parseRtpUlpfecPacket parses one FEC payload which protects the stream of ssrc.
+func parseRtpUlpfecPacket(ssrc uint32, data []byte) (*rtpFecPacket, error) {
+	if len(data) < kUlpfecHeaderSize+kUlpfecLevelHeaderSize {
+		return nil, NewErrorf("ULPFEC insufficient: %d", len(data))
+	}
+	if data[0]&kUlpfecEBit != 0 {
+		return nil, NewErrorf("ULPFEC extension unsupported")
+	}
+	headerSize := kUlpfecHeaderSize + kUlpfecLevelHeaderSize
+	mask := uint64(binary.BigEndian.Uint16(data[kUlpfecHeaderSize+2:])) << (kUlpfecLongMaskBits - kUlpfecShortMaskBits)
+	if data[0]&kUlpfecLBit != 0 {
+		if len(data) < headerSize+kUlpfecLongMaskSize {
+			return nil, NewErrorf("ULPFEC long mask insufficient: %d", len(data))
+		}
+		mask |= uint64(binary.BigEndian.Uint32(data[kUlpfecHeaderSize+4:]))
+		headerSize += kUlpfecLongMaskSize
+	}
+	protLen := int(binary.BigEndian.Uint16(data[kUlpfecHeaderSize:]))
+	if headerSize+protLen > len(data) {
+		return nil, NewErrorf("ULPFEC protection length invalid: %d", protLen)
+	}
+
+	fec := &rtpFecPacket{
+		recovery: &rtpFecRecovery{
+			flags:     data[0] & kRtpFecRecoveryMask,
+			markerPt:  data[1],
+			timestamp: binary.BigEndian.Uint32(data[4:]),
+			length:    binary.BigEndian.Uint16(data[8:]),
+			payload:   append([]byte(nil), data[headerSize:headerSize+protLen]...),
+		},
+	}
+	base := binary.BigEndian.Uint16(data[2:])
+	for i := 0; i < kUlpfecLongMaskBits; i++ {
+		if mask&(uint64(1)<<(kUlpfecLongMaskBits-1-uint(i))) != 0 {
+			fec.keys = append(fec.keys, rtpFecKey(ssrc, base+uint16(i)))
+		}
+	}
+	if len(fec.keys) == 0 {
+		return nil, NewErrorf("ULPFEC mask empty")
+	}
+	return fec, nil
+}
+
+// RtpUlpfecDecoder recovers the lost media packets of one stream with the
+// received media and FEC packets.
+type RtpUlpfecDecoder struct {
+	sync.Mutex
+	recoverer *rtpFecRecoverer
+
+	Recovered int
+}
+
+// NewRtpUlpfecDecoder creates a decoder which keeps capacity(<=0 for default) media packets.
+func NewRtpUlpfecDecoder(capacity int) *RtpUlpfecDecoder {
+	return &RtpUlpfecDecoder{recoverer: newRtpFecRecoverer(capacity)}
+}
+
+// PushMedia adds one received media packet, and returns the recovered ones.
+func (d *RtpUlpfecDecoder) PushMedia(pkt *RtpPacket) ([]*RtpPacket, error) {
+	raw, err := pkt.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.recoverer.addMedia(rtpFecKey(pkt.SSRC, pkt.SequenceNumber), raw)
+	return d.recover(), nil
+}
+
+// PushFec adds one received FEC packet(the ulpfec block of RED, or of ulpfec
+// ptype), and returns the recovered media packets.
+func (d *RtpUlpfecDecoder) PushFec(pkt *RtpPacket) ([]*RtpPacket, error) {
+	fec, err := parseRtpUlpfecPacket(pkt.SSRC, rtpPayloadWithoutPadding(pkt))
+	if err != nil {
+		return nil, err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.recoverer.addFec(fec)
+	return d.recover(), nil
+}
+
+func (d *RtpUlpfecDecoder) recover() []*RtpPacket {
+	pkts := d.recoverer.recover()
+	d.Recovered = d.recoverer.recovered
+	return pkts
+}
@@ -0,0 +1,364 @@
+package goutil
+
+import (
+	"encoding/binary"
+)
+
+/*
+ * VP8 payload descriptor(RFC 7741 4.2):
+ *
+ *       0 1 2 3 4 5 6 7
+ *      +-+-+-+-+-+-+-+-+
+ *      |X|R|N|S|R| PID | (REQUIRED)
+ *      +-+-+-+-+-+-+-+-+
+ * X:   |I|L|T|K| RSV   | (OPTIONAL)
+ *      +-+-+-+-+-+-+-+-+
+ * I:   |M| PictureID   | (OPTIONAL)
+ *      +-+-+-+-+-+-+-+-+
+ *      |   PictureID   | (OPTIONAL, M=1)
+ *      +-+-+-+-+-+-+-+-+
+ * L:   |   TL0PICIDX   | (OPTIONAL)
+ *      +-+-+-+-+-+-+-+-+
+ * T/K: |TID|Y| KEYIDX  | (OPTIONAL)
+ *      +-+-+-+-+-+-+-+-+
+ */
+
+const (
+	kVp8XBit          = 0x80
+	kVp8NBit          = 0x20
+	kVp8SBit          = 0x10
+	kVp8PidMask       = 0x07
+	kVp8IBit          = 0x80
+	kVp8LBit          = 0x40
+	kVp8TBit          = 0x20
+	kVp8KBit          = 0x10
+	kVp8MBit          = 0x80
+	kVp8TidMask       = 0xC0
+	kVp8YBit          = 0x20
+	kVp8KeyIdxMask    = 0x1F
+	kVp8MaxPictureId  = 0x7FFF
+	kVp8DefaultMtu    = 1200
+	kVp8KeyHeaderSize = 10 // frame tag(3), start code(3), width(2), height(2)
+)
+
+var kVp8StartCode = []byte{0x9d, 0x01, 0x2a}
+
+// Vp8Descriptor is the VP8 payload descriptor of one RTP packet.
+type Vp8Descriptor struct {
+	NonReference bool // N
+	Start        bool // S, the start of one partition
+	PartitionId  uint8
+
+	PictureIdPresent bool // I
+	PictureId        uint16
+	PictureIdLong    bool // M, 15-bit PictureID
+	Tl0PicIdxPresent bool // L
+	Tl0PicIdx        uint8
+	TidPresent       bool // T
+	Tid              uint8
+	LayerSync        bool // Y
+	KeyIdxPresent    bool // K
+	KeyIdx           uint8
+}
+
+// MarshalSize returns the size of the descriptor.
+func (d *Vp8Descriptor) MarshalSize() int {
+	size := 1
+	if d.PictureIdPresent || d.Tl0PicIdxPresent || d.TidPresent || d.KeyIdxPresent {
+		size += 1
+		if d.PictureIdPresent {
+			size += 1
+			if d.PictureIdLong {
+				size += 1
+			}
+		}
+		if d.Tl0PicIdxPresent {
+			size += 1
+		}
+		if d.TidPresent || d.KeyIdxPresent {
+			size += 1
+		}
+	}
+	return size
+}
+
+// MarshalTo writes the descriptor into buf, and returns the size.
+func (d *Vp8Descriptor) MarshalTo(buf []byte) (int, error) {
+	size := d.MarshalSize()
+	if len(buf) < size {
+		return 0, NewErrorf("VP8 descriptor buffer insufficient: %d < %d", len(buf), size)
+	}
+
+	buf[0] = d.PartitionId & kVp8PidMask
+	if d.NonReference {
+		buf[0] |= kVp8NBit
+	}
+	if d.Start {
+		buf[0] |= kVp8SBit
+	}
+	if size == 1 {
+		return size, nil
+	}
+
+	buf[0] |= kVp8XBit
+	buf[1] = 0
+	n := 2
+	if d.PictureIdPresent {
+		buf[1] |= kVp8IBit
+		if d.PictureIdLong {
+			binary.BigEndian.PutUint16(buf[n:], kVp8MBit<<8|(d.PictureId&kVp8MaxPictureId))
+			n += 2
+		} else {
+			buf[n] = uint8(d.PictureId & 0x7F)
+			n += 1
+		}
+	}
+	if d.Tl0PicIdxPresent {
+		buf[1] |= kVp8LBit
+		buf[n] = d.Tl0PicIdx
+		n += 1
+	}
+	if d.TidPresent || d.KeyIdxPresent {
+		buf[n] = 0
+		if d.TidPresent {
+			buf[1] |= kVp8TBit
+			buf[n] |= (d.Tid << 6) & kVp8TidMask
+			if d.LayerSync {
+				buf[n] |= kVp8YBit
+			}
+		}
+		if d.KeyIdxPresent {
+			buf[1] |= kVp8KBit
+			buf[n] |= d.KeyIdx & kVp8KeyIdxMask
+		}
+		n += 1
+	}
+	return n, nil
+}
+
+// Unmarshal parses the descriptor from one RTP payload, and returns its size.
+func (d *Vp8Descriptor) Unmarshal(payload []byte) (int, error) {
+	if len(payload) < 1 {
+		return 0, NewErrorf("VP8 descriptor insufficient: %d", len(payload))
+	}
+	*d = Vp8Descriptor{}
+	d.NonReference = payload[0]&kVp8NBit != 0
+	d.Start = payload[0]&kVp8SBit != 0
+	d.PartitionId = payload[0] & kVp8PidMask
+	if payload[0]&kVp8XBit == 0 {
+		return 1, nil
+	}
+
+	if len(payload) < 2 {
+		return 0, NewErrorf("VP8 descriptor X insufficient: %d", len(payload))
+	}
+	ext := payload[1]
+	n := 2
+	if ext&kVp8IBit != 0 {
+		d.PictureIdPresent = true
+		if len(payload) < n+1 {
+			return 0, NewErrorf("VP8 descriptor PictureID insufficient: %d", len(payload))
+		}
+		if payload[n]&kVp8MBit != 0 {
+			if len(payload) < n+2 {
+				return 0, NewErrorf("VP8 descriptor PictureID insufficient: %d", len(payload))
+			}
+			d.PictureIdLong = true
+			d.PictureId = binary.BigEndian.Uint16(payload[n:]) & kVp8MaxPictureId
+			n += 2
+		} else {
+			d.PictureId = uint16(payload[n])
+			n += 1
+		}
+	}
+	if ext&kVp8LBit != 0 {
+		d.Tl0PicIdxPresent = true
+		if len(payload) < n+1 {
+			return 0, NewErrorf("VP8 descriptor TL0PICIDX insufficient: %d", len(payload))
+		}
+		d.Tl0PicIdx = payload[n]
+		n += 1
+	}
+	if ext&(kVp8TBit|kVp8KBit) != 0 {
+		if len(payload) < n+1 {
+			return 0, NewErrorf("VP8 descriptor TID/KEYIDX insufficient: %d", len(payload))
+		}
+		if ext&kVp8TBit != 0 {
+			d.TidPresent = true
+			d.Tid = payload[n] >> 6
+			d.LayerSync = payload[n]&kVp8YBit != 0
+		}
+		if ext&kVp8KBit != 0 {
+			d.KeyIdxPresent = true
+			d.KeyIdx = payload[n] & kVp8KeyIdxMask
+		}
+		n += 1
+	}
+	return n, nil
+}
+
+// Vp8FrameHeader is the header of one VP8 frame(RFC 6386 9.1).
+type Vp8FrameHeader struct {
+	Keyframe      bool
+	Version       uint8
+	ShowFrame     bool
+	FirstPartSize uint32
+	Width         int // only for keyframe
+	Height        int
+	HorizScale    uint8
+	VertScale     uint8
+}
+
+// ParseVp8FrameHeader parses the frame tag and the keyframe size of one VP8 frame.
+func ParseVp8FrameHeader(data []byte) (*Vp8FrameHeader, error) {
+	if len(data) < 3 {
+		return nil, NewErrorf("VP8 frame insufficient: %d", len(data))
+	}
+	tag := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16
+	h := &Vp8FrameHeader{
+		Keyframe:      tag&0x01 == 0,
+		Version:       uint8(tag>>1) & 0x07,
+		ShowFrame:     tag&0x10 != 0,
+		FirstPartSize: tag >> 5,
+	}
+	if !h.Keyframe {
+		return h, nil
+	}
+
+	if len(data) < kVp8KeyHeaderSize {
+		return nil, NewErrorf("VP8 keyframe insufficient: %d", len(data))
+	}
+	if data[3] != kVp8StartCode[0] || data[4] != kVp8StartCode[1] || data[5] != kVp8StartCode[2] {
+		return nil, NewErrorf("VP8 keyframe start code invalid: %x", data[3:6])
+	}
+	width := binary.LittleEndian.Uint16(data[6:])
+	height := binary.LittleEndian.Uint16(data[8:])
+	h.Width = int(width & 0x3FFF)
+	h.HorizScale = uint8(width >> 14)
+	h.Height = int(height & 0x3FFF)
+	h.VertScale = uint8(height >> 14)
+	return h, nil
+}
+
+// Vp8Packetizer splits VP8 frames into RTP packets(RFC 7741) with 15-bit PictureID.
+type Vp8Packetizer struct {
+	rtpPacketizer
+	PictureId uint16 // the PictureID of the next frame
+}
+
+// NewVp8Packetizer creates a packetizer with random PictureID, and the mtu works
+// as newRtpPacketizer.
+func NewVp8Packetizer(ssrc uint32, ptype uint8, mtu int) *Vp8Packetizer {
+	return &Vp8Packetizer{
+		rtpPacketizer: newRtpPacketizer(ssrc, ptype, mtu, kVp8DefaultMtu),
+		PictureId:     uint16(RandomUint32()) & kVp8MaxPictureId,
+	}
+}
+
+// Packetize splits one VP8 frame into RTP packets of timestamp, and the last
+// packet has the marker bit.
+func (p *Vp8Packetizer) Packetize(frame []byte, timestamp uint32) ([]*RtpPacket, error) {
+	if len(frame) == 0 {
+		return nil, NewErrorf("VP8 frame empty")
+	}
+	desc := Vp8Descriptor{
+		Start:            true,
+		PictureIdPresent: true,
+		PictureIdLong:    true,
+		PictureId:        p.PictureId,
+	}
+	headerSize := desc.MarshalSize()
+	if p.Mtu <= headerSize {
+		return nil, NewErrorf("VP8 mtu too small: %d", p.Mtu)
+	}
+
+	fragments := splitRtpFragments(frame, p.Mtu-headerSize, 0)
+	payloads := make([][]byte, 0, len(fragments))
+	for idx, fragment := range fragments {
+		desc.Start = idx == 0
+		payload := make([]byte, headerSize+len(fragment))
+		desc.MarshalTo(payload)
+		copy(payload[headerSize:], fragment)
+		payloads = append(payloads, payload)
+	}
+	p.PictureId = (p.PictureId + 1) & kVp8MaxPictureId
+	return p.packetize(payloads, timestamp), nil
+}
+
+// Vp8Frame is one VP8 frame rebuilt from RTP packets.
+type Vp8Frame struct {
+	Timestamp        uint32
+	PictureId        uint16
+	PictureIdPresent bool
+	Data             []byte
+	Keyframe         bool
+	Width            int // only for keyframe
+	Height           int
+}
+
+// Vp8Depacketizer rebuilds VP8 frames from in-order RTP packets(e.g. from
+// JitterBuffer), and the frames with lost packets are dropped.
+type Vp8Depacketizer struct {
+	rtpFrameAssembler
+	frame   *Vp8Frame
+	frames  []*Vp8Frame   // completed in Push
+	desc    Vp8Descriptor // of the current packet
+	payload []byte
+	n       int // the descriptor size of payload
+}
+
+func NewVp8Depacketizer() *Vp8Depacketizer {
+	return &Vp8Depacketizer{}
+}
+
+// Push adds one RTP packet, and returns the frames completed by marker bit or
+// timestamp change.
+func (d *Vp8Depacketizer) Push(pkt *RtpPacket) ([]*Vp8Frame, error) {
+	err := d.push(d, pkt)
+	frames := d.frames
+	d.frames = nil
+	return frames, err
+}
+
+func (d *Vp8Depacketizer) parsePacket(pkt *RtpPacket) (bool, bool, error) {
+	d.desc = Vp8Descriptor{}
+	d.payload = rtpPayloadWithoutPadding(pkt)
+	n, err := d.desc.Unmarshal(d.payload)
+	if err == nil && len(d.payload) == n {
+		err = NewErrorf("VP8 payload empty")
+	}
+	d.n = n
+	return false, false, err
+}
+
+func (d *Vp8Depacketizer) newFrame(pkt *RtpPacket, lost bool) bool {
+	d.frame = &Vp8Frame{
+		Timestamp:        pkt.Timestamp,
+		PictureId:        d.desc.PictureId,
+		PictureIdPresent: d.desc.PictureIdPresent,
+	}
+	// the first packet of this frame must be the start of partition 0
+	return d.desc.Start && d.desc.PartitionId == 0
+}
+
+func (d *Vp8Depacketizer) addPacket(pkt *RtpPacket) error {
+	d.frame.Data = append(d.frame.Data, d.payload[d.n:]...)
+	return nil
+}
+
+func (d *Vp8Depacketizer) finishFrame(broken bool) bool {
+	frame := d.frame
+	d.frame = nil
+	if broken || len(frame.Data) == 0 {
+		return false
+	}
+	header, err := ParseVp8FrameHeader(frame.Data)
+	if err != nil {
+		return false
+	}
+	frame.Keyframe = header.Keyframe
+	frame.Width = header.Width
+	frame.Height = header.Height
+	d.frames = append(d.frames, frame)
+	return true
+}
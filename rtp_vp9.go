@@ -0,0 +1,540 @@
+package goutil
+
+import (
+	"encoding/binary"
+)
+
+/*
+ * VP9 payload descriptor(RFC 9628 4.2):
+ *
+ *       0 1 2 3 4 5 6 7
+ *      +-+-+-+-+-+-+-+-+
+ *      |I|P|L|F|B|E|V|Z| (REQUIRED)
+ *      +-+-+-+-+-+-+-+-+
+ * I:   |M| PICTURE ID  | (REQUIRED)
+ *      +-+-+-+-+-+-+-+-+
+ * M:   | EXTENDED PID  | (RECOMMENDED)
+ *      +-+-+-+-+-+-+-+-+
+ * L:   | TID |U| SID |D| (Conditionally RECOMMENDED)
+ *      +-+-+-+-+-+-+-+-+
+ *      |   TL0PICIDX   | (non-flexible mode only)
+ *      +-+-+-+-+-+-+-+-+                             -\
+ * P,F: | P_DIFF      |N| (Conditionally REQUIRED)    - up to 3 times
+ *      +-+-+-+-+-+-+-+-+                             -/
+ * V:   | SS            |
+ *      | ..            |
+ *      +-+-+-+-+-+-+-+-+
+ */
+
+const (
+	kVp9IBit          = 0x80
+	kVp9PBit          = 0x40
+	kVp9LBit          = 0x20
+	kVp9FBit          = 0x10
+	kVp9BBit          = 0x08
+	kVp9EBit          = 0x04
+	kVp9VBit          = 0x02
+	kVp9ZBit          = 0x01
+	kVp9MBit          = 0x80
+	kVp9UBit          = 0x10
+	kVp9DBit          = 0x01
+	kVp9NBit          = 0x01
+	kVp9SsYBit        = 0x10
+	kVp9SsGBit        = 0x08
+	kVp9MaxPictureId  = 0x7FFF
+	kVp9MaxPDiffs     = 3
+	kVp9MaxSpatial    = 8
+	kVp9DefaultMtu    = 1200
+	kVp9MaxRefPicture = 3 // R of SS
+)
+
+// Vp9PictureGroup is one picture description of SS.
+type Vp9PictureGroup struct {
+	Tid         uint8
+	SwitchingUp bool
+	PDiffs      []uint8
+}
+
+/*
+ * VP9 scalability structure(RFC 9628 4.2.1):
+ *
+ *      +-+-+-+-+-+-+-+-+
+ * V:   | N_S |Y|G|-|-|-|
+ *      +-+-+-+-+-+-+-+-+              -\
+ * Y:   |     WIDTH     | (OPTIONAL)    .
+ *      +               +               .
+ *      |               | (OPTIONAL)    .
+ *      +-+-+-+-+-+-+-+-+               . - N_S + 1 times
+ *      |     HEIGHT    | (OPTIONAL)    .
+ *      +               +               .
+ *      |               | (OPTIONAL)    .
+ *      +-+-+-+-+-+-+-+-+              -/
+ * G:   |      N_G      | (OPTIONAL)
+ *      +-+-+-+-+-+-+-+-+                           -\
+ * N_G: |  T  |U| R |-|-| (OPTIONAL)                 .
+ *      +-+-+-+-+-+-+-+-+              -\            . - N_G times
+ *      |    P_DIFF     | (OPTIONAL)    . - R times  .
+ *      +-+-+-+-+-+-+-+-+              -/            -/
+ */
+
+// Vp9ScalabilityStructure is the SS of VP9 payload descriptor.
+type Vp9ScalabilityStructure struct {
+	NumSpatialLayers int
+	Widths           []uint16 // empty if Y=0
+	Heights          []uint16
+	PictureGroups    []Vp9PictureGroup // nil if G=0
+	HasPictureGroups bool
+}
+
+func (s *Vp9ScalabilityStructure) marshalSize() int {
+	size := 1
+	if len(s.Widths) > 0 {
+		size += 4 * s.NumSpatialLayers
+	}
+	if s.HasPictureGroups {
+		size += 1
+		for _, group := range s.PictureGroups {
+			size += 1 + len(group.PDiffs)
+		}
+	}
+	return size
+}
+
+func (s *Vp9ScalabilityStructure) marshalTo(buf []byte) int {
+	buf[0] = uint8(s.NumSpatialLayers-1) << 5
+	n := 1
+	if len(s.Widths) > 0 {
+		buf[0] |= kVp9SsYBit
+		for i := 0; i < s.NumSpatialLayers; i++ {
+			binary.BigEndian.PutUint16(buf[n:], s.Widths[i])
+			binary.BigEndian.PutUint16(buf[n+2:], s.Heights[i])
+			n += 4
+		}
+	}
+	if s.HasPictureGroups {
+		buf[0] |= kVp9SsGBit
+		buf[n] = uint8(len(s.PictureGroups))
+		n += 1
+		for _, group := range s.PictureGroups {
+			buf[n] = group.Tid<<5 | uint8(len(group.PDiffs))<<2
+			if group.SwitchingUp {
+				buf[n] |= kVp9UBit
+			}
+			n += 1
+			n += copy(buf[n:], group.PDiffs)
+		}
+	}
+	return n
+}
+
+func (s *Vp9ScalabilityStructure) unmarshal(data []byte) (int, error) {
+	if len(data) < 1 {
+		return 0, NewErrorf("VP9 SS insufficient: %d", len(data))
+	}
+	*s = Vp9ScalabilityStructure{}
+	s.NumSpatialLayers = int(data[0]>>5) + 1
+	n := 1
+	if data[0]&kVp9SsYBit != 0 {
+		if len(data) < n+4*s.NumSpatialLayers {
+			return 0, NewErrorf("VP9 SS resolution insufficient: %d", len(data))
+		}
+		for i := 0; i < s.NumSpatialLayers; i++ {
+			s.Widths = append(s.Widths, binary.BigEndian.Uint16(data[n:]))
+			s.Heights = append(s.Heights, binary.BigEndian.Uint16(data[n+2:]))
+			n += 4
+		}
+	}
+	if data[0]&kVp9SsGBit != 0 {
+		s.HasPictureGroups = true
+		if len(data) < n+1 {
+			return 0, NewErrorf("VP9 SS N_G insufficient: %d", len(data))
+		}
+		num := int(data[n])
+		n += 1
+		for i := 0; i < num; i++ {
+			if len(data) < n+1 {
+				return 0, NewErrorf("VP9 SS picture group insufficient: %d", len(data))
+			}
+			group := Vp9PictureGroup{
+				Tid:         data[n] >> 5,
+				SwitchingUp: data[n]&kVp9UBit != 0,
+			}
+			refs := int(data[n]>>2) & kVp9MaxRefPicture
+			n += 1
+			if len(data) < n+refs {
+				return 0, NewErrorf("VP9 SS P_DIFF insufficient: %d", len(data))
+			}
+			group.PDiffs = append([]uint8(nil), data[n:n+refs]...)
+			n += refs
+			s.PictureGroups = append(s.PictureGroups, group)
+		}
+	}
+	return n, nil
+}
+
+// Vp9Descriptor is the VP9 payload descriptor of one RTP packet.
+type Vp9Descriptor struct {
+	PictureIdPresent      bool // I
+	InterPicturePredicted bool // P
+	LayerIndicesPresent   bool // L
+	FlexibleMode          bool // F
+	StartOfFrame          bool // B
+	EndOfFrame            bool // E
+	NotRefForUpperSpatial bool // Z
+
+	PictureId     uint16
+	PictureIdLong bool // M, 15-bit PictureID
+
+	Tid                  uint8
+	SwitchingUp          bool // U
+	Sid                  uint8
+	InterLayerDependency bool  // D
+	Tl0PicIdx            uint8 // non-flexible mode only
+
+	PDiffs []uint8 // flexible mode and P=1
+
+	Ss *Vp9ScalabilityStructure // V
+}
+
+// MarshalSize returns the size of the descriptor.
+func (d *Vp9Descriptor) MarshalSize() int {
+	size := 1
+	if d.PictureIdPresent {
+		size += 1
+		if d.PictureIdLong {
+			size += 1
+		}
+	}
+	if d.LayerIndicesPresent {
+		size += 1
+		if !d.FlexibleMode {
+			size += 1
+		}
+	}
+	if d.FlexibleMode && d.InterPicturePredicted {
+		size += len(d.PDiffs)
+	}
+	if d.Ss != nil {
+		size += d.Ss.marshalSize()
+	}
+	return size
+}
+
+// MarshalTo writes the descriptor into buf, and returns the size.
+func (d *Vp9Descriptor) MarshalTo(buf []byte) (int, error) {
+	size := d.MarshalSize()
+	if len(buf) < size {
+		return 0, NewErrorf("VP9 descriptor buffer insufficient: %d < %d", len(buf), size)
+	}
+	if d.FlexibleMode && d.InterPicturePredicted && (len(d.PDiffs) == 0 || len(d.PDiffs) > kVp9MaxPDiffs) {
+		return 0, NewErrorf("VP9 descriptor P_DIFF number invalid: %d", len(d.PDiffs))
+	}
+	if d.Ss != nil && (d.Ss.NumSpatialLayers < 1 || d.Ss.NumSpatialLayers > kVp9MaxSpatial ||
+		(len(d.Ss.Widths) > 0 && (len(d.Ss.Widths) != d.Ss.NumSpatialLayers || len(d.Ss.Heights) != d.Ss.NumSpatialLayers))) {
+		return 0, NewErrorf("VP9 descriptor SS invalid: %d", d.Ss.NumSpatialLayers)
+	}
+
+	var flags uint8
+	for _, item := range []struct {
+		set bool
+		bit uint8
+	}{
+		{d.PictureIdPresent, kVp9IBit},
+		{d.InterPicturePredicted, kVp9PBit},
+		{d.LayerIndicesPresent, kVp9LBit},
+		{d.FlexibleMode, kVp9FBit},
+		{d.StartOfFrame, kVp9BBit},
+		{d.EndOfFrame, kVp9EBit},
+		{d.Ss != nil, kVp9VBit},
+		{d.NotRefForUpperSpatial, kVp9ZBit},
+	} {
+		if item.set {
+			flags |= item.bit
+		}
+	}
+	buf[0] = flags
+	n := 1
+
+	if d.PictureIdPresent {
+		if d.PictureIdLong {
+			binary.BigEndian.PutUint16(buf[n:], kVp9MBit<<8|(d.PictureId&kVp9MaxPictureId))
+			n += 2
+		} else {
+			buf[n] = uint8(d.PictureId & 0x7F)
+			n += 1
+		}
+	}
+	if d.LayerIndicesPresent {
+		buf[n] = d.Tid<<5 | (d.Sid&0x07)<<1
+		if d.SwitchingUp {
+			buf[n] |= kVp9UBit
+		}
+		if d.InterLayerDependency {
+			buf[n] |= kVp9DBit
+		}
+		n += 1
+		if !d.FlexibleMode {
+			buf[n] = d.Tl0PicIdx
+			n += 1
+		}
+	}
+	if d.FlexibleMode && d.InterPicturePredicted {
+		for i, diff := range d.PDiffs {
+			buf[n] = diff << 1
+			if i < len(d.PDiffs)-1 {
+				buf[n] |= kVp9NBit
+			}
+			n += 1
+		}
+	}
+	if d.Ss != nil {
+		n += d.Ss.marshalTo(buf[n:])
+	}
+	return n, nil
+}
+
+// Unmarshal parses the descriptor from one RTP payload, and returns its size.
+func (d *Vp9Descriptor) Unmarshal(payload []byte) (int, error) {
+	if len(payload) < 1 {
+		return 0, NewErrorf("VP9 descriptor insufficient: %d", len(payload))
+	}
+	*d = Vp9Descriptor{}
+	flags := payload[0]
+	d.PictureIdPresent = flags&kVp9IBit != 0
+	d.InterPicturePredicted = flags&kVp9PBit != 0
+	d.LayerIndicesPresent = flags&kVp9LBit != 0
+	d.FlexibleMode = flags&kVp9FBit != 0
+	d.StartOfFrame = flags&kVp9BBit != 0
+	d.EndOfFrame = flags&kVp9EBit != 0
+	d.NotRefForUpperSpatial = flags&kVp9ZBit != 0
+	n := 1
+
+	if d.PictureIdPresent {
+		if len(payload) < n+1 {
+			return 0, NewErrorf("VP9 descriptor PictureID insufficient: %d", len(payload))
+		}
+		if payload[n]&kVp9MBit != 0 {
+			if len(payload) < n+2 {
+				return 0, NewErrorf("VP9 descriptor PictureID insufficient: %d", len(payload))
+			}
+			d.PictureIdLong = true
+			d.PictureId = binary.BigEndian.Uint16(payload[n:]) & kVp9MaxPictureId
+			n += 2
+		} else {
+			d.PictureId = uint16(payload[n])
+			n += 1
+		}
+	}
+	if d.LayerIndicesPresent {
+		if len(payload) < n+1 {
+			return 0, NewErrorf("VP9 descriptor layer indices insufficient: %d", len(payload))
+		}
+		d.Tid = payload[n] >> 5
+		d.SwitchingUp = payload[n]&kVp9UBit != 0
+		d.Sid = (payload[n] >> 1) & 0x07
+		d.InterLayerDependency = payload[n]&kVp9DBit != 0
+		n += 1
+		if !d.FlexibleMode {
+			if len(payload) < n+1 {
+				return 0, NewErrorf("VP9 descriptor TL0PICIDX insufficient: %d", len(payload))
+			}
+			d.Tl0PicIdx = payload[n]
+			n += 1
+		}
+	}
+	if d.FlexibleMode && d.InterPicturePredicted {
+		for {
+			if len(payload) < n+1 {
+				return 0, NewErrorf("VP9 descriptor P_DIFF insufficient: %d", len(payload))
+			}
+			if len(d.PDiffs) == kVp9MaxPDiffs {
+				return 0, NewErrorf("VP9 descriptor P_DIFF too many")
+			}
+			d.PDiffs = append(d.PDiffs, payload[n]>>1)
+			more := payload[n]&kVp9NBit != 0
+			n += 1
+			if !more {
+				break
+			}
+		}
+	}
+	if flags&kVp9VBit != 0 {
+		d.Ss = &Vp9ScalabilityStructure{}
+		size, err := d.Ss.unmarshal(payload[n:])
+		if err != nil {
+			return 0, err
+		}
+		n += size
+	}
+	return n, nil
+}
+
+// Vp9Packetizer splits VP9 frames into RTP packets(RFC 9628) in non-flexible
+// mode without layer indices, i.e. one spatial and temporal layer. The SS with
+// resolution is sent in the first packet of keyframes.
+type Vp9Packetizer struct {
+	rtpPacketizer
+	PictureId uint16 // the PictureID of the next frame
+}
+
+// NewVp9Packetizer creates a packetizer with random PictureID, and the mtu works
+// as newRtpPacketizer.
+func NewVp9Packetizer(ssrc uint32, ptype uint8, mtu int) *Vp9Packetizer {
+	return &Vp9Packetizer{
+		rtpPacketizer: newRtpPacketizer(ssrc, ptype, mtu, kVp9DefaultMtu),
+		PictureId:     uint16(RandomUint32()) & kVp9MaxPictureId,
+	}
+}
+
+// Packetize splits one VP9 frame into RTP packets of timestamp, and the last
+// packet has the marker bit.
+func (p *Vp9Packetizer) Packetize(frame []byte, timestamp uint32) ([]*RtpPacket, error) {
+	header, err := ParseVp9FrameHeader(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	desc := Vp9Descriptor{
+		PictureIdPresent:      true,
+		PictureIdLong:         true,
+		PictureId:             p.PictureId,
+		InterPicturePredicted: !header.Keyframe && !header.IntraOnly,
+	}
+	var ss *Vp9ScalabilityStructure
+	if header.Keyframe {
+		ss = &Vp9ScalabilityStructure{
+			NumSpatialLayers: 1,
+			Widths:           []uint16{uint16(header.Width)},
+			Heights:          []uint16{uint16(header.Height)},
+		}
+	}
+	headerSize := desc.MarshalSize()
+	firstSize := headerSize
+	if ss != nil {
+		firstSize += ss.marshalSize()
+	}
+	if p.Mtu <= firstSize {
+		return nil, NewErrorf("VP9 mtu too small: %d", p.Mtu)
+	}
+
+	// the first fragment has SS
+	fragments := splitRtpFragments(frame, p.Mtu-headerSize, firstSize-headerSize)
+	payloads := make([][]byte, 0, len(fragments))
+	for idx, fragment := range fragments {
+		desc.StartOfFrame = idx == 0
+		desc.EndOfFrame = idx == len(fragments)-1
+		desc.Ss = nil
+		if desc.StartOfFrame {
+			desc.Ss = ss
+		}
+
+		descSize := desc.MarshalSize()
+		payload := make([]byte, descSize+len(fragment))
+		desc.MarshalTo(payload)
+		copy(payload[descSize:], fragment)
+		payloads = append(payloads, payload)
+	}
+	p.PictureId = (p.PictureId + 1) & kVp9MaxPictureId
+	return p.packetize(payloads, timestamp), nil
+}
+
+// Vp9Frame is one VP9 layer frame rebuilt from RTP packets, and the frames of
+// one picture have the same timestamp.
+type Vp9Frame struct {
+	Timestamp             uint32
+	PictureId             uint16
+	PictureIdPresent      bool
+	Sid                   uint8
+	Tid                   uint8
+	InterPicturePredicted bool
+	EndOfPicture          bool // marker bit
+	Data                  []byte
+	Keyframe              bool
+	Width                 int // from uncompressed header or SS, zero if unknown
+	Height                int
+}
+
+// Vp9Depacketizer rebuilds VP9 frames from in-order RTP packets(e.g. from
+// JitterBuffer), and the frames with lost packets are dropped.
+type Vp9Depacketizer struct {
+	rtpFrameAssembler
+	frame   *Vp9Frame
+	frames  []*Vp9Frame // completed in Push
+	ss      *Vp9ScalabilityStructure
+	desc    Vp9Descriptor // of the current packet
+	payload []byte
+	n       int // the descriptor size of payload
+}
+
+func NewVp9Depacketizer() *Vp9Depacketizer {
+	d := &Vp9Depacketizer{}
+	// the previous frame without E bit is dropped
+	d.endRequired = true
+	return d
+}
+
+// Push adds one RTP packet, and returns the frames completed by E bit, or
+// dropped by timestamp change.
+func (d *Vp9Depacketizer) Push(pkt *RtpPacket) ([]*Vp9Frame, error) {
+	err := d.push(d, pkt)
+	frames := d.frames
+	d.frames = nil
+	return frames, err
+}
+
+func (d *Vp9Depacketizer) parsePacket(pkt *RtpPacket) (bool, bool, error) {
+	d.desc = Vp9Descriptor{}
+	d.payload = rtpPayloadWithoutPadding(pkt)
+	n, err := d.desc.Unmarshal(d.payload)
+	if err == nil && len(d.payload) == n {
+		err = NewErrorf("VP9 payload empty")
+	}
+	d.n = n
+	if err != nil {
+		return false, false, err
+	}
+	if d.desc.Ss != nil {
+		d.ss = d.desc.Ss
+	}
+	return d.desc.StartOfFrame, d.desc.EndOfFrame, nil
+}
+
+func (d *Vp9Depacketizer) newFrame(pkt *RtpPacket, lost bool) bool {
+	d.frame = &Vp9Frame{
+		Timestamp:             pkt.Timestamp,
+		PictureId:             d.desc.PictureId,
+		PictureIdPresent:      d.desc.PictureIdPresent,
+		Sid:                   d.desc.Sid,
+		Tid:                   d.desc.Tid,
+		InterPicturePredicted: d.desc.InterPicturePredicted,
+	}
+	// the first packet of this frame must have B bit
+	return d.desc.StartOfFrame
+}
+
+func (d *Vp9Depacketizer) addPacket(pkt *RtpPacket) error {
+	d.frame.Data = append(d.frame.Data, d.payload[d.n:]...)
+	d.frame.EndOfPicture = pkt.Marker
+	return nil
+}
+
+func (d *Vp9Depacketizer) finishFrame(broken bool) bool {
+	frame := d.frame
+	d.frame = nil
+	if broken || len(frame.Data) == 0 {
+		return false
+	}
+	header, err := ParseVp9FrameHeader(frame.Data)
+	if err != nil {
+		return false
+	}
+	frame.Keyframe = header.Keyframe
+	frame.Width = header.Width
+	frame.Height = header.Height
+	if frame.Width == 0 && d.ss != nil && int(frame.Sid) < len(d.ss.Widths) {
+		frame.Width = int(d.ss.Widths[frame.Sid])
+		frame.Height = int(d.ss.Heights[frame.Sid])
+	}
+	d.frames = append(d.frames, frame)
+	return true
+}
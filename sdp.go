@@ -2,7 +2,6 @@ package goutil
 
 import (
 	//"crypto/rsa"
-	"crypto/sha256"
 	//"crypto/tls"
 	"crypto/x509"
 	//"encoding/hex"
@@ -49,6 +48,15 @@ func NewSdpMediaAttrs() *SdpMediaAttrs {
 	}
 }
 
+// GetRtpExtensionMap returns the id<->type mapping of a=extmap for RtpHeader.
+func (a *SdpMediaAttrs) GetRtpExtensionMap() *RtpExtensionMap {
+	extmap := NewRtpExtensionMap()
+	for id, item := range a.Extmaps {
+		extmap.RegisterUri(id, item.Uri)
+	}
+	return extmap
+}
+
 // RtpMap
 type SdpPtype struct {
 	Ptype     uint8
@@ -56,6 +64,7 @@ type SdpPtype struct {
 	Codec     string
 	Channels  int
 	Frequency int
+	Fmtp      map[string]int // a=fmtp: integer params, e.g. packetization-mode
 }
 
 func (sp SdpPtype) String() string {
@@ -71,6 +80,7 @@ type SdpSsrc struct {
 	Rtx  uint32
 	Num  int
 	Idx  int
+	Fec  uint32 // FlexFEC ssrc of a=ssrc-group:FEC-FR
 }
 
 func (ss SdpSsrc) String() string {
@@ -154,6 +164,7 @@ type FmtpInfo struct {
 	ptype int
 	props map[string]int
 	misc  string
+	line  string // the raw parameters
 }
 
 // SDP rtcp feedback: a=rtcp-fb
@@ -194,6 +205,13 @@ type FidInfo struct {
 	rtx  uint32
 }
 
+// SDP a=ssrc-group:FEC-FR(FlexFEC)
+// a=ssrc-group:FEC-FR 1081040086 1081040088
+type FecFrInfo struct {
+	main uint32
+	fec  uint32
+}
+
 // SDP sctp: a=sctpmap
 // a=sctpmap:5000 webrtc-datachannel 1024
 type SctpInfo struct {
@@ -229,6 +247,7 @@ type MediaAttr struct {
 	rtcp_fbs         []*RtcpFbInfo     // a=rtcp-fb:..
 	extmaps          []*ExtMapInfo     // a=extmap:..
 	fid_ssrcs        []*FidInfo        // a=ssrc-group:FID ..
+	fecfr_ssrcs      []*FecFrInfo      // a=ssrc-group:FEC-FR ..
 	ssrcs            []*SsrcInfo       // a=ssrc:..
 	msids            []string          // a=msid:..
 	sctp             *SctpInfo         // a=sctpmap: or a=sctp-port:
@@ -254,13 +273,47 @@ func (a *MediaAttr) GetSsrcs() *SdpSsrc {
 		ssrc.Num = len(a.fid_ssrcs)
 	} else if len(a.ssrcs) > 0 {
 		ssrc.Main = a.ssrcs[0].ssrc
-		ssrc.Num = len(a.ssrcs)
+		ssrc.Num = a.numMediaSsrcs()
 	} else {
 		ssrc = nil
 	}
+	if ssrc != nil {
+		ssrc.Fec = a.getFecSsrc(ssrc.Main)
+	}
 	return ssrc
 }
 
+// getFecSsrc returns the FlexFEC ssrc of main, or 0.
+func (a *MediaAttr) getFecSsrc(main uint32) uint32 {
+	for _, item := range a.fecfr_ssrcs {
+		if item.main == main {
+			return item.fec
+		}
+	}
+	return 0
+}
+
+// numMediaSsrcs returns the number of a=ssrc except the FlexFEC ones.
+func (a *MediaAttr) numMediaSsrcs() int {
+	num := 0
+	for _, item := range a.ssrcs {
+		if !a.isFecSsrc(item.ssrc) {
+			num += 1
+		}
+	}
+	return num
+}
+
+// isFecSsrc checks whether ssrc is a FlexFEC ssrc.
+func (a *MediaAttr) isFecSsrc(ssrc uint32) bool {
+	for _, item := range a.fecfr_ssrcs {
+		if item.fec == ssrc {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *MediaAttr) GetExtmaps(attrs *SdpMediaAttrs) {
 	for _, item := range a.extmaps {
 		attrs.Extmaps[item.id] = &SdpExtmap{item.id, item.uri}
@@ -272,14 +325,17 @@ func (a *MediaAttr) GetSsrc(attrs *SdpMediaAttrs) {
 		num := len(a.fid_ssrcs)
 		idx := num - 1
 		for _, item := range a.fid_ssrcs {
-			attrs.Ssrcs[item.main] = &SdpSsrc{item.main, item.rtx, num, idx}
+			attrs.Ssrcs[item.main] = &SdpSsrc{item.main, item.rtx, num, idx, a.getFecSsrc(item.main)}
 			idx -= 1
 		}
 	} else if len(a.ssrcs) > 0 {
-		num := len(a.ssrcs)
+		num := a.numMediaSsrcs()
 		idx := num - 1
 		for _, item := range a.ssrcs {
-			attrs.Ssrcs[item.ssrc] = &SdpSsrc{item.ssrc, 0, num, idx}
+			if a.isFecSsrc(item.ssrc) {
+				continue
+			}
+			attrs.Ssrcs[item.ssrc] = &SdpSsrc{item.ssrc, 0, num, idx, a.getFecSsrc(item.ssrc)}
 			idx -= 1
 		}
 	}
@@ -294,6 +350,12 @@ func (a *MediaAttr) GetPtype(attrs *SdpMediaAttrs) {
 				Codec:     item.codec,
 				Channels:  item.channels,
 				Frequency: item.frequency,
+				Fmtp:      make(map[string]int),
+			}
+			if fmtp, ok := a.fmtps[item.ptype]; ok {
+				for key, value := range fmtp.props {
+					sdpPtype.Fmtp[key] = value
+				}
 			}
 
 			// Check a=fmtp:rtx_ptype apt=main_ptype
@@ -504,6 +566,7 @@ func (m *MediaSdp) parseSdp_a(line []byte, media *MediaAttr) {
 		attrs := strings.SplitN(fields[1], " ", 2)
 		if len(attrs) == 2 {
 			fmtp := NewFmtpInfo(Atoi(attrs[0]))
+			fmtp.line = attrs[1]
 			props := strings.Split(attrs[1], ";")
 			for k := range props {
 				kv := strings.Split(props[k], "=")
@@ -540,6 +603,12 @@ func (m *MediaSdp) parseSdp_a(line []byte, media *MediaAttr) {
 					fid := &FidInfo{Atou32(props[0]), Atou32(props[1])}
 					media.fid_ssrcs = append(media.fid_ssrcs, fid)
 				}
+			} else if attrs[0] == "FEC-FR" {
+				props := strings.Split(attrs[1], " ")
+				if len(props) == 2 {
+					fecfr := &FecFrInfo{Atou32(props[0]), Atou32(props[1])}
+					media.fecfr_ssrcs = append(media.fecfr_ssrcs, fecfr)
+				}
 			} else if attrs[0] == "SIM" {
 				// not support
 				fmt.Println("unsupported a=SIM")
@@ -600,6 +669,31 @@ type MediaDesc struct {
 	av_ice_pwd      string
 	av_fingerprint  StringPair // answer a=fingerprint:sha-256 ..
 	av_ssrcs        map[uint32]uint32
+	av_video_codecs []string // local video codecs by priority
+	av_red_fec      bool     // enable RED/ULPFEC in answer
+}
+
+// SetRedFec enables or disables(default) RED/ULPFEC for video in answer, which
+// are processed by RtpRedEncoder/DecodeRtpRedPacket and RtpUlpfecEncoder/Decoder.
+func (m *MediaDesc) SetRedFec(enable bool) {
+	m.av_red_fec = enable
+}
+
+// SetVideoCodecs sets the local video codecs by priority(e.g. "h265", "h264"),
+// and CreateAnswer selects the first one also in offer. The default is h264,
+// vp8, vp9, av1 and h265.
+func (m *MediaDesc) SetVideoCodecs(codecs ...string) {
+	m.av_video_codecs = nil
+	for _, codec := range codecs {
+		m.av_video_codecs = append(m.av_video_codecs, strings.ToLower(codec))
+	}
+}
+
+func (m *MediaDesc) getVideoCodecs() []string {
+	if len(m.av_video_codecs) == 0 {
+		return []string{"h264", "vp8", "vp9", "av1", "h265"}
+	}
+	return m.av_video_codecs
 }
 
 func (m *MediaDesc) Parse(data []byte) bool {
@@ -678,6 +772,24 @@ func (m *MediaDesc) GetPasswd() string {
 	}
 }
 
+// GetFingerprint returns the remote a=fingerprint(media-level first), which
+// is the DtlsConfig.RemoteFingerprint of DTLS-SRTP.
+func (m *MediaDesc) GetFingerprint() StringPair {
+	mt := m.GetMediaType()
+	var media *MediaAttr
+	if (mt & kMediaAudio) != 0 {
+		media = m.Sdp.audios[0]
+	} else if (mt & kMediaVideo) != 0 {
+		media = m.Sdp.videos[0]
+	} else if (mt & kMediaApplication) != 0 {
+		media = m.Sdp.applications[0]
+	}
+	if media != nil && media.fingerprint.Second != "" {
+		return media.fingerprint
+	}
+	return m.Sdp.fingerprint
+}
+
 func (m *MediaDesc) GetCandidates() []string {
 	mt := m.GetMediaType()
 	if (mt & kMediaAudio) != 0 {
@@ -710,17 +822,11 @@ func (m *MediaDesc) CreateAnswer(agent string, certFile string) bool {
 
 	// create fingerprint
 	if cert, err := LoadX509Certificate(certFile); err == nil {
-		sum := sha256.Sum256(cert.Raw)
-		if len(sum) != sha256.Size {
-			fmt.Println("[sdp] fail to sha256.Sum256")
+		fingerprint, err := DtlsFingerprint(cert.Raw, "sha-256")
+		if err != nil {
+			fmt.Println("[sdp] fail to fingerprint:", err)
 			return false
 		}
-		prefix := ""
-		fingerprint := ""
-		for k := 0; k < sha256.Size; k++ {
-			fingerprint += fmt.Sprintf("%s%02X", prefix, sum[k])
-			prefix = ":"
-		}
 		// 32*2+31 = 95
 		fmt.Println("[sdp] fingerprint=", len(fingerprint), fingerprint)
 		m.av_fingerprint.First = "sha-256"
@@ -764,7 +870,6 @@ func (m *MediaDesc) CreateAnswer(agent string, certFile string) bool {
 		//codecs := []string{"h264-0", "h264-1", "h264-2"}
 
 		for i := range m.Sdp.videos {
-			have_h264 := false
 			have_red := false
 			have_fec := false
 
@@ -773,16 +878,23 @@ func (m *MediaDesc) CreateAnswer(agent string, certFile string) bool {
 
 			video := m.Sdp.videos[i]
 
-			// select the first h264
+			// select the first codec of local priority
+			for _, codec := range m.getVideoCodecs() {
+				for j := range video.rtpmaps {
+					rtpmap := video.rtpmaps[j]
+					if rtpmap.codec == codec {
+						video.av_rtpmaps["main"] = rtpmap.Clone()
+						break
+					}
+				}
+				if _, ok := video.av_rtpmaps["main"]; ok {
+					break
+				}
+			}
 			for j := range video.rtpmaps {
 				rtpmap := video.rtpmaps[j]
 				//fmt.Println("[sdp] check codec:", rtpmap.codec)
 				switch rtpmap.codec {
-				case "h264":
-					if !have_h264 {
-						have_h264 = true
-						video.av_rtpmaps["main"] = rtpmap.Clone()
-					}
 				case "red":
 					if !have_red {
 						have_red = true
@@ -817,15 +929,30 @@ func (m *MediaDesc) CreateAnswer(agent string, certFile string) bool {
 				}
 			}
 
+			// rtx of red: a=fmtp:rtx_ptype apt=red_ptype
+			have_red_rtx := false
+			if redmap := video.av_rtpmaps["red"]; redmap != nil {
+				for j := range video.rtpmaps {
+					rtpmap := video.rtpmaps[j]
+					if rtpmap.codec == "rtx" {
+						if fmtp, ok := video.fmtps[rtpmap.ptype]; ok && fmtp.props["apt"] == redmap.ptype {
+							have_red_rtx = true
+							redmap.apt_ptype = rtpmap.ptype
+							break
+						}
+					}
+				}
+			}
+
 			have_rtx_fid := (len(video.fid_ssrcs) > 0)
-			if have_h264 {
+			if _, ok := video.av_rtpmaps["main"]; ok {
 				// hardcode to select supported features
 				video.use_rtx = have_rtx
 				video.use_rtx_apt = have_rtx_apt
 				video.use_rtx_fid = have_rtx_fid
-				video.use_red_fec = false
-				video.use_red_rtx = false
-				video.use_red_rtx_apt = false
+				video.use_red_fec = have_red && have_fec && m.av_red_fec
+				video.use_red_rtx = video.use_red_fec && have_rtx && have_red_rtx
+				video.use_red_rtx_apt = video.use_red_rtx
 			}
 		}
 	}
@@ -872,6 +999,20 @@ func (m *MediaDesc) GetVideoCodec() string {
 	return ""
 }
 
+// GetVideoRedFec returns the RED and ULPFEC ptypes in answer, or zeros if not used.
+func (m *MediaDesc) GetVideoRedFec() (uint8, uint8) {
+	if m.haveAnswer {
+		for j := range m.Sdp.videos {
+			video := m.Sdp.videos[j]
+			redmap, fecmap := video.av_rtpmaps["red"], video.av_rtpmaps["ulpfec"]
+			if video.use_red_fec && redmap != nil && fecmap != nil {
+				return uint8(redmap.ptype), uint8(fecmap.ptype)
+			}
+		}
+	}
+	return 0, 0
+}
+
 func (m *MediaDesc) AnswerSdp() string {
 	var prefix []string
 	prefix = append(prefix, "v=0")
@@ -1021,6 +1162,11 @@ func (m *MediaDesc) AnswerSdp() string {
 						aextmap = "a=extmap:" + Itoa(extmap.id) + " " + extmap.uri
 					} else if strings.Contains(extmap.uri, "ietf-avtext-framemarking") {
 						aextmap = "a=extmap:" + Itoa(extmap.id) + " " + extmap.uri
+					} else if strings.Contains(extmap.uri, "dependency-descriptor-rtp-header-extension") {
+						// only for av1 to drop layers
+						if rtpmap != nil && rtpmap.codec == "av1" {
+							aextmap = "a=extmap:" + Itoa(extmap.id) + " " + extmap.uri
+						}
 					}
 					if len(aextmap) > 0 {
 						body = append(body, aextmap)
@@ -1044,7 +1190,12 @@ func (m *MediaDesc) AnswerSdp() string {
 					body = append(body, "a=rtcp-fb:"+Itoa(rtpmap.ptype)+" nack pli")
 					//body = append(body, "a=rtcp-fb:"+Itoa(rtpmap.ptype)+" ccm fir")
 					body = append(body, "a=rtcp-fb:"+Itoa(rtpmap.ptype)+" goog-remb")
-					body = append(body, "a=fmtp:"+Itoa(rtpmap.ptype)+" level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f")
+					if rtpmap.codec == "h264" {
+						body = append(body, "a=fmtp:"+Itoa(rtpmap.ptype)+" level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f")
+					} else if fmtp, ok := video.fmtps[rtpmap.ptype]; ok && len(fmtp.line) > 0 {
+						// e.g. h265: level-id, profile-id, tier-flag and tx-mode of offer
+						body = append(body, "a=fmtp:"+Itoa(rtpmap.ptype)+" "+fmtp.line)
+					}
 					// rtx payload: rtx-rtp for chrome and raw-rtp for firefox
 					if video.use_rtx && video.use_rtx_apt {
 						body = append(body, "a=rtpmap:"+rtpmap.a_rtxmap())
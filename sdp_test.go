@@ -1,8 +1,11 @@
 package goutil
 
 import (
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -51,3 +54,251 @@ func TestSdp_2(t *testing.T) {
 	}
 	fmt.Println("chrome answer: ", desc.AnswerSdp())
 }
+
+func TestSdp_3(t *testing.T) {
+	offer := strings.Join([]string{
+		"v=0",
+		"o=- 1 2 IN IP4 127.0.0.1",
+		"s=-",
+		"t=0 0",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96 97 98",
+		"a=mid:0",
+		"a=rtpmap:96 VP8/90000",
+		"a=rtpmap:97 rtx/90000",
+		"a=fmtp:97 apt=96",
+		"a=rtpmap:98 flexfec-03/90000",
+		"a=ssrc-group:FID 1001 1002",
+		"a=ssrc-group:FEC-FR 1001 1003",
+		"a=ssrc:1001 cname:test",
+		"a=ssrc:1002 cname:test",
+		"a=ssrc:1003 cname:test",
+		"",
+	}, "\r\n")
+
+	var desc MediaDesc
+	if !desc.Parse([]byte(offer)) {
+		t.Fatalf("parse failed")
+	}
+	attrs := desc.GetVideoAttrs()
+	ssrc := attrs.Ssrcs[1001]
+	if ssrc == nil || ssrc.Rtx != 1002 || ssrc.Fec != 1003 {
+		t.Fatalf("FEC-FR failed: %v", ssrc)
+	}
+	if _, ok := attrs.Ssrcs[1003]; ok {
+		t.Fatalf("FEC ssrc failed")
+	}
+}
+
+func TestSdp_4(t *testing.T) {
+	newOffer := func(sessionAttrs, mediaAttrs []string) string {
+		lines := []string{"v=0", "o=- 1 2 IN IP4 127.0.0.1", "s=-", "t=0 0"}
+		lines = append(lines, sessionAttrs...)
+		lines = append(lines, "m=video 9 UDP/TLS/RTP/SAVPF 96", "a=mid:0", "a=rtpmap:96 VP8/90000")
+		lines = append(lines, mediaAttrs...)
+		return strings.Join(append(lines, ""), "\r\n")
+	}
+
+	// the media-level a=fingerprint first
+	var desc MediaDesc
+	offer := newOffer([]string{"a=fingerprint:sha-1 01:02:03"},
+		[]string{"a=setup:actpass", "a=fingerprint:sha-256 AB:CD:EF"})
+	if !desc.Parse([]byte(offer)) {
+		t.Fatalf("parse failed")
+	}
+	if fp := desc.GetFingerprint(); fp.First != "sha-256" || fp.Second != "AB:CD:EF" {
+		t.Fatalf("media fingerprint failed: %v", fp)
+	}
+
+	// the session-level a=fingerprint
+	var desc2 MediaDesc
+	offer = newOffer([]string{"a=fingerprint:sha-256 01:02:03"}, []string{"a=setup:actpass"})
+	if !desc2.Parse([]byte(offer)) {
+		t.Fatalf("parse failed")
+	}
+	if fp := desc2.GetFingerprint(); fp.First != "sha-256" || fp.Second != "01:02:03" {
+		t.Fatalf("session fingerprint failed: %v", fp)
+	}
+}
+
+// newSdpTestCertFile writes a new certificate for CreateAnswer.
+func newSdpTestCertFile(t *testing.T) string {
+	cert, err := GenerateDtlsCertificate("test")
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	if err := ioutil.WriteFile(certFile, data, 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return certFile
+}
+
+// newSdpTestAnswer returns the answer of one m=video offer with ptypes and attrs.
+func newSdpTestAnswer(t *testing.T, desc *MediaDesc, ptypes string, attrs ...string) string {
+	lines := []string{
+		"v=0",
+		"o=- 1 2 IN IP4 127.0.0.1",
+		"s=-",
+		"t=0 0",
+		"a=group:BUNDLE 0",
+		"m=video 9 UDP/TLS/RTP/SAVPF " + ptypes,
+		"a=mid:0",
+		"a=sendrecv",
+	}
+	offer := strings.Join(append(append(lines, attrs...), ""), "\r\n")
+	if !desc.Parse([]byte(offer)) {
+		t.Fatalf("parse failed")
+	}
+	if !desc.CreateAnswer(ChromeAgent, newSdpTestCertFile(t)) {
+		t.Fatalf("answer failed")
+	}
+	return desc.AnswerSdp()
+}
+
+// checkSdpLines checks the answer has all lines.
+func checkSdpLines(t *testing.T, answer string, lines ...string) {
+	for _, line := range lines {
+		if !strings.Contains(answer+"\r\n", line+"\r\n") {
+			t.Fatalf("%q missing in answer:\n%s", line, answer)
+		}
+	}
+}
+
+func TestSdp_5(t *testing.T) {
+	// the h265 only offer
+	var desc MediaDesc
+	answer := newSdpTestAnswer(t, &desc, "49 50",
+		"a=rtpmap:49 H265/90000",
+		"a=fmtp:49 level-id=93;profile-id=1;tier-flag=0;tx-mode=SRST",
+		"a=rtpmap:50 rtx/90000",
+		"a=fmtp:50 apt=49",
+	)
+	if desc.GetVideoCodec() != "h265" {
+		t.Fatalf("h265 not selected: %s", desc.GetVideoCodec())
+	}
+	checkSdpLines(t, answer,
+		"m=video 1 UDP/TLS/RTP/SAVPF 49 50",
+		"a=rtpmap:49 h265/90000",
+		"a=fmtp:49 level-id=93;profile-id=1;tier-flag=0;tx-mode=SRST",
+		"a=rtpmap:50 rtx/90000",
+		"a=fmtp:50 apt=49",
+	)
+}
+
+func TestSdp_6(t *testing.T) {
+	ptypes := "96 97 98 99 100"
+	attrs := []string{
+		"a=rtpmap:96 H264/90000",
+		"a=rtpmap:97 rtx/90000",
+		"a=fmtp:97 apt=96",
+		"a=rtpmap:98 red/90000",
+		"a=rtpmap:99 rtx/90000",
+		"a=fmtp:99 apt=98",
+		"a=rtpmap:100 ulpfec/90000",
+	}
+
+	// RED/ULPFEC are disabled by default
+	var desc MediaDesc
+	answer := newSdpTestAnswer(t, &desc, ptypes, attrs...)
+	checkSdpLines(t, answer, "m=video 1 UDP/TLS/RTP/SAVPF 96 97", "a=rtpmap:96 h264/90000")
+	if red, fec := desc.GetVideoRedFec(); red != 0 || fec != 0 || strings.Contains(answer, "red/90000") ||
+		strings.Contains(answer, "ulpfec/90000") {
+		t.Fatalf("red/fec not disabled: %d, %d\n%s", red, fec, answer)
+	}
+
+	// RED/ULPFEC and the rtx of RED are enabled
+	var desc2 MediaDesc
+	desc2.SetRedFec(true)
+	answer = newSdpTestAnswer(t, &desc2, ptypes, attrs...)
+	checkSdpLines(t, answer,
+		"m=video 1 UDP/TLS/RTP/SAVPF 96 97 98 99 100",
+		"a=rtpmap:98 red/90000",
+		"a=rtpmap:100 ulpfec/90000",
+		"a=rtpmap:99 rtx/90000",
+		"a=fmtp:99 apt=98",
+	)
+	if red, fec := desc2.GetVideoRedFec(); red != 98 || fec != 100 {
+		t.Fatalf("red/fec not enabled: %d, %d", red, fec)
+	}
+}
+
+func TestSdp_7(t *testing.T) {
+	ptypes := "96 97 98 99 100 101"
+	attrs := []string{
+		"a=rtpmap:96 VP8/90000",
+		"a=rtpmap:97 rtx/90000",
+		"a=fmtp:97 apt=96",
+		"a=rtpmap:98 VP9/90000",
+		"a=fmtp:98 profile-id=0",
+		"a=rtpmap:99 rtx/90000",
+		"a=fmtp:99 apt=98",
+		"a=rtpmap:100 VP9/90000",
+		"a=fmtp:100 profile-id=2",
+		"a=rtpmap:101 rtx/90000",
+		"a=fmtp:101 apt=100",
+	}
+
+	// vp8 by default priority
+	var desc MediaDesc
+	answer := newSdpTestAnswer(t, &desc, ptypes, attrs...)
+	checkSdpLines(t, answer, "m=video 1 UDP/TLS/RTP/SAVPF 96 97", "a=rtpmap:96 vp8/90000", "a=fmtp:97 apt=96")
+
+	// the first vp9(profile-id=0) of local priority
+	var desc2 MediaDesc
+	desc2.SetVideoCodecs("VP9", "VP8")
+	answer = newSdpTestAnswer(t, &desc2, ptypes, attrs...)
+	if desc2.GetVideoCodec() != "vp9" {
+		t.Fatalf("vp9 not selected: %s", desc2.GetVideoCodec())
+	}
+	checkSdpLines(t, answer,
+		"m=video 1 UDP/TLS/RTP/SAVPF 98 99",
+		"a=rtpmap:98 vp9/90000",
+		"a=fmtp:98 profile-id=0",
+		"a=rtpmap:99 rtx/90000",
+		"a=fmtp:99 apt=98",
+	)
+	if strings.Contains(answer, "profile-id=2") || strings.Contains(answer, "apt=96") {
+		t.Fatalf("other codecs in answer:\n%s", answer)
+	}
+}
+
+func TestSdp_8(t *testing.T) {
+	ptypes := "96 97 45 46"
+	attrs := []string{
+		"a=extmap:3 http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time",
+		"a=extmap:12 https://aomediacodec.github.io/av1-rtp-spec/#dependency-descriptor-rtp-header-extension",
+		"a=rtpmap:96 VP8/90000",
+		"a=rtpmap:97 rtx/90000",
+		"a=fmtp:97 apt=96",
+		"a=rtpmap:45 AV1/90000",
+		"a=fmtp:45 level-idx=5;profile=0;tier=0",
+		"a=rtpmap:46 rtx/90000",
+		"a=fmtp:46 apt=45",
+	}
+	ddExtmap := "a=extmap:12 https://aomediacodec.github.io/av1-rtp-spec/#dependency-descriptor-rtp-header-extension"
+
+	// the dependency descriptor is only for av1
+	var desc MediaDesc
+	answer := newSdpTestAnswer(t, &desc, ptypes, attrs...)
+	checkSdpLines(t, answer, "m=video 1 UDP/TLS/RTP/SAVPF 96 97")
+	if strings.Contains(answer, ddExtmap) {
+		t.Fatalf("dependency descriptor for vp8:\n%s", answer)
+	}
+
+	var desc2 MediaDesc
+	desc2.SetVideoCodecs("av1")
+	answer = newSdpTestAnswer(t, &desc2, ptypes, attrs...)
+	if desc2.GetVideoCodec() != "av1" {
+		t.Fatalf("av1 not selected: %s", desc2.GetVideoCodec())
+	}
+	checkSdpLines(t, answer,
+		"m=video 1 UDP/TLS/RTP/SAVPF 45 46",
+		"a=extmap:3 http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time",
+		ddExtmap,
+		"a=rtpmap:45 av1/90000",
+		"a=fmtp:45 level-idx=5;profile=0;tier=0",
+		"a=rtpmap:46 rtx/90000",
+		"a=fmtp:46 apt=45",
+	)
+}
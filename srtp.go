@@ -0,0 +1,625 @@
+package goutil
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"sync"
+)
+
+/*
+ * SRTP and SRTCP(RFC 3711, RFC 7714 for AEAD_AES_GCM).
+ *
+ * SRTP packet:    RTP header | encrypted payload | auth tag(AES-CM) or GCM tag
+ * SRTCP packet:   first 8 bytes | encrypted payload(with GCM tag) | E + SRTCP index | auth tag(AES-CM)
+ *
+ * The session keys are derived from the master key and salt by the AES-CM PRF
+ * with key_derivation_rate 0, and the ROC of each SSRC is estimated by the
+ * unwrapped sequence of the highest authenticated packet.
+ */
+
+// SrtpProtectionProfile is the SRTP protection profile(RFC 5764 4.1.2, RFC 7714 14.2).
+type SrtpProtectionProfile uint16
+
+const (
+	SRTP_AES128_CM_HMAC_SHA1_80 SrtpProtectionProfile = 0x0001
+	SRTP_AES128_CM_HMAC_SHA1_32 SrtpProtectionProfile = 0x0002
+	SRTP_AEAD_AES_128_GCM       SrtpProtectionProfile = 0x0007
+	SRTP_AEAD_AES_256_GCM       SrtpProtectionProfile = 0x0008
+)
+
+func (p SrtpProtectionProfile) String() string {
+	switch p {
+	case SRTP_AES128_CM_HMAC_SHA1_80:
+		return "SRTP_AES128_CM_HMAC_SHA1_80"
+	case SRTP_AES128_CM_HMAC_SHA1_32:
+		return "SRTP_AES128_CM_HMAC_SHA1_32"
+	case SRTP_AEAD_AES_128_GCM:
+		return "SRTP_AEAD_AES_128_GCM"
+	case SRTP_AEAD_AES_256_GCM:
+		return "SRTP_AEAD_AES_256_GCM"
+	}
+	return fmt.Sprintf("SRTP(%d)", uint16(p))
+}
+
+// KeyLen returns the size of master key.
+func (p SrtpProtectionProfile) KeyLen() int {
+	switch p {
+	case SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32, SRTP_AEAD_AES_128_GCM:
+		return 16
+	case SRTP_AEAD_AES_256_GCM:
+		return 32
+	}
+	return 0
+}
+
+// SaltLen returns the size of master salt.
+func (p SrtpProtectionProfile) SaltLen() int {
+	switch p {
+	case SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32:
+		return 14
+	case SRTP_AEAD_AES_128_GCM, SRTP_AEAD_AES_256_GCM:
+		return 12
+	}
+	return 0
+}
+
+// IsAead checks whether the profile is AEAD_AES_GCM.
+func (p SrtpProtectionProfile) IsAead() bool {
+	return p == SRTP_AEAD_AES_128_GCM || p == SRTP_AEAD_AES_256_GCM
+}
+
+// rtpTagLen returns the size of SRTP auth tag(or GCM tag).
+func (p SrtpProtectionProfile) rtpTagLen() int {
+	switch p {
+	case SRTP_AES128_CM_HMAC_SHA1_80:
+		return 10
+	case SRTP_AES128_CM_HMAC_SHA1_32:
+		return 4
+	}
+	return kSrtpGcmTagLen
+}
+
+// rtcpTagLen returns the size of SRTCP auth tag(or GCM tag), which is 80-bit
+// for both AES-CM profiles(RFC 5764 4.1.2).
+func (p SrtpProtectionProfile) rtcpTagLen() int {
+	if p.IsAead() {
+		return kSrtpGcmTagLen
+	}
+	return 10
+}
+
+const (
+	kSrtpLabelRtpEncryption  = 0x00
+	kSrtpLabelRtpAuth        = 0x01
+	kSrtpLabelRtpSalt        = 0x02
+	kSrtpLabelRtcpEncryption = 0x03
+	kSrtpLabelRtcpAuth       = 0x04
+	kSrtpLabelRtcpSalt       = 0x05
+
+	kSrtpAuthKeyLen        = 20
+	kSrtpGcmTagLen         = 16
+	kSrtpGcmIvLen          = 12
+	kSrtpRocLen            = 4
+	kSrtcpIndexLen         = 4
+	kSrtcpEBit             = 0x80000000
+	kSrtcpMaxIndex         = 0x7FFFFFFF
+	kSrtpReplayWindowSize  = 64
+	kSrtpMaxIndex          = 0xFFFFFFFFFFFF // 48-bit
+	kSrtcpEncryptedOffset  = 8
+	kSrtpMaxSsrcStates     = 1024
+	kSrtpDefaultKdfIvSize  = 16
+	kSrtpKdfCounterOffset  = 14
+	kSrtpKdfLabelOffset    = 7
+	kSrtpCmSsrcOffset      = 4
+	kSrtpCmIndexOffset     = 8
+	kSrtpGcmSsrcOffset     = 2
+	kSrtpGcmIndexOffset    = 6
+	kSrtcpGcmIndexOffset   = 8
+	kSrtpCmIndexFieldBytes = 6
+)
+
+// srtpDeriveKey derives one session key/salt of label(RFC 3711 4.3.1 and 4.3.3).
+func srtpDeriveKey(masterKey, masterSalt []byte, label uint8, size int) ([]byte, error) {
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, NewError2(err, "SRTP master key invalid")
+	}
+	iv := make([]byte, kSrtpDefaultKdfIvSize)
+	copy(iv, masterSalt)
+	iv[kSrtpKdfLabelOffset] ^= label
+
+	out := make([]byte, (size+aes.BlockSize-1)/aes.BlockSize*aes.BlockSize)
+	for i := 0; i < len(out)/aes.BlockSize; i++ {
+		binary.BigEndian.PutUint16(iv[kSrtpKdfCounterOffset:], uint16(i))
+		block.Encrypt(out[i*aes.BlockSize:], iv)
+	}
+	return out[:size], nil
+}
+
+// srtpReplayWindow is the replay list(RFC 3711 3.3.2) of the recent indexes.
+type srtpReplayWindow struct {
+	maxIndex int64
+	mask     uint64 // bit i for maxIndex-i
+	hasMax   bool
+}
+
+// check returns whether index is not received yet and not too old.
+func (w *srtpReplayWindow) check(index int64) bool {
+	if !w.hasMax || index > w.maxIndex {
+		return true
+	}
+	diff := w.maxIndex - index
+	if diff >= kSrtpReplayWindowSize {
+		return false
+	}
+	return w.mask&(uint64(1)<<uint(diff)) == 0
+}
+
+// update records one authenticated index.
+func (w *srtpReplayWindow) update(index int64) {
+	if !w.hasMax {
+		w.maxIndex = index
+		w.mask = 1
+		w.hasMax = true
+		return
+	}
+	if index > w.maxIndex {
+		diff := index - w.maxIndex
+		if diff >= kSrtpReplayWindowSize {
+			w.mask = 0
+		} else {
+			w.mask <<= uint(diff)
+		}
+		w.mask |= 1
+		w.maxIndex = index
+		return
+	}
+	w.mask |= uint64(1) << uint(w.maxIndex-index)
+}
+
+// srtpSsrcState is the ROC and replay state of one SSRC.
+type srtpSsrcState struct {
+	unwrapper SeqUnwrapper // the unwrapped sequence is the 48-bit index
+	roc       uint32       // the initial ROC before the first packet
+	replay    srtpReplayWindow
+	rtcpIndex uint32 // the next SRTCP index for sending
+	lastUsed  uint64 // the use counter of context when last used
+}
+
+// index estimates the 48-bit index(ROC||SEQ) of seq(RFC 3711 3.3.1).
+func (s *srtpSsrcState) index(seq uint16) int64 {
+	if !s.unwrapper.hasLast {
+		return int64(s.roc)<<16 | int64(seq)
+	}
+	return s.unwrapper.PeekUnwrap(seq)
+}
+
+// update records the index of one sent or authenticated packet, and the ROC
+// is increased only by the newer one.
+func (s *srtpSsrcState) update(seq uint16, index int64) {
+	if !s.unwrapper.hasLast {
+		s.unwrapper.lastValue = index
+		s.unwrapper.hasLast = true
+	} else if index > s.unwrapper.lastValue {
+		s.unwrapper.Unwrap(seq)
+	}
+}
+
+// SrtpContext protects or unprotects the packets of one direction(e.g. the
+// local keys for sending, and the remote keys for receiving) in a session.
+// At most 1024 SSRCs are tracked for each of SRTP and SRTCP, and the least
+// recently used one is evicted(losing its ROC and replay state) for a new SSRC.
+type SrtpContext struct {
+	sync.Mutex
+	profile SrtpProtectionProfile
+
+	srtpBlock   cipher.Block
+	srtpSalt    []byte
+	srtpAuth    hash.Hash
+	srtpGcm     cipher.AEAD
+	srtcpBlock  cipher.Block
+	srtcpSalt   []byte
+	srtcpAuth   hash.Hash
+	srtcpGcm    cipher.AEAD
+	rtpStates   map[uint32]*srtpSsrcState
+	rtcpStates  map[uint32]*srtpSsrcState
+	useCount    uint64
+	replayCheck bool
+}
+
+// NewSrtpContext creates a context of profile with the master key and salt,
+// e.g. from DTLS-SRTP key export.
+func NewSrtpContext(profile SrtpProtectionProfile, masterKey, masterSalt []byte) (*SrtpContext, error) {
+	if profile.KeyLen() == 0 {
+		return nil, NewErrorf("SRTP profile unsupported: %v", profile)
+	}
+	if len(masterKey) != profile.KeyLen() || len(masterSalt) != profile.SaltLen() {
+		return nil, NewErrorf("SRTP master key/salt invalid: %d, %d", len(masterKey), len(masterSalt))
+	}
+	var err error
+	var srtpKey, srtcpKey, srtpAuthKey, srtcpAuthKey []byte
+	if srtpKey, err = srtpDeriveKey(masterKey, masterSalt, kSrtpLabelRtpEncryption, len(masterKey)); err != nil {
+		return nil, err
+	}
+	if srtcpKey, err = srtpDeriveKey(masterKey, masterSalt, kSrtpLabelRtcpEncryption, len(masterKey)); err != nil {
+		return nil, err
+	}
+	srtpSalt, _ := srtpDeriveKey(masterKey, masterSalt, kSrtpLabelRtpSalt, len(masterSalt))
+	srtcpSalt, _ := srtpDeriveKey(masterKey, masterSalt, kSrtpLabelRtcpSalt, len(masterSalt))
+	if !profile.IsAead() {
+		srtpAuthKey, _ = srtpDeriveKey(masterKey, masterSalt, kSrtpLabelRtpAuth, kSrtpAuthKeyLen)
+		srtcpAuthKey, _ = srtpDeriveKey(masterKey, masterSalt, kSrtpLabelRtcpAuth, kSrtpAuthKeyLen)
+	}
+	return newSrtpContextWithSessionKeys(profile, srtpKey, srtpSalt, srtpAuthKey, srtcpKey, srtcpSalt, srtcpAuthKey)
+}
+
+// newSrtpContextWithSessionKeys creates a context with the derived session keys,
+// where the auth keys are not used by AEAD profiles.
+func newSrtpContextWithSessionKeys(profile SrtpProtectionProfile, srtpKey, srtpSalt, srtpAuthKey,
+	srtcpKey, srtcpSalt, srtcpAuthKey []byte) (*SrtpContext, error) {
+	c := &SrtpContext{
+		profile:     profile,
+		srtpSalt:    srtpSalt,
+		srtcpSalt:   srtcpSalt,
+		rtpStates:   make(map[uint32]*srtpSsrcState),
+		rtcpStates:  make(map[uint32]*srtpSsrcState),
+		replayCheck: true,
+	}
+
+	var err error
+	if c.srtpBlock, err = aes.NewCipher(srtpKey); err != nil {
+		return nil, NewError2(err, "SRTP session key invalid")
+	}
+	if c.srtcpBlock, err = aes.NewCipher(srtcpKey); err != nil {
+		return nil, NewError2(err, "SRTCP session key invalid")
+	}
+
+	if profile.IsAead() {
+		if c.srtpGcm, err = cipher.NewGCM(c.srtpBlock); err != nil {
+			return nil, NewError2(err, "SRTP GCM invalid")
+		}
+		if c.srtcpGcm, err = cipher.NewGCM(c.srtcpBlock); err != nil {
+			return nil, NewError2(err, "SRTCP GCM invalid")
+		}
+	} else {
+		c.srtpAuth = hmac.New(sha1.New, srtpAuthKey)
+		c.srtcpAuth = hmac.New(sha1.New, srtcpAuthKey)
+	}
+	return c, nil
+}
+
+// Profile returns the protection profile.
+func (c *SrtpContext) Profile() SrtpProtectionProfile {
+	return c.profile
+}
+
+// SetReplayCheck enables(default) or disables the replay protection of unprotecting.
+func (c *SrtpContext) SetReplayCheck(enable bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.replayCheck = enable
+}
+
+// SetRoc sets the ROC of ssrc, e.g. when joining one session whose ROC is not 0.
+func (c *SrtpContext) SetRoc(ssrc uint32, roc uint32) {
+	c.Lock()
+	defer c.Unlock()
+	state := c.getState(c.rtpStates, ssrc)
+	state.unwrapper.Reset()
+	state.roc = roc
+}
+
+// Roc returns the current ROC of ssrc.
+func (c *SrtpContext) Roc(ssrc uint32) uint32 {
+	c.Lock()
+	defer c.Unlock()
+	if state, ok := c.rtpStates[ssrc]; ok {
+		if !state.unwrapper.hasLast {
+			return state.roc
+		}
+		return uint32(state.unwrapper.lastValue >> 16)
+	}
+	return 0
+}
+
+// getState returns the state of ssrc, which is added if not found.
+func (c *SrtpContext) getState(states map[uint32]*srtpSsrcState, ssrc uint32) *srtpSsrcState {
+	state := c.lookupState(states, ssrc)
+	c.addState(states, ssrc, state)
+	return state
+}
+
+// lookupState returns the state of ssrc, or a new one not added, e.g. before
+// the packet of a new SSRC is authenticated.
+func (c *SrtpContext) lookupState(states map[uint32]*srtpSsrcState, ssrc uint32) *srtpSsrcState {
+	c.useCount += 1
+	state, ok := states[ssrc]
+	if !ok {
+		state = &srtpSsrcState{}
+	}
+	state.lastUsed = c.useCount
+	return state
+}
+
+// addState adds the state of ssrc if not added, and evicts the least recently
+// used one if too many.
+func (c *SrtpContext) addState(states map[uint32]*srtpSsrcState, ssrc uint32, state *srtpSsrcState) {
+	if _, ok := states[ssrc]; ok {
+		return
+	}
+	if len(states) >= kSrtpMaxSsrcStates {
+		var oldest uint32
+		var oldestUsed uint64
+		for key, item := range states {
+			if oldestUsed == 0 || item.lastUsed < oldestUsed {
+				oldest, oldestUsed = key, item.lastUsed
+			}
+		}
+		delete(states, oldest)
+	}
+	states[ssrc] = state
+}
+
+// srtpCmIv returns the AES-CM IV(RFC 3711 4.1.1) of salt/ssrc/index.
+func srtpCmIv(salt []byte, ssrc uint32, index uint64) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, salt)
+	var buf [8]byte
+	binary.BigEndian.PutUint32(buf[:4], ssrc)
+	for i := 0; i < 4; i++ {
+		iv[kSrtpCmSsrcOffset+i] ^= buf[i]
+	}
+	binary.BigEndian.PutUint64(buf[:], index)
+	for i := 0; i < kSrtpCmIndexFieldBytes; i++ {
+		iv[kSrtpCmIndexOffset+i] ^= buf[2+i]
+	}
+	return iv
+}
+
+// srtpGcmIv returns the GCM IV(RFC 7714 8.1 and 9.1) of salt/ssrc/index, where
+// index is ROC||SEQ for SRTP or SRTCP index.
+func srtpGcmIv(salt []byte, ssrc uint32, index uint64, offset int) []byte {
+	iv := make([]byte, kSrtpGcmIvLen)
+	binary.BigEndian.PutUint32(iv[kSrtpGcmSsrcOffset:], ssrc)
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], index)
+	copy(iv[offset:], buf[8-(kSrtpGcmIvLen-offset):])
+	for i := range iv {
+		iv[i] ^= salt[i]
+	}
+	return iv
+}
+
+func (c *SrtpContext) rtpAuthTag(data []byte, roc uint32) []byte {
+	var buf [kSrtpRocLen]byte
+	binary.BigEndian.PutUint32(buf[:], roc)
+	c.srtpAuth.Reset()
+	c.srtpAuth.Write(data)
+	c.srtpAuth.Write(buf[:])
+	return c.srtpAuth.Sum(nil)[:c.profile.rtpTagLen()]
+}
+
+func (c *SrtpContext) rtcpAuthTag(data []byte) []byte {
+	c.srtcpAuth.Reset()
+	c.srtcpAuth.Write(data)
+	return c.srtcpAuth.Sum(nil)[:c.profile.rtcpTagLen()]
+}
+
+// EncryptRtp protects one plain RTP packet, and returns the SRTP packet.
+func (c *SrtpContext) EncryptRtp(raw []byte) ([]byte, error) {
+	var header RtpHeader
+	if err := header.Unmarshal(raw); err != nil {
+		return nil, NewError2(err, "SRTP invalid RTP")
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	state := c.getState(c.rtpStates, header.SSRC)
+	index := state.index(header.SequenceNumber)
+	if index < 0 || index > kSrtpMaxIndex {
+		return nil, NewErrorf("SRTP index out of range: %d", index)
+	}
+	state.update(header.SequenceNumber, index)
+	roc := uint32(index >> 16)
+	offset := header.PayloadOffset
+
+	if c.profile.IsAead() {
+		out := make([]byte, offset, len(raw)+kSrtpGcmTagLen)
+		copy(out, raw[:offset])
+		iv := srtpGcmIv(c.srtpSalt, header.SSRC, uint64(index), kSrtpGcmIndexOffset)
+		return c.srtpGcm.Seal(out, iv, raw[offset:], raw[:offset]), nil
+	}
+
+	out := make([]byte, len(raw), len(raw)+c.profile.rtpTagLen())
+	copy(out, raw[:offset])
+	stream := cipher.NewCTR(c.srtpBlock, srtpCmIv(c.srtpSalt, header.SSRC, uint64(index)))
+	stream.XORKeyStream(out[offset:], raw[offset:])
+	return append(out, c.rtpAuthTag(out, roc)...), nil
+}
+
+// DecryptRtp authenticates and decrypts one SRTP packet, and returns the plain RTP packet.
+func (c *SrtpContext) DecryptRtp(data []byte) ([]byte, error) {
+	tagLen := c.profile.rtpTagLen()
+	if len(data) < kRtpHeaderLength+tagLen {
+		return nil, NewErrorf("SRTP packet insufficient: %d", len(data))
+	}
+	var header RtpHeader
+	if err := header.Unmarshal(data[:len(data)-tagLen]); err != nil {
+		return nil, NewError2(err, "SRTP invalid RTP")
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	state := c.lookupState(c.rtpStates, header.SSRC)
+	index := state.index(header.SequenceNumber)
+	if index < 0 || index > kSrtpMaxIndex {
+		return nil, NewErrorf("SRTP index out of range: %d", index)
+	}
+	if c.replayCheck && !state.replay.check(index) {
+		return nil, NewErrorf("SRTP replayed: ssrc=%d, seq=%d", header.SSRC, header.SequenceNumber)
+	}
+	roc := uint32(index >> 16)
+	offset := header.PayloadOffset
+
+	var out []byte
+	if c.profile.IsAead() {
+		out = make([]byte, offset, len(data))
+		copy(out, data[:offset])
+		iv := srtpGcmIv(c.srtpSalt, header.SSRC, uint64(index), kSrtpGcmIndexOffset)
+		var err error
+		if out, err = c.srtpGcm.Open(out, iv, data[offset:], data[:offset]); err != nil {
+			return nil, NewError2(err, "SRTP authentication failed")
+		}
+	} else {
+		body := data[:len(data)-tagLen]
+		if !hmac.Equal(c.rtpAuthTag(body, roc), data[len(body):]) {
+			return nil, NewErrorf("SRTP authentication failed: ssrc=%d, seq=%d", header.SSRC, header.SequenceNumber)
+		}
+		out = make([]byte, len(body))
+		copy(out, body[:offset])
+		stream := cipher.NewCTR(c.srtpBlock, srtpCmIv(c.srtpSalt, header.SSRC, uint64(index)))
+		stream.XORKeyStream(out[offset:], body[offset:])
+	}
+
+	c.addState(c.rtpStates, header.SSRC, state)
+	state.replay.update(index)
+	state.update(header.SequenceNumber, index)
+	return out, nil
+}
+
+// EncryptRtcp protects one plain RTCP(compound) packet, and returns the SRTCP packet.
+func (c *SrtpContext) EncryptRtcp(raw []byte) ([]byte, error) {
+	if len(raw) < kSrtcpEncryptedOffset {
+		return nil, NewErrorf("SRTCP invalid RTCP: %d", len(raw))
+	}
+	ssrc := binary.BigEndian.Uint32(raw[4:])
+
+	c.Lock()
+	defer c.Unlock()
+	state := c.getState(c.rtcpStates, ssrc)
+	index := state.rtcpIndex
+	state.rtcpIndex = (state.rtcpIndex + 1) & kSrtcpMaxIndex
+
+	var trailer [kSrtcpIndexLen]byte
+	binary.BigEndian.PutUint32(trailer[:], kSrtcpEBit|index)
+
+	if c.profile.IsAead() {
+		out := make([]byte, kSrtcpEncryptedOffset, len(raw)+kSrtpGcmTagLen+kSrtcpIndexLen)
+		copy(out, raw[:kSrtcpEncryptedOffset])
+		aad := append(append([]byte(nil), raw[:kSrtcpEncryptedOffset]...), trailer[:]...)
+		iv := srtpGcmIv(c.srtcpSalt, ssrc, uint64(index), kSrtcpGcmIndexOffset)
+		out = c.srtcpGcm.Seal(out, iv, raw[kSrtcpEncryptedOffset:], aad)
+		return append(out, trailer[:]...), nil
+	}
+
+	out := make([]byte, len(raw), len(raw)+kSrtcpIndexLen+c.profile.rtcpTagLen())
+	copy(out, raw[:kSrtcpEncryptedOffset])
+	stream := cipher.NewCTR(c.srtcpBlock, srtpCmIv(c.srtcpSalt, ssrc, uint64(index)))
+	stream.XORKeyStream(out[kSrtcpEncryptedOffset:], raw[kSrtcpEncryptedOffset:])
+	out = append(out, trailer[:]...)
+	return append(out, c.rtcpAuthTag(out)...), nil
+}
+
+// DecryptRtcp authenticates and decrypts one SRTCP packet, and returns the plain RTCP packet.
+func (c *SrtpContext) DecryptRtcp(data []byte) ([]byte, error) {
+	tagLen := c.profile.rtcpTagLen()
+	if len(data) < kSrtcpEncryptedOffset+kSrtcpIndexLen+tagLen {
+		return nil, NewErrorf("SRTCP packet insufficient: %d", len(data))
+	}
+	if c.profile.IsAead() {
+		tagLen = 0 // the GCM tag is before the index
+	}
+	ssrc := binary.BigEndian.Uint32(data[4:])
+	indexOffset := len(data) - tagLen - kSrtcpIndexLen
+	trailer := binary.BigEndian.Uint32(data[indexOffset:])
+	index := trailer & kSrtcpMaxIndex
+	encrypted := trailer&kSrtcpEBit != 0
+
+	c.Lock()
+	defer c.Unlock()
+	state := c.lookupState(c.rtcpStates, ssrc)
+	if c.replayCheck && !state.replay.check(int64(index)) {
+		return nil, NewErrorf("SRTCP replayed: ssrc=%d, index=%d", ssrc, index)
+	}
+
+	var out []byte
+	if c.profile.IsAead() {
+		aad := append(append([]byte(nil), data[:kSrtcpEncryptedOffset]...), data[indexOffset:indexOffset+kSrtcpIndexLen]...)
+		iv := srtpGcmIv(c.srtcpSalt, ssrc, uint64(index), kSrtcpGcmIndexOffset)
+		out = make([]byte, kSrtcpEncryptedOffset, indexOffset)
+		copy(out, data[:kSrtcpEncryptedOffset])
+		var err error
+		if encrypted {
+			out, err = c.srtcpGcm.Open(out, iv, data[kSrtcpEncryptedOffset:indexOffset], aad)
+		} else {
+			// the unencrypted payload is authenticated as AAD only
+			body := data[kSrtcpEncryptedOffset : indexOffset-kSrtpGcmTagLen]
+			aad = append(append(append([]byte(nil), data[:kSrtcpEncryptedOffset]...), body...), data[indexOffset:indexOffset+kSrtcpIndexLen]...)
+			_, err = c.srtcpGcm.Open(nil, iv, data[indexOffset-kSrtpGcmTagLen:indexOffset], aad)
+			out = append(out, body...)
+		}
+		if err != nil {
+			return nil, NewError2(err, "SRTCP authentication failed")
+		}
+	} else {
+		body := data[:len(data)-tagLen]
+		if !hmac.Equal(c.rtcpAuthTag(body), data[len(body):]) {
+			return nil, NewErrorf("SRTCP authentication failed: ssrc=%d, index=%d", ssrc, index)
+		}
+		out = make([]byte, indexOffset)
+		copy(out, data[:indexOffset])
+		if encrypted {
+			stream := cipher.NewCTR(c.srtcpBlock, srtpCmIv(c.srtcpSalt, ssrc, uint64(index)))
+			stream.XORKeyStream(out[kSrtcpEncryptedOffset:], data[kSrtcpEncryptedOffset:indexOffset])
+		}
+	}
+
+	c.addState(c.rtcpStates, ssrc, state)
+	state.replay.update(int64(index))
+	return out, nil
+}
+
+// ProtectRtp marshals and protects one RTP packet.
+func (c *SrtpContext) ProtectRtp(pkt *RtpPacket) ([]byte, error) {
+	raw, err := pkt.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return c.EncryptRtp(raw)
+}
+
+// UnprotectRtp unprotects and parses one SRTP packet.
+func (c *SrtpContext) UnprotectRtp(data []byte) (*RtpPacket, error) {
+	raw, err := c.DecryptRtp(data)
+	if err != nil {
+		return nil, err
+	}
+	pkt := &RtpPacket{}
+	if err := pkt.Unmarshal(raw); err != nil {
+		return nil, err
+	}
+	return pkt, nil
+}
+
+// ProtectRtcp marshals and protects the RTCP packets as one compound packet.
+func (c *SrtpContext) ProtectRtcp(packets []RtcpPacket) ([]byte, error) {
+	raw, err := MarshalRtcpPackets(packets)
+	if err != nil {
+		return nil, err
+	}
+	return c.EncryptRtcp(raw)
+}
+
+// UnprotectRtcp unprotects and parses one SRTCP packet.
+func (c *SrtpContext) UnprotectRtcp(data []byte) ([]RtcpPacket, error) {
+	raw, err := c.DecryptRtcp(data)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalRtcpPackets(raw)
+}
@@ -1,24 +1,5 @@
 package goutil
 
-/*
-#cgo CFLAGS: -Wno-deprecated -std=c99
-
-#include <string.h>
-
-typedef unsigned char * uint8ptr;
-typedef short * int16ptr;
-typedef short int16;
-typedef unsigned short uint16;
-
-static void goutil_convert_to_int16(uint8ptr src, size_t srcLen, int16ptr dst, size_t dstLen) {
-    if (srcLen <= (dstLen * 2)) {
-        uint8ptr tmp = (uint8ptr)dst;
-        memcpy(tmp, src, srcLen);
-    }
-}
-*/
-import "C"
-
 import (
 	"encoding/binary"
 	"errors"
@@ -30,7 +11,6 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-	"unsafe"
 )
 
 // Atou16 convert a string to uint16
@@ -301,15 +281,12 @@ func Clone(src []byte) []byte {
 	return dst
 }
 
-// Convert []byte to int16[]
+// Convert []byte to int16[] (LittleEndian), and the trailing byte is the low byte
 func Convert2Int16(src []byte) []int16 {
-	src_ptr := C.uint8ptr(unsafe.Pointer(&src[0]))
-	src_len := C.size_t(len(src))
-
-	dst := make([]int16, (len(src)+1)/2)
-	dst_ptr := C.int16ptr(unsafe.Pointer(&dst[0]))
-	dst_len := C.size_t(len(dst))
-	C.goutil_convert_to_int16(src_ptr, src_len, dst_ptr, dst_len)
+	dst, _ := ByteToInt16Slice(src[:len(src)&^1])
+	if len(src)%2 != 0 {
+		dst = append(dst, int16(src[len(src)-1]))
+	}
 	return dst
 }
 
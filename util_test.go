@@ -12,3 +12,13 @@ func TestUtil_1(t *testing.T) {
 	agent2 := "Firefox/64.0"
 	fmt.Println(ParseAgent(agent2))
 }
+
+func TestUtil_2(t *testing.T) {
+	vals := Convert2Int16([]byte{0x01, 0x02, 0xff, 0xff, 0x03})
+	if len(vals) != 3 || vals[0] != 0x0201 || vals[1] != -1 || vals[2] != 0x03 {
+		t.Fatalf("Convert2Int16 failed: %v", vals)
+	}
+	if vals := Convert2Int16(nil); len(vals) != 0 {
+		t.Fatalf("Convert2Int16 empty failed: %v", vals)
+	}
+}
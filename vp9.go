@@ -0,0 +1,154 @@
+package goutil
+
+// VP9 color spaces(VP9 bitstream spec 7.2.2).
+const (
+	VP9_CS_UNKNOWN   uint8 = 0
+	VP9_CS_BT_601    uint8 = 1
+	VP9_CS_BT_709    uint8 = 2
+	VP9_CS_SMPTE_170 uint8 = 3
+	VP9_CS_SMPTE_240 uint8 = 4
+	VP9_CS_BT_2020   uint8 = 5
+	VP9_CS_RESERVED  uint8 = 6
+	VP9_CS_RGB       uint8 = 7
+)
+
+const (
+	kVp9FrameMarker = 2
+	kVp9SyncCode    = 0x498342
+)
+
+// Vp9FrameHeader is the beginning of uncompressed_header()(VP9 bitstream spec
+// 6.2), parsed to the frame and render size. The size is only present in key
+// and intra-only frames, otherwise it is zero.
+type Vp9FrameHeader struct {
+	Profile           uint8
+	ShowExistingFrame bool
+	FrameToShow       uint8
+	Keyframe          bool
+	ShowFrame         bool
+	ErrorResilient    bool
+	IntraOnly         bool
+
+	BitDepth     uint8
+	ColorSpace   uint8
+	ColorRange   bool
+	SubsamplingX bool
+	SubsamplingY bool
+
+	RefreshFrameFlags uint8
+	Width             int
+	Height            int
+	RenderWidth       int
+	RenderHeight      int
+}
+
+// ParseVp9FrameHeader parses the uncompressed header of one VP9 frame.
+func ParseVp9FrameHeader(data []byte) (*Vp9FrameHeader, error) {
+	r := NewBitReader(data)
+	h := &Vp9FrameHeader{}
+	if marker := r.ReadBits(2); r.Err() == nil && marker != kVp9FrameMarker {
+		return nil, NewErrorf("VP9 frame marker invalid: %d", marker)
+	}
+	low := r.ReadBits(1)
+	high := r.ReadBits(1)
+	h.Profile = uint8(high<<1 | low)
+	if h.Profile == 3 {
+		r.SkipBits(1) // reserved_zero
+	}
+
+	h.ShowExistingFrame = r.ReadFlag()
+	if h.ShowExistingFrame {
+		h.FrameToShow = uint8(r.ReadBits(3))
+		if r.Err() != nil {
+			return nil, NewError2(r.Err(), "VP9 header invalid")
+		}
+		return h, nil
+	}
+
+	h.Keyframe = r.ReadBits(1) == 0 // frame_type: KEY_FRAME(0)
+	h.ShowFrame = r.ReadFlag()
+	h.ErrorResilient = r.ReadFlag()
+	if h.Keyframe {
+		if err := parseVp9SyncCode(r); err != nil {
+			return nil, err
+		}
+		parseVp9ColorConfig(r, h)
+		h.RefreshFrameFlags = 0xFF
+		parseVp9FrameSize(r, h)
+	} else {
+		if !h.ShowFrame {
+			h.IntraOnly = r.ReadFlag()
+		}
+		if !h.ErrorResilient {
+			r.SkipBits(2) // reset_frame_context
+		}
+		if h.IntraOnly {
+			if err := parseVp9SyncCode(r); err != nil {
+				return nil, err
+			}
+			if h.Profile > 0 {
+				parseVp9ColorConfig(r, h)
+			} else {
+				h.BitDepth = 8
+				h.ColorSpace = VP9_CS_BT_601
+				h.SubsamplingX = true
+				h.SubsamplingY = true
+			}
+			h.RefreshFrameFlags = uint8(r.ReadBits(8))
+			parseVp9FrameSize(r, h)
+		}
+	}
+	if r.Err() != nil {
+		return nil, NewError2(r.Err(), "VP9 header invalid")
+	}
+	return h, nil
+}
+
+func parseVp9SyncCode(r *BitReader) error {
+	if code := r.ReadBits(24); r.Err() == nil && code != kVp9SyncCode {
+		return NewErrorf("VP9 sync code invalid: %x", code)
+	}
+	return nil
+}
+
+// parseVp9ColorConfig parses color_config().
+func parseVp9ColorConfig(r *BitReader, h *Vp9FrameHeader) {
+	h.BitDepth = 8
+	if h.Profile >= 2 {
+		if r.ReadFlag() {
+			h.BitDepth = 12
+		} else {
+			h.BitDepth = 10
+		}
+	}
+	h.ColorSpace = uint8(r.ReadBits(3))
+	if h.ColorSpace != VP9_CS_RGB {
+		h.ColorRange = r.ReadFlag()
+		if h.Profile == 1 || h.Profile == 3 {
+			h.SubsamplingX = r.ReadFlag()
+			h.SubsamplingY = r.ReadFlag()
+			r.SkipBits(1) // reserved_zero
+		} else {
+			h.SubsamplingX = true
+			h.SubsamplingY = true
+		}
+	} else {
+		h.ColorRange = true
+		if h.Profile == 1 || h.Profile == 3 {
+			r.SkipBits(1) // reserved_zero
+		}
+	}
+}
+
+// parseVp9FrameSize parses frame_size() and render_size().
+func parseVp9FrameSize(r *BitReader, h *Vp9FrameHeader) {
+	h.Width = int(r.ReadBits(16)) + 1
+	h.Height = int(r.ReadBits(16)) + 1
+	if r.ReadFlag() {
+		h.RenderWidth = int(r.ReadBits(16)) + 1
+		h.RenderHeight = int(r.ReadBits(16)) + 1
+	} else {
+		h.RenderWidth = h.Width
+		h.RenderHeight = h.Height
+	}
+}